@@ -10,8 +10,10 @@ package app
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,9 +21,13 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/encoding/protojson"
 
+	"github.com/openconfig/gnmic/pkg/cache"
+	"github.com/openconfig/gnmic/pkg/path"
 	"github.com/openconfig/gnmic/pkg/types"
 	"github.com/openconfig/gnmic/pkg/utils"
 )
@@ -228,6 +234,87 @@ type clusterMember struct {
 	LockedTargets         []string `json:"locked-targets,omitempty"`
 }
 
+// handleCacheQueryGet returns the notifications of the gNMI server cache
+// matching the target, subscription and xpath query parameters,
+// keyed by subscription name.
+func (a *App) handleCacheQueryGet(w http.ResponseWriter, r *http.Request) {
+	if a.c == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{"gnmi-server cache is not enabled"}})
+		return
+	}
+	q := r.URL.Query()
+	target := q.Get("target")
+	if target == "" {
+		target = "*"
+	}
+	p, err := path.ParsePath(q.Get("xpath"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("invalid xpath: %v", err)}})
+		return
+	}
+	rsp, err := a.c.Read(q.Get("subscription"), target, p)
+	switch {
+	case errors.Is(err, cache.ErrSubscriptionNotFound), errors.Is(err, cache.ErrTargetNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	result := make(map[string][]json.RawMessage, len(rsp))
+	for sub, ns := range rsp {
+		result[sub] = make([]json.RawMessage, 0, len(ns))
+		for _, n := range ns {
+			b, err := protojson.Marshal(n)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+				return
+			}
+			result[sub] = append(result[sub], b)
+		}
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleCacheReplicatePost writes the notifications replicated
+// by another instance of the cluster to the gNMI server cache.
+// The requests without the cluster cache replication token are rejected.
+func (a *App) handleCacheReplicatePost(w http.ResponseWriter, r *http.Request) {
+	if a.c == nil || a.replicator.Load() == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{"gnmi-server cache replication is not enabled"}})
+		return
+	}
+	token := []byte("Bearer " + a.Config.Clustering.CacheReplicationToken)
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{"invalid cache replication token"}})
+		return
+	}
+	notifications, err := cache.ReadNotifications(http.MaxBytesReader(w, r.Body, cacheReplicationMaxBodySize), cacheReplicationMaxRecordSize)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	for sub, ns := range notifications {
+		for _, n := range ns {
+			// the values older than the cached ones are rejected.
+			a.c.Write(r.Context(), sub, &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: n}})
+		}
+	}
+}
+
 func (a *App) handleClusteringGet(w http.ResponseWriter, r *http.Request) {
 	if a.Config.Clustering == nil {
 		return
@@ -0,0 +1,88 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/openconfig/gnmic/pkg/cache"
+)
+
+func TestHandleCacheQueryGet(t *testing.T) {
+	c, err := cache.New(&cache.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    &gnmi.Path{Target: "t1"},
+			Update: []*gnmi.Update{{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "srl1"}},
+			}},
+		}},
+	})
+	a := &App{c: c}
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{name: "unknown_subscription", query: "subscription=sub2", code: http.StatusNotFound},
+		{name: "unknown_target", query: "subscription=sub1&target=t2", code: http.StatusNotFound},
+		{name: "invalid_xpath", query: "xpath=/system[name", code: http.StatusBadRequest},
+		{name: "query", query: "subscription=sub1&target=t1&xpath=/system/name", code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			a.handleCacheQueryGet(w, httptest.NewRequest(http.MethodGet, "/api/v1/cache/query?"+tt.query, nil))
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status code %d, expected %d: %s", w.Code, tt.code, w.Body)
+			}
+			if tt.code != http.StatusOK {
+				apiErrs := new(APIErrors)
+				if err := json.Unmarshal(w.Body.Bytes(), apiErrs); err != nil || len(apiErrs.Errors) == 0 {
+					t.Errorf("unexpected error body %q: %v", w.Body, err)
+				}
+				return
+			}
+			rsp := make(map[string][]json.RawMessage)
+			if err := json.Unmarshal(w.Body.Bytes(), &rsp); err != nil {
+				t.Fatalf("failed to unmarshal response %q: %v", w.Body, err)
+			}
+			if len(rsp["sub1"]) != 1 {
+				t.Fatalf("unexpected response %q", w.Body)
+			}
+			n := new(gnmi.Notification)
+			if err := protojson.Unmarshal(rsp["sub1"][0], n); err != nil {
+				t.Fatalf("failed to unmarshal notification: %v", err)
+			}
+			if n.GetUpdate()[0].GetVal().GetAsciiVal() != "srl1" {
+				t.Errorf("unexpected notification: %v", n)
+			}
+		})
+	}
+
+	// without gnmi-server cache.
+	w := httptest.NewRecorder()
+	(&App{}).handleCacheQueryGet(w, httptest.NewRequest(http.MethodGet, "/api/v1/cache/query", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code %d without cache", w.Code)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -70,6 +71,8 @@ type App struct {
 	targetsChan   chan *target.Target
 	activeTargets map[string]struct{}
 	targetsLockFn map[string]context.CancelFunc
+	// set of the names of the targets this instance holds the lock of.
+	lockedTargets sync.Map
 	rootDesc      desc.Descriptor
 	// end collector
 	router *mux.Router
@@ -98,6 +101,7 @@ type App struct {
 	grpcSrv *grpc.Server
 	// gNMI cache
 	c               cache.Cache
+	replicator      atomic.Pointer[cacheReplicator] // set when the gNMI server starts, read by the collectors
 	subscribeRPCsem *semaphore.Weighted
 	unaryRPCsem     *semaphore.Weighted
 	// tunnel server
@@ -360,6 +364,14 @@ func (a *App) loadTargets(e fsnotify.Event) {
 	defer a.sem.Release(1)
 	switch e.Op {
 	case fsnotify.Write, fsnotify.Create:
+		if a.Config.GnmiServer != nil && a.Config.GnmiServer.Cache != nil {
+			// the targets are assigned to the cache shards when they are written,
+			// a new shard count only applies on restart.
+			err = cache.CheckShards(a.Config.GnmiServer.Cache, a.Config.FileConfig.GetInt("gnmi-server/cache/shards"))
+			if err != nil {
+				a.Logger.Printf("refusing to reshard the running gNMI server cache, the change applies on restart: %v", err)
+			}
+		}
 		newTargets, err := a.Config.GetTargets()
 		if err != nil && !errors.Is(err, config.ErrNoTargetsFound) {
 			a.Logger.Printf("failed getting targets from new config: %v", err)
@@ -0,0 +1,224 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/openconfig/gnmic/pkg/cache"
+	"github.com/openconfig/gnmic/pkg/lockers"
+)
+
+const (
+	// maximum number of notifications waiting to be replicated,
+	// the notifications written once it is reached are not replicated.
+	cacheReplicationMaxPending = 100000
+	// maximum size of a replication request body,
+	// the pending notifications are split in several requests.
+	cacheReplicationMaxBodySize = 32 * 1024 * 1024
+	// maximum size of a replicated notification.
+	cacheReplicationMaxRecordSize = 1024 * 1024
+)
+
+// cacheReplicator holds the notifications written to the gNMI server cache
+// until they are sent to the other instances of the cluster.
+type cacheReplicator struct {
+	m       *sync.Mutex
+	pending map[string][]*gnmi.Notification
+	count   int
+	dropped int
+}
+
+func newCacheReplicator() *cacheReplicator {
+	return &cacheReplicator{
+		m:       new(sync.Mutex),
+		pending: make(map[string][]*gnmi.Notification),
+	}
+}
+
+// add queues the notification n of subscription sub for replication.
+func (r *cacheReplicator) add(sub string, n *gnmi.Notification) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.count >= cacheReplicationMaxPending {
+		r.dropped++
+		return
+	}
+	r.pending[sub] = append(r.pending[sub], n)
+	r.count++
+}
+
+// take returns the queued notifications, grouped by subscription name,
+// and the number of notifications dropped since the last call.
+func (r *cacheReplicator) take() (map[string][]*gnmi.Notification, int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	pending, dropped := r.pending, r.dropped
+	r.pending = make(map[string][]*gnmi.Notification)
+	r.count = 0
+	r.dropped = 0
+	return pending, dropped
+}
+
+// replicateCache sends the notifications written to the gNMI server cache
+// for the targets this instance holds the lock of to the other instances
+// of the cluster every cache-replication-interval, so that an instance taking
+// over a target already has its cached values.
+// The notifications not replicated are counted by clusterCacheReplicationDropped.
+func (a *App) replicateCache(ctx context.Context) {
+	ticker := time.NewTicker(a.Config.Clustering.CacheReplicationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pending, dropped := a.replicator.Load().take()
+			bodies, oversized, err := encodeCacheReplication(pending, cacheReplicationMaxBodySize)
+			dropped += oversized
+			if dropped > 0 {
+				clusterCacheReplicationDropped.Add(float64(dropped))
+				a.Logger.Printf("cache replication: dropped %d notification(s)", dropped)
+			}
+			if err != nil {
+				a.Logger.Printf("cache replication: failed to encode notifications: %v", err)
+				continue
+			}
+			if len(bodies) == 0 {
+				continue
+			}
+			peers, err := a.cachePeers(ctx)
+			if err != nil {
+				a.Logger.Printf("cache replication: failed to get the cluster instances: %v", err)
+				continue
+			}
+			for _, s := range peers {
+				for _, b := range bodies {
+					err = a.sendCacheReplication(ctx, s, b)
+					if err != nil {
+						a.Logger.Printf("cache replication: failed to replicate to %q: %v", s.ID, err)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// encodeCacheReplication encodes the pending notifications grouped by subscription
+// name in request bodies of at most maxSize bytes. It returns the bodies and the number
+// of notifications not encoded because they are larger than cacheReplicationMaxRecordSize.
+func encodeCacheReplication(pending map[string][]*gnmi.Notification, maxSize int) ([][]byte, int, error) {
+	var bodies [][]byte
+	oversized := 0
+	batch := make(map[string][]*gnmi.Notification)
+	size := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		buffer := new(bytes.Buffer)
+		err := cache.WriteNotifications(buffer, batch)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, buffer.Bytes())
+		batch = make(map[string][]*gnmi.Notification)
+		size = 0
+		return nil
+	}
+	for sub, ns := range pending {
+		for _, n := range ns {
+			nSize := proto.Size(n)
+			if nSize > cacheReplicationMaxRecordSize {
+				oversized++
+				continue
+			}
+			// the record and its length, counting the subscription name
+			// and the notifications count for each record.
+			recordSize := nSize + len(sub) + 3*binary.MaxVarintLen64
+			if size+recordSize > maxSize {
+				err := flush()
+				if err != nil {
+					return nil, oversized, err
+				}
+			}
+			batch[sub] = append(batch[sub], n)
+			size += recordSize
+		}
+	}
+	err := flush()
+	if err != nil {
+		return nil, oversized, err
+	}
+	return bodies, oversized, nil
+}
+
+// cachePeers returns the API services of the other instances of the cluster.
+func (a *App) cachePeers(ctx context.Context) ([]*lockers.Service, error) {
+	serviceName := fmt.Sprintf("%s-%s", a.Config.Clustering.ClusterName, apiServiceName)
+	srvs, err := a.locker.GetServices(ctx, serviceName, []string{"cluster-name=" + a.Config.Clustering.ClusterName})
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]*lockers.Service, 0, len(srvs))
+	for _, s := range srvs {
+		if s.ID == a.Config.Clustering.InstanceName+"-api" {
+			continue
+		}
+		peers = append(peers, s)
+	}
+	return peers, nil
+}
+
+func (a *App) sendCacheReplication(ctx context.Context, s *lockers.Service, b []byte) error {
+	scheme := "http"
+	client := &http.Client{
+		Timeout: defaultHTTPClientTimeout,
+	}
+	for _, t := range s.Tags {
+		if strings.HasPrefix(t, "protocol=") {
+			scheme = strings.Split(t, "=")[1]
+			break
+		}
+	}
+	if scheme == "https" {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s://%s/api/v1/cache/replicate", scheme, s.Address), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+a.Config.Clustering.CacheReplicationToken)
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode > 200 {
+		return fmt.Errorf("status code=%d", rsp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+
+	"github.com/openconfig/gnmic/pkg/cache"
+	"github.com/openconfig/gnmic/pkg/config"
+	"github.com/openconfig/gnmic/pkg/lockers"
+	"github.com/openconfig/gnmic/pkg/outputs"
+)
+
+func TestCacheReplication(t *testing.T) {
+	r := newCacheReplicator()
+	n := &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    &gnmi.Path{Target: "t1"},
+		Update: []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "srl1"}},
+		}},
+	}
+	r.add("sub1", n)
+	pending, dropped := r.take()
+	if len(pending["sub1"]) != 1 || dropped != 0 {
+		t.Fatalf("unexpected pending notifications %v, dropped %d", pending, dropped)
+	}
+	if pending, _ = r.take(); len(pending) != 0 {
+		t.Errorf("unexpected pending notifications after take: %v", pending)
+	}
+
+}
+
+func TestEncodeCacheReplication(t *testing.T) {
+	pending := make(map[string][]*gnmi.Notification)
+	for i := 0; i < 10; i++ {
+		sub := fmt.Sprintf("sub%d", i%2)
+		pending[sub] = append(pending[sub], &gnmi.Notification{
+			Timestamp: int64(i),
+			Prefix:    &gnmi.Path{Target: "t1"},
+			Update: []*gnmi.Update{{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: fmt.Sprintf("leaf%d", i)}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(i)}},
+			}},
+		})
+	}
+	// too large to be replicated.
+	pending["sub0"] = append(pending["sub0"], &gnmi.Notification{
+		Prefix: &gnmi.Path{Target: "t1"},
+		Update: []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "blob"}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_BytesVal{BytesVal: make([]byte, cacheReplicationMaxRecordSize)}},
+		}},
+	})
+	bodies, oversized, err := encodeCacheReplication(pending, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oversized != 1 {
+		t.Errorf("unexpected oversized notifications count %d", oversized)
+	}
+	if len(bodies) < 2 {
+		t.Fatalf("the notifications are not split, got %d bodies", len(bodies))
+	}
+	count := 0
+	for _, b := range bodies {
+		if len(b) > 200 {
+			t.Errorf("body of %d bytes larger than the limit", len(b))
+		}
+		ns, err := cache.ReadNotifications(bytes.NewReader(b), cacheReplicationMaxRecordSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sns := range ns {
+			count += len(sns)
+		}
+	}
+	if count != 10 {
+		t.Errorf("unexpected replicated notifications count %d", count)
+	}
+}
+
+func TestUpdateCacheReplicatesLockedTargets(t *testing.T) {
+	c, err := cache.New(&cache.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Config: config.New(), c: c}
+	a.replicator.Store(newCacheReplicator())
+	a.lockedTargets.Store("t1", struct{}{})
+	for _, target := range []string{"t1", "t2"} {
+		a.updateCache(context.TODO(), &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Update: []*gnmi.Update{{
+					Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}},
+					Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: target}},
+				}},
+			}},
+		}, outputs.Meta{"source": target, "subscription-name": "sub1"})
+	}
+	pending, _ := a.replicator.Load().take()
+	if len(pending["sub1"]) != 1 || pending["sub1"][0].GetPrefix().GetTarget() != "t1" {
+		t.Errorf("unexpected pending notifications %v", pending)
+	}
+	// both targets are cached.
+	if rsp, err := c.Read("sub1", "*", nil); err != nil || len(rsp["sub1"]) != 2 {
+		t.Errorf("unexpected cached notifications %v: %v", rsp, err)
+	}
+}
+
+// testLocker is the locker of the clustering configurations under test.
+type testLocker struct {
+	lockers.Locker
+}
+
+func init() {
+	lockers.Register("test", func() lockers.Locker { return new(testLocker) })
+}
+
+func TestHandleCacheReplicatePost(t *testing.T) {
+	c, err := cache.New(&cache.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.New()
+	cfg.FileConfig.Set("clustering/cluster-name", "cluster1")
+	cfg.FileConfig.Set("clustering/instance-name", "gnmic1")
+	cfg.FileConfig.Set("clustering/cache-replication", true)
+	cfg.FileConfig.Set("clustering/locker", map[string]interface{}{"type": "test"})
+	if err = cfg.GetClustering(); err == nil {
+		t.Fatal("expected an error without cache replication token")
+	}
+	cfg.FileConfig.Set("clustering/cache-replication-token", "s3cret")
+	if err = cfg.GetClustering(); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Config: cfg,
+		c:      c,
+	}
+	n := &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    &gnmi.Path{Target: "t1"},
+		Update: []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "srl1"}},
+		}},
+	}
+	body := func() *bytes.Buffer {
+		b := new(bytes.Buffer)
+		if err := cache.WriteNotifications(b, map[string][]*gnmi.Notification{"sub1": {n}}); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	post := func(token string, b io.Reader) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/cache/replicate", b)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		a.handleCacheReplicatePost(w, r)
+		return w.Code
+	}
+
+	// this instance does not replicate its cache.
+	if code := post("s3cret", body()); code != http.StatusNotFound {
+		t.Errorf("unexpected status code %d without cache replication", code)
+	}
+	a.replicator.Store(newCacheReplicator())
+	// without the cluster token.
+	for _, token := range []string{"", "secret"} {
+		if code := post(token, body()); code != http.StatusUnauthorized {
+			t.Errorf("token %q: unexpected status code %d", token, code)
+		}
+	}
+	if _, err := c.Read("sub1", "t1", nil); err == nil {
+		t.Fatal("notifications written without the cluster token")
+	}
+	if code := post("s3cret", bytes.NewBufferString("\xff")); code != http.StatusBadRequest {
+		t.Errorf("unexpected status code for an invalid body %d", code)
+	}
+	// a record larger than the limit is rejected before it is read.
+	large := binary.AppendUvarint([]byte("\x04sub1\x01"), cacheReplicationMaxRecordSize+1)
+	if code := post("s3cret", bytes.NewReader(large)); code != http.StatusBadRequest {
+		t.Errorf("unexpected status code for a record too large %d", code)
+	}
+	// the peer writes the replicated notifications to its cache.
+	if code := post("s3cret", body()); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	rsp, err := c.Read("sub1", "t1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp["sub1"]) != 1 {
+		t.Errorf("unexpected replicated notifications: %v", rsp)
+	}
+}
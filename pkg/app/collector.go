@@ -209,7 +209,13 @@ func (a *App) updateCache(ctx context.Context, rsp *gnmi.SubscribeResponse, m ou
 			a.Logger.Printf("updating target %q cache", target)
 		}
 		sub := m["subscription-name"]
-		a.c.Write(ctx, sub, &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: r.Update}})
+		err := a.c.Write(ctx, sub, &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: r.Update}})
+		// only the values of the targets this instance holds the lock of are replicated.
+		if rep := a.replicator.Load(); err == nil && rep != nil {
+			if _, ok := a.lockedTargets.Load(m["source"]); ok {
+				rep.add(sub, r.Update)
+			}
+		}
 	}
 }
 
@@ -70,6 +70,7 @@ START:
 				goto START
 			}
 			a.Logger.Printf("acquired lock for target %q", tc.Name)
+			a.lockedTargets.Store(tc.Name, struct{}{})
 		}
 		a.Logger.Printf("queuing target %q", tc.Name)
 		a.targetsChan <- t
@@ -86,15 +87,18 @@ START:
 			for {
 				select {
 				case <-nctx.Done():
+					a.lockedTargets.Delete(tc.Name)
 					a.Logger.Printf("target %q stopped: %v", tc.Name, nctx.Err())
 					// drain errChan
 					err := <-errChan
 					a.Logger.Printf("target %q keepLock returned: %v", tc.Name, err)
 					return
 				case <-doneChan:
+					a.lockedTargets.Delete(tc.Name)
 					a.Logger.Printf("target lock %q removed", tc.Name)
 					return
 				case err := <-errChan:
+					a.lockedTargets.Delete(tc.Name)
 					a.Logger.Printf("failed to maintain target %q lock: %v", tc.Name, err)
 					a.stopTarget(ctx, tc.Name)
 					if errors.Is(err, context.Canceled) {
@@ -57,6 +57,13 @@ func (a *App) startGnmiServer() {
 		a.Logger.Printf("failed to initialize gNMI cache: %v", err)
 		return
 	}
+	if a.Config.GnmiServer.EnableMetrics && a.reg != nil {
+		a.c.RegisterMetrics(a.reg)
+	}
+	if a.locker != nil && a.inCluster() && a.Config.Clustering.CacheReplication {
+		a.replicator.Store(newCacheReplicator())
+		go a.replicateCache(a.ctx)
+	}
 
 	a.subscribeRPCsem = semaphore.NewWeighted(a.Config.GnmiServer.MaxSubscriptions)
 	a.unaryRPCsem = semaphore.NewWeighted(a.Config.GnmiServer.MaxUnaryRPC)
@@ -586,6 +593,10 @@ func (a *App) handleONCESubscriptionRequest(sc *streamClient) {
 			err = n.Err
 			return
 		}
+		// the sync response is sent by the caller.
+		if n.SyncResponse {
+			continue
+		}
 		err = sc.stream.Send(&gnmi.SubscribeResponse{
 			Response: &gnmi.SubscribeResponse_Update{
 				Update: n.Notification,
@@ -705,6 +716,11 @@ func (a *App) handleStreamSubscriptionRequest(sc *streamClient) {
 
 					continue
 				}
+				// the cache marks the end of the initial values
+				// of each subscription, they are not forwarded.
+				if n.SyncResponse {
+					continue
+				}
 
 				err := sc.stream.Send(&gnmi.SubscribeResponse{
 					Response: &gnmi.SubscribeResponse_Update{
@@ -35,6 +35,12 @@ var clusterNumberOfLockedTargets = prometheus.NewGauge(prometheus.GaugeOpts{
 	Name:      "number_of_locked_targets",
 	Help:      "number of locked targets",
 })
+var clusterCacheReplicationDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cluster",
+	Name:      "cache_replication_dropped_notifications_total",
+	Help:      "Total number of gNMI server cache notifications not replicated to the other instances",
+})
 var clusterIsLeader = prometheus.NewGauge(prometheus.GaugeOpts{
 	Namespace: "gnmic",
 	Subsystem: "cluster",
@@ -55,6 +61,10 @@ func (a *App) startClusterMetrics() {
 	if err != nil {
 		a.Logger.Printf("failed to register metric: %v", err)
 	}
+	err = a.reg.Register(clusterCacheReplicationDropped)
+	if err != nil {
+		a.Logger.Printf("failed to register metric: %v", err)
+	}
 	ticker := time.NewTicker(clusterMetricsUpdatePeriod)
 	defer ticker.Stop()
 	for {
@@ -20,6 +20,7 @@ func (a *App) routes() {
 	a.configRoutes(apiV1)
 	a.targetRoutes(apiV1)
 	a.healthRoutes(apiV1)
+	a.cacheRoutes(apiV1)
 }
 
 func (a *App) clusterRoutes(r *mux.Router) {
@@ -60,6 +61,11 @@ func (a *App) targetRoutes(r *mux.Router) {
 	r.HandleFunc("/targets/{id}", a.handleTargetsDelete).Methods(http.MethodDelete)
 }
 
+func (a *App) cacheRoutes(r *mux.Router) {
+	r.HandleFunc("/cache/query", a.handleCacheQueryGet).Methods(http.MethodGet)
+	r.HandleFunc("/cache/replicate", a.handleCacheReplicatePost).Methods(http.MethodPost)
+}
+
 func (a *App) healthRoutes(r *mux.Router) {
 	r.HandleFunc("/healthz", a.handleHealthzGet).Methods(http.MethodGet)
 }
@@ -10,12 +10,15 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -34,21 +37,113 @@ const (
 	ReadMode_StreamSample   = "stream_sample"
 )
 
+// policies applied when some updates of a notification cannot be cached
+const (
+	PartialUpdateBestEffort   = "best-effort"
+	PartialUpdateAllOrNothing = "all-or-nothing"
+)
+
+// policies applied to the new paths of a target that reached MaxTargetEntries,
+// or of a subscription that reached MaxEntries or MaxSize
+const (
+	OverflowPolicyReject      = "reject"
+	OverflowPolicyEvictOldest = "evict-oldest"
+)
+
+// policies applied to the notifications timestamped ahead of MaxClockSkew
+const (
+	SkewPolicyReject = "reject"
+	SkewPolicyClamp  = "clamp"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrTargetNotFound       = errors.New("target not found")
+	ErrRawNotRetained       = errors.New("raw responses are not retained")
+)
+
+// reasons a response is not cached, wrapped by the WriteError returned by Write.
+var (
+	ErrMissingTarget     = errors.New("response missing target")
+	ErrEmptyPath         = errors.New("update with an empty path")
+	ErrEmptyNotification = errors.New("notification without updates or deletes")
+	ErrReadOnly          = errors.New("subscription is read-only")
+	ErrClockSkew         = errors.New("timestamp ahead of max-clock-skew")
+	// ErrRejected wraps the error returned by the cache update.
+	ErrRejected = errors.New("cache update failed")
+)
+
+// WriteError is returned by Write when a response is not cached,
+// Err is one of the reasons above.
+type WriteError struct {
+	Subscription string
+	Target       string
+	Err          error
+}
+
+func (e *WriteError) Error() string {
+	if e.Target == "" {
+		return fmt.Sprintf("subscription %q: write failed: %v", e.Subscription, e.Err)
+	}
+	return fmt.Sprintf("subscription %q: target %q: write failed: %v", e.Subscription, e.Target, e.Err)
+}
+
+func (e *WriteError) Unwrap() error { return e.Err }
+
 type Cache interface {
-	// Write inserts the proto.Message (SubscribeResponse) into the cache under a subscription called `sub`
-	Write(ctx context.Context, sub string, m proto.Message)
+	// Write inserts the proto.Message (SubscribeResponse) into the cache under a subscription called `sub`.
+	// It returns a *WriteError if the response is not cached.
+	Write(ctx context.Context, sub string, m proto.Message) error
 	// ReadAll, reads entries from the local cache, return the entries grouped by subscription name.
 	ReadAll() (map[string][]*gnmi.Notification, error)
-	// Read, reads a single path value from the cache filtering by subscription and target name
+	// Read, reads a single path value from the cache filtering by subscription and target name.
+	// It returns ErrSubscriptionNotFound or ErrTargetNotFound if the subscription or the target are unknown,
+	// an empty result with a nil error means the path matched nothing.
 	Read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error)
+	// ReadHistory, reads up to `depth` values per path from the cache, newest first,
+	// filtering by subscription and target name.
+	ReadHistory(sub, target string, p *gnmi.Path, depth int) (map[string][]*gnmi.Notification, error)
+	// ReadRaw, reads the SubscribeResponse messages retained as written, ordered by timestamp,
+	// filtering by subscription and target name, grouped by subscription name.
+	// It returns ErrRawNotRetained if the cache is not configured to retain them.
+	ReadRaw(sub, target string) (map[string][]*gnmi.SubscribeResponse, error)
+	// LatestAll, returns the latest notification matching the path for each target, keyed by target name.
+	LatestAll(sub string, p *gnmi.Path) map[string]*gnmi.Notification
 	// Subscribes to the local cache and returns the notification over a channel
 	Subscribe(ctx context.Context, so *ReadOpts) chan *Notification
 	// Stops the cache
 	Stop()
-	// DeleteTarget deletes the target from the cache by name
+	// ListTargets returns the names of the targets present in the cache, sorted,
+	// keyed by subscription name.
+	ListTargets() map[string][]string
+	// GetStats returns a snapshot of the number of targets and cached values,
+	// their oldest and newest timestamps, and the write counters,
+	// per subscription and in total.
+	GetStats() *CacheStats
+	// DeleteTarget deletes the target from the cache by name,
+	// a name ending with `*` deletes all the targets starting with the name prefix.
 	DeleteTarget(name string)
+	// DeleteTargetReport deletes the target(s) from the cache like DeleteTarget and
+	// returns, for each subscription, whether a target was deleted from it.
+	DeleteTargetReport(name string) map[string]bool
+	// Clear removes all the cached values of all the subscriptions.
+	Clear()
+	// ClearSubscription removes all the cached values of the subscription name.
+	ClearSubscription(name string)
+	// DeletePath deletes the leaves of a target matching a path from the cache,
+	// filtering by subscription name. The path must have at least one element.
+	// It returns ErrSubscriptionNotFound or ErrTargetNotFound if the subscription or the target are unknown.
+	DeletePath(sub, target string, p *gnmi.Path) error
 	// SetLogger sets a logger for the cache
 	SetLogger(l *log.Logger)
+	// SetOnWrite sets a callback called for each notification, update or delete,
+	// once it is cached. A nil callback removes it.
+	// It is called synchronously by the writer, without holding the cache locks,
+	// hence it must not block as it delays the next writes.
+	SetOnWrite(f WriteFunc)
+	// RegisterMetrics registers the cache metrics with the registry
+	// and enables their collection.
+	RegisterMetrics(reg *prometheus.Registry)
 }
 
 type Config struct {
@@ -57,10 +152,93 @@ type Config struct {
 	Timeout    time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
 	Expiration time.Duration `mapstructure:"expiration,omitempty" json:"expiration,omitempty"`
 	Debug      bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+	// QueryTimeout, maximum duration of a read, or of a once or sample query,
+	// including the time spent sending the results to a subscriber.
+	// Once elapsed, the read returns the notifications collected so far with
+	// a context.DeadlineExceeded error, the query sends that error to the subscriber.
+	// defaults to 10s, a negative value disables the timeout.
+	QueryTimeout time.Duration `mapstructure:"query-timeout,omitempty" json:"query-timeout,omitempty"`
+	// OC cfg options
+	// Shards, number of shards the targets of each subscription are split between,
+	// each with its own lock. A target is always assigned to the same shard,
+	// the shard count cannot be changed while the cache is running.
+	// defaults to 1.
+	Shards int `mapstructure:"shards,omitempty" json:"shards,omitempty"`
+	// AtomicReplace, if true, an atomic notification replaces
+	// the whole subtree cached under its prefix instead of being merged with it.
+	AtomicReplace bool `mapstructure:"atomic-replace,omitempty" json:"atomic-replace,omitempty"`
+	// ReadOnlySubscriptions, list of subscription names the cache
+	// rejects writes for.
+	ReadOnlySubscriptions []string `mapstructure:"read-only-subscriptions,omitempty" json:"read-only-subscriptions,omitempty"`
+	// Subscriptions, per subscription name options,
+	// overriding the global ones.
+	Subscriptions map[string]*SubscriptionConfig `mapstructure:"subscriptions,omitempty" json:"subscriptions,omitempty"`
+	// HistoryDepth, number of values retained per path and returned by ReadHistory
+	// and by the once reads with ReadOpts.History set.
+	// defaults to 1, i.e only the latest value is kept.
+	HistoryDepth int `mapstructure:"history-depth,omitempty" json:"history-depth,omitempty"`
+	// PartialUpdatePolicy, defines how a notification with some updates that cannot be cached
+	// (stale or colliding with the cached tree) is handled:
+	// `best-effort` caches the other updates, `all-or-nothing` rejects the whole notification.
+	// defaults to `best-effort`.
+	PartialUpdatePolicy string `mapstructure:"partial-update-policy,omitempty" json:"partial-update-policy,omitempty"`
+	// RetainRaw, if true, the SubscribeResponse messages written to the cache,
+	// including the sync responses, are also retained as is, until they expire,
+	// and returned by ReadRaw.
+	RetainRaw bool `mapstructure:"retain-raw,omitempty" json:"retain-raw,omitempty"`
+	// MaxTargetEntries, if set, the maximum number of leaves cached per target
+	// and subscription. The updates of the paths not cached yet beyond that number
+	// are handled according to OverflowPolicy, the updates of the cached paths are always applied.
+	MaxTargetEntries int `mapstructure:"max-target-entries,omitempty" json:"max-target-entries,omitempty"`
+	// OverflowPolicy, defines how the new paths of a target that reached MaxTargetEntries,
+	// or of a subscription that reached MaxEntries or MaxSize, are handled:
+	// `reject` drops their updates, `evict-oldest` removes the target, or subscription,
+	// leaves with the oldest timestamps to make room for them.
+	// defaults to `reject`.
+	OverflowPolicy string `mapstructure:"overflow-policy,omitempty" json:"overflow-policy,omitempty"`
+	// MaxEntries, if set, the maximum number of leaves cached per subscription,
+	// all targets included. Like MaxTargetEntries, the updates of the paths not cached yet
+	// beyond that number are handled according to OverflowPolicy.
+	MaxEntries int `mapstructure:"max-entries,omitempty" json:"max-entries,omitempty"`
+	// MaxSize, if set, the maximum size in bytes of the leaves cached per subscription,
+	// all targets included, measured as the size of their protobuf encoded notifications.
+	// Like MaxEntries, the updates of the paths not cached yet beyond that size
+	// are handled according to OverflowPolicy.
+	MaxSize int64 `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+	// MaxClockSkew, if set, the notifications timestamped more than MaxClockSkew
+	// ahead of the cache clock are handled according to SkewPolicy.
+	MaxClockSkew time.Duration `mapstructure:"max-clock-skew,omitempty" json:"max-clock-skew,omitempty"`
+	// SkewPolicy, defines how the notifications timestamped beyond MaxClockSkew are handled:
+	// `reject` drops them, `clamp` caches them with the current time as timestamp.
+	// defaults to `reject`.
+	SkewPolicy string `mapstructure:"skew-policy,omitempty" json:"skew-policy,omitempty"`
+	// TargetAliases, maps target names to the canonical name their notifications are cached under,
+	// e.g the IP address of a device to its hostname.
+	// The reads and deletes of an aliased target name apply to its canonical name.
+	TargetAliases map[string]string `mapstructure:"target-aliases,omitempty" json:"target-aliases,omitempty"`
+	// SweepInterval, interval at which the expired values are removed from the cache.
+	// defaults to half the shortest expiration, a negative value disables the removal,
+	// the expired values are then only skipped by the reads.
+	SweepInterval time.Duration `mapstructure:"sweep-interval,omitempty" json:"sweep-interval,omitempty"`
+	// SnapshotPath, if set, the cache is restored from the snapshot file at that path
+	// when it is created, and saved to it every SnapshotInterval and when it is stopped.
+	SnapshotPath string `mapstructure:"snapshot-path,omitempty" json:"snapshot-path,omitempty"`
+	// SnapshotInterval, interval at which the cache is saved to SnapshotPath.
+	// defaults to 1m, a negative value only saves the cache when it is stopped.
+	SnapshotInterval time.Duration `mapstructure:"snapshot-interval,omitempty" json:"snapshot-interval,omitempty"`
+	// SnapshotMaxSize, if set, the maximum size in bytes of the snapshot file.
+	// A snapshot exceeding it is not saved, SnapshotPath keeps the previous one.
+	SnapshotMaxSize int64 `mapstructure:"snapshot-max-size,omitempty" json:"snapshot-max-size,omitempty"`
 	// NATS, JS and Redis cfg options
 	Username string `mapstructure:"username,omitempty" json:"username,omitempty"`
 	Password string `mapstructure:"password,omitempty" json:"password,omitempty"`
 
+	// Redis cfg options
+	// Persist, if true, the latest value of each path is also stored in redis
+	// and loaded from it when the cache is created, so that the cached values
+	// survive the restart of the gnmic instances sharing the redis server.
+	Persist bool `mapstructure:"persist,omitempty" json:"persist,omitempty"`
+
 	// JS cfg options
 	MaxBytes               int64         `mapstructure:"max-bytes,omitempty" json:"max-bytes,omitempty"`
 	MaxMsgsPerSubscription int64         `mapstructure:"max-msgs-per-subscription,omitempty" json:"max-msgs-per-subscription,omitempty"`
@@ -68,6 +246,19 @@ type Config struct {
 	FetchWaitTime          time.Duration `mapstructure:"fetch-wait-time,omitempty" json:"fetch-wait-time,omitempty"`
 }
 
+// SubscriptionConfig holds the cache options specific to a subscription.
+type SubscriptionConfig struct {
+	// Expiration, if not zero, overrides the cache expiration for this subscription.
+	Expiration time.Duration `mapstructure:"expiration,omitempty" json:"expiration,omitempty"`
+	// SuppressRedundant, if true, the reads of this subscription only send the updates
+	// with a value different from the last one sent, unless ReadOpts.KeepRedundant is set.
+	SuppressRedundant bool `mapstructure:"suppress-redundant,omitempty" json:"suppress-redundant,omitempty"`
+	// MaxEntries, if not zero, overrides the cache max-entries for this subscription.
+	MaxEntries int `mapstructure:"max-entries,omitempty" json:"max-entries,omitempty"`
+	// MaxSize, if not zero, overrides the cache max-size for this subscription.
+	MaxSize int64 `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+}
+
 func (c *Config) setDefaults() {
 	if c.Address == "" {
 		switch c.Type {
@@ -83,6 +274,24 @@ func (c *Config) setDefaults() {
 	if c.Expiration == 0 {
 		c.Expiration = defaultExpiration
 	}
+	if c.QueryTimeout == 0 {
+		c.QueryTimeout = defaultTimeout
+	}
+	if c.SnapshotPath != "" && c.SnapshotInterval == 0 {
+		c.SnapshotInterval = defaultSnapshotInterval
+	}
+	if c.HistoryDepth <= 0 {
+		c.HistoryDepth = 1
+	}
+	if c.PartialUpdatePolicy == "" {
+		c.PartialUpdatePolicy = PartialUpdateBestEffort
+	}
+	if c.SkewPolicy == "" {
+		c.SkewPolicy = SkewPolicyReject
+	}
+	if c.OverflowPolicy == "" {
+		c.OverflowPolicy = OverflowPolicyReject
+	}
 
 	if c.Type != cacheType_JS {
 		return
@@ -109,9 +318,27 @@ func New(c *Config, opts ...Option) (Cache, error) {
 	if c.Type == "" {
 		c.Type = cacheType_OC
 	}
+	switch c.PartialUpdatePolicy {
+	case "", PartialUpdateBestEffort, PartialUpdateAllOrNothing:
+	default:
+		return nil, fmt.Errorf("unknown partial-update-policy: %q", c.PartialUpdatePolicy)
+	}
+	switch c.OverflowPolicy {
+	case "", OverflowPolicyReject, OverflowPolicyEvictOldest:
+	default:
+		return nil, fmt.Errorf("unknown overflow-policy: %q", c.OverflowPolicy)
+	}
+	switch c.SkewPolicy {
+	case "", SkewPolicyReject, SkewPolicyClamp:
+	default:
+		return nil, fmt.Errorf("unknown skew-policy: %q", c.SkewPolicy)
+	}
+	if err := validateTargetAliases(c.TargetAliases); err != nil {
+		return nil, err
+	}
 	switch c.Type {
 	case cacheType_OC:
-		return newGNMICache(c, "", opts...), nil
+		return newGNMICache(c, opts...), nil
 	case cacheType_NATS:
 		return newNATSCache(c, opts...)
 	case cacheType_JS:
@@ -124,18 +351,98 @@ func New(c *Config, opts ...Option) (Cache, error) {
 }
 
 type ReadOpts struct {
-	Subscription      string
-	Target            string
-	Paths             []*gnmi.Path
+	Subscription string
+	Target       string
+	Paths        []*gnmi.Path
+	// Origin, if set, is the origin of the Paths without one,
+	// `*` matches all the origins, including the values without origin.
+	// By default, a path without origin matches the values without origin,
+	// and an empty path matches the values of all the origins.
+	Origin            string
 	Mode              string
 	SampleInterval    time.Duration
 	HeartbeatInterval time.Duration
 	SuppressRedundant bool
-	UpdatesOnly       bool
-	OverrideTS        bool
+	// UpdatesOnly, if true, the initial values are not sent.
+	// In sample mode, each sample then only carries the values
+	// that changed since they were last sent, like SuppressRedundant.
+	UpdatesOnly bool
+	OverrideTS  bool
+	// PathOrdered, if true, the notifications of a target are sent
+	// ordered by xpath instead of the cache tree walk order.
+	PathOrdered bool
+	// IncludeOldValue, if true, the notifications sent in on-change mode
+	// carry the value previously cached for the same path in Notification.OldValue.
+	// Deletes carry the last known value of the deleted path.
+	IncludeOldValue bool
+	// KeepRedundant, if true, the redundant updates are sent even for
+	// the subscriptions configured with suppress-redundant.
+	// It is ignored if SuppressRedundant is set.
+	KeepRedundant bool
+	// CollapseAtomic, if true, the atomic notifications are sent
+	// as a non-atomic notification per update.
+	// By default, they are sent as they were written.
+	CollapseAtomic bool
+	// MaxAge, if set, the values with a timestamp older than MaxAge
+	// are not returned by this read, regardless of the cache expiration.
+	MaxAge time.Duration
+	// Bundle, if true, the notifications sent in once and sample modes
+	// are coalesced per target and prefix, see Bundle.
+	// It is ignored in on-change mode.
+	Bundle bool
+	// ValueFilter, if set, only the updates with a value matching
+	// the filter are sent, the deletes are sent regardless.
+	ValueFilter *ValueFilter
+	// AlignToClock, if true, the samples of a sample read are taken on the
+	// multiples of SampleInterval, e.g on the minute for a 1m interval,
+	// instead of every SampleInterval from the start of the read.
+	// The initial values are still sent right away.
+	AlignToClock bool
+	// ChannelBufferSize is the number of notifications buffered
+	// in the channel returned by Subscribe, unbuffered by default.
+	ChannelBufferSize int
+	// OnFull is the policy applied to the values sent while the channel
+	// returned by Subscribe is full, defaults to DropPolicyBlock.
+	// With DropPolicyBlock, a slow reader blocks the cache queries
+	// and, in on-change mode, the cache writes matching the read.
+	// The errors and sync markers are not subject to the policy, they wait
+	// for room in the channel, though DropPolicyOldest drops the oldest
+	// buffered notification whatever its kind.
+	OnFull DropPolicy
+	// History, if true, a once read sends all the values retained
+	// for each matching path by the cache history (see Config.HistoryDepth)
+	// within [HistoryStart, HistoryEnd], ordered by timestamp,
+	// instead of only the latest ones.
+	// It is ignored in the stream modes.
+	History bool
+	// HistoryStart and HistoryEnd bound the window of a History read,
+	// a zero time leaves the window open on that side.
+	HistoryStart time.Time
+	HistoryEnd   time.Time
 
 	m        *sync.RWMutex
 	lastSent map[string]*gnmi.TypedValue
+	// number of notifications dropped by the OnFull policy,
+	// shared with the heartbeat reads.
+	dropped *atomic.Uint64
+}
+
+// channelBufferSize returns the buffer size of the channel returned by Subscribe.
+func (ro *ReadOpts) channelBufferSize() int {
+	// the drop policies need a buffer to drop from.
+	if ro.OnFull != "" && ro.OnFull != DropPolicyBlock && ro.ChannelBufferSize < 1 {
+		return 1
+	}
+	return ro.ChannelBufferSize
+}
+
+// Dropped returns the number of notifications dropped
+// because of the OnFull policy.
+func (ro *ReadOpts) Dropped() uint64 {
+	if ro.dropped == nil {
+		return 0
+	}
+	return ro.dropped.Load()
 }
 
 func (ro *ReadOpts) setDefaults() {
@@ -148,6 +455,9 @@ func (ro *ReadOpts) setDefaults() {
 	if len(ro.Paths) == 0 {
 		ro.Paths = []*gnmi.Path{{}}
 	}
+	if ro.Origin != "" {
+		ro.Paths = withOrigin(ro.Paths, ro.Origin)
+	}
 	if ro.Mode == ReadMode_StreamSample && ro.SampleInterval <= 0 {
 		ro.SampleInterval = 10 * time.Second
 	}
@@ -155,10 +465,50 @@ func (ro *ReadOpts) setDefaults() {
 		ro.m = new(sync.RWMutex)
 		ro.lastSent = make(map[string]*gnmi.TypedValue)
 	}
+	if ro.dropped == nil {
+		ro.dropped = new(atomic.Uint64)
+	}
+}
+
+// withOrigin returns the paths ps with origin set on those without one.
+// The paths are copied rather than modified.
+// With the `*` origin, a path with elements is kept without origin as well,
+// to also match the values without origin.
+func withOrigin(ps []*gnmi.Path, origin string) []*gnmi.Path {
+	rs := make([]*gnmi.Path, 0, len(ps))
+	for _, p := range ps {
+		switch {
+		case p.GetOrigin() != "":
+			rs = append(rs, p)
+			continue
+		case origin == "*" && len(p.GetElem()) == 0:
+			// matches all the origins already.
+			rs = append(rs, p)
+			continue
+		case origin == "*":
+			// matches the values without origin.
+			rs = append(rs, p)
+		}
+		op := proto.Clone(p).(*gnmi.Path)
+		if op == nil {
+			op = new(gnmi.Path)
+		}
+		op.Origin = origin
+		rs = append(rs, op)
+	}
+	return rs
 }
 
 type Notification struct {
 	Name         string
 	Notification *gnmi.Notification
-	Err          error
+	// OldValue is the notification previously cached for the path
+	// of Notification, only set for on-change subscriptions with IncludeOldValue.
+	// It is nil if the path was not cached.
+	OldValue *gnmi.Notification
+	Err      error
+	// SyncResponse is set on the notification marking the end of the initial
+	// values of a once or sample read, like the gNMI sync_response.
+	// It carries no Notification.
+	SyncResponse bool
 }
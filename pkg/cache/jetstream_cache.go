@@ -26,6 +26,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/gnmic/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -66,7 +67,7 @@ func newJetStreamCache(cfg *Config, opts ...Option) (*jetStreamCache, error) {
 	var err error
 	c := &jetStreamCache{
 		cfg:        cfg,
-		oc:         newGNMICache(cfg, "jetstream", opts...),
+		oc:         newGNMICache(cfg, opts...),
 		streamChan: make(chan string),
 		m:          new(sync.RWMutex),
 		streams:    make(map[string]struct{}),
@@ -97,6 +98,10 @@ func newJetStreamCache(cfg *Config, opts ...Option) (*jetStreamCache, error) {
 	return c, nil
 }
 
+func (c *jetStreamCache) SetOnWrite(f WriteFunc) {
+	c.oc.SetOnWrite(f)
+}
+
 func (c *jetStreamCache) SetLogger(logger *log.Logger) {
 	if logger != nil && c.logger != nil {
 		c.logger.SetOutput(logger.Writer())
@@ -183,8 +188,8 @@ func (c *jetStreamCache) createStream(streamName string, subjects []string) erro
 	return nil
 }
 
-func (c *jetStreamCache) Write(ctx context.Context, subscriptionName string, m proto.Message) {
-	c.writeRemoteJS(ctx, subscriptionName, m)
+func (c *jetStreamCache) Write(ctx context.Context, subscriptionName string, m proto.Message) error {
+	err := c.writeRemoteJS(ctx, subscriptionName, m)
 	// publish the subscription name to nats for other gnmic instances
 	var ok bool
 	c.m.RLock()
@@ -198,9 +203,10 @@ func (c *jetStreamCache) Write(ctx context.Context, subscriptionName string, m p
 		}
 	}()
 	_, ok = c.streams[subscriptionName]
+	return err
 }
 
-func (c *jetStreamCache) writeRemoteJS(ctx context.Context, subscriptionName string, m proto.Message) {
+func (c *jetStreamCache) writeRemoteJS(ctx context.Context, subscriptionName string, m proto.Message) error {
 	switch m := m.ProtoReflect().Interface().(type) {
 	case *gnmi.SubscribeResponse:
 		switch rsp := m.GetResponse().(type) {
@@ -208,7 +214,7 @@ func (c *jetStreamCache) writeRemoteJS(ctx context.Context, subscriptionName str
 			targetName := rsp.Update.GetPrefix().GetTarget()
 			if targetName == "" {
 				c.logger.Printf("subscription=%q: response missing target: %v", subscriptionName, rsp)
-				return
+				return &WriteError{Subscription: subscriptionName, Err: ErrMissingTarget}
 			}
 
 			// check if a stream with the same name as the subscription is being created or has been created
@@ -224,7 +230,7 @@ func (c *jetStreamCache) writeRemoteJS(ctx context.Context, subscriptionName str
 					delete(c.streams, subscriptionName)
 					c.m.Unlock()
 					c.logger.Printf("failed to create stream: %v", err)
-					return
+					return &WriteError{Subscription: subscriptionName, Target: targetName, Err: fmt.Errorf("%w: %w", ErrRejected, err)}
 				}
 				c.m.Unlock()
 				c.streamChan <- subscriptionName
@@ -236,10 +242,11 @@ func (c *jetStreamCache) writeRemoteJS(ctx context.Context, subscriptionName str
 			err := c.publishNotificationJS(ctx, subscriptionName, targetName, m)
 			if err != nil {
 				c.logger.Print(err)
+				return &WriteError{Subscription: subscriptionName, Target: targetName, Err: fmt.Errorf("%w: %w", ErrRejected, err)}
 			}
-
 		}
 	}
+	return nil
 }
 
 func (c *jetStreamCache) publishNotificationJS(ctx context.Context, subscriptionName, targetName string, r *gnmi.SubscribeResponse) error {
@@ -326,15 +333,40 @@ func (c *jetStreamCache) ReadAll() (map[string][]*gnmi.Notification, error) {
 }
 
 func (c *jetStreamCache) Read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error) {
-	return c.oc.read(sub, target, p), nil
+	return c.oc.read(sub, target, p)
+}
+
+func (c *jetStreamCache) ReadHistory(sub, target string, p *gnmi.Path, depth int) (map[string][]*gnmi.Notification, error) {
+	return c.oc.ReadHistory(sub, target, p, depth)
+}
+
+func (c *jetStreamCache) ReadRaw(sub, target string) (map[string][]*gnmi.SubscribeResponse, error) {
+	return c.oc.ReadRaw(sub, target)
+}
+
+func (c *jetStreamCache) LatestAll(sub string, p *gnmi.Path) map[string]*gnmi.Notification {
+	return c.oc.LatestAll(sub, p)
 }
 
 func (c *jetStreamCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notification {
 	return c.oc.Subscribe(ctx, ro)
 }
 
+func (c *jetStreamCache) ListTargets() map[string][]string {
+	return c.oc.ListTargets()
+}
+
+func (c *jetStreamCache) GetStats() *CacheStats {
+	return c.oc.GetStats()
+}
+
+func (c *jetStreamCache) RegisterMetrics(reg *prometheus.Registry) {
+	c.oc.RegisterMetrics(reg)
+}
+
 func (c *jetStreamCache) Stop() {
 	c.cfn()
+	c.oc.Stop()
 	if c.nc != nil {
 		c.nc.Close()
 	}
@@ -347,6 +379,22 @@ func (c *jetStreamCache) DeleteTarget(name string) {
 	c.oc.DeleteTarget(name)
 }
 
+func (c *jetStreamCache) DeleteTargetReport(name string) map[string]bool {
+	return c.oc.DeleteTargetReport(name)
+}
+
+func (c *jetStreamCache) Clear() {
+	c.oc.Clear()
+}
+
+func (c *jetStreamCache) ClearSubscription(name string) {
+	c.oc.ClearSubscription(name)
+}
+
+func (c *jetStreamCache) DeletePath(sub, target string, p *gnmi.Path) error {
+	return c.oc.DeletePath(sub, target, p)
+}
+
 func subjectName(streamName, target string, m proto.Message) (string, error) {
 	sb := &strings.Builder{}
 	sb.WriteString(streamName)
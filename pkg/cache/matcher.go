@@ -0,0 +1,50 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"github.com/openconfig/gnmi/match"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/subscribe"
+)
+
+// Matcher dispatches the updates applied to a subscription cache
+// to the on-change queries registered against it.
+//
+// AddQuery registers client for the updates of the paths matching query,
+// query is made of the target name followed by the path elements and key values
+// (as returned by path.ToStrings), it may contain `*` wildcards.
+// The returned function removes the query, it is called exactly once.
+//
+// UpdateNotification is called for each notification applied to the cache,
+// v is the cache leaf holding n (a *ctree.Leaf) and prefix is n's prefix,
+// target included. It must pass v to the Update method of the clients whose query
+// matches the prefix joined with any of n's update or delete paths,
+// each client being called at most once per notification.
+type Matcher interface {
+	AddQuery(query []string, client match.Client) (remove func())
+	UpdateNotification(v interface{}, n *gnmi.Notification, prefix []string)
+}
+
+// MatchFactory returns a new Matcher, it is called for each subscription cache.
+type MatchFactory func() Matcher
+
+// defaultMatcher is the Matcher used if no MatchFactory is set,
+// it relies on the gNMI match package.
+type defaultMatcher struct {
+	*match.Match
+}
+
+func newDefaultMatcher() Matcher {
+	return &defaultMatcher{Match: match.New()}
+}
+
+func (m *defaultMatcher) UpdateNotification(v interface{}, n *gnmi.Notification, prefix []string) {
+	subscribe.UpdateNotification(m.Match, v, n, prefix)
+}
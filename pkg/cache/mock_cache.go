@@ -0,0 +1,471 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ Cache = (*gnmiCache)(nil)
+	_ Cache = (*natsCache)(nil)
+	_ Cache = (*jetStreamCache)(nil)
+	_ Cache = (*redisCache)(nil)
+	_ Cache = (*MockCache)(nil)
+)
+
+// MockCache is an in-memory Cache implementation meant to be used
+// in unit tests of the cache consumers.
+// It stores the written notifications as is, without merging them,
+// and returns them in the order they were written.
+// Notifications can be preloaded using Preload and pushed to
+// the active subscribers using Emit.
+type MockCache struct {
+	m             *sync.RWMutex
+	notifications map[string][]*gnmi.Notification
+	subscribers   map[*mockSubscriber]struct{}
+	logger        *log.Logger
+	onWrite       WriteFunc
+}
+
+type mockSubscriber struct {
+	ctx context.Context
+	ro  *ReadOpts
+	ch  chan *Notification
+	// held by Emit while sending to ch,
+	// ch is closed once the subscriber is unregistered.
+	m      sync.RWMutex
+	closed bool
+}
+
+// NewMock returns an empty MockCache.
+func NewMock() *MockCache {
+	return &MockCache{
+		m:             new(sync.RWMutex),
+		notifications: make(map[string][]*gnmi.Notification),
+		subscribers:   make(map[*mockSubscriber]struct{}),
+		logger:        log.New(io.Discard, "[cache:mock] ", 0),
+	}
+}
+
+// Preload adds the notifications ns to the subscription sub,
+// without sending them to the active subscribers.
+func (mc *MockCache) Preload(sub string, ns ...*gnmi.Notification) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+	mc.notifications[sub] = append(mc.notifications[sub], ns...)
+}
+
+// Emit sends the notification n to the active subscribers
+// matching the subscription sub and the notification target.
+// It blocks until all the matching subscribers received it,
+// unless their ReadOpts.OnFull policy drops it or their context is done.
+// The cache is not locked while sending, a blocked subscriber
+// does not block the writes.
+func (mc *MockCache) Emit(sub string, n *gnmi.Notification) {
+	mc.m.RLock()
+	subs := make([]*mockSubscriber, 0, len(mc.subscribers))
+	for s := range mc.subscribers {
+		if s.ro.Mode == ReadMode_Once || !mockMatch(s.ro.Subscription, s.ro.Target, sub, n) {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	mc.m.RUnlock()
+	for _, s := range subs {
+		s.m.RLock()
+		if !s.closed {
+			sendWithPolicy(s.ctx, s.ch, &Notification{Name: sub, Notification: n}, s.ro.OnFull,
+				func(*Notification) { s.ro.dropped.Add(1) })
+		}
+		s.m.RUnlock()
+	}
+}
+
+func (mc *MockCache) Write(_ context.Context, sub string, m proto.Message) error {
+	rsp, ok := m.(*gnmi.SubscribeResponse)
+	if !ok || rsp.GetUpdate() == nil {
+		return nil
+	}
+	mc.Preload(sub, rsp.GetUpdate())
+	mc.m.RLock()
+	onWrite := mc.onWrite
+	mc.m.RUnlock()
+	if onWrite != nil {
+		onWrite(sub, rsp.GetUpdate().GetPrefix().GetTarget(), rsp.GetUpdate())
+	}
+	mc.Emit(sub, rsp.GetUpdate())
+	return nil
+}
+
+// SetOnWrite sets a callback called for each notification written,
+// before it is sent to the active subscribers.
+func (mc *MockCache) SetOnWrite(f WriteFunc) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+	mc.onWrite = f
+}
+
+func (mc *MockCache) ReadAll() (map[string][]*gnmi.Notification, error) {
+	return mc.read("", "*"), nil
+}
+
+// Read returns the notifications of subscription sub and target,
+// with only their updates under the path p if it has elements or an origin.
+// The notifications without any update under p are not returned.
+func (mc *MockCache) Read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error) {
+	rs := mc.read(sub, target)
+	if len(rs) > 0 {
+		for name, ns := range rs {
+			rs[name] = mockFilterPath(ns, p)
+			if len(rs[name]) == 0 {
+				delete(rs, name)
+			}
+		}
+		return rs, nil
+	}
+	if sub != "" && sub != "*" && len(mc.read(sub, "*")) == 0 {
+		return nil, ErrSubscriptionNotFound
+	}
+	if target != "" && target != "*" {
+		return nil, ErrTargetNotFound
+	}
+	return rs, nil
+}
+
+// ReadHistory returns up to depth notifications of subscription sub and target, newest first,
+// filtered by the path p like Read.
+func (mc *MockCache) ReadHistory(sub, target string, p *gnmi.Path, depth int) (map[string][]*gnmi.Notification, error) {
+	rs, err := mc.Read(sub, target, p)
+	if err != nil {
+		return nil, err
+	}
+	for name, ns := range rs {
+		rev := make([]*gnmi.Notification, 0, len(ns))
+		for i := len(ns) - 1; i >= 0; i-- {
+			if depth > 0 && len(rev) == depth {
+				break
+			}
+			rev = append(rev, ns[i])
+		}
+		rs[name] = rev
+	}
+	return rs, nil
+}
+
+// ReadRaw returns the notifications of subscription sub and target
+// wrapped in SubscribeResponse messages, in the order they were written.
+// The sync responses are not stored.
+func (mc *MockCache) ReadRaw(sub, target string) (map[string][]*gnmi.SubscribeResponse, error) {
+	rs, err := mc.Read(sub, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsps := make(map[string][]*gnmi.SubscribeResponse, len(rs))
+	for name, ns := range rs {
+		for _, n := range ns {
+			rsps[name] = append(rsps[name], &gnmi.SubscribeResponse{
+				Response: &gnmi.SubscribeResponse_Update{Update: n},
+			})
+		}
+	}
+	return rsps, nil
+}
+
+// LatestAll returns the notification with the highest timestamp
+// of subscription sub for each target, filtered by the path p like Read.
+func (mc *MockCache) LatestAll(sub string, p *gnmi.Path) map[string]*gnmi.Notification {
+	latest := make(map[string]*gnmi.Notification)
+	for _, ns := range mc.read(sub, "*") {
+		for _, n := range mockFilterPath(ns, p) {
+			target := n.GetPrefix().GetTarget()
+			if l, ok := latest[target]; !ok || n.GetTimestamp() >= l.GetTimestamp() {
+				latest[target] = n
+			}
+		}
+	}
+	return latest
+}
+
+// Subscribe sends the stored notifications matching the ReadOpts subscription
+// and target, followed by a sync marker in `once` and `stream_sample` modes, then,
+// unless the mode is `once`, the notifications pushed using Emit until the context is done.
+// Paths and sampling related fields of the ReadOpts are ignored.
+func (mc *MockCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notification {
+	if ro == nil {
+		ro = new(ReadOpts)
+	}
+	ro.setDefaults()
+	ch := make(chan *Notification, ro.channelBufferSize())
+	s := &mockSubscriber{ctx: ctx, ro: ro, ch: ch}
+	// register the subscriber before sending the stored notifications
+	// so that no emitted notification is missed.
+	if ro.Mode != ReadMode_Once {
+		mc.m.Lock()
+		mc.subscribers[s] = struct{}{}
+		mc.m.Unlock()
+	}
+	go func() {
+		defer close(ch)
+		if !ro.UpdatesOnly {
+		DUMP:
+			for name, ns := range mc.read(ro.Subscription, ro.Target) {
+				for _, n := range ns {
+					select {
+					case <-ctx.Done():
+						break DUMP
+					case ch <- &Notification{Name: name, Notification: n}:
+					}
+				}
+			}
+		}
+		if ro.Mode != ReadMode_StreamOnChange {
+			select {
+			case <-ctx.Done():
+			case ch <- &Notification{SyncResponse: true}:
+			}
+		}
+		if ro.Mode == ReadMode_Once {
+			return
+		}
+		<-ctx.Done()
+		mc.m.Lock()
+		delete(mc.subscribers, s)
+		mc.m.Unlock()
+		// wait for a concurrent Emit, which returns once ctx is done,
+		// before closing the channel.
+		s.m.Lock()
+		s.closed = true
+		s.m.Unlock()
+	}()
+	return ch
+}
+
+func (mc *MockCache) Stop() {}
+
+func (mc *MockCache) RegisterMetrics(*prometheus.Registry) {}
+
+// ListTargets returns the sorted targets of the stored notifications,
+// keyed by subscription name.
+func (mc *MockCache) ListTargets() map[string][]string {
+	mc.m.RLock()
+	defer mc.m.RUnlock()
+	targets := make(map[string][]string, len(mc.notifications))
+	for sub, ns := range mc.notifications {
+		seen := make(map[string]struct{})
+		ts := make([]string, 0)
+		for _, n := range ns {
+			t := n.GetPrefix().GetTarget()
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			ts = append(ts, t)
+		}
+		sort.Strings(ts)
+		targets[sub] = ts
+	}
+	return targets
+}
+
+// GetStats returns the stats of the stored notifications, each one counting
+// as a write and its updates as entries. No write is dropped by the mock.
+func (mc *MockCache) GetStats() *CacheStats {
+	mc.m.RLock()
+	defer mc.m.RUnlock()
+	stats := &CacheStats{Subscriptions: make(map[string]*SubscriptionStats, len(mc.notifications))}
+	for sub, ns := range mc.notifications {
+		ss := &SubscriptionStats{Writes: uint64(len(ns))}
+		seen := make(map[string]struct{})
+		for _, n := range ns {
+			seen[n.GetPrefix().GetTarget()] = struct{}{}
+			ss.Entries += len(n.GetUpdate())
+			ss.observe(n.GetTimestamp())
+		}
+		ss.Targets = len(seen)
+		stats.Subscriptions[sub] = ss
+		stats.Total.add(ss)
+	}
+	return stats
+}
+
+func (mc *MockCache) DeleteTarget(name string) {
+	mc.DeleteTargetReport(name)
+}
+
+// DeleteTargetReport removes the stored notifications of the target name,
+// or of the targets starting with its prefix if it ends with `*`.
+func (mc *MockCache) DeleteTargetReport(name string) map[string]bool {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+	prefix, glob := strings.CutSuffix(name, "*")
+	report := make(map[string]bool, len(mc.notifications))
+	for sub, ns := range mc.notifications {
+		kept := make([]*gnmi.Notification, 0, len(ns))
+		for _, n := range ns {
+			target := n.GetPrefix().GetTarget()
+			if target == name || (glob && strings.HasPrefix(target, prefix)) {
+				continue
+			}
+			kept = append(kept, n)
+		}
+		report[sub] = len(kept) < len(ns)
+		mc.notifications[sub] = kept
+	}
+	return report
+}
+
+// Clear removes the stored notifications of all the subscriptions.
+func (mc *MockCache) Clear() {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+	mc.notifications = make(map[string][]*gnmi.Notification)
+}
+
+// ClearSubscription removes the stored notifications of the subscription name.
+func (mc *MockCache) ClearSubscription(name string) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+	delete(mc.notifications, name)
+}
+
+// DeletePath removes the stored notifications of subscription sub and target
+// with all their updates under the path p, then emits a delete notification
+// for p to the active subscribers.
+func (mc *MockCache) DeletePath(sub, target string, p *gnmi.Path) error {
+	if len(p.GetElem()) == 0 {
+		return errors.New("cannot delete an empty path, use DeleteTarget")
+	}
+	mc.m.Lock()
+	for name, ns := range mc.notifications {
+		kept := make([]*gnmi.Notification, 0, len(ns))
+		for _, n := range ns {
+			if !mockMatch(sub, target, name, n) || !mockUnderPath(n, p) {
+				kept = append(kept, n)
+			}
+		}
+		mc.notifications[name] = kept
+	}
+	mc.m.Unlock()
+	mc.Emit(sub, &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    &gnmi.Path{Origin: p.GetOrigin(), Target: target},
+		Delete:    []*gnmi.Path{{Elem: p.GetElem()}},
+	})
+	return nil
+}
+
+// mockUnderPath returns true if all the updates of n are under the path p,
+// a `*` element name or key value in p matches any value.
+func mockUnderPath(n *gnmi.Notification, p *gnmi.Path) bool {
+	if len(n.GetUpdate()) == 0 {
+		return false
+	}
+	for _, upd := range n.GetUpdate() {
+		if !mockUpdateUnderPath(n, upd, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// mockUpdateUnderPath returns true if the update upd of n is under the path p.
+func mockUpdateUnderPath(n *gnmi.Notification, upd *gnmi.Update, p *gnmi.Path) bool {
+	elems := append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), upd.GetPath().GetElem()...)
+	if len(elems) < len(p.GetElem()) {
+		return false
+	}
+	for i, pe := range p.GetElem() {
+		if pe.GetName() != "*" && pe.GetName() != elems[i].GetName() {
+			return false
+		}
+		for k, v := range pe.GetKey() {
+			if v != "*" && v != elems[i].GetKey()[k] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mockFilterPath returns the notifications ns with only their updates under the path p,
+// and with the origin of p unless it is empty or `*`.
+// ns is returned as is if p has no elements nor origin.
+func mockFilterPath(ns []*gnmi.Notification, p *gnmi.Path) []*gnmi.Notification {
+	origin := p.GetOrigin()
+	if origin == "*" {
+		origin = ""
+	}
+	if len(p.GetElem()) == 0 && origin == "" {
+		return ns
+	}
+	filtered := make([]*gnmi.Notification, 0, len(ns))
+	for _, n := range ns {
+		if origin != "" && notificationOrigin(n) != origin {
+			continue
+		}
+		if mockUnderPath(n, p) {
+			filtered = append(filtered, n)
+			continue
+		}
+		fn := &gnmi.Notification{
+			Timestamp: n.GetTimestamp(),
+			Prefix:    n.GetPrefix(),
+			Atomic:    n.GetAtomic(),
+		}
+		for _, upd := range n.GetUpdate() {
+			if mockUpdateUnderPath(n, upd, p) {
+				fn.Update = append(fn.Update, upd)
+			}
+		}
+		if len(fn.Update) > 0 {
+			filtered = append(filtered, fn)
+		}
+	}
+	return filtered
+}
+
+func (mc *MockCache) SetLogger(logger *log.Logger) {
+	if logger != nil && mc.logger != nil {
+		mc.logger.SetOutput(logger.Writer())
+		mc.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (mc *MockCache) read(sub, target string) map[string][]*gnmi.Notification {
+	mc.m.RLock()
+	defer mc.m.RUnlock()
+	rs := make(map[string][]*gnmi.Notification)
+	for name, ns := range mc.notifications {
+		for _, n := range ns {
+			if mockMatch(sub, target, name, n) {
+				rs[name] = append(rs[name], n)
+			}
+		}
+	}
+	return rs
+}
+
+func mockMatch(sub, target, nSub string, n *gnmi.Notification) bool {
+	if sub != "" && sub != "*" && sub != nSub {
+		return false
+	}
+	return target == "" || target == "*" || target == n.GetPrefix().GetTarget()
+}
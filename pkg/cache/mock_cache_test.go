@@ -0,0 +1,157 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestMockCache(t *testing.T) {
+	mc := NewMock()
+	mc.Preload("sub1",
+		&gnmi.Notification{Timestamp: 1, Prefix: &gnmi.Path{Target: "t1"}},
+		&gnmi.Notification{Timestamp: 2, Prefix: &gnmi.Path{Target: "t2"}},
+	)
+	mc.Preload("sub2", &gnmi.Notification{Timestamp: 3, Prefix: &gnmi.Path{Target: "t1"}})
+
+	rsp, err := mc.Read("sub1", "t1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || rsp["sub1"][0].GetTimestamp() != 1 {
+		t.Errorf("unexpected Read response: %v", rsp)
+	}
+	rsp, _ = mc.ReadAll()
+	if len(rsp["sub1"]) != 2 || len(rsp["sub2"]) != 1 {
+		t.Errorf("unexpected ReadAll response: %v", rsp)
+	}
+
+	// once
+	var count int
+	var last *Notification
+	for n := range mc.Subscribe(context.TODO(), &ReadOpts{Subscription: "sub1", Mode: ReadMode_Once}) {
+		count++
+		last = n
+	}
+	if count != 3 {
+		t.Errorf("unexpected once subscription notifications count, got %d, expected 2 and a sync marker", count)
+	}
+	if last == nil || !last.SyncResponse {
+		t.Errorf("unexpected last once subscription notification: %v", last)
+	}
+
+	// on-change driven by Emit
+	ctx, cancel := context.WithCancel(context.TODO())
+	ch := mc.Subscribe(ctx, &ReadOpts{Subscription: "sub2", Target: "t1"})
+	n := <-ch
+	if n.Notification.GetTimestamp() != 3 {
+		t.Fatalf("unexpected initial notification: %v", n.Notification)
+	}
+	go func() {
+		// not matching the subscription
+		mc.Emit("sub1", &gnmi.Notification{Timestamp: 4, Prefix: &gnmi.Path{Target: "t1"}})
+		mc.Emit("sub2", &gnmi.Notification{Timestamp: 5, Prefix: &gnmi.Path{Target: "t1"}})
+	}()
+	n = <-ch
+	if n.Name != "sub2" || n.Notification.GetTimestamp() != 5 {
+		t.Errorf("unexpected emitted notification: %s: %v", n.Name, n.Notification)
+	}
+	cancel()
+	for range ch {
+	}
+
+	mc.Preload("sub1", &gnmi.Notification{
+		Timestamp: 6,
+		Prefix:    &gnmi.Path{Target: "t1", Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+		Update:    []*gnmi.Update{{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "mtu"}}}}},
+	})
+	err = mc.DeletePath("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "*"}}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rsp, _ = mc.Read("sub1", "t1", nil)
+	if len(rsp["sub1"]) != 1 || rsp["sub1"][0].GetTimestamp() != 1 {
+		t.Errorf("unexpected Read response after DeletePath: %v", rsp)
+	}
+
+	mc.DeleteTarget("t1")
+	rsp, _ = mc.ReadAll()
+	if len(rsp["sub1"]) != 1 || len(rsp["sub2"]) != 0 {
+		t.Errorf("unexpected ReadAll response after DeleteTarget: %v", rsp)
+	}
+}
+
+func TestMockCache_readPath(t *testing.T) {
+	mc := NewMock()
+	mc.Preload("sub1", &gnmi.Notification{
+		Timestamp: 1,
+		Prefix:    &gnmi.Path{Target: "t1"},
+		Update: []*gnmi.Update{
+			{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}, {Name: "mtu"}}}},
+			{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}}},
+		},
+	})
+	rsp, err := mc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "*"}}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || len(rsp["sub1"][0].GetUpdate()) != 1 ||
+		rsp["sub1"][0].GetUpdate()[0].GetPath().GetElem()[0].GetName() != "interface" {
+		t.Errorf("unexpected Read response: %v", rsp)
+	}
+	rsp, err = mc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "network-instance"}}})
+	if err != nil || len(rsp) != 0 {
+		t.Errorf("unexpected Read response for a path not matching: %v, %v", rsp, err)
+	}
+	// the stored notification is not modified.
+	rsp, _ = mc.Read("sub1", "t1", nil)
+	if len(rsp["sub1"]) != 1 || len(rsp["sub1"][0].GetUpdate()) != 2 {
+		t.Errorf("unexpected Read response without path: %v", rsp)
+	}
+}
+
+func TestMockCache_emitBlockedSubscriber(t *testing.T) {
+	mc := NewMock()
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	// the subscriber does not read the emitted notifications.
+	ch := mc.Subscribe(ctx, &ReadOpts{Subscription: "sub1", Mode: ReadMode_StreamOnChange, UpdatesOnly: true, ChannelBufferSize: 1})
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		for i := 0; i < 3; i++ {
+			mc.Emit("sub1", &gnmi.Notification{Timestamp: int64(i), Prefix: &gnmi.Path{Target: "t1"}})
+		}
+	}()
+	// the blocked Emit does not block the writes.
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		mc.Preload("sub2", &gnmi.Notification{Timestamp: 1, Prefix: &gnmi.Path{Target: "t1"}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write blocked by a subscriber not reading")
+	}
+	// Emit returns once the subscriber is done.
+	cancel()
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("Emit blocked after the subscriber context is done")
+	}
+	for range ch {
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/gnmic/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -57,7 +58,7 @@ func newNATSCache(cfg *Config, opts ...Option) (*natsCache, error) {
 	var err error
 	c := &natsCache{
 		cfg:         cfg,
-		oc:          newGNMICache(cfg, "nats", opts...),
+		oc:          newGNMICache(cfg, opts...),
 		subjectChan: make(chan string),
 
 		m:        new(sync.RWMutex),
@@ -196,9 +197,9 @@ START:
 	}
 }
 
-func (c *natsCache) Write(ctx context.Context, subscriptionName string, m proto.Message) {
+func (c *natsCache) Write(ctx context.Context, subscriptionName string, m proto.Message) error {
 	// write the msg to nats
-	c.writeRemoteNATS(ctx, subscriptionName, m)
+	err := c.writeRemoteNATS(ctx, subscriptionName, m)
 	// publish the subscription name to nats for other gnmic instances
 	var ok bool
 	c.m.RLock()
@@ -212,9 +213,10 @@ func (c *natsCache) Write(ctx context.Context, subscriptionName string, m proto.
 		}
 	}()
 	_, ok = c.subjects[subscriptionName]
+	return err
 }
 
-func (c *natsCache) writeRemoteNATS(ctx context.Context, subscriptionName string, m proto.Message) {
+func (c *natsCache) writeRemoteNATS(ctx context.Context, subscriptionName string, m proto.Message) error {
 	switch m := m.ProtoReflect().Interface().(type) {
 	case *gnmi.SubscribeResponse:
 		switch rsp := m.GetResponse().(type) {
@@ -222,16 +224,17 @@ func (c *natsCache) writeRemoteNATS(ctx context.Context, subscriptionName string
 			targetName := rsp.Update.GetPrefix().GetTarget()
 			if targetName == "" {
 				c.logger.Printf("subscription=%q: response missing target: %v", subscriptionName, rsp)
-				return
+				return &WriteError{Subscription: subscriptionName, Err: ErrMissingTarget}
 			}
 			c.subjectChan <- subscriptionName
-			var err error
-			err = c.publishNotificationNATS(ctx, subscriptionName, targetName, m)
+			err := c.publishNotificationNATS(ctx, subscriptionName, targetName, m)
 			if err != nil {
 				c.logger.Print(err)
+				return &WriteError{Subscription: subscriptionName, Target: targetName, Err: fmt.Errorf("%w: %w", ErrRejected, err)}
 			}
 		}
 	}
+	return nil
 }
 
 func (c *natsCache) publishNotificationNATS(_ context.Context, subscriptionName, targetName string, r *gnmi.SubscribeResponse) error {
@@ -251,15 +254,40 @@ func (c *natsCache) ReadAll() (map[string][]*gnmi.Notification, error) {
 }
 
 func (c *natsCache) Read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error) {
-	return c.oc.read(sub, target, p), nil
+	return c.oc.read(sub, target, p)
+}
+
+func (c *natsCache) ReadHistory(sub, target string, p *gnmi.Path, depth int) (map[string][]*gnmi.Notification, error) {
+	return c.oc.ReadHistory(sub, target, p, depth)
+}
+
+func (c *natsCache) ReadRaw(sub, target string) (map[string][]*gnmi.SubscribeResponse, error) {
+	return c.oc.ReadRaw(sub, target)
+}
+
+func (c *natsCache) LatestAll(sub string, p *gnmi.Path) map[string]*gnmi.Notification {
+	return c.oc.LatestAll(sub, p)
 }
 
 func (c *natsCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notification {
 	return c.oc.Subscribe(ctx, ro)
 }
 
+func (c *natsCache) ListTargets() map[string][]string {
+	return c.oc.ListTargets()
+}
+
+func (c *natsCache) GetStats() *CacheStats {
+	return c.oc.GetStats()
+}
+
+func (c *natsCache) RegisterMetrics(reg *prometheus.Registry) {
+	c.oc.RegisterMetrics(reg)
+}
+
 func (c *natsCache) Stop() {
 	c.cfn()
+	c.oc.Stop()
 	if c.nc != nil {
 		c.nc.Close()
 	}
@@ -268,6 +296,10 @@ func (c *natsCache) Stop() {
 	}
 }
 
+func (c *natsCache) SetOnWrite(f WriteFunc) {
+	c.oc.SetOnWrite(f)
+}
+
 func (c *natsCache) SetLogger(logger *log.Logger) {
 	if logger != nil && c.logger != nil {
 		c.logger.SetOutput(logger.Writer())
@@ -279,3 +311,19 @@ func (c *natsCache) SetLogger(logger *log.Logger) {
 func (c *natsCache) DeleteTarget(name string) {
 	c.oc.DeleteTarget(name)
 }
+
+func (c *natsCache) DeleteTargetReport(name string) map[string]bool {
+	return c.oc.DeleteTargetReport(name)
+}
+
+func (c *natsCache) Clear() {
+	c.oc.Clear()
+}
+
+func (c *natsCache) ClearSubscription(name string) {
+	c.oc.ClearSubscription(name)
+}
+
+func (c *natsCache) DeletePath(sub, target string, p *gnmi.Path) error {
+	return c.oc.DeletePath(sub, target, p)
+}
@@ -10,18 +10,20 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ocCache "github.com/openconfig/gnmi/cache"
 	"github.com/openconfig/gnmi/ctree"
-	"github.com/openconfig/gnmi/match"
 	"github.com/openconfig/gnmi/path"
 	"github.com/openconfig/gnmi/proto/gnmi"
-	"github.com/openconfig/gnmi/subscribe"
 	gpath "github.com/openconfig/gnmic/pkg/path"
 	"github.com/openconfig/gnmic/pkg/utils"
 	"google.golang.org/protobuf/proto"
@@ -33,34 +35,206 @@ const (
 )
 
 type gnmiCache struct {
-	m      *sync.Mutex
-	caches map[string]*subCache
+	// m is held while the subscription caches are created or removed,
+	// and while their targets are added or removed.
+	// The writes and the reads look up the caches without holding it.
+	m *sync.Mutex
+	// *subCache keyed by subscription name.
+	caches sync.Map
+	// set of read-only subscription names
+	readOnly sync.Map
 	// match  *match.Match
+	matchFactory MatchFactory
 
-	logger     *log.Logger
-	expiration time.Duration
-	debug      bool
+	logger        *log.Logger
+	expiration    time.Duration
+	subExpiration map[string]time.Duration
+	// number of shards the targets of each subscription are split between.
+	shards int
+	// subscriptions with suppress-redundant set.
+	subSuppress   map[string]bool
+	debug         bool
+	atomicReplace bool
+	// if true, a notification with an update that
+	// cannot be cached is rejected as a whole.
+	allOrNothing bool
+	// maximum duration of a read or of a once or sample query, 0 if unlimited.
+	queryTimeout time.Duration
+	// maximum number of leaves per target and subscription, 0 if unlimited.
+	maxTargetEntries int
+	// maximum number of leaves per subscription, 0 if unlimited,
+	// and its per subscription overrides.
+	maxEntries    int
+	subMaxEntries map[string]int
+	// maximum size in bytes of the leaves cached per subscription, 0 if unlimited,
+	// and its per subscription overrides.
+	maxSize    int64
+	subMaxSize map[string]int64
+	// if true, the oldest leaves of a target that reached maxTargetEntries,
+	// or of a subscription that reached its max entries or max size, are evicted
+	// to cache the new paths, otherwise the new paths are rejected.
+	evictOldest bool
+	// per path values history, nil if the history depth is 1
+	history *history
+	// SubscribeResponse messages as written, nil if retain-raw is not set
+	raw *rawResponses
+	// called for each leaf expired, deleted or removed with its target.
+	onEvict EvictFunc
+	// called for each notification written, nil if not set.
+	onWrite atomic.Pointer[WriteFunc]
+	// set by RegisterMetrics
+	metrics atomic.Bool
+	// time source of the expired leaves sweeper, of the clock skew check
+	// and of the clock aligned samples.
+	clock func() time.Time
+	// notifications timestamped further ahead are rejected or clamped.
+	maxClockSkew time.Duration
+	// if true, the timestamps beyond maxClockSkew are clamped to now.
+	clampSkew bool
+	// canonical target names keyed by alias.
+	targetAliases map[string]string
+	// *writeCounts keyed by subscription name.
+	writeCounts sync.Map
+	// closed by Stop
+	stop     chan struct{}
+	stopOnce sync.Once
+	// closed once the last snapshot is saved, nil if the cache is not persisted.
+	saved chan struct{}
+	// maximum size of the snapshot file, 0 if unlimited.
+	snapshotMaxSize int64
 }
 
 type subCache struct {
-	c     *ocCache.Cache
-	match *match.Match
+	name  string
+	c     *shardedCache
+	match Matcher
+	// called for each leaf deleted from the cache, can be nil.
+	onEvict EvictFunc
+	// leaves already reported to onEvict as expired, keyed by leafKey,
+	// with the timestamp of their expired value.
+	em       sync.Mutex
+	reported map[string]int64
+
+	// wm is held exclusively by the writes that must not interleave
+	// with the writes to any target, i.e those limited by the
+	// subscription max entries or max size. The other writes read lock it.
+	wm *sync.RWMutex
+	// *sync.Mutex keyed by target name, held by the writes that must not
+	// interleave with the other writes to the same target, i.e those looking
+	// up the old values, checking the updates before applying them,
+	// limited by the target max entries, replacing a subtree or recording
+	// the history. The other writes run concurrently.
+	// The mutexes of the deleted targets are kept for their next writes.
+	targetLocks sync.Map
+	// *metadata.Metadata of each target keyed by target name,
+	// the cache only returns a copy of the metadata of all the targets.
+	meta sync.Map
+	// number of on-change subscribers requesting the old values.
+	oldValueSubs atomic.Int32
+	// values cached for the paths of the notification being written
+	// to a target, keyed by leafKey, in a map keyed by target name.
+	// Only set while the notification is applied.
+	old sync.Map
+	// size of the leaves cached, keyed by leafKey, in a map keyed by
+	// target name, and their total. Only maintained if the subscription
+	// max size is set, sizes is nil otherwise.
+	sm    sync.Mutex
+	sizes map[string]map[string]int
+	size  int64
+	// delete notifications written, returned by the reads.
+	deleted deletedLeaves
+}
+
+// addTarget adds target to the cache, it must be called with gc.m held.
+func (sc *subCache) addTarget(target string) {
+	sc.c.Add(target)
+	sc.meta.Store(target, sc.c.TargetMetadata(target))
+}
+
+// removeTarget removes target from the cache, it must be called with gc.m held
+// or once sc is no longer reachable from gc.caches.
+func (sc *subCache) removeTarget(target string) {
+	sc.c.Remove(target)
+	sc.meta.Delete(target)
+	sc.deleted.removeTarget(target)
+}
+
+// targetLock returns the write mutex of target.
+func (sc *subCache) targetLock(target string) *sync.Mutex {
+	if l, ok := sc.targetLocks.Load(target); ok {
+		return l.(*sync.Mutex)
+	}
+	l, _ := sc.targetLocks.LoadOrStore(target, new(sync.Mutex))
+	return l.(*sync.Mutex)
+}
+
+// oldValue returns the value cached for the leaf with key k of target
+// before the notification being written to target is applied.
+func (sc *subCache) oldValue(target, k string) *gnmi.Notification {
+	old, ok := sc.old.Load(target)
+	if !ok {
+		return nil
+	}
+	return old.(map[string]*gnmi.Notification)[k]
 }
 
 func (gc *gnmiCache) loadConfig(gcc *Config) {
 	gc.expiration = gcc.Expiration
+	gc.shards = max(gcc.Shards, 1)
 	gc.logger = log.New(io.Discard, loggingPrefixOC, utils.DefaultLoggingFlags)
 	gc.debug = gcc.Debug
+	gc.atomicReplace = gcc.AtomicReplace
+	gc.allOrNothing = gcc.PartialUpdatePolicy == PartialUpdateAllOrNothing
+	gc.queryTimeout = max(gcc.QueryTimeout, 0)
+	gc.maxTargetEntries = gcc.MaxTargetEntries
+	gc.maxEntries = gcc.MaxEntries
+	gc.maxSize = gcc.MaxSize
+	gc.evictOldest = gcc.OverflowPolicy == OverflowPolicyEvictOldest
+	gc.maxClockSkew = gcc.MaxClockSkew
+	gc.clampSkew = gcc.SkewPolicy == SkewPolicyClamp
+	gc.targetAliases = gcc.TargetAliases
+	gc.snapshotMaxSize = gcc.SnapshotMaxSize
+	if gcc.HistoryDepth > 1 {
+		gc.history = newHistory(gcc.HistoryDepth)
+	}
+	if gcc.RetainRaw {
+		gc.raw = newRawResponses()
+	}
+	gc.subExpiration = make(map[string]time.Duration)
+	gc.subSuppress = make(map[string]bool)
+	gc.subMaxEntries = make(map[string]int)
+	gc.subMaxSize = make(map[string]int64)
+	for name, sc := range gcc.Subscriptions {
+		if sc == nil {
+			continue
+		}
+		if sc.Expiration != 0 {
+			gc.subExpiration[name] = sc.Expiration
+		}
+		if sc.SuppressRedundant {
+			gc.subSuppress[name] = true
+		}
+		if sc.MaxEntries != 0 {
+			gc.subMaxEntries[name] = sc.MaxEntries
+		}
+		if sc.MaxSize != 0 {
+			gc.subMaxSize[name] = sc.MaxSize
+		}
+	}
+	for _, name := range gcc.ReadOnlySubscriptions {
+		gc.readOnly.Store(name, struct{}{})
+	}
 }
 
-func newGNMICache(cfg *Config, loggingPrefix string, opts ...Option) *gnmiCache {
+func newGNMICache(cfg *Config, opts ...Option) *gnmiCache {
 	if cfg == nil {
 		cfg = new(Config)
 	}
 	gc := &gnmiCache{
 		m: new(sync.Mutex),
 		// match:  match.New(),
-		caches: make(map[string]*subCache),
+		clock: time.Now,
+		stop:  make(chan struct{}),
 	}
 	cfg.setDefaults()
 
@@ -69,24 +243,66 @@ func newGNMICache(cfg *Config, loggingPrefix string, opts ...Option) *gnmiCache
 		opt(gc)
 	}
 	if gc.logger != nil {
-		if loggingPrefix == "" {
-			loggingPrefix = "oc"
-		}
 		gc.logger.SetPrefix(loggingPrefixOC)
 	}
+	if interval := sweepInterval(cfg); interval > 0 {
+		go gc.sweeper(interval)
+	}
+	if cfg.SnapshotPath != "" {
+		err := gc.restoreFile(cfg.SnapshotPath)
+		if err != nil {
+			gc.logger.Printf("failed to restore cache snapshot from %q: %v", cfg.SnapshotPath, err)
+		}
+		gc.saved = make(chan struct{})
+		go gc.snapshotter(cfg.SnapshotPath, cfg.SnapshotInterval)
+	}
 	return gc
 }
 
 func (gc *subCache) update(n *ctree.Leaf) {
 	switch v := n.Value().(type) {
 	case *gnmi.Notification:
+		switch {
+		case len(v.GetDelete()) == 0:
+			gc.deleted.written(v)
+			// an expired leaf overwritten is no longer expired.
+			if gc.onEvict != nil {
+				if k, ok := leafKey(v); ok {
+					gc.takeReported(k)
+				}
+			}
+		case isTargetRemoval(v):
+			gc.deleted.removeTarget(v.GetPrefix().GetTarget())
+			if gc.onEvict != nil {
+				gc.forgetReported(v.GetPrefix().GetTarget() + "\x00")
+			}
+		default:
+			// the expired leaves removed by the sweeper
+			// may have been reported by a read already.
+			if gc.onEvict != nil {
+				if k, ok := leafKey(v); !ok || !gc.takeReported(k) {
+					gc.onEvict(gc.name, v.GetPrefix().GetTarget(), notificationXPath(v))
+				}
+			}
+		}
+		if gc.sizes != nil {
+			gc.updateSize(v)
+		}
 		pathElems := path.ToStrings(v.GetPrefix(), true)
-		subscribe.UpdateNotification(gc.match, n, v, pathElems)
+		gc.match.UpdateNotification(n, v, pathElems)
 	default:
 		// gc.logger.Printf("unexpected update type: %T", v)
 	}
 }
 
+func (gc *gnmiCache) SetOnWrite(f WriteFunc) {
+	if f == nil {
+		gc.onWrite.Store(nil)
+		return
+	}
+	gc.onWrite.Store(&f)
+}
+
 func (gc *gnmiCache) SetLogger(logger *log.Logger) {
 	if logger != nil && gc.logger != nil {
 		gc.logger.SetOutput(logger.Writer())
@@ -94,16 +310,34 @@ func (gc *gnmiCache) SetLogger(logger *log.Logger) {
 	}
 }
 
-func (gc *gnmiCache) Write(ctx context.Context, measName string, m proto.Message) {
-	var err error
-	switch rsp := m.ProtoReflect().Interface().(type) {
+func (gc *gnmiCache) Write(ctx context.Context, measName string, m proto.Message) error {
+	err := gc.write(ctx, measName, m)
+	if err != nil {
+		gc.countWriteError(measName)
+	}
+	return err
+}
+
+func (gc *gnmiCache) write(ctx context.Context, measName string, m proto.Message) error {
+	switch srsp := m.ProtoReflect().Interface().(type) {
 	case *gnmi.SubscribeResponse:
-		switch rsp := rsp.GetResponse().(type) {
+		switch rsp := srsp.GetResponse().(type) {
+		case *gnmi.SubscribeResponse_SyncResponse:
+			if gc.raw == nil {
+				return nil
+			}
+			if _, readOnly := gc.readOnly.Load(measName); readOnly {
+				return &WriteError{Subscription: measName, Err: ErrReadOnly}
+			}
+			gc.raw.add(measName, "", time.Now().UnixNano(), srsp, gc.subscriptionExpiration(measName))
+			return nil
 		case *gnmi.SubscribeResponse_Update:
-			target := rsp.Update.GetPrefix().GetTarget()
+			prefix := gc.canonicalPrefix(rsp.Update.GetPrefix())
+			target := prefix.GetTarget()
 			if target == "" {
 				gc.logger.Printf("subscription=%q: response missing target: %v", measName, rsp)
-				return
+				gc.countDroppedWrite(measName, dropReasonMissingTarget)
+				return &WriteError{Subscription: measName, Err: ErrMissingTarget}
 			}
 
 			// if the update does not have a prefix path,
@@ -112,31 +346,28 @@ func (gc *gnmiCache) Write(ctx context.Context, measName string, m proto.Message
 				for _, upd := range rsp.Update.GetUpdate() {
 					if len(upd.GetPath().GetElem()) == 0 {
 						gc.logger.Printf("write fail: received an update with en empty path: %v", upd)
-						return
+						gc.countDroppedWrite(measName, dropReasonEmptyPath)
+						return &WriteError{Subscription: measName, Target: target, Err: ErrEmptyPath}
 					}
 				}
 			}
-			gc.m.Lock()
-			sCache, ok := gc.caches[measName]
+			ts, ok := gc.checkSkew(measName, target, rsp.Update.GetTimestamp())
 			if !ok {
-				sCache = &subCache{
-					c:     ocCache.New(nil),
-					match: match.New(),
-				}
-				sCache.c.SetClient(sCache.update)
-				sCache.c.Add(target)
-				gc.logger.Printf("target %q added to local cache %q", target, measName)
-				gc.caches[measName] = sCache
+				return &WriteError{Subscription: measName, Target: target, Err: ErrClockSkew}
 			}
-			if !sCache.c.HasTarget(target) {
-				sCache.c.Add(target)
-				gc.logger.Printf("target %q added to local cache %q", target, measName)
+			sCache, ok := gc.subCache(measName, target)
+			if !ok {
+				gc.logger.Printf("write fail: subscription %q is read-only, target=%q", measName, target)
+				gc.countDroppedWrite(measName, dropReasonReadOnly)
+				return &WriteError{Subscription: measName, Target: target, Err: ErrReadOnly}
+			}
+			if gc.raw != nil {
+				gc.raw.add(measName, target, ts, srsp, gc.subscriptionExpiration(measName))
 			}
-			gc.m.Unlock()
 			// do not write updates with nil values to cache.
 			notif := &gnmi.Notification{
-				Timestamp: rsp.Update.GetTimestamp(),
-				Prefix:    rsp.Update.GetPrefix(),
+				Timestamp: ts,
+				Prefix:    prefix,
 				Update:    make([]*gnmi.Update, 0, len(rsp.Update.GetUpdate())),
 				Delete:    rsp.Update.GetDelete(),
 				Atomic:    rsp.Update.GetAtomic(),
@@ -148,24 +379,392 @@ func (gc *gnmiCache) Write(ctx context.Context, measName string, m proto.Message
 				notif.Update = append(notif.Update, upd)
 			}
 			if len(notif.Update) == 0 && len(notif.Delete) == 0 {
-				return
+				return &WriteError{Subscription: measName, Target: target, Err: ErrEmptyNotification}
 			}
-			err = sCache.c.GnmiUpdate(notif)
-			if err != nil {
-				gc.logger.Printf("failed to update gNMI cache: %v", err)
-				return
+			for _, n := range originPrefixed(notif) {
+				// the deletes are recorded before they are applied, so that
+				// they are forgotten by a more recent value written concurrently.
+				sCache.deleted.add(n)
+				err := gc.update(sCache, n)
+				if err != nil {
+					gc.logger.Printf("failed to update gNMI cache: %v", err)
+					switch {
+					case errors.Is(err, errMaxTargetEntries):
+						gc.countDroppedWrite(measName, dropReasonMaxTargetEntries)
+					case errors.Is(err, errMaxEntries):
+						gc.countDroppedWrite(measName, dropReasonMaxEntries)
+					case errors.Is(err, errMaxSize):
+						gc.countDroppedWrite(measName, dropReasonMaxSize)
+					default:
+						gc.countDroppedWrite(measName, dropReasonRejected)
+					}
+					return &WriteError{Subscription: measName, Target: target, Err: fmt.Errorf("%w: %w", ErrRejected, err)}
+				}
+				if f := gc.onWrite.Load(); f != nil {
+					(*f)(measName, target, n)
+				}
+			}
+			gc.countWrite(measName)
+		}
+	}
+	return nil
+}
+
+// subCache returns the cache of subscription sub, creating it and adding
+// the target to it if needed. It returns false if sub is read-only.
+func (gc *gnmiCache) subCache(sub, target string) (*subCache, bool) {
+	if _, readOnly := gc.readOnly.Load(sub); readOnly {
+		return nil, false
+	}
+	if c, ok := gc.caches.Load(sub); ok && c.(*subCache).c.HasTarget(target) {
+		return c.(*subCache), true
+	}
+	// the subscription cache or the target are missing,
+	// check again once exclusively locked.
+	gc.m.Lock()
+	defer gc.m.Unlock()
+	if _, readOnly := gc.readOnly.Load(sub); readOnly {
+		return nil, false
+	}
+	var sCache *subCache
+	if c, ok := gc.caches.Load(sub); ok {
+		sCache = c.(*subCache)
+	} else {
+		sCache = &subCache{
+			name:    sub,
+			c:       newShardedCache(gc.shards),
+			match:   gc.newMatcher(),
+			onEvict: gc.onEvict,
+			wm:      new(sync.RWMutex),
+		}
+		if gc.subscriptionMaxSize(sub) > 0 {
+			sCache.sizes = make(map[string]map[string]int)
+		}
+		sCache.c.SetClient(sCache.update)
+		gc.caches.Store(sub, sCache)
+	}
+	if !sCache.c.HasTarget(target) {
+		sCache.addTarget(target)
+		gc.logger.Printf("target %q added to local cache %q", target, sub)
+	}
+	return sCache, true
+}
+
+// SetReadOnly marks the subscription `sub` as read-only (or writable).
+// Writes to a read-only subscription are rejected, this allows protecting
+// data injected in the cache (e.g. test fixtures) from being overwritten by
+// a live target reporting the same subscription name.
+func (gc *gnmiCache) SetReadOnly(sub string, readOnly bool) {
+	gc.m.Lock()
+	defer gc.m.Unlock()
+	if readOnly {
+		gc.readOnly.Store(sub, struct{}{})
+		return
+	}
+	gc.readOnly.Delete(sub)
+}
+
+func (gc *gnmiCache) newMatcher() Matcher {
+	if gc.matchFactory != nil {
+		return gc.matchFactory()
+	}
+	return newDefaultMatcher()
+}
+
+// update applies the notification n to the subscription cache.
+// If some on-change subscribers requested the old values, the values cached
+// for the paths touched by n are looked up before n is applied, i.e before
+// the subscribers callbacks are called, and kept in sCache.old until n is applied.
+//
+// The writes to different targets run concurrently, unless they are limited
+// by the subscription max entries or max size.
+//
+// The updates of a non-atomic notification are cached individually, an update that
+// cannot be cached does not prevent the others from being cached, unless the
+// partial update policy is all-or-nothing, in which case the notification is
+// checked before being applied.
+//
+// If the history is enabled, the updates and deletes actually applied are
+// recorded in it, even if some others were rejected.
+func (gc *gnmiCache) update(sCache *subCache, n *gnmi.Notification) error {
+	numUpdates := len(n.GetUpdate())
+	checked := gc.allOrNothing && !n.GetAtomic() && numUpdates > 0 && numUpdates+len(n.GetDelete()) > 1
+	replace := gc.atomicReplace && n.GetAtomic()
+	limited := (gc.maxTargetEntries > 0 || gc.subscriptionLimited(sCache.name)) && numUpdates > 0
+	if !checked && !replace && !limited && gc.history == nil && sCache.oldValueSubs.Load() == 0 {
+		sCache.wm.RLock()
+		defer sCache.wm.RUnlock()
+		return sCache.c.GnmiUpdate(n)
+	}
+	if limited && gc.subscriptionLimited(sCache.name) {
+		sCache.wm.Lock()
+		defer sCache.wm.Unlock()
+	} else {
+		sCache.wm.RLock()
+		defer sCache.wm.RUnlock()
+		tl := sCache.targetLock(n.GetPrefix().GetTarget())
+		tl.Lock()
+		defer tl.Unlock()
+	}
+	if checked {
+		err := checkUpdates(sCache, n)
+		if err != nil {
+			return fmt.Errorf("notification rejected: %w", err)
+		}
+	}
+	if limited {
+		var err error
+		n, err = gc.limitEntries(sCache, n)
+		if err != nil {
+			return err
+		}
+	}
+	if sCache.oldValueSubs.Load() > 0 {
+		target := n.GetPrefix().GetTarget()
+		sCache.old.Store(target, oldValues(sCache, n))
+		defer sCache.old.Delete(target)
+	}
+	if replace {
+		err := gc.deleteSubtree(sCache, n)
+		if err != nil {
+			return fmt.Errorf("failed to delete subtree before atomic replace: %w", err)
+		}
+	}
+	err := sCache.c.GnmiUpdate(n)
+	if gc.history != nil {
+		applied := n
+		if err != nil {
+			applied = appliedUpdates(sCache, n)
+		}
+		if applied != nil {
+			herr := gc.history.add(sCache.name, applied)
+			if herr != nil {
+				gc.logger.Printf("failed to update cache history: %v", herr)
 			}
-			return
 		}
 	}
+	return err
+}
+
+// appliedUpdates returns a notification made of the updates and deletes of n
+// applied to the cache despite the error returned by the cache update, or nil if
+// none was applied. The updates applied are those still cached as is.
+// It must be called with the target lock of n held.
+func appliedUpdates(sCache *subCache, n *gnmi.Notification) *gnmi.Notification {
+	if n.GetAtomic() || len(n.GetUpdate())+len(n.GetDelete()) < 2 ||
+		!sCache.c.HasTarget(n.GetPrefix().GetTarget()) {
+		return nil
+	}
+	applied := &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Delete:    n.GetDelete(),
+	}
+	for _, upd := range n.GetUpdate() {
+		cp, err := path.CompletePath(n.GetPrefix(), upd.GetPath())
+		if err != nil {
+			continue
+		}
+		sCache.c.Query(n.GetPrefix().GetTarget(), cp,
+			func(p []string, _ *ctree.Leaf, v interface{}) error {
+				if cn, ok := v.(*gnmi.Notification); ok && len(p) == len(cp) &&
+					len(cn.GetUpdate()) == 1 && cn.GetUpdate()[0] == upd {
+					applied.Update = append(applied.Update, upd)
+				}
+				return errStopQuery
+			})
+	}
+	if len(applied.Update) == 0 && len(applied.Delete) == 0 {
+		return nil
+	}
+	return applied
+}
+
+// errStopQuery stops a cache query after the first visited leaf.
+var errStopQuery = errors.New("stop query")
+
+// checkUpdates returns an error if one of the updates of n would be rejected
+// by the gNMI cache, i.e if it is older than (or a duplicate of) the cached
+// value of its path, its path is a branch of the cached tree or one of its ancestors is a leaf.
+// It must be called with the target lock of n held.
+func checkUpdates(sCache *subCache, n *gnmi.Notification) error {
+	target := n.GetPrefix().GetTarget()
+	for _, upd := range n.GetUpdate() {
+		cp, err := path.CompletePath(n.GetPrefix(), upd.GetPath())
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= len(cp); i++ {
+			var visited []string
+			var v interface{}
+			err = sCache.c.Query(target, cp[:i],
+				func(p []string, _ *ctree.Leaf, val interface{}) error {
+					visited, v = p, val
+					return errStopQuery
+				})
+			if err != nil && !errors.Is(err, errStopQuery) {
+				return err
+			}
+			if visited == nil {
+				// the path does not exist beyond cp[:i]
+				break
+			}
+			isLeaf := len(visited) == i
+			switch {
+			case isLeaf && i < len(cp):
+				return fmt.Errorf("update %v collides with cached leaf %v", cp, cp[:i])
+			case !isLeaf && i == len(cp):
+				return fmt.Errorf("update %v collides with a cached branch", cp)
+			case isLeaf:
+				old, ok := v.(*gnmi.Notification)
+				if !ok {
+					continue
+				}
+				if n.GetTimestamp() < old.GetTimestamp() {
+					return fmt.Errorf("update %v is older than the cached value", cp)
+				}
+				if n.GetTimestamp() == old.GetTimestamp() && proto.Equal(old, singleUpdate(n, upd)) {
+					return fmt.Errorf("update %v is a duplicate of the cached value: %w", cp, ocCache.ErrStale)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// singleUpdate returns the notification the gNMI cache stores for
+// the update upd of the non-atomic notification n.
+func singleUpdate(n *gnmi.Notification, upd *gnmi.Update) *gnmi.Notification {
+	return &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Update:    []*gnmi.Update{upd},
+	}
+}
+
+// oldValues returns the notifications currently cached
+// under the update and delete paths of n, keyed by leafKey.
+func oldValues(sCache *subCache, n *gnmi.Notification) map[string]*gnmi.Notification {
+	var ps []*gnmi.Path
+	if n.GetAtomic() {
+		ps = []*gnmi.Path{nil}
+	} else {
+		ps = make([]*gnmi.Path, 0, len(n.GetUpdate())+len(n.GetDelete()))
+		for _, upd := range n.GetUpdate() {
+			ps = append(ps, upd.GetPath())
+		}
+		ps = append(ps, n.GetDelete()...)
+	}
+	old := make(map[string]*gnmi.Notification)
+	for _, p := range ps {
+		cp, err := path.CompletePath(n.GetPrefix(), p)
+		if err != nil {
+			continue
+		}
+		sCache.c.Query(n.GetPrefix().GetTarget(), cp,
+			func(_ []string, _ *ctree.Leaf, v interface{}) error {
+				if on, ok := v.(*gnmi.Notification); ok {
+					if k, ok := leafKey(on); ok {
+						old[k] = on
+					}
+				}
+				return nil
+			})
+	}
+	return old
+}
+
+// leafKey returns a key identifying the cache leaf of the single update,
+// single delete or atomic notification n.
+// An update and the delete of the same leaf have the same key.
+func leafKey(n *gnmi.Notification) (string, bool) {
+	target, cp, ok := leafPath(n)
+	if !ok {
+		return "", false
+	}
+	return target + "\x00" + strings.Join(cp, "\x00"), true
+}
+
+// leafPath returns the target and the path of the cache leaf
+// of the single update, single delete or atomic notification n.
+// Like in the cache tree, the path is made of the origin,
+// the path elements names and their keys values.
+func leafPath(n *gnmi.Notification) (string, []string, bool) {
+	var p *gnmi.Path
+	switch {
+	case n.GetAtomic():
+	case len(n.GetUpdate()) == 1:
+		p = n.GetUpdate()[0].GetPath()
+	case len(n.GetDelete()) == 1:
+		p = n.GetDelete()[0]
+	default:
+		return "", nil, false
+	}
+	cp, err := path.CompletePath(n.GetPrefix(), p)
+	if err != nil {
+		return "", nil, false
+	}
+	return n.GetPrefix().GetTarget(), cp, true
+}
+
+// deleteSubtree removes all the leaves cached under the prefix of
+// the atomic notification n, the resulting deletes are sent to the
+// subscribers like any other delete.
+// It is a no-op if the prefix has no path elements.
+func (gc *gnmiCache) deleteSubtree(sCache *subCache, n *gnmi.Notification) error {
+	if len(n.GetPrefix().GetElem()) == 0 {
+		return nil
+	}
+	return sCache.c.GnmiUpdate(&gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix: &gnmi.Path{
+			Origin: n.GetPrefix().GetOrigin(),
+			Target: n.GetPrefix().GetTarget(),
+		},
+		Delete: []*gnmi.Path{{Elem: n.GetPrefix().GetElem()}},
+	})
 }
 
 func (gc *gnmiCache) ReadAll() (map[string][]*gnmi.Notification, error) {
-	return gc.read("", "*", nil), nil
+	return gc.read("", "*", nil)
 }
 
 func (gc *gnmiCache) Read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error) {
-	return gc.read(sub, target, p), nil
+	return gc.read(sub, target, p)
+}
+
+// LatestAll returns the latest notification matching path p for each target
+// of subscription sub (or of all subscriptions if sub is empty).
+// Unlike Read, the caches are queried sequentially in the calling goroutine.
+func (gc *gnmiCache) LatestAll(sub string, p *gnmi.Path) map[string]*gnmi.Notification {
+	if sub == "*" {
+		sub = ""
+	}
+	cp, err := path.CompletePath(p, nil)
+	if err != nil {
+		gc.logger.Printf("failed to generate CompletePath from %v", p)
+		return nil
+	}
+	now := time.Now()
+	latest := make(map[string]*gnmi.Notification)
+	for _, c := range gc.getCaches(sub) {
+		err = c.c.Query("*", cp,
+			func(_ []string, _ *ctree.Leaf, v interface{}) error {
+				notif, ok := v.(*gnmi.Notification)
+				if !ok || !originMatches(p, notif) || gc.expiredLeaf(c, notif, now) {
+					return nil
+				}
+				target := notif.GetPrefix().GetTarget()
+				if l, ok := latest[target]; !ok || notif.GetTimestamp() > l.GetTimestamp() {
+					latest[target] = notif
+				}
+				return nil
+			})
+		if err != nil {
+			gc.logger.Printf("failed cache query:%v", err)
+		}
+	}
+	return latest
 }
 
 func (gc *gnmiCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notification {
@@ -174,7 +773,7 @@ func (gc *gnmiCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notifica
 	}
 
 	ro.setDefaults()
-	ch := make(chan *Notification)
+	ch := make(chan *Notification, ro.channelBufferSize())
 	go gc.subscribe(ctx, ro, ch)
 
 	return ch
@@ -182,18 +781,42 @@ func (gc *gnmiCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notifica
 
 func (gc *gnmiCache) subscribe(ctx context.Context, ro *ReadOpts, ch chan *Notification) {
 	defer close(ch)
+	ro.Target = gc.canonicalTarget(ro.Target)
+	// the subscriptions configured with suppress-redundant
+	// need the last sent values even if ro does not set it.
+	if ro.lastSent == nil {
+		ro.m = new(sync.RWMutex)
+		ro.lastSent = make(map[string]*gnmi.TypedValue)
+	}
+	if ro.ValueFilter != nil {
+		if err := ro.ValueFilter.validate(); err != nil {
+			sendNotification(ctx, ch, &Notification{Err: err})
+			return
+		}
+	}
+	if err := ro.OnFull.validate(); err != nil {
+		sendNotification(ctx, ch, &Notification{Err: err})
+		return
+	}
 	switch ro.Mode {
 	case ReadMode_Once:
-		gc.handleSingleQuery(ctx, ro, ch)
+		query := gc.handleSingleQuery
+		if ro.History {
+			query = gc.handleHistoryQuery
+		}
+		if query(ctx, ro, ch) {
+			sendNotification(ctx, ch, &Notification{SyncResponse: true})
+		}
 	case ReadMode_StreamOnChange: // default:
-		ro.SuppressRedundant = false
 		gc.handleOnChangeQuery(ctx, ro, ch)
 	case ReadMode_StreamSample:
-		gc.handleSampledQuery(ctx, ro, ch)
+		gc.handleSampledQuery(ctx, ro, ch, true)
 	}
 }
 
-func (gc *gnmiCache) handleSingleQuery(ctx context.Context, ro *ReadOpts, ch chan *Notification) {
+// handleSingleQuery sends the values matching ro once,
+// it returns false if the query failed or timed out.
+func (gc *gnmiCache) handleSingleQuery(ctx context.Context, ro *ReadOpts, ch chan *Notification) bool {
 	if gc.debug {
 		gc.logger.Printf("running single query for target %q", ro.Target)
 	}
@@ -203,8 +826,12 @@ func (gc *gnmiCache) handleSingleQuery(ctx context.Context, ro *ReadOpts, ch cha
 	if gc.debug {
 		gc.logger.Printf("single query got %d caches", len(caches))
 	}
+	now := time.Now()
+	qctx, cancel := gc.queryContext(ctx)
+	defer cancel()
 	wg := new(sync.WaitGroup)
 	wg.Add(len(caches))
+	failed := new(atomic.Bool)
 
 	for name, c := range caches {
 		go func(name string, c *subCache) {
@@ -215,87 +842,106 @@ func (gc *gnmiCache) handleSingleQuery(ctx context.Context, ro *ReadOpts, ch cha
 				}
 				return
 			}
+			defer func() {
+				if ctx.Err() == nil && qctx.Err() != nil {
+					gc.logger.Printf("subscription-cache %q: query to target %q timed out after %s", name, ro.Target, gc.queryTimeout)
+					failed.Store(true)
+					sendNotification(ctx, ch, &Notification{Name: name, Err: fmt.Errorf("query timed out: %w", qctx.Err())})
+				}
+			}()
+			suppress := gc.suppressRedundant(ro, name)
+			send := func(n *Notification) { gc.sendValue(qctx, ro, ch, n) }
+			if ro.PathOrdered || ro.Bundle {
+				ordered := make([]*Notification, 0)
+				send = func(n *Notification) { ordered = append(ordered, n) }
+				defer func() {
+					if ro.PathOrdered {
+						sortNotifications(ordered)
+					}
+					if ro.Bundle {
+						ordered = bundleNotifications(name, ordered)
+					}
+					for _, n := range ordered {
+						if !gc.sendValue(qctx, ro, ch, n) {
+							return
+						}
+					}
+				}()
+			}
 			for _, p := range ro.Paths {
 				fp, err := path.CompletePath(p, nil)
 				if err != nil {
 					gc.logger.Printf("failed to generate CompletePath from %v", p)
-					ch <- &Notification{Name: name, Err: err}
+					failed.Store(true)
+					sendNotification(ctx, ch, &Notification{Name: name, Err: err})
 					return
 				}
+				matched := 0
+				start := time.Now()
 				err = c.c.Query(ro.Target, fp,
 					func(_ []string, l *ctree.Leaf, _ interface{}) error {
 						if err != nil {
 							return err
 						}
+						if qctx.Err() != nil {
+							return qctx.Err()
+						}
 						switch gl := l.Value().(type) {
 						case *gnmi.Notification:
+							if !originMatches(p, gl) || gc.expiredLeaf(c, gl, now) || ro.tooOld(gl, now) {
+								return nil
+							}
+							matched++
 							if ro.OverrideTS {
 								// override timestamp
 								gl = proto.Clone(gl).(*gnmi.Notification)
 								gl.Timestamp = time.Now().UnixNano()
 							}
-							//no suppress redundant, send to channel and return
-							if !ro.SuppressRedundant {
-								ch <- &Notification{Name: name, Notification: gl}
-								return nil
-							}
-							// suppress redundant part
-							if ro.lastSent == nil {
-								ro.lastSent = make(map[string]*gnmi.TypedValue)
-								ro.m = new(sync.RWMutex)
-							}
-
-							prefix := gpath.GnmiPathToXPath(gl.GetPrefix(), true)
-							target := gl.GetPrefix().GetTarget()
-							for _, upd := range gl.GetUpdate() {
-								p := gpath.GnmiPathToXPath(upd.GetPath(), true)
-								valXPath := strings.Join([]string{target, prefix, p}, "/")
-								ro.m.RLock()
-								sv, ok := ro.lastSent[valXPath]
-								ro.m.RUnlock()
-								if !ok || !proto.Equal(sv, upd.Val) {
-									ch <- &Notification{
-										Name: name,
-										Notification: &gnmi.Notification{
-											Timestamp: gl.GetTimestamp(),
-											Prefix:    gl.GetPrefix(),
-											Update:    []*gnmi.Update{upd},
-										},
-									}
-									ro.m.Lock()
-									ro.lastSent[valXPath] = upd.Val
-									ro.m.Unlock()
-								}
-							}
-
-							if gl.GetDelete() != nil {
-								ch <- &Notification{
-									Name: name,
-									Notification: &gnmi.Notification{
-										Timestamp: gl.GetTimestamp(),
-										Prefix:    gl.GetPrefix(),
-										Delete:    gl.GetDelete(),
-									},
-								}
+							for _, nn := range ro.toSend(name, gl, suppress) {
+								send(&Notification{Name: name, Notification: nn})
 							}
 							return nil
 						}
 						return nil
 					})
+				gc.observeQuery(name, start)
+				if gc.debug {
+					gc.logQueryPlan(name, ro.Target, p, fp, matched)
+				}
 				if err != nil {
+					if qctx.Err() != nil {
+						return
+					}
 					gc.logger.Printf("target %q failed internal cache query: %v", ro.Target, err)
-					ch <- &Notification{Name: name, Err: err}
+					failed.Store(true)
+					sendNotification(ctx, ch, &Notification{Name: name, Err: err})
 					return
 				}
 			}
 		}(name, c)
 	}
 	wg.Wait()
+	return !failed.Load()
 }
 
-func (gc *gnmiCache) handleSampledQuery(ctx context.Context, ro *ReadOpts, ch chan *Notification) {
-	if !ro.UpdatesOnly {
-		gc.handleSingleQuery(ctx, ro, ch)
+// handleSampledQuery sends the values matching ro every sample interval.
+// If sync is set, a sync marker is sent after the first sample
+// unless it failed, or right away with UpdatesOnly.
+func (gc *gnmiCache) handleSampledQuery(ctx context.Context, ro *ReadOpts, ch chan *Notification, sync bool) {
+	if !ro.UpdatesOnly && !gc.handleSingleQuery(ctx, ro, ch) {
+		sync = false
+	}
+	if sync {
+		sendNotification(ctx, ch, &Notification{SyncResponse: true})
+	}
+	if ro.AlignToClock {
+		select {
+		case <-ctx.Done():
+			gc.logger.Printf("periodic query to target %q stopped: %v", ro.Target, ctx.Err())
+			return
+		case <-time.After(gc.untilBoundary(ro.SampleInterval)):
+			gc.handleSingleQuery(ctx, ro, ch)
+		}
 	}
 
 	ticker := time.NewTicker(ro.SampleInterval)
@@ -328,82 +974,286 @@ func (gc *gnmiCache) handleOnChangeQuery(ctx context.Context, ro *ReadOpts, ch c
 				}
 				return
 			}
+			suppress := gc.suppressRedundant(ro, name)
 			for _, p := range ro.Paths {
 				cp, err := path.CompletePath(p, nil)
 				if err != nil {
 					gc.logger.Printf("failed to generate CompletePath from %v", p)
-					ch <- &Notification{Name: name, Err: err}
+					sendNotification(ctx, ch, &Notification{Name: name, Err: err})
 					return
 				}
 				// handle updates only
 				if !ro.UpdatesOnly {
+					var ordered []*Notification
+					now := time.Now()
+					matched := 0
+					start := time.Now()
 					err = c.c.Query(ro.Target, cp,
 						func(_ []string, l *ctree.Leaf, _ interface{}) error {
 							switch gl := l.Value().(type) {
 							case *gnmi.Notification:
-								ch <- &Notification{Name: name, Notification: gl}
+								if !originMatches(p, gl) || gc.expiredLeaf(c, gl, now) || ro.tooOld(gl, now) {
+									return nil
+								}
+								matched++
+								for _, nn := range ro.toSend(name, gl, suppress) {
+									if ro.PathOrdered {
+										ordered = append(ordered, &Notification{Name: name, Notification: nn})
+										continue
+									}
+									if !gc.sendValue(ctx, ro, ch, &Notification{Name: name, Notification: nn}) {
+										return ctx.Err()
+									}
+								}
 							}
 							return nil
 						})
+					gc.observeQuery(name, start)
+					if gc.debug {
+						gc.logQueryPlan(name, ro.Target, p, cp, matched)
+					}
 					if err != nil {
+						if ctx.Err() != nil {
+							return
+						}
 						gc.logger.Printf("failed to run cache query for target %q and path %q: %v", ro.Target, cp, err)
-						ch <- &Notification{Name: name, Err: err}
+						sendNotification(ctx, ch, &Notification{Name: name, Err: err})
 						return
 					}
+					sortNotifications(ordered)
+					for _, n := range ordered {
+						if !gc.sendValue(ctx, ro, ch, n) {
+							return
+						}
+					}
 				}
 				// main on-change subscription
 				fp := make([]string, 0, len(cp)+1)
 				fp = append(fp, ro.Target)
 				fp = append(fp, cp...)
 				// set callback
-				mc := &matchClient{name: name, ch: ch}
+				mc := &matchClient{gc: gc, ctx: ctx, name: name, ch: ch, query: p, ro: ro, suppress: suppress}
+				if ro.IncludeOldValue {
+					mc.sc = c
+					c.oldValueSubs.Add(1)
+					defer c.oldValueSubs.Add(-1)
+				}
 				remove := c.match.AddQuery(fp, mc)
 				defer remove()
-
-				// handle on-change heartbeat
-				if ro.HeartbeatInterval > 0 {
-					// run a sampled query using heartbeat interval as sample interval
-					gc.handleSampledQuery(ctx, &ReadOpts{
-						Subscription:   ro.Subscription,
-						Target:         ro.Target,
-						Paths:          ro.Paths,
-						Mode:           ReadMode_StreamSample,
-						SampleInterval: ro.HeartbeatInterval,
-						OverrideTS:     ro.OverrideTS,
-					}, ch)
-				}
-			}
-
-			for range ctx.Done() {
 			}
+			// keep the queries registered until the subscription is canceled.
+			<-ctx.Done()
 		}(name, c)
 	}
+	// handle on-change heartbeat
+	if ro.HeartbeatInterval > 0 {
+		// run a sampled query using heartbeat interval as sample interval
+		gc.handleSampledQuery(ctx, &ReadOpts{
+			Subscription:   ro.Subscription,
+			Target:         ro.Target,
+			Paths:          ro.Paths,
+			Mode:           ReadMode_StreamSample,
+			SampleInterval: ro.HeartbeatInterval,
+			OverrideTS:     ro.OverrideTS,
+			MaxAge:         ro.MaxAge,
+			ValueFilter:    ro.ValueFilter,
+			// heartbeats resend the cached values regardless
+			// of the subscription suppress-redundant setting.
+			KeepRedundant: true,
+			OnFull:        ro.OnFull,
+			dropped:       ro.dropped,
+		}, ch, false)
+	}
 	wg.Wait()
 }
 
-func (gc *gnmiCache) Stop() {}
+// untilBoundary returns the duration until the next multiple of interval.
+func (gc *gnmiCache) untilBoundary(interval time.Duration) time.Duration {
+	now := gc.clock()
+	next := now.Truncate(interval)
+	if next.Before(now) {
+		next = next.Add(interval)
+	}
+	return next.Sub(now)
+}
 
-func (gc *gnmiCache) read(sub, target string, p *gnmi.Path) map[string][]*gnmi.Notification {
-	notificationChan := make(chan *Notification)
-	notifications := make(map[string][]*gnmi.Notification, 0)
-	doneCh := make(chan struct{})
-	// this go routine will collect all the notifications
-	// from the cache queries
-	go func() {
-		for nn := range notificationChan {
-			if _, ok := notifications[nn.Name]; !ok {
-				notifications[nn.Name] = make([]*gnmi.Notification, 0)
-			}
-			notifications[nn.Name] = append(notifications[nn.Name], nn.Notification)
+// suppressRedundant reports whether the redundant updates
+// of the subscription sub are suppressed for the read ro.
+func (gc *gnmiCache) suppressRedundant(ro *ReadOpts, sub string) bool {
+	// the samples of an updates-only sample read
+	// only carry the values changed since the previous one.
+	if ro.SuppressRedundant || (ro.UpdatesOnly && ro.Mode == ReadMode_StreamSample) {
+		return true
+	}
+	return !ro.KeepRedundant && gc.subSuppress[sub]
+}
+
+// toSend returns the notifications sent to a subscriber for
+// the cached notification n of the subscription sub.
+func (ro *ReadOpts) toSend(sub string, n *gnmi.Notification, suppress bool) []*gnmi.Notification {
+	if n = ro.filterValues(n); n == nil {
+		return nil
+	}
+	// the redundant updates are suppressed per update,
+	// which also collapses the atomic notifications.
+	if suppress {
+		return ro.nonRedundant(sub, n)
+	}
+	if ro.CollapseAtomic && n.GetAtomic() {
+		return collapseAtomic(n)
+	}
+	return []*gnmi.Notification{n}
+}
+
+// collapseAtomic splits the atomic notification n into
+// a non-atomic notification per update, followed by the deletes if any.
+func collapseAtomic(n *gnmi.Notification) []*gnmi.Notification {
+	nns := make([]*gnmi.Notification, 0, len(n.GetUpdate())+1)
+	for _, upd := range n.GetUpdate() {
+		nns = append(nns, &gnmi.Notification{
+			Timestamp: n.GetTimestamp(),
+			Prefix:    n.GetPrefix(),
+			Update:    []*gnmi.Update{upd},
+		})
+	}
+	if len(n.GetDelete()) > 0 {
+		nns = append(nns, &gnmi.Notification{
+			Timestamp: n.GetTimestamp(),
+			Prefix:    n.GetPrefix(),
+			Delete:    n.GetDelete(),
+		})
+	}
+	return nns
+}
+
+// nonRedundant splits n into a notification per update, skipping the updates
+// with the same value as the last one sent for their path and subscription sub.
+// The deletes are sent as is and reset the last sent values of their paths.
+func (ro *ReadOpts) nonRedundant(sub string, n *gnmi.Notification) []*gnmi.Notification {
+	prefix := gpath.GnmiPathToXPath(n.GetPrefix(), false)
+	target := n.GetPrefix().GetTarget()
+	valXPath := func(p *gnmi.Path) string {
+		return strings.Join([]string{sub, target, prefix, gpath.GnmiPathToXPath(p, false)}, "/")
+	}
+	nns := make([]*gnmi.Notification, 0, len(n.GetUpdate())+1)
+	ro.m.Lock()
+	defer ro.m.Unlock()
+	for _, upd := range n.GetUpdate() {
+		k := valXPath(upd.GetPath())
+		if sv, ok := ro.lastSent[k]; ok && proto.Equal(sv, upd.GetVal()) {
+			continue
 		}
-		close(doneCh)
-	}()
+		ro.lastSent[k] = upd.GetVal()
+		nns = append(nns, &gnmi.Notification{
+			Timestamp: n.GetTimestamp(),
+			Prefix:    n.GetPrefix(),
+			Update:    []*gnmi.Update{upd},
+		})
+	}
+	if len(n.GetDelete()) > 0 {
+		for _, del := range n.GetDelete() {
+			delete(ro.lastSent, valXPath(del))
+		}
+		nns = append(nns, &gnmi.Notification{
+			Timestamp: n.GetTimestamp(),
+			Prefix:    n.GetPrefix(),
+			Delete:    n.GetDelete(),
+		})
+	}
+	return nns
+}
+
+// logQueryPlan logs how a query path was run against a subscription cache:
+// the input path, the complete path queried and the number of matched leaves.
+func (gc *gnmiCache) logQueryPlan(sub, target string, p *gnmi.Path, cp []string, matched int) {
+	gc.logger.Printf("query plan: subscription-cache=%q target=%q path=%q complete-path=%q matched-leaves=%d",
+		sub, target, gpath.GnmiPathToXPath(p, false), cp, matched)
+}
+
+// queryContext returns a context derived from ctx,
+// canceled once the query timeout elapsed, if any.
+func (gc *gnmiCache) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if gc.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, gc.queryTimeout)
+}
+
+// sendNotification sends n to ch unless ctx is done first,
+// it returns false if n was not sent.
+func sendNotification(ctx context.Context, ch chan<- *Notification, n *Notification) bool {
+	select {
+	case ch <- n:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendValue sends the value notification n to ch according
+// to the ro.OnFull policy, it returns false if n was not sent
+// because ctx is done.
+func (gc *gnmiCache) sendValue(ctx context.Context, ro *ReadOpts, ch chan *Notification, n *Notification) bool {
+	return sendWithPolicy(ctx, ch, n, ro.OnFull, func(d *Notification) {
+		ro.dropped.Add(1)
+		if gc.debug {
+			gc.logger.Printf("subscription-cache %q: channel full, dropped notification for target %q (%s)",
+				d.Name, d.Notification.GetPrefix().GetTarget(), ro.OnFull)
+		}
+	})
+}
+
+// Stop stops the expired leaves sweeper and the periodic snapshots.
+// If the cache is persisted, it returns once its last snapshot is saved.
+func (gc *gnmiCache) Stop() {
+	gc.stopOnce.Do(func() { close(gc.stop) })
+	cacheGauges.caches.Delete(gc)
+	if gc.saved != nil {
+		<-gc.saved
+	}
+}
+
+// read queries the subscription caches for path p under target.
+// It returns ErrSubscriptionNotFound or ErrTargetNotFound if the
+// subscription or the target are not known to the cache,
+// an empty result with a nil error means the path matched nothing.
+// If the query timeout elapses, it returns the notifications collected so far
+// and a context.DeadlineExceeded error.
+func (gc *gnmiCache) read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error) {
 	if sub == "*" {
 		sub = ""
 	}
+	target = gc.canonicalTarget(target)
 	now := time.Now()
-	wg := new(sync.WaitGroup)
 	caches := gc.getCaches(sub)
+	if sub != "" && len(caches) == 0 {
+		return nil, ErrSubscriptionNotFound
+	}
+	if target != "" && target != "*" {
+		for name, c := range caches {
+			if !c.c.HasTarget(target) {
+				delete(caches, name)
+			}
+		}
+		if len(caches) == 0 {
+			if gc.debug {
+				gc.logger.Printf("query plan: no subscription-cache has target %q", target)
+			}
+			return nil, ErrTargetNotFound
+		}
+	}
+	if gc.debug {
+		gc.logger.Printf("query plan: path %q consults %d subscription-cache(s)", gpath.GnmiPathToXPath(p, false), len(caches))
+	}
+	ctx, cancel := gc.queryContext(context.Background())
+	defer cancel()
+	// mu protects the notifications, the errors and done,
+	// set once the read returns.
+	mu := new(sync.Mutex)
+	notifications := make(map[string][]*gnmi.Notification, 0)
+	var errs []error
+	var done bool
+	wg := new(sync.WaitGroup)
 	wg.Add(len(caches))
 
 	for name, c := range caches {
@@ -412,80 +1262,480 @@ func (gc *gnmiCache) read(sub, target string, p *gnmi.Path) map[string][]*gnmi.N
 			cp, err := path.CompletePath(p, nil)
 			if err != nil {
 				gc.logger.Printf("failed to generate CompletePath from %v", p)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("subscription %q: %w", name, err))
+				mu.Unlock()
 				return
 			}
+			matched := 0
+			start := time.Now()
 			err = c.c.Query(target, cp,
 				func(_ []string, _ *ctree.Leaf, v interface{}) error {
 					if err != nil {
 						return err
 					}
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
 					switch notif := v.(type) {
 					case *gnmi.Notification:
-						if gc.expiration > 0 &&
-							time.Unix(0, notif.Timestamp).Before(now.Add(time.Duration(-gc.expiration))) {
+						if !originMatches(p, notif) || gc.expiredLeaf(c, notif, now) {
 							return nil
 						}
-						notificationChan <- &Notification{
-							Name:         name,
-							Notification: notif,
+						matched++
+						mu.Lock()
+						defer mu.Unlock()
+						if done {
+							return ctx.Err()
 						}
+						notifications[name] = append(notifications[name], notif)
 					}
 					return nil
 				})
+			if err == nil {
+				// only this goroutine appends the notifications of name.
+				mu.Lock()
+				values := notifications[name]
+				mu.Unlock()
+				deletes := gc.readDeleted(c, target, p, cp, values, now)
+				matched += len(deletes)
+				mu.Lock()
+				if !done && len(deletes) > 0 {
+					notifications[name] = append(notifications[name], deletes...)
+				}
+				mu.Unlock()
+			}
+			gc.observeQuery(name, start)
+			if gc.debug {
+				gc.logQueryPlan(name, target, p, cp, matched)
+			}
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				gc.logger.Printf("failed cache query:%v", err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("subscription %q: %w", name, err))
+				mu.Unlock()
 				return
 			}
 		}(c, name)
 	}
-	wg.Wait()
-	close(notificationChan)
-	// wait for notifications to be appended to the array
-	<-doneCh
-	return notifications
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	if err := ctx.Err(); err != nil {
+		gc.logger.Printf("query for path %q timed out after %s", gpath.GnmiPathToXPath(p, false), gc.queryTimeout)
+		errs = append(errs, fmt.Errorf("query timed out: %w", err))
+	}
+	return notifications, errors.Join(errs...)
 }
 
-func (gc *gnmiCache) getCaches(names ...string) map[string]*subCache {
-	gc.m.Lock()
-	defer gc.m.Unlock()
+// expired returns true if the notification n, cached under subscription sub,
+// is older than the subscription's expiration.
+func (gc *gnmiCache) expired(sub string, n *gnmi.Notification, now time.Time) bool {
+	exp := gc.subscriptionExpiration(sub)
+	return exp > 0 && time.Unix(0, n.GetTimestamp()).Before(now.Add(-exp))
+}
+
+// tooOld returns true if the notification n is older than the read MaxAge.
+func (ro *ReadOpts) tooOld(n *gnmi.Notification, now time.Time) bool {
+	return ro.MaxAge > 0 && time.Unix(0, n.GetTimestamp()).Before(now.Add(-ro.MaxAge))
+}
+
+// subscriptionExpiration returns the expiration of subscription sub.
+func (gc *gnmiCache) subscriptionExpiration(sub string) time.Duration {
+	if exp, ok := gc.subExpiration[sub]; ok {
+		return exp
+	}
+	return gc.expiration
+}
+
+// expiredLeaf is like expired for a notification read from the cache tree
+// of sCache, it calls the eviction callback if the notification is expired.
+// Expired leaves are kept in the cache until they are removed by the sweeper,
+// overwritten or deleted, the callback is only called the first time
+// they are skipped by a read.
+func (gc *gnmiCache) expiredLeaf(sCache *subCache, n *gnmi.Notification, now time.Time) bool {
+	if !gc.expired(sCache.name, n, now) {
+		return false
+	}
+	if gc.onEvict == nil {
+		return true
+	}
+	k, ok := leafKey(n)
+	if !ok {
+		return true
+	}
+	sCache.em.Lock()
+	if ts, ok := sCache.reported[k]; ok && ts == n.GetTimestamp() {
+		sCache.em.Unlock()
+		return true
+	}
+	if sCache.reported == nil {
+		sCache.reported = make(map[string]int64)
+	}
+	sCache.reported[k] = n.GetTimestamp()
+	sCache.em.Unlock()
+	gc.onEvict(sCache.name, n.GetPrefix().GetTarget(), notificationXPath(n))
+	return true
+}
+
+// takeReported removes the leaf with key k from the leaves
+// reported as expired, it returns true if it was reported.
+func (sc *subCache) takeReported(k string) bool {
+	sc.em.Lock()
+	defer sc.em.Unlock()
+	_, ok := sc.reported[k]
+	delete(sc.reported, k)
+	return ok
+}
+
+// forgetReported removes the leaves with a key starting with
+// prefix from the leaves reported as expired.
+func (sc *subCache) forgetReported(prefix string) {
+	sc.em.Lock()
+	defer sc.em.Unlock()
+	for k := range sc.reported {
+		if strings.HasPrefix(k, prefix) {
+			delete(sc.reported, k)
+		}
+	}
+}
 
+func (gc *gnmiCache) getCaches(names ...string) map[string]*subCache {
 	caches := make(map[string]*subCache)
 	numCaches := len(names)
 	if numCaches == 0 || (numCaches == 1 && names[0] == "") {
-		for n, c := range gc.caches {
-			caches[n] = c
-		}
+		gc.caches.Range(func(k, v any) bool {
+			caches[k.(string)] = v.(*subCache)
+			return true
+		})
 		return caches
 	}
 	for _, n := range names {
-		if c, ok := gc.caches[n]; ok {
-			caches[n] = c
+		if c, ok := gc.caches.Load(n); ok {
+			caches[n] = c.(*subCache)
 		}
 	}
 	return caches
 }
 
+// ListTargets returns the sorted names of the targets cached
+// by each subscription cache.
+func (gc *gnmiCache) ListTargets() map[string][]string {
+	caches := gc.getCaches()
+	targets := make(map[string][]string, len(caches))
+	for name, c := range caches {
+		md := c.c.Metadata()
+		ts := make([]string, 0, len(md))
+		for t := range md {
+			ts = append(ts, t)
+		}
+		sort.Strings(ts)
+		targets[name] = ts
+	}
+	return targets
+}
+
 func (gc *gnmiCache) DeleteTarget(name string) {
+	gc.DeleteTargetReport(name)
+}
+
+// DeleteTargetReport deletes the target from the cache by name,
+// a name ending with `*` deletes all the targets starting with the name prefix.
+// It returns, for each subscription, whether a target was deleted from it.
+func (gc *gnmiCache) DeleteTargetReport(name string) map[string]bool {
 	caches := gc.getCaches()
-	for _, c := range caches {
-		c.c.Remove(name)
+	report := make(map[string]bool, len(caches))
+	deleted := make(map[string]struct{})
+	name = gc.canonicalTarget(name)
+	if !strings.HasSuffix(name, "*") {
+		deleted[name] = struct{}{}
+	}
+	for sub, c := range caches {
+		report[sub] = false
+		for _, target := range matchingTargets(c, name) {
+			if gc.onEvict != nil {
+				c.c.Query(target, []string{"*"},
+					func(_ []string, _ *ctree.Leaf, v interface{}) error {
+						if n, ok := v.(*gnmi.Notification); ok {
+							gc.onEvict(sub, target, notificationXPath(n))
+						}
+						return nil
+					})
+			}
+			gc.m.Lock()
+			c.removeTarget(target)
+			gc.m.Unlock()
+			report[sub] = true
+			deleted[target] = struct{}{}
+		}
+	}
+	for target := range deleted {
+		if gc.history != nil {
+			gc.history.deleteTarget(target)
+		}
+		if gc.raw != nil {
+			gc.raw.deleteTarget(target)
+		}
+	}
+	return report
+}
+
+// matchingTargets returns the targets of the subscription cache c
+// named name, or starting with its prefix if name ends with `*`.
+func matchingTargets(c *subCache, name string) []string {
+	prefix, ok := strings.CutSuffix(name, "*")
+	if !ok {
+		if c.c.GetTarget(name) == nil {
+			return nil
+		}
+		return []string{name}
+	}
+	var targets []string
+	for target := range c.c.Metadata() {
+		if strings.HasPrefix(target, prefix) {
+			targets = append(targets, target)
+		}
 	}
+	return targets
+}
+
+// DeletePath deletes the leaves of the target matching the path p
+// from the subscription sub, or from all the subscriptions if sub is empty or `*`.
+// The on-change subscribers receive a delete notification per deleted leaf.
+func (gc *gnmiCache) DeletePath(sub, target string, p *gnmi.Path) error {
+	if len(p.GetElem()) == 0 {
+		return errors.New("cannot delete an empty path, use DeleteTarget")
+	}
+	if sub == "*" {
+		sub = ""
+	}
+	target = gc.canonicalTarget(target)
+	caches := gc.getCaches(sub)
+	if sub != "" && len(caches) == 0 {
+		return ErrSubscriptionNotFound
+	}
+	n := &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix: &gnmi.Path{
+			Origin: p.GetOrigin(),
+			Target: target,
+		},
+		Delete: []*gnmi.Path{{Elem: p.GetElem()}},
+	}
+	var found bool
+	var errs []error
+	for name, c := range caches {
+		if !c.c.HasTarget(target) {
+			continue
+		}
+		found = true
+		c.deleted.add(n)
+		// the history of the deleted paths is removed by update.
+		err := gc.update(c, n)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscription %q: %w", name, err))
+		}
+	}
+	if !found {
+		return ErrTargetNotFound
+	}
+	return errors.Join(errs...)
+}
+
+// originMatches returns true if the origin of the cached notification n
+// matches the origin of the query path q.
+// In the cache tree, the origin is stored as the first path element,
+// a query with an origin would otherwise match the leaves without origin
+// whose first element has the same name, and vice versa.
+// A query without origin nor path elements matches all origins,
+// a query with the `*` origin matches all the origins, and the values without
+// origin only if it has no path elements: its first element matches an origin.
+func originMatches(q *gnmi.Path, n *gnmi.Notification) bool {
+	switch q.GetOrigin() {
+	case "*":
+		return len(q.GetElem()) == 0 || notificationOrigin(n) != ""
+	case "":
+		return len(q.GetElem()) == 0 || notificationOrigin(n) == ""
+	default:
+		return notificationOrigin(n) == q.GetOrigin()
+	}
+}
+
+// notificationOrigin returns the origin of the cached notification n,
+// set either in its prefix or in the path of its update or delete.
+func notificationOrigin(n *gnmi.Notification) string {
+	if o := n.GetPrefix().GetOrigin(); o != "" {
+		return o
+	}
+	switch {
+	case len(n.GetUpdate()) > 0:
+		return n.GetUpdate()[0].GetPath().GetOrigin()
+	case len(n.GetDelete()) > 0:
+		return n.GetDelete()[0].GetOrigin()
+	}
+	return ""
+}
+
+// hasPathOrigin returns true if an update or delete path of n has an origin.
+func hasPathOrigin(n *gnmi.Notification) bool {
+	for _, upd := range n.GetUpdate() {
+		if upd.GetPath().GetOrigin() != "" {
+			return true
+		}
+	}
+	for _, del := range n.GetDelete() {
+		if del.GetOrigin() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// originPrefixed returns n with the origin of its update and delete paths
+// moved to its prefix, the gNMI cache only keys the leaves by the prefix origin.
+// A non-atomic notification with several path origins is split per origin.
+// n is returned as is if its prefix has an origin or elements, or if none
+// of its paths has one, as well as if it is atomic with several path origins.
+func originPrefixed(n *gnmi.Notification) []*gnmi.Notification {
+	if n.GetPrefix().GetOrigin() != "" || len(n.GetPrefix().GetElem()) > 0 || !hasPathOrigin(n) {
+		return []*gnmi.Notification{n}
+	}
+	var origins []string
+	byOrigin := make(map[string]*gnmi.Notification)
+	get := func(origin string) *gnmi.Notification {
+		on, ok := byOrigin[origin]
+		if !ok {
+			prefix := proto.Clone(n.GetPrefix()).(*gnmi.Path)
+			if prefix == nil {
+				prefix = new(gnmi.Path)
+			}
+			prefix.Origin = origin
+			on = &gnmi.Notification{Timestamp: n.GetTimestamp(), Prefix: prefix, Atomic: n.GetAtomic()}
+			byOrigin[origin] = on
+			origins = append(origins, origin)
+		}
+		return on
+	}
+	// withoutOrigin returns a copy of p without origin.
+	withoutOrigin := func(p *gnmi.Path) *gnmi.Path {
+		if p.GetOrigin() == "" {
+			return p
+		}
+		p = proto.Clone(p).(*gnmi.Path)
+		p.Origin = ""
+		return p
+	}
+	for _, upd := range n.GetUpdate() {
+		on := get(upd.GetPath().GetOrigin())
+		on.Update = append(on.Update, &gnmi.Update{
+			Path:       withoutOrigin(upd.GetPath()),
+			Val:        upd.GetVal(),
+			Duplicates: upd.GetDuplicates(),
+		})
+	}
+	for _, del := range n.GetDelete() {
+		on := get(del.GetOrigin())
+		on.Delete = append(on.Delete, withoutOrigin(del))
+	}
+	if n.GetAtomic() && len(origins) > 1 {
+		return []*gnmi.Notification{n}
+	}
+	ns := make([]*gnmi.Notification, 0, len(origins))
+	for _, origin := range origins {
+		ns = append(ns, byOrigin[origin])
+	}
+	return ns
+}
+
+// isTargetRemoval returns true if n is the notification
+// sent by the gNMI cache when a target is removed.
+func isTargetRemoval(n *gnmi.Notification) bool {
+	return len(n.GetPrefix().GetElem()) == 0 && len(n.GetDelete()) == 1 &&
+		len(n.GetDelete()[0].GetElem()) == 1 && n.GetDelete()[0].GetElem()[0].GetName() == "*"
+}
+
+// sortNotifications sorts the notifications by target,
+// then by the xpath of their first update or delete.
+func sortNotifications(ns []*Notification) {
+	sort.SliceStable(ns, func(i, j int) bool {
+		ti := ns[i].Notification.GetPrefix().GetTarget()
+		tj := ns[j].Notification.GetPrefix().GetTarget()
+		if ti != tj {
+			return ti < tj
+		}
+		return notificationXPath(ns[i].Notification) < notificationXPath(ns[j].Notification)
+	})
+}
+
+// notificationXPath returns the xpath made of the notification prefix
+// and the path of its first update, or its first delete.
+func notificationXPath(n *gnmi.Notification) string {
+	var p *gnmi.Path
+	switch {
+	case len(n.GetUpdate()) > 0:
+		p = n.GetUpdate()[0].GetPath()
+	case len(n.GetDelete()) > 0:
+		p = n.GetDelete()[0]
+	}
+	return gpath.GnmiPathToXPath(&gnmi.Path{
+		Origin: n.GetPrefix().GetOrigin(),
+		Elem:   gpath.PathElems(n.GetPrefix(), p),
+	}, false)
 }
 
 // match client
 type matchClient struct {
+	gc   *gnmiCache
+	ctx  context.Context
 	name string
 	ch   chan *Notification
+	// query path, used to filter the notifications by origin.
+	query *gnmi.Path
+	// set if the subscriber requested the old values.
+	sc *subCache
+	ro *ReadOpts
+	// set if the redundant updates are suppressed.
+	suppress bool
 }
 
+// Update is called by the subscription cache while a notification
+// is being applied, i.e with sc.wm held, and with the target lock held
+// if sc.old is set.
 func (m *matchClient) Update(n interface{}) {
 	switch n := n.(type) {
 	case *ctree.Leaf:
 		switch v := n.Value().(type) {
 		case *gnmi.Notification:
-			m.ch <- &Notification{
-				Name:         m.name,
-				Notification: v,
+			if !originMatches(m.query, v) {
+				return
+			}
+			if len(v.GetDelete()) == 0 && m.ro.tooOld(v, time.Now()) {
+				return
+			}
+			var old *gnmi.Notification
+			if m.sc != nil {
+				if k, ok := leafKey(v); ok {
+					old = m.sc.oldValue(v.GetPrefix().GetTarget(), k)
+				}
+			}
+			for _, nn := range m.ro.toSend(m.name, v, m.suppress) {
+				// do not block the cache writes if
+				// the subscriber is gone.
+				if !m.gc.sendValue(m.ctx, m.ro, m.ch, &Notification{Name: m.name, Notification: nn, OldValue: old}) {
+					return
+				}
 			}
 		}
 	}
@@ -0,0 +1,51 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"fmt"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// validateTargetAliases checks that the canonical names are not aliases themselves,
+// so that a target name is rewritten at most once.
+func validateTargetAliases(aliases map[string]string) error {
+	for name, canonical := range aliases {
+		if name == "" || canonical == "" {
+			return fmt.Errorf("target-aliases: empty target name in %q: %q", name, canonical)
+		}
+		if _, ok := aliases[canonical]; ok && canonical != name {
+			return fmt.Errorf("target-aliases: %q is an alias of %q and has an alias itself", name, canonical)
+		}
+	}
+	return nil
+}
+
+// canonicalTarget returns the name target is cached under,
+// its alias if one is configured, the target itself otherwise.
+func (gc *gnmiCache) canonicalTarget(target string) string {
+	if canonical, ok := gc.targetAliases[target]; ok {
+		return canonical
+	}
+	return target
+}
+
+// canonicalPrefix returns prefix with its target replaced by the canonical one,
+// prefix is copied if the target is changed.
+func (gc *gnmiCache) canonicalPrefix(prefix *gnmi.Path) *gnmi.Path {
+	canonical := gc.canonicalTarget(prefix.GetTarget())
+	if canonical == prefix.GetTarget() {
+		return prefix
+	}
+	prefix = proto.Clone(prefix).(*gnmi.Path)
+	prefix.Target = canonical
+	return prefix
+}
@@ -0,0 +1,76 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"github.com/openconfig/gnmi/proto/gnmi"
+
+	gpath "github.com/openconfig/gnmic/pkg/path"
+)
+
+// Bundle coalesces the notifications sharing the same target and prefix
+// into a single notification carrying all their updates and deletes,
+// timestamped with the newest of their timestamps.
+// The atomic notifications are not merged.
+// The bundles are returned in the order of their first notification,
+// the notifications in ns are not modified.
+func Bundle(ns []*gnmi.Notification) []*gnmi.Notification {
+	bundles := make([]*gnmi.Notification, 0, len(ns))
+	// index of the bundle of each target and prefix
+	index := make(map[string]int)
+	// set if the bundle at that index was copied from its first notification
+	copied := make(map[int]bool)
+	for _, n := range ns {
+		if n == nil {
+			continue
+		}
+		if n.GetAtomic() {
+			bundles = append(bundles, n)
+			continue
+		}
+		k := n.GetPrefix().GetTarget() + "/" + gpath.GnmiPathToXPath(n.GetPrefix(), false)
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(bundles)
+			bundles = append(bundles, n)
+			continue
+		}
+		b := bundles[i]
+		if !copied[i] {
+			b = &gnmi.Notification{
+				Timestamp: b.GetTimestamp(),
+				Prefix:    b.GetPrefix(),
+				Update:    append([]*gnmi.Update(nil), b.GetUpdate()...),
+				Delete:    append([]*gnmi.Path(nil), b.GetDelete()...),
+			}
+			bundles[i] = b
+			copied[i] = true
+		}
+		if n.GetTimestamp() > b.Timestamp {
+			b.Timestamp = n.GetTimestamp()
+		}
+		b.Update = append(b.Update, n.GetUpdate()...)
+		b.Delete = append(b.Delete, n.GetDelete()...)
+	}
+	return bundles
+}
+
+// bundleNotifications bundles the notifications of the subscription cache sub.
+func bundleNotifications(sub string, ns []*Notification) []*Notification {
+	gns := make([]*gnmi.Notification, 0, len(ns))
+	for _, n := range ns {
+		gns = append(gns, n.Notification)
+	}
+	gns = Bundle(gns)
+	bundled := make([]*Notification, 0, len(gns))
+	for _, n := range gns {
+		bundled = append(bundled, &Notification{Name: sub, Notification: n})
+	}
+	return bundled
+}
@@ -0,0 +1,71 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// Clear removes all the subscription caches, along with their history
+// and raw responses. The next write to a subscription creates its cache again.
+func (gc *gnmiCache) Clear() {
+	gc.m.Lock()
+	caches := gc.getCaches()
+	for name := range caches {
+		gc.caches.Delete(name)
+	}
+	gc.m.Unlock()
+	for name, c := range caches {
+		gc.clearSubCache(name, c)
+	}
+	if gc.history != nil {
+		gc.history.clear()
+	}
+	if gc.raw != nil {
+		gc.raw.clear()
+	}
+}
+
+// ClearSubscription removes the cache of subscription name, along with its history
+// and raw responses. The next write to the subscription creates its cache again.
+func (gc *gnmiCache) ClearSubscription(name string) {
+	gc.m.Lock()
+	c, ok := gc.caches.LoadAndDelete(name)
+	gc.m.Unlock()
+	if ok {
+		gc.clearSubCache(name, c.(*subCache))
+	}
+	if gc.history != nil {
+		gc.history.deleteSubscription(name)
+	}
+	if gc.raw != nil {
+		gc.raw.deleteSubscription(name)
+	}
+}
+
+// clearSubCache removes the targets of the subscription cache c,
+// once it is no longer reachable from gc.caches.
+// The on-change subscribers of the subscription receive the target removals,
+// they do not receive the values written once its cache is created again.
+func (gc *gnmiCache) clearSubCache(name string, c *subCache) {
+	md := c.c.Metadata()
+	for target := range md {
+		if gc.onEvict != nil {
+			c.c.Query(target, []string{"*"},
+				func(_ []string, _ *ctree.Leaf, v interface{}) error {
+					if n, ok := v.(*gnmi.Notification); ok {
+						gc.onEvict(name, target, notificationXPath(n))
+					}
+					return nil
+				})
+		}
+		c.removeTarget(target)
+	}
+}
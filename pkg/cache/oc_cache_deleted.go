@@ -0,0 +1,212 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/path"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// deletedLeaves keeps the delete notifications written to a subscription
+// cache, so that the reads return them along with the cached values.
+// A delete is forgotten once its path is written again with a more recent
+// timestamp, once its target is removed, or once it expires.
+// The leaves removed as expired or evicted are not recorded.
+type deletedLeaves struct {
+	m sync.Mutex
+	// deletes keyed by path, in a map keyed by target name.
+	leaves map[string]map[string]*deletedLeaf
+}
+
+type deletedLeaf struct {
+	// deleted path, made of the origin, the path elements
+	// names and their keys values, like in the cache tree.
+	path []string
+	n    *gnmi.Notification
+}
+
+// add records the deletes of the notification n.
+func (d *deletedLeaves) add(n *gnmi.Notification) {
+	if len(n.GetDelete()) == 0 {
+		return
+	}
+	target := n.GetPrefix().GetTarget()
+	d.m.Lock()
+	defer d.m.Unlock()
+	for _, del := range n.GetDelete() {
+		cp, err := path.CompletePath(n.GetPrefix(), del)
+		if err != nil {
+			continue
+		}
+		dn := n
+		if len(n.GetUpdate()) > 0 || len(n.GetDelete()) > 1 {
+			dn = &gnmi.Notification{
+				Timestamp: n.GetTimestamp(),
+				Prefix:    n.GetPrefix(),
+				Delete:    []*gnmi.Path{del},
+			}
+		}
+		if d.leaves == nil {
+			d.leaves = make(map[string]map[string]*deletedLeaf)
+		}
+		if d.leaves[target] == nil {
+			d.leaves[target] = make(map[string]*deletedLeaf)
+		}
+		d.leaves[target][strings.Join(cp, "\x00")] = &deletedLeaf{path: cp, n: dn}
+	}
+}
+
+// written forgets the deletes of the leaf written by the notification n,
+// made of a single update, if they are older than n.
+// An atomic notification overwrites the deletes under its prefix.
+func (d *deletedLeaves) written(n *gnmi.Notification) {
+	target := n.GetPrefix().GetTarget()
+	d.m.Lock()
+	_, ok := d.leaves[target]
+	d.m.Unlock()
+	if !ok {
+		return
+	}
+	_, cp, ok := leafPath(n)
+	if !ok {
+		return
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	leaves := d.leaves[target]
+	if n.GetAtomic() {
+		for k, l := range leaves {
+			if pathMatches(cp, l.path) && l.n.GetTimestamp() <= n.GetTimestamp() {
+				delete(leaves, k)
+			}
+		}
+	} else {
+		k := strings.Join(cp, "\x00")
+		if l, ok := leaves[k]; ok && l.n.GetTimestamp() <= n.GetTimestamp() {
+			delete(leaves, k)
+		}
+	}
+	if len(leaves) == 0 {
+		delete(d.leaves, target)
+	}
+}
+
+// removeTarget forgets the deletes of target.
+func (d *deletedLeaves) removeTarget(target string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	delete(d.leaves, target)
+}
+
+// query returns the delete notifications of target, or of all the targets
+// if target is `*`, deleting the path cp, a path under it or one of its parents.
+func (d *deletedLeaves) query(target string, cp []string) []*gnmi.Notification {
+	d.m.Lock()
+	defer d.m.Unlock()
+	var ns []*gnmi.Notification
+	for t, leaves := range d.leaves {
+		if target != "*" && t != target {
+			continue
+		}
+		for _, l := range leaves {
+			if pathsOverlap(cp, l.path) {
+				ns = append(ns, l.n)
+			}
+		}
+	}
+	return ns
+}
+
+// sweep forgets the deletes for which expired returns true.
+func (d *deletedLeaves) sweep(expired func(*gnmi.Notification) bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	for target, leaves := range d.leaves {
+		for k, l := range leaves {
+			if expired(l.n) {
+				delete(leaves, k)
+			}
+		}
+		if len(leaves) == 0 {
+			delete(d.leaves, target)
+		}
+	}
+}
+
+// readDeleted returns the deletes of the subscription cache c matching
+// the read of path p from target, cp being its complete path.
+// The expired deletes and those older than one of the values read
+// under their path are not returned.
+func (gc *gnmiCache) readDeleted(c *subCache, target string, p *gnmi.Path, cp []string, values []*gnmi.Notification, now time.Time) []*gnmi.Notification {
+	deletes := c.deleted.query(target, cp)
+	if len(deletes) == 0 {
+		return nil
+	}
+	type value struct {
+		target string
+		path   []string
+		ts     int64
+	}
+	vs := make([]value, 0, len(values))
+	for _, v := range values {
+		t, vp, ok := leafPath(v)
+		if ok {
+			vs = append(vs, value{target: t, path: vp, ts: v.GetTimestamp()})
+		}
+	}
+	n := 0
+DELETES:
+	for _, d := range deletes {
+		if !originMatches(p, d) || gc.expired(c.name, d, now) {
+			continue
+		}
+		t, dp, ok := leafPath(d)
+		if !ok {
+			continue
+		}
+		for _, v := range vs {
+			if v.ts > d.GetTimestamp() && v.target == t && pathMatches(dp, v.path) {
+				continue DELETES
+			}
+		}
+		deletes[n] = d
+		n++
+	}
+	return deletes[:n]
+}
+
+// pathMatches returns true if the path q matches the path p, or one of
+// its parents, like a query or a delete of the cache tree:
+// a `*` element name or key value matches any of them.
+func pathMatches(q, p []string) bool {
+	if len(q) > len(p) {
+		return false
+	}
+	for i, e := range q {
+		if e != "*" && e != p[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pathsOverlap returns true if the path a matches the path b,
+// or if b matches a, a `*` in either of them matches any element name or key value.
+func pathsOverlap(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != "*" && b[i] != "*" && a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
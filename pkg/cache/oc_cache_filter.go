@@ -0,0 +1,141 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// operators of a ValueFilter
+const (
+	ValueFilterEqual       = "=="
+	ValueFilterNotEqual    = "!="
+	ValueFilterGreaterThan = ">"
+	ValueFilterLessThan    = "<"
+	ValueFilterContains    = "contains"
+)
+
+// ValueFilter selects the updates sent by a read based on their value.
+// The numeric operators apply to the int, uint, float and double values,
+// Value being parsed as a number, the operators ==, != and contains
+// apply to the string and ascii values.
+// The updates with other value types never match.
+type ValueFilter struct {
+	// Operator, one of ==, !=, >, < and contains.
+	Operator string
+	// Value is the literal the update values are compared to.
+	Value string
+}
+
+func (f *ValueFilter) validate() error {
+	switch f.Operator {
+	case ValueFilterEqual, ValueFilterNotEqual, ValueFilterGreaterThan, ValueFilterLessThan, ValueFilterContains:
+		return nil
+	}
+	return fmt.Errorf("unknown value filter operator %q", f.Operator)
+}
+
+// matches reports whether the value v matches the filter.
+func (f *ValueFilter) matches(v *gnmi.TypedValue) bool {
+	switch v.GetValue().(type) {
+	case *gnmi.TypedValue_StringVal, *gnmi.TypedValue_AsciiVal:
+		s := v.GetStringVal()
+		if s == "" {
+			s = v.GetAsciiVal()
+		}
+		switch f.Operator {
+		case ValueFilterEqual:
+			return s == f.Value
+		case ValueFilterNotEqual:
+			return s != f.Value
+		case ValueFilterContains:
+			return strings.Contains(s, f.Value)
+		}
+	case *gnmi.TypedValue_IntVal, *gnmi.TypedValue_UintVal, *gnmi.TypedValue_FloatVal, *gnmi.TypedValue_DoubleVal:
+		c, ok := compareNumber(v, f.Value)
+		if !ok {
+			return false
+		}
+		switch f.Operator {
+		case ValueFilterEqual:
+			return c == 0
+		case ValueFilterNotEqual:
+			return c != 0
+		case ValueFilterGreaterThan:
+			return c > 0
+		case ValueFilterLessThan:
+			return c < 0
+		}
+	}
+	return false
+}
+
+// compareNumber compares the numeric value v to the literal lit,
+// as integers if both are, as floats otherwise.
+// It returns false if lit is not a number.
+func compareNumber(v *gnmi.TypedValue, lit string) (int, bool) {
+	switch v := v.GetValue().(type) {
+	case *gnmi.TypedValue_IntVal:
+		if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+			return cmp.Compare(v.IntVal, i), true
+		}
+		return compareFloat(float64(v.IntVal), lit)
+	case *gnmi.TypedValue_UintVal:
+		if u, err := strconv.ParseUint(lit, 10, 64); err == nil {
+			return cmp.Compare(v.UintVal, u), true
+		}
+		return compareFloat(float64(v.UintVal), lit)
+	case *gnmi.TypedValue_FloatVal:
+		return compareFloat(float64(v.FloatVal), lit)
+	case *gnmi.TypedValue_DoubleVal:
+		return compareFloat(v.DoubleVal, lit)
+	}
+	return 0, false
+}
+
+func compareFloat(f float64, lit string) (int, bool) {
+	l, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return 0, false
+	}
+	return cmp.Compare(f, l), true
+}
+
+// filterValues returns n with only the updates matching the read ValueFilter,
+// or nil if none of them matches and n has no deletes.
+// The deletes are always kept, n is not modified.
+func (ro *ReadOpts) filterValues(n *gnmi.Notification) *gnmi.Notification {
+	if ro.ValueFilter == nil {
+		return n
+	}
+	upds := make([]*gnmi.Update, 0, len(n.GetUpdate()))
+	for _, upd := range n.GetUpdate() {
+		if ro.ValueFilter.matches(upd.GetVal()) {
+			upds = append(upds, upd)
+		}
+	}
+	if len(upds) == len(n.GetUpdate()) {
+		return n
+	}
+	if len(upds) == 0 && len(n.GetDelete()) == 0 {
+		return nil
+	}
+	// a subset of the updates of an atomic notification is not atomic.
+	return &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Update:    upds,
+		Delete:    n.GetDelete(),
+	}
+}
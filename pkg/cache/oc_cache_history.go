@@ -0,0 +1,272 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/path"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// history keeps the last `depth` values of each path,
+// it is only populated if the configured history depth is greater than 1.
+type history struct {
+	m     *sync.Mutex
+	depth int
+	// subscription name -> target name -> tree of *[]*gnmi.Notification,
+	// each leaf holding the values of a single path, oldest first.
+	trees map[string]map[string]*ctree.Tree
+}
+
+func newHistory(depth int) *history {
+	return &history{
+		m:     new(sync.Mutex),
+		depth: depth,
+		trees: make(map[string]map[string]*ctree.Tree),
+	}
+}
+
+// add records the updates of notification n as single update notifications,
+// evicting the oldest values of a path once the depth is reached.
+// The history of the deleted paths is removed.
+func (h *history) add(sub string, n *gnmi.Notification) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	target := n.GetPrefix().GetTarget()
+	if _, ok := h.trees[sub]; !ok {
+		h.trees[sub] = make(map[string]*ctree.Tree)
+	}
+	t, ok := h.trees[sub][target]
+	if !ok {
+		t = new(ctree.Tree)
+		h.trees[sub][target] = t
+	}
+	for _, del := range n.GetDelete() {
+		p, err := path.CompletePath(n.GetPrefix(), del)
+		if err != nil {
+			return err
+		}
+		t.Delete(p)
+	}
+	for _, upd := range n.GetUpdate() {
+		p, err := path.CompletePath(n.GetPrefix(), upd.GetPath())
+		if err != nil {
+			return err
+		}
+		hn := &gnmi.Notification{
+			Timestamp: n.GetTimestamp(),
+			Prefix:    n.GetPrefix(),
+			Update:    []*gnmi.Update{upd},
+		}
+		if vs, ok := t.GetLeafValue(p).(*[]*gnmi.Notification); ok {
+			*vs = append(*vs, hn)
+			if len(*vs) > h.depth {
+				*vs = (*vs)[len(*vs)-h.depth:]
+			}
+			continue
+		}
+		err = t.Add(p, &[]*gnmi.Notification{hn})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *history) deleteTarget(target string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	for _, ts := range h.trees {
+		delete(ts, target)
+	}
+}
+
+func (h *history) deleteSubscription(sub string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	delete(h.trees, sub)
+}
+
+func (h *history) clear() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.trees = make(map[string]map[string]*ctree.Tree)
+}
+
+// ReadHistory returns up to `depth` values of each path matching p,
+// newest first, grouped by subscription name.
+// If depth is not set or is greater than the configured history depth,
+// all the retained values are returned.
+// If the history is not enabled, it behaves like Read.
+func (gc *gnmiCache) ReadHistory(sub, target string, p *gnmi.Path, depth int) (map[string][]*gnmi.Notification, error) {
+	if gc.history == nil {
+		return gc.read(sub, target, p)
+	}
+	if sub == "*" {
+		sub = ""
+	}
+	target = gc.canonicalTarget(target)
+	caches := gc.getCaches(sub)
+	if sub != "" && len(caches) == 0 {
+		return nil, ErrSubscriptionNotFound
+	}
+	cp, err := path.CompletePath(p, nil)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notifications := make(map[string][]*gnmi.Notification)
+	allTargets := target == "" || target == "*"
+	targetFound := allTargets
+
+	gc.history.m.Lock()
+	defer gc.history.m.Unlock()
+	for name := range caches {
+		for tName, t := range gc.history.trees[name] {
+			if !allTargets {
+				if tName != target {
+					continue
+				}
+				targetFound = true
+			}
+			err = t.Query(cp, func(_ []string, _ *ctree.Leaf, v interface{}) error {
+				vs, ok := v.(*[]*gnmi.Notification)
+				if !ok {
+					return nil
+				}
+				count := 0
+				for i := len(*vs) - 1; i >= 0; i-- {
+					if depth > 0 && count == depth {
+						break
+					}
+					if !originMatches(p, (*vs)[i]) || gc.expired(name, (*vs)[i], now) {
+						continue
+					}
+					notifications[name] = append(notifications[name], (*vs)[i])
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !targetFound {
+		return nil, ErrTargetNotFound
+	}
+	return notifications, nil
+}
+
+// inWindow returns true if the notification n is timestamped
+// within the history window of the read.
+func (ro *ReadOpts) inWindow(n *gnmi.Notification) bool {
+	ts := time.Unix(0, n.GetTimestamp())
+	if !ro.HistoryStart.IsZero() && ts.Before(ro.HistoryStart) {
+		return false
+	}
+	return ro.HistoryEnd.IsZero() || !ts.After(ro.HistoryEnd)
+}
+
+// handleHistoryQuery sends the values retained by the history for the paths
+// matching ro and timestamped within its history window, ordered by timestamp
+// per subscription. If the history is not enabled, only the latest values
+// within the window are sent.
+// It returns false if the query failed or timed out.
+func (gc *gnmiCache) handleHistoryQuery(ctx context.Context, ro *ReadOpts, ch chan *Notification) bool {
+	qctx, cancel := gc.queryContext(ctx)
+	defer cancel()
+	now := time.Now()
+	for name, c := range gc.getCaches(ro.Subscription) {
+		if !c.c.HasTarget(ro.Target) {
+			continue
+		}
+		ns := make([]*gnmi.Notification, 0)
+		for _, p := range ro.Paths {
+			cp, err := path.CompletePath(p, nil)
+			if err != nil {
+				gc.logger.Printf("failed to generate CompletePath from %v", p)
+				sendNotification(ctx, ch, &Notification{Name: name, Err: err})
+				return false
+			}
+			add := func(n *gnmi.Notification) {
+				if originMatches(p, n) && !ro.tooOld(n, now) && ro.inWindow(n) {
+					ns = append(ns, n)
+				}
+			}
+			if gc.history == nil {
+				err = c.c.Query(ro.Target, cp, func(_ []string, _ *ctree.Leaf, v interface{}) error {
+					if n, ok := v.(*gnmi.Notification); ok && !gc.expiredLeaf(c, n, now) {
+						add(n)
+					}
+					return nil
+				})
+			} else {
+				// the values retained by the history are not cache leaves,
+				// their expiration is not reported.
+				err = gc.history.query(name, ro.Target, cp, func(vs []*gnmi.Notification) {
+					for _, n := range vs {
+						if !gc.expired(name, n, now) {
+							add(n)
+						}
+					}
+				})
+			}
+			if err != nil {
+				gc.logger.Printf("target %q failed history query: %v", ro.Target, err)
+				sendNotification(ctx, ch, &Notification{Name: name, Err: err})
+				return false
+			}
+		}
+		sort.SliceStable(ns, func(i, j int) bool {
+			return ns[i].GetTimestamp() < ns[j].GetTimestamp()
+		})
+		for _, n := range ns {
+			for _, nn := range ro.toSend(name, n, false) {
+				if !gc.sendValue(qctx, ro, ch, &Notification{Name: name, Notification: nn}) {
+					if ctx.Err() == nil && qctx.Err() != nil {
+						gc.logger.Printf("subscription-cache %q: history query to target %q timed out after %s", name, ro.Target, gc.queryTimeout)
+						sendNotification(ctx, ch, &Notification{Name: name, Err: fmt.Errorf("query timed out: %w", qctx.Err())})
+					}
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// query calls f with the values retained for each path
+// of the target matching the complete path cp, oldest first.
+// A `*` target matches all the targets of the subscription.
+func (h *history) query(sub, target string, cp []string, f func(vs []*gnmi.Notification)) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	for tName, t := range h.trees[sub] {
+		if target != "*" && tName != target {
+			continue
+		}
+		err := t.Query(cp, func(_ []string, _ *ctree.Leaf, v interface{}) error {
+			if vs, ok := v.(*[]*gnmi.Notification); ok {
+				f(*vs)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/openconfig/gnmi/path"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// once a limit is reached, the evict-oldest policy evicts 1/evictionBatchRatio
+// of the limit on top of the leaves needed to make room for the new paths,
+// so that the oldest leaves are looked up once per batch of new paths
+// instead of on each write.
+const evictionBatchRatio = 10
+
+var (
+	errMaxTargetEntries = errors.New("max-target-entries reached")
+	errMaxEntries       = errors.New("max-entries reached")
+	errMaxSize          = errors.New("max-size reached")
+)
+
+// limitEntries applies the overflow policy to the updates of n adding new leaves
+// to a target that reached the maximum number of entries per target, or to
+// a subscription that reached its maximum number of entries or its maximum size.
+// With the reject policy, it returns n without those updates, or an error if
+// none of its updates and deletes is left or if the partial update policy is all-or-nothing.
+// With the evict-oldest policy, it removes the oldest leaves of the target,
+// or of the subscription, to make room for them and returns n as is.
+// The leaves are evicted in batches, down to a low-water mark below the limit.
+// The number of leaves of each target is maintained by the gNMI cache, and their
+// size by sCache.update, only the paths of n are looked up.
+// It must be called with the target lock of n held, or with sCache.wm held
+// exclusively if the subscription max entries or max size is set.
+func (gc *gnmiCache) limitEntries(sCache *subCache, n *gnmi.Notification) (*gnmi.Notification, error) {
+	target := n.GetPrefix().GetTarget()
+	maxEntries := gc.subscriptionMaxEntries(sCache.name)
+	maxSize := gc.subscriptionMaxSize(sCache.name)
+	// an atomic notification is cached as a single leaf.
+	var ps []*gnmi.Path
+	if n.GetAtomic() {
+		ps = []*gnmi.Path{nil}
+	} else {
+		ps = make([]*gnmi.Path, 0, len(n.GetUpdate()))
+		for _, upd := range n.GetUpdate() {
+			ps = append(ps, upd.GetPath())
+		}
+	}
+	var targetEntries, entries int
+	var size int64
+	if gc.maxTargetEntries > 0 {
+		targetEntries = sCache.leafCount(target)
+	}
+	if maxEntries > 0 {
+		entries = sCache.totalLeafCount()
+	}
+	if maxSize > 0 {
+		size = sCache.cachedSize()
+	}
+	added := 0
+	// size added to the cache by the updates of n.
+	var grown int64
+	// target and paths of n, not evicted.
+	touched := make(map[string]struct{}, len(ps))
+	// indexes of the rejected updates.
+	rejected := make(map[int]struct{})
+	// the limit the last rejected update reached.
+	var limitErr error
+	var limit int64
+	for i, p := range ps {
+		cp, err := path.CompletePath(n.GetPrefix(), p)
+		if err != nil {
+			// the update is rejected by the gNMI cache.
+			continue
+		}
+		k := target + "\x00" + strings.Join(cp, "\x00")
+		if _, ok := touched[k]; ok {
+			continue
+		}
+		touched[k] = struct{}{}
+		var leafSize int64
+		if maxSize > 0 {
+			ln := n
+			if !n.GetAtomic() {
+				ln = singleUpdate(n, n.GetUpdate()[i])
+			}
+			leafSize = int64(proto.Size(ln))
+		}
+		if isCached(sCache, target, cp) {
+			grown += leafSize - sCache.leafSize(target, k)
+			continue
+		}
+		if !gc.evictOldest {
+			if gc.maxTargetEntries > 0 && targetEntries+added >= gc.maxTargetEntries {
+				rejected[i] = struct{}{}
+				limitErr, limit = errMaxTargetEntries, int64(gc.maxTargetEntries)
+				continue
+			}
+			if maxEntries > 0 && entries+added >= maxEntries {
+				rejected[i] = struct{}{}
+				limitErr, limit = errMaxEntries, int64(maxEntries)
+				continue
+			}
+			if maxSize > 0 && size+grown+leafSize > maxSize {
+				rejected[i] = struct{}{}
+				limitErr, limit = errMaxSize, maxSize
+				continue
+			}
+		}
+		added++
+		grown += leafSize
+	}
+	if gc.evictOldest {
+		if gc.maxTargetEntries > 0 {
+			if excess := targetEntries + added - gc.maxTargetEntries; excess > 0 {
+				evicted, freed := gc.evictOldestLeaves(sCache, target, excess+evictionBatch(gc.maxTargetEntries), 0, touched)
+				entries -= evicted
+				size -= freed
+			}
+		}
+		// number of leaves and size evicted from the subscription.
+		var count int
+		var freeSize int64
+		if maxEntries > 0 {
+			if excess := entries + added - maxEntries; excess > 0 {
+				count = excess + evictionBatch(maxEntries)
+			}
+		}
+		if maxSize > 0 {
+			if excess := size + grown - maxSize; excess > 0 {
+				freeSize = excess + maxSize/evictionBatchRatio
+			}
+		}
+		if count > 0 || freeSize > 0 {
+			gc.evictOldestLeaves(sCache, "*", count, freeSize, touched)
+		}
+		return n, nil
+	}
+	if len(rejected) == 0 {
+		return n, nil
+	}
+	var dropReason string
+	switch limitErr {
+	case errMaxTargetEntries:
+		dropReason = dropReasonMaxTargetEntries
+	case errMaxEntries:
+		dropReason = dropReasonMaxEntries
+	default:
+		dropReason = dropReasonMaxSize
+	}
+	if n.GetAtomic() || (len(rejected) == len(n.GetUpdate()) && len(n.GetDelete()) == 0) {
+		return nil, fmt.Errorf("target %q: %w (%d)", target, limitErr, limit)
+	}
+	if gc.allOrNothing {
+		return nil, fmt.Errorf("notification rejected: target %q: %w (%d)", target, limitErr, limit)
+	}
+	gc.logger.Printf("subscription %q: target %q: %v (%d), %d new path(s) rejected",
+		sCache.name, target, limitErr, limit, len(rejected))
+	gc.countDroppedWrite(sCache.name, dropReason)
+	kept := &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Update:    make([]*gnmi.Update, 0, len(n.GetUpdate())-len(rejected)),
+		Delete:    n.GetDelete(),
+	}
+	for i, upd := range n.GetUpdate() {
+		if _, ok := rejected[i]; !ok {
+			kept.Update = append(kept.Update, upd)
+		}
+	}
+	return kept, nil
+}
+
+// evictOldestLeaves removes the leaves of target with the oldest timestamps,
+// except those with a path in keep, until at least count leaves and size bytes
+// are removed, and returns the number of leaves and the size removed.
+// A `*` target evicts the oldest leaves of all the targets of the subscription.
+// The removals are reported to the eviction callback and to the on-change subscribers
+// like any other delete.
+// It must be called with the target lock held, or with sCache.wm held
+// exclusively for the `*` target.
+func (gc *gnmiCache) evictOldestLeaves(sCache *subCache, target string, count int, size int64, keep map[string]struct{}) (int, int64) {
+	var leaves []*gnmi.Notification
+	err := sCache.c.Query(target, []string{"*"},
+		func(p []string, _ *ctree.Leaf, v interface{}) error {
+			ln, ok := v.(*gnmi.Notification)
+			if !ok {
+				return nil
+			}
+			if _, ok := keep[ln.GetPrefix().GetTarget()+"\x00"+strings.Join(p, "\x00")]; ok {
+				return nil
+			}
+			leaves = append(leaves, ln)
+			return nil
+		})
+	if err != nil {
+		gc.logger.Printf("subscription %q: target %q: failed to look up the oldest values: %v", sCache.name, target, err)
+		return 0, 0
+	}
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return leaves[i].GetTimestamp() < leaves[j].GetTimestamp()
+	})
+	evicted := 0
+	var freed int64
+	for _, ln := range leaves {
+		if evicted >= count && freed >= size {
+			break
+		}
+		err = sCache.c.GnmiUpdate(expiredLeafDelete(ln))
+		if err != nil {
+			gc.logger.Printf("subscription %q: failed to evict value: %v", sCache.name, err)
+			continue
+		}
+		evicted++
+		freed += int64(proto.Size(ln))
+	}
+	gc.countEvictions(sCache.name, evicted)
+	if gc.debug {
+		if target == "*" {
+			gc.logger.Printf("subscription %q reached max-entries (%d) or max-size (%d), evicted %d value(s), %d byte(s)",
+				sCache.name, gc.subscriptionMaxEntries(sCache.name), gc.subscriptionMaxSize(sCache.name), evicted, freed)
+		} else {
+			gc.logger.Printf("subscription %q: target %q reached max-target-entries (%d), evicted %d value(s)",
+				sCache.name, target, gc.maxTargetEntries, evicted)
+		}
+	}
+	return evicted, freed
+}
+
+// evictionBatch returns the number of leaves evicted beyond the excess
+// once limit is reached, so that the target or subscription is brought down
+// to its low-water mark.
+func evictionBatch(limit int) int {
+	return limit / evictionBatchRatio
+}
+
+// subscriptionMaxEntries returns the maximum number of leaves
+// cached for subscription sub, 0 if unlimited.
+func (gc *gnmiCache) subscriptionMaxEntries(sub string) int {
+	if m, ok := gc.subMaxEntries[sub]; ok {
+		return m
+	}
+	return gc.maxEntries
+}
+
+// subscriptionMaxSize returns the maximum size in bytes
+// of the leaves cached for subscription sub, 0 if unlimited.
+func (gc *gnmiCache) subscriptionMaxSize(sub string) int64 {
+	if m, ok := gc.subMaxSize[sub]; ok {
+		return m
+	}
+	return gc.maxSize
+}
+
+// subscriptionLimited returns true if the number of leaves, or their size,
+// cached for subscription sub is limited.
+func (gc *gnmiCache) subscriptionLimited(sub string) bool {
+	return gc.subscriptionMaxEntries(sub) > 0 || gc.subscriptionMaxSize(sub) > 0
+}
+
+// updateSize records the size of the leaf set by n, or removes the size
+// of the leaf deleted by n, or of the leaves of the target it removes.
+func (sc *subCache) updateSize(n *gnmi.Notification) {
+	target := n.GetPrefix().GetTarget()
+	sc.sm.Lock()
+	defer sc.sm.Unlock()
+	if isTargetRemoval(n) {
+		for _, size := range sc.sizes[target] {
+			sc.size -= int64(size)
+		}
+		delete(sc.sizes, target)
+		return
+	}
+	k, ok := leafKey(n)
+	if !ok {
+		return
+	}
+	leaves, ok := sc.sizes[target]
+	if !ok {
+		leaves = make(map[string]int)
+		sc.sizes[target] = leaves
+	}
+	sc.size -= int64(leaves[k])
+	if len(n.GetDelete()) > 0 {
+		delete(leaves, k)
+		return
+	}
+	size := proto.Size(n)
+	leaves[k] = size
+	sc.size += int64(size)
+}
+
+// cachedSize returns the size of the leaves cached for all the targets.
+func (sc *subCache) cachedSize() int64 {
+	sc.sm.Lock()
+	defer sc.sm.Unlock()
+	return sc.size
+}
+
+// leafSize returns the size of the leaf with key k of target, 0 if it is not cached.
+func (sc *subCache) leafSize(target, k string) int64 {
+	sc.sm.Lock()
+	defer sc.sm.Unlock()
+	return int64(sc.sizes[target][k])
+}
+
+// totalLeafCount returns the number of leaves cached for all the targets.
+func (sc *subCache) totalLeafCount() int {
+	count := 0
+	sc.meta.Range(func(_, md any) bool {
+		v, _ := md.(*metadata.Metadata).GetInt(metadata.LeafCount)
+		count += int(v)
+		return true
+	})
+	return count
+}
+
+// leafCount returns the number of leaves cached for target.
+func (sc *subCache) leafCount(target string) int {
+	md, ok := sc.meta.Load(target)
+	if !ok {
+		return 0
+	}
+	v, _ := md.(*metadata.Metadata).GetInt(metadata.LeafCount)
+	return int(v)
+}
+
+// isCached returns true if the complete path cp is a leaf of target.
+func isCached(sc *subCache, target string, cp []string) bool {
+	var visited []string
+	sc.c.Query(target, cp,
+		func(p []string, _ *ctree.Leaf, _ interface{}) error {
+			visited = p
+			return errStopQuery
+		})
+	return visited != nil && len(visited) == len(cp)
+}
@@ -0,0 +1,235 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reasons a write is dropped by the cache
+const (
+	dropReasonMissingTarget = "missing_target"
+	dropReasonEmptyPath     = "empty_path"
+	dropReasonReadOnly      = "read_only"
+	dropReasonRejected      = "rejected"
+	// some or all the updates of the notification
+	// were rejected by the overflow policy.
+	dropReasonMaxTargetEntries = "max_target_entries"
+	// some or all the updates of the notification
+	// were rejected by the overflow policy of the subscription max-entries.
+	dropReasonMaxEntries = "max_entries"
+	// some or all the updates of the notification
+	// were rejected by the overflow policy of the subscription max-size.
+	dropReasonMaxSize = "max_size"
+	// the notification timestamp is ahead of max-clock-skew.
+	dropReasonClockSkew = "clock_skew"
+)
+
+// cacheGauges computes the number of subscriptions, targets and leaves
+// of the caches with registered metrics when collected, rather than on each write,
+// so that the values written before the metrics are registered,
+// e.g restored from a snapshot, are counted.
+var cacheGauges = &gaugesCollector{
+	subscriptions: prometheus.NewDesc("gnmic_cache_subscriptions",
+		"Number of subscriptions cached by gnmic oc cache",
+		nil, nil),
+	targets: prometheus.NewDesc("gnmic_cache_targets",
+		"Number of targets cached by gnmic oc cache, by subscription",
+		[]string{"subscription"}, nil),
+	leaves: prometheus.NewDesc("gnmic_cache_leaves",
+		"Number of leaves cached by gnmic oc cache, including the expired ones not removed yet, by subscription",
+		[]string{"subscription"}, nil),
+}
+
+type gaugesCollector struct {
+	subscriptions *prometheus.Desc
+	targets       *prometheus.Desc
+	leaves        *prometheus.Desc
+	// set of *gnmiCache
+	caches sync.Map
+}
+
+// subscriptionCounts is the number of targets and leaves of a subscription.
+type subscriptionCounts struct {
+	targets int
+	leaves  int
+}
+
+func (g *gaugesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.subscriptions
+	ch <- g.targets
+	ch <- g.leaves
+}
+
+func (g *gaugesCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := g.counts()
+	ch <- prometheus.MustNewConstMetric(g.subscriptions, prometheus.GaugeValue, float64(len(counts)))
+	for sub, c := range counts {
+		ch <- prometheus.MustNewConstMetric(g.targets, prometheus.GaugeValue, float64(c.targets), sub)
+		ch <- prometheus.MustNewConstMetric(g.leaves, prometheus.GaugeValue, float64(c.leaves), sub)
+	}
+}
+
+// counts returns the number of targets and leaves of each subscription,
+// summed over the caches with registered metrics.
+func (g *gaugesCollector) counts() map[string]*subscriptionCounts {
+	counts := make(map[string]*subscriptionCounts)
+	g.caches.Range(func(k, _ any) bool {
+		for name, c := range k.(*gnmiCache).getCaches() {
+			sc, ok := counts[name]
+			if !ok {
+				sc = new(subscriptionCounts)
+				counts[name] = sc
+			}
+			c.meta.Range(func(_, _ any) bool {
+				sc.targets++
+				return true
+			})
+			sc.leaves += c.totalLeafCount()
+		}
+		return true
+	})
+	return counts
+}
+
+var cacheWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "writes_total",
+	Help:      "Number of notifications written to gnmic oc cache, by subscription",
+}, []string{"subscription"})
+
+var cacheDroppedWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "dropped_writes_total",
+	Help:      "Number of notifications not written to gnmic oc cache, by subscription and reason",
+}, []string{"subscription", "reason"})
+
+var cacheWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "write_errors_total",
+	Help:      "Number of writes to gnmic oc cache that returned an error, by subscription",
+}, []string{"subscription"})
+
+var cacheExpired = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "expired_total",
+	Help:      "Number of expired values removed from gnmic oc cache, by subscription",
+}, []string{"subscription"})
+
+var cacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "evictions_total",
+	Help:      "Number of values evicted from gnmic oc cache by the evict-oldest overflow policy, by subscription",
+}, []string{"subscription"})
+
+var cacheQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "queries_total",
+	Help:      "Number of queries run against gnmic oc cache, by subscription",
+}, []string{"subscription"})
+
+var cacheQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gnmic",
+	Subsystem: "cache",
+	Name:      "query_duration_seconds",
+	Help:      "Duration of the queries run against gnmic oc cache, by subscription",
+	// 10µs to ~5s
+	Buckets: prometheus.ExponentialBuckets(0.00001, 2, 20),
+}, []string{"subscription"})
+
+func registerMetrics(reg *prometheus.Registry) error {
+	for _, c := range []prometheus.Collector{
+		cacheGauges,
+		cacheWrites,
+		cacheDroppedWrites,
+		cacheWriteErrors,
+		cacheExpired,
+		cacheEvictions,
+		cacheQueries,
+		cacheQueryDuration,
+	} {
+		err := reg.Register(c)
+		if err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterMetrics registers the cache metrics with reg
+// and enables their collection.
+func (gc *gnmiCache) RegisterMetrics(reg *prometheus.Registry) {
+	if err := registerMetrics(reg); err != nil {
+		gc.logger.Printf("failed to register metrics: %v", err)
+		return
+	}
+	gc.metrics.Store(true)
+	cacheGauges.caches.Store(gc, struct{}{})
+}
+
+func (gc *gnmiCache) countWrite(sub string) {
+	gc.subWriteCounts(sub).writes.Add(1)
+	if gc.metrics.Load() {
+		cacheWrites.WithLabelValues(sub).Inc()
+	}
+}
+
+func (gc *gnmiCache) countDroppedWrite(sub, reason string) {
+	gc.subWriteCounts(sub).dropped.Add(1)
+	if gc.metrics.Load() {
+		cacheDroppedWrites.WithLabelValues(sub, reason).Inc()
+	}
+}
+
+func (gc *gnmiCache) countWriteError(sub string) {
+	gc.subWriteCounts(sub).errors.Add(1)
+	if gc.metrics.Load() {
+		cacheWriteErrors.WithLabelValues(sub).Inc()
+	}
+}
+
+// countExpired counts n expired values of subscription sub removed by the sweeper.
+func (gc *gnmiCache) countExpired(sub string, n int) {
+	if n == 0 {
+		return
+	}
+	gc.subWriteCounts(sub).expired.Add(uint64(n))
+	if gc.metrics.Load() {
+		cacheExpired.WithLabelValues(sub).Add(float64(n))
+	}
+}
+
+// countEvictions counts n values of subscription sub evicted by the overflow policy.
+func (gc *gnmiCache) countEvictions(sub string, n int) {
+	if n == 0 {
+		return
+	}
+	gc.subWriteCounts(sub).evictions.Add(uint64(n))
+	if gc.metrics.Load() {
+		cacheEvictions.WithLabelValues(sub).Add(float64(n))
+	}
+}
+
+// observeQuery counts a query of subscription sub started at start.
+func (gc *gnmiCache) observeQuery(sub string, start time.Time) {
+	if gc.metrics.Load() {
+		cacheQueries.WithLabelValues(sub).Inc()
+		cacheQueryDuration.WithLabelValues(sub).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,137 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+const defaultSnapshotInterval = time.Minute
+
+var errSnapshotTooLarge = errors.New("snapshot exceeds snapshot-max-size")
+
+// limitedWriter fails the writes beyond max bytes.
+type limitedWriter struct {
+	w       io.Writer
+	max     int64
+	written int64
+}
+
+func (lw *limitedWriter) Write(b []byte) (int, error) {
+	if lw.written+int64(len(b)) > lw.max {
+		return 0, fmt.Errorf("%w (%d bytes)", errSnapshotTooLarge, lw.max)
+	}
+	n, err := lw.w.Write(b)
+	lw.written += int64(n)
+	return n, err
+}
+
+// Snapshot writes the current content of the cache to w,
+// in the format read by Restore, Diff and DiffCurrent.
+func (gc *gnmiCache) Snapshot(w io.Writer) error {
+	return WriteSnapshot(gc, w)
+}
+
+// Restore writes the notifications of the snapshot read from r to the cache,
+// through Write, so that they are matched by the on-change subscribers.
+// The notifications already expired are skipped.
+func (gc *gnmiCache) Restore(r io.Reader) error {
+	notifications, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	now := gc.clock()
+	for sub, ns := range notifications {
+		restored := 0
+		for _, n := range ns {
+			if gc.expired(sub, n, now) {
+				continue
+			}
+			gc.Write(context.Background(), sub, &gnmi.SubscribeResponse{
+				Response: &gnmi.SubscribeResponse_Update{Update: n},
+			})
+			restored++
+		}
+		if gc.debug {
+			gc.logger.Printf("subscription %q: restored %d out of %d notification(s)", sub, restored, len(ns))
+		}
+	}
+	return nil
+}
+
+// restoreFile restores the cache from the snapshot file at path, if it exists.
+func (gc *gnmiCache) restoreFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gc.Restore(f)
+}
+
+// saveFile writes a snapshot of the cache to a temporary file
+// renamed to path, so that path always holds a complete snapshot.
+// If the snapshot exceeds the max snapshot size, path is left as is.
+func (gc *gnmiCache) saveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	var w io.Writer = f
+	if gc.snapshotMaxSize > 0 {
+		w = &limitedWriter{w: f, max: gc.snapshotMaxSize}
+	}
+	err = gc.Snapshot(w)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// snapshotter saves the cache to path every interval, if positive,
+// and once the cache is stopped.
+func (gc *gnmiCache) snapshotter(path string, interval time.Duration) {
+	defer close(gc.saved)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-gc.stop:
+			if err := gc.saveFile(path); err != nil {
+				gc.logger.Printf("failed to save cache snapshot to %q: %v", path, err)
+			}
+			return
+		case <-tick:
+			if err := gc.saveFile(path); err != nil {
+				gc.logger.Printf("failed to save cache snapshot to %q: %v", path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// rawResponses keeps the SubscribeResponse messages as written,
+// it is only populated if retain-raw is set.
+type rawResponses struct {
+	m sync.Mutex
+	// subscription name -> target name -> responses, in write order.
+	// The sync responses, which have no target, are kept under an empty target name.
+	rsps map[string]map[string][]*rawResponse
+}
+
+type rawResponse struct {
+	// notification timestamp, or write time for a sync response.
+	ts  int64
+	rsp *gnmi.SubscribeResponse
+}
+
+func newRawResponses() *rawResponses {
+	return &rawResponses{
+		rsps: make(map[string]map[string][]*rawResponse),
+	}
+}
+
+// add retains the response rsp of subscription sub and target,
+// removing the oldest responses of the target once they are older than exp.
+func (r *rawResponses) add(sub, target string, ts int64, rsp *gnmi.SubscribeResponse, exp time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.rsps[sub]; !ok {
+		r.rsps[sub] = make(map[string][]*rawResponse)
+	}
+	rs := append(r.rsps[sub][target], &rawResponse{ts: ts, rsp: rsp})
+	if exp > 0 {
+		oldest := time.Now().Add(-exp).UnixNano()
+		i := 0
+		for i < len(rs) && rs[i].ts < oldest {
+			i++
+		}
+		rs = rs[i:]
+	}
+	r.rsps[sub][target] = rs
+}
+
+func (r *rawResponses) deleteTarget(target string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, ts := range r.rsps {
+		delete(ts, target)
+	}
+}
+
+func (r *rawResponses) deleteSubscription(sub string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.rsps, sub)
+}
+
+func (r *rawResponses) clear() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.rsps = make(map[string]map[string][]*rawResponse)
+}
+
+// ReadRaw returns the SubscribeResponse messages written to subscription sub
+// for target, ordered by timestamp and grouped by subscription name.
+// The sync responses are returned if target is empty or `*`.
+// The expired responses are not returned.
+func (gc *gnmiCache) ReadRaw(sub, target string) (map[string][]*gnmi.SubscribeResponse, error) {
+	if gc.raw == nil {
+		return nil, ErrRawNotRetained
+	}
+	if sub == "*" {
+		sub = ""
+	}
+	target = gc.canonicalTarget(target)
+	caches := gc.getCaches(sub)
+	if sub != "" && len(caches) == 0 {
+		return nil, ErrSubscriptionNotFound
+	}
+	allTargets := target == "" || target == "*"
+	targetFound := allTargets
+	now := time.Now()
+	rsps := make(map[string][]*gnmi.SubscribeResponse)
+
+	gc.raw.m.Lock()
+	defer gc.raw.m.Unlock()
+	for name, c := range caches {
+		if !allTargets && c.c.HasTarget(target) {
+			targetFound = true
+		}
+		var rs []*rawResponse
+		for tName, trs := range gc.raw.rsps[name] {
+			if !allTargets && tName != target {
+				continue
+			}
+			rs = append(rs, trs...)
+		}
+		sort.SliceStable(rs, func(i, j int) bool {
+			return rs[i].ts < rs[j].ts
+		})
+		for _, r := range rs {
+			if gc.expired(name, &gnmi.Notification{Timestamp: r.ts}, now) {
+				continue
+			}
+			rsps[name] = append(rsps[name], r.rsp)
+		}
+	}
+	if !targetFound {
+		return nil, ErrTargetNotFound
+	}
+	return rsps, nil
+}
@@ -0,0 +1,138 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	ocCache "github.com/openconfig/gnmi/cache"
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// ErrLiveReshard is returned by CheckShards if the shard count
+// of a running cache is changed.
+var ErrLiveReshard = errors.New("the cache shard count cannot be changed while it is running")
+
+// shardedCache splits the targets of a subscription cache between
+// a fixed number of gNMI caches, each with its own lock,
+// a target is always assigned to the same shard.
+// It has the methods of the gNMI cache used by the subscription caches,
+// a query of all the targets queries the shards one after the other.
+type shardedCache struct {
+	shards []*ocCache.Cache
+}
+
+func newShardedCache(n int) *shardedCache {
+	sc := &shardedCache{shards: make([]*ocCache.Cache, max(n, 1))}
+	for i := range sc.shards {
+		sc.shards[i] = ocCache.New(nil)
+	}
+	return sc
+}
+
+// shardIndex returns the shard of target among n shards,
+// the FNV-1a hash of the target name modulo n.
+func shardIndex(target string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(target))
+	return int(h.Sum32() % uint32(n))
+}
+
+// CheckShards returns ErrLiveReshard if shards, the shard count of
+// a changed configuration, differs from the one of cfg,
+// the configuration of a running cache.
+// The targets are assigned to the shards when they are written,
+// the shard count only changes when the cache is created again, i.e on restart:
+// its snapshot, or persisted values, are then written to the new shards.
+func CheckShards(cfg *Config, shards int) error {
+	var current int
+	if cfg != nil {
+		current = cfg.Shards
+	}
+	if max(current, 1) != max(shards, 1) {
+		return fmt.Errorf("%w: %d shard(s) configured, %d running", ErrLiveReshard, max(shards, 1), max(current, 1))
+	}
+	return nil
+}
+
+func (sc *shardedCache) shard(target string) *ocCache.Cache {
+	return sc.shards[shardIndex(target, len(sc.shards))]
+}
+
+// SetClient registers the callback of the updates accepted by the shards.
+func (sc *shardedCache) SetClient(client func(*ctree.Leaf)) {
+	for _, c := range sc.shards {
+		c.SetClient(client)
+	}
+}
+
+// Metadata returns the per-target metadata of all the shards.
+func (sc *shardedCache) Metadata() map[string]*metadata.Metadata {
+	if len(sc.shards) == 1 {
+		return sc.shards[0].Metadata()
+	}
+	md := make(map[string]*metadata.Metadata)
+	for _, c := range sc.shards {
+		for target, m := range c.Metadata() {
+			md[target] = m
+		}
+	}
+	return md
+}
+
+// TargetMetadata returns the metadata of target, nil if it is not cached.
+func (sc *shardedCache) TargetMetadata(target string) *metadata.Metadata {
+	return sc.shard(target).Metadata()[target]
+}
+
+func (sc *shardedCache) GetTarget(target string) *ocCache.Target {
+	return sc.shard(target).GetTarget(target)
+}
+
+// HasTarget reports whether target is cached, `*` matches any target.
+func (sc *shardedCache) HasTarget(target string) bool {
+	if target == "*" {
+		return true
+	}
+	return sc.shard(target).HasTarget(target)
+}
+
+// Query calls fn for the values of target matching query,
+// or for those of all the targets if target is `*`.
+func (sc *shardedCache) Query(target string, query []string, fn ctree.VisitFunc) error {
+	if target != "*" {
+		return sc.shard(target).Query(target, query, fn)
+	}
+	for _, c := range sc.shards {
+		if err := c.Query(target, query, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *shardedCache) Add(target string) *ocCache.Target {
+	return sc.shard(target).Add(target)
+}
+
+func (sc *shardedCache) Remove(target string) {
+	sc.shard(target).Remove(target)
+}
+
+// GnmiUpdate applies the notification n to the shard of its target.
+func (sc *shardedCache) GnmiUpdate(n *gnmi.Notification) error {
+	return sc.shard(n.GetPrefix().GetTarget()).GnmiUpdate(n)
+}
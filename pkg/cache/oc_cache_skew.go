@@ -0,0 +1,37 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"time"
+)
+
+// checkSkew returns the timestamp a notification of subscription sub
+// and target timestamped ts is cached with.
+// It returns false if the notification is rejected for being ahead
+// of the cache clock by more than max-clock-skew.
+func (gc *gnmiCache) checkSkew(sub, target string, ts int64) (int64, bool) {
+	if gc.maxClockSkew <= 0 {
+		return ts, true
+	}
+	now := gc.clock()
+	ahead := time.Unix(0, ts).Sub(now)
+	if ahead <= gc.maxClockSkew {
+		return ts, true
+	}
+	if gc.clampSkew {
+		if gc.debug {
+			gc.logger.Printf("subscription %q: target %q timestamp ahead by %s, clamped to now", sub, target, ahead)
+		}
+		return now.UnixNano(), true
+	}
+	gc.logger.Printf("write fail: subscription %q: target %q timestamp ahead by %s, max-clock-skew is %s", sub, target, ahead, gc.maxClockSkew)
+	gc.countDroppedWrite(sub, dropReasonClockSkew)
+	return 0, false
+}
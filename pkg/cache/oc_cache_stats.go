@@ -0,0 +1,131 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"sync/atomic"
+
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// CacheStats is a snapshot of the cache contents and write counters.
+type CacheStats struct {
+	// Subscriptions, the stats of each subscription, keyed by name.
+	// A subscription whose writes were all dropped has no cached values.
+	Subscriptions map[string]*SubscriptionStats
+	// Total, the stats of all the subscriptions. A target cached
+	// by several subscriptions is counted once per subscription.
+	Total SubscriptionStats
+}
+
+// SubscriptionStats holds the stats of a subscription, or of all of them.
+type SubscriptionStats struct {
+	// Targets, the number of cached targets.
+	Targets int
+	// Entries, the number of cached leaves, including the expired ones not removed yet.
+	Entries int
+	// OldestTimestamp and NewestTimestamp, the timestamps in nanoseconds
+	// of the oldest and newest cached leaves, 0 if nothing is cached.
+	OldestTimestamp int64
+	NewestTimestamp int64
+	// Writes, the number of notifications written since the cache was created.
+	Writes uint64
+	// DroppedWrites, the number of notifications not written, for any reason.
+	DroppedWrites uint64
+	// WriteErrors, the number of writes that returned an error.
+	WriteErrors uint64
+	// Evictions, the number of values evicted by the evict-oldest overflow policy.
+	Evictions uint64
+	// Expired, the number of expired values removed by the sweeper.
+	Expired uint64
+}
+
+// writeCounts holds the write counters of a subscription.
+type writeCounts struct {
+	writes    atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+	evictions atomic.Uint64
+	expired   atomic.Uint64
+}
+
+// subWriteCounts returns the write counters of subscription sub.
+func (gc *gnmiCache) subWriteCounts(sub string) *writeCounts {
+	if wc, ok := gc.writeCounts.Load(sub); ok {
+		return wc.(*writeCounts)
+	}
+	wc, _ := gc.writeCounts.LoadOrStore(sub, new(writeCounts))
+	return wc.(*writeCounts)
+}
+
+// add adds the stats of another subscription to s.
+func (s *SubscriptionStats) add(o *SubscriptionStats) {
+	s.Targets += o.Targets
+	s.Entries += o.Entries
+	s.Writes += o.Writes
+	s.DroppedWrites += o.DroppedWrites
+	s.WriteErrors += o.WriteErrors
+	s.Evictions += o.Evictions
+	s.Expired += o.Expired
+	s.observe(o.OldestTimestamp)
+	s.observe(o.NewestTimestamp)
+}
+
+// observe extends the oldest and newest timestamps to ts, if not 0.
+func (s *SubscriptionStats) observe(ts int64) {
+	if ts == 0 {
+		return
+	}
+	if s.OldestTimestamp == 0 || ts < s.OldestTimestamp {
+		s.OldestTimestamp = ts
+	}
+	if ts > s.NewestTimestamp {
+		s.NewestTimestamp = ts
+	}
+}
+
+// GetStats returns a snapshot of the cache stats, it is safe
+// to call concurrently with the writes.
+// The cached leaves are walked to count them, the stats of
+// a subscription written in the meantime may be partially updated.
+func (gc *gnmiCache) GetStats() *CacheStats {
+	stats := &CacheStats{Subscriptions: make(map[string]*SubscriptionStats)}
+	for name, c := range gc.getCaches() {
+		ss := &SubscriptionStats{Targets: len(c.c.Metadata())}
+		c.c.Query("*", []string{"*"},
+			func(_ []string, _ *ctree.Leaf, v interface{}) error {
+				if n, ok := v.(*gnmi.Notification); ok {
+					ss.Entries++
+					ss.observe(n.GetTimestamp())
+				}
+				return nil
+			})
+		stats.Subscriptions[name] = ss
+	}
+	gc.writeCounts.Range(func(k, v any) bool {
+		name := k.(string)
+		ss, ok := stats.Subscriptions[name]
+		if !ok {
+			ss = new(SubscriptionStats)
+			stats.Subscriptions[name] = ss
+		}
+		wc := v.(*writeCounts)
+		ss.Writes = wc.writes.Load()
+		ss.DroppedWrites = wc.dropped.Load()
+		ss.WriteErrors = wc.errors.Load()
+		ss.Evictions = wc.evictions.Load()
+		ss.Expired = wc.expired.Load()
+		return true
+	})
+	for _, ss := range stats.Subscriptions {
+		stats.Total.add(ss)
+	}
+	return stats
+}
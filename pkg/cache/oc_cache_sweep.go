@@ -0,0 +1,114 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"time"
+
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// sweepInterval returns the interval at which the expired leaves are removed,
+// half the shortest expiration if not configured, 0 if no sweep is needed.
+func sweepInterval(cfg *Config) time.Duration {
+	if cfg.SweepInterval != 0 {
+		return max(cfg.SweepInterval, 0)
+	}
+	shortest := cfg.Expiration
+	for _, sc := range cfg.Subscriptions {
+		if sc == nil || sc.Expiration <= 0 {
+			continue
+		}
+		if shortest <= 0 || sc.Expiration < shortest {
+			shortest = sc.Expiration
+		}
+	}
+	if shortest <= 0 {
+		return 0
+	}
+	return shortest / 2
+}
+
+// sweeper removes the expired leaves from the cache every interval,
+// until the cache is stopped.
+func (gc *gnmiCache) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gc.stop:
+			return
+		case <-ticker.C:
+			gc.sweep()
+		}
+	}
+}
+
+// sweep removes the leaves older than their subscription expiration.
+// The removals are applied as deletes timestamped right after the expired value,
+// so that a value written concurrently is not removed, and they are
+// reported to the eviction callback and to the on-change subscribers
+// like any other delete.
+func (gc *gnmiCache) sweep() {
+	now := gc.clock()
+	for name, c := range gc.getCaches() {
+		if gc.subscriptionExpiration(name) <= 0 {
+			continue
+		}
+		var expired []*gnmi.Notification
+		err := c.c.Query("*", []string{"*"},
+			func(_ []string, l *ctree.Leaf, _ interface{}) error {
+				if n, ok := l.Value().(*gnmi.Notification); ok && gc.expired(name, n, now) {
+					expired = append(expired, n)
+				}
+				return nil
+			})
+		if err != nil {
+			gc.logger.Printf("subscription %q: failed to look up the expired values: %v", name, err)
+			continue
+		}
+		removed := 0
+		for _, n := range expired {
+			err = gc.update(c, expiredLeafDelete(n))
+			if err != nil {
+				gc.logger.Printf("subscription %q: failed to remove expired value: %v", name, err)
+				continue
+			}
+			removed++
+		}
+		gc.countExpired(name, removed)
+		c.deleted.sweep(func(n *gnmi.Notification) bool { return gc.expired(name, n, now) })
+		if gc.debug && len(expired) > 0 {
+			gc.logger.Printf("subscription %q: removed %d expired value(s)", name, len(expired))
+		}
+	}
+}
+
+// expiredLeafDelete returns the notification deleting the cached leaf n
+// if it was not overwritten since.
+func expiredLeafDelete(n *gnmi.Notification) *gnmi.Notification {
+	origin := n.GetPrefix().GetOrigin()
+	elems := n.GetPrefix().GetElem()
+	if !n.GetAtomic() && len(n.GetUpdate()) == 1 {
+		p := n.GetUpdate()[0].GetPath()
+		if origin == "" {
+			origin = p.GetOrigin()
+		}
+		elems = append(elems[:len(elems):len(elems)], p.GetElem()...)
+	}
+	return &gnmi.Notification{
+		Timestamp: n.GetTimestamp() + 1,
+		Prefix: &gnmi.Path{
+			Origin: origin,
+			Target: n.GetPrefix().GetTarget(),
+		},
+		Delete: []*gnmi.Path{{Elem: elems}},
+	}
+}
@@ -9,12 +9,29 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/match"
 	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/proto"
+
+	gpath "github.com/openconfig/gnmic/pkg/path"
 )
 
 func Test_gnmiCache_read(t *testing.T) {
@@ -335,12 +352,15 @@ func Test_gnmiCache_read(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gc := newGNMICache(&Config{}, "oc", WithLogger(log.Default()))
+			gc := newGNMICache(&Config{}, WithLogger(log.Default()))
 			for _, in := range tt.fields.inputs {
 				gc.Write(context.TODO(), in.measName, in.m)
 			}
 
-			rsp := gc.read(tt.args.sub, tt.args.target, tt.args.p)
+			rsp, err := gc.read(tt.args.sub, tt.args.target, tt.args.p)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
 			if _, ok := rsp[tt.args.sub]; !ok && tt.args.sub != "" {
 				t.Errorf("%s: response does not contain the expected subscription name", tt.name)
 			}
@@ -359,3 +379,3306 @@ func Test_gnmiCache_read(t *testing.T) {
 		})
 	}
 }
+
+func Test_gnmiCache_atomicReplace(t *testing.T) {
+	ifPrefix := &gnmi.Path{
+		Target: "t1",
+		Elem: []*gnmi.PathElem{
+			{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+		},
+	}
+	now := time.Now()
+	tests := []struct {
+		name              string
+		atomicReplace     bool
+		expectedRespCount int
+		expectAtomic      bool
+	}{
+		{
+			name:              "merge",
+			atomicReplace:     false,
+			expectedRespCount: 2,
+			expectAtomic:      false,
+		},
+		{
+			name:              "replace",
+			atomicReplace:     true,
+			expectedRespCount: 1,
+			expectAtomic:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := newGNMICache(&Config{AtomicReplace: tt.atomicReplace}, WithLogger(log.Default()))
+			// non atomic children
+			gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+				Response: &gnmi.SubscribeResponse_Update{
+					Update: &gnmi.Notification{
+						Timestamp: now.UnixNano(),
+						Prefix:    ifPrefix,
+						Update: []*gnmi.Update{
+							{
+								Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "admin-state"}}},
+								Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "enable"}},
+							},
+							{
+								Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "description"}}},
+								Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "stale"}},
+							},
+						},
+					},
+				},
+			})
+			// full state push
+			gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+				Response: &gnmi.SubscribeResponse_Update{
+					Update: &gnmi.Notification{
+						Timestamp: now.Add(time.Second).UnixNano(),
+						Prefix:    ifPrefix,
+						Atomic:    true,
+						Update: []*gnmi.Update{
+							{
+								Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "admin-state"}}},
+								Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "disable"}},
+							},
+						},
+					},
+				},
+			})
+			rsp, _ := gc.read("sub1", "*", nil)
+			if len(rsp["sub1"]) != tt.expectedRespCount {
+				t.Fatalf("unexpected response count, got %d, expected %d", len(rsp["sub1"]), tt.expectedRespCount)
+			}
+			if rsp["sub1"][0].GetAtomic() != tt.expectAtomic {
+				t.Errorf("unexpected atomic flag, got %v, expected %v", rsp["sub1"][0].GetAtomic(), tt.expectAtomic)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_pathOrdered(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	for _, name := range []string{"e1/3", "e1/1", "e1/2", "e1/10"} {
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now,
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Update: []*gnmi.Update{
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{
+								{Name: "interface", Key: map[string]string{"name": name}},
+								{Name: "admin-state"},
+							}},
+							Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "enable"}},
+						},
+					},
+				},
+			},
+		})
+	}
+	ch := gc.Subscribe(context.TODO(), &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Mode:         ReadMode_Once,
+		PathOrdered:  true,
+	})
+	xpaths := make([]string, 0)
+	for n := range ch {
+		if n.Err != nil {
+			t.Fatalf("unexpected error: %v", n.Err)
+		}
+		if n.SyncResponse {
+			continue
+		}
+		xpaths = append(xpaths, notificationXPath(n.Notification))
+	}
+	expected := []string{
+		"interface[name=e1/10]/admin-state",
+		"interface[name=e1/1]/admin-state",
+		"interface[name=e1/2]/admin-state",
+		"interface[name=e1/3]/admin-state",
+	}
+	if len(xpaths) != len(expected) {
+		t.Fatalf("unexpected notifications count, got %d, expected %d", len(xpaths), len(expected))
+	}
+	for i := range expected {
+		if xpaths[i] != expected[i] {
+			t.Errorf("unexpected notification at index %d, got %q, expected %q", i, xpaths[i], expected[i])
+		}
+	}
+}
+
+func Test_gnmiCache_bundle(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	for i, leaf := range []string{"admin-state", "description", "mtu"} {
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now + int64(i),
+					Prefix: &gnmi.Path{Target: "t1", Elem: []*gnmi.PathElem{
+						{Name: "interface", Key: map[string]string{"name": "e1/1"}},
+					}},
+					Update: []*gnmi.Update{
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: leaf}}},
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "value"}},
+						},
+					},
+				},
+			},
+		})
+	}
+	ch := gc.Subscribe(context.TODO(), &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Mode:         ReadMode_Once,
+		Bundle:       true,
+	})
+	ns := make([]*gnmi.Notification, 0)
+	for n := range ch {
+		if n.Err != nil {
+			t.Fatalf("unexpected error: %v", n.Err)
+		}
+		if n.SyncResponse {
+			continue
+		}
+		ns = append(ns, n.Notification)
+	}
+	if len(ns) != 1 {
+		t.Fatalf("unexpected notifications count, got %d, expected 1", len(ns))
+	}
+	if len(ns[0].GetUpdate()) != 3 {
+		t.Errorf("unexpected updates count, got %d, expected 3", len(ns[0].GetUpdate()))
+	}
+	if ns[0].GetTimestamp() != now+2 {
+		t.Errorf("unexpected timestamp, got %d, expected the newest %d", ns[0].GetTimestamp(), now+2)
+	}
+	// the cached notifications are not modified by the bundling.
+	rsp, err := gc.Read("sub1", "t1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, n := range rsp["sub1"] {
+		if len(n.GetUpdate()) != 1 {
+			t.Errorf("cached notification modified: %v", n)
+		}
+	}
+	if len(Bundle(rsp["sub1"])) != 1 {
+		t.Errorf("unexpected bundles count, got %d, expected 1", len(Bundle(rsp["sub1"])))
+	}
+}
+
+func TestBundle(t *testing.T) {
+	prefix := func(target, name string) *gnmi.Path {
+		return &gnmi.Path{Target: target, Elem: []*gnmi.PathElem{
+			{Name: "interface", Key: map[string]string{"name": name}},
+		}}
+	}
+	upd := func(leaf string) []*gnmi.Update {
+		return []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: leaf}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "value"}},
+		}}
+	}
+	ns := []*gnmi.Notification{
+		{Timestamp: 2, Prefix: prefix("t1", "e1/1"), Update: upd("admin-state")},
+		{Timestamp: 1, Prefix: prefix("t1", "e1/2"), Update: upd("admin-state")},
+		{Timestamp: 3, Prefix: prefix("t1", "e1/1"), Delete: []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "mtu"}}}}},
+		{Timestamp: 1, Prefix: prefix("t2", "e1/1"), Update: upd("admin-state")},
+		{Timestamp: 4, Prefix: prefix("t1", "e1/1"), Update: upd("description"), Atomic: true},
+		{Timestamp: 1, Prefix: prefix("t1", "e1/2"), Update: upd("description")},
+	}
+	bundles := Bundle(ns)
+	expected := []struct {
+		target  string
+		ts      int64
+		updates int
+		deletes int
+		atomic  bool
+	}{
+		{target: "t1", ts: 3, updates: 1, deletes: 1},
+		{target: "t1", ts: 1, updates: 2},
+		{target: "t2", ts: 1, updates: 1},
+		{target: "t1", ts: 4, updates: 1, atomic: true},
+	}
+	if len(bundles) != len(expected) {
+		t.Fatalf("unexpected bundles count, got %d, expected %d", len(bundles), len(expected))
+	}
+	for i, e := range expected {
+		b := bundles[i]
+		if b.GetPrefix().GetTarget() != e.target || b.GetTimestamp() != e.ts ||
+			len(b.GetUpdate()) != e.updates || len(b.GetDelete()) != e.deletes || b.GetAtomic() != e.atomic {
+			t.Errorf("unexpected bundle at index %d: %v", i, b)
+		}
+	}
+	if len(ns[0].GetDelete()) != 0 || len(ns[1].GetUpdate()) != 1 {
+		t.Errorf("input notifications modified")
+	}
+}
+
+// hostnameResponse returns a SubscribeResponse for target t1 carrying
+// a single system/name/host-name update.
+func Test_gnmiCache_valueFilter(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	leaf := func(name string, v *gnmi.TypedValue) *gnmi.Update {
+		return &gnmi.Update{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: name}}}, Val: v}
+	}
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Prefix: &gnmi.Path{Target: "t1", Elem: []*gnmi.PathElem{
+					{Name: "interface", Key: map[string]string{"name": "e1/1"}},
+				}},
+				Update: []*gnmi.Update{
+					leaf("mtu", &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1500}}),
+					leaf("in-octets", &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 800}}),
+					leaf("utilization", &gnmi.TypedValue{Value: &gnmi.TypedValue_DoubleVal{DoubleVal: 1000.5}}),
+					leaf("description", &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "uplink to spine1"}}),
+					leaf("name", &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "1500"}}),
+					leaf("enabled", &gnmi.TypedValue{Value: &gnmi.TypedValue_BoolVal{BoolVal: true}}),
+				},
+			},
+		},
+	})
+	tests := []struct {
+		name   string
+		filter *ValueFilter
+		want   []string
+	}{
+		{
+			name:   "greater than",
+			filter: &ValueFilter{Operator: ValueFilterGreaterThan, Value: "1000"},
+			want:   []string{"mtu", "utilization"},
+		},
+		{
+			name:   "less than float",
+			filter: &ValueFilter{Operator: ValueFilterLessThan, Value: "1000.1"},
+			want:   []string{"in-octets"},
+		},
+		{
+			name:   "equal",
+			filter: &ValueFilter{Operator: ValueFilterEqual, Value: "1500"},
+			want:   []string{"mtu", "name"},
+		},
+		{
+			name:   "contains",
+			filter: &ValueFilter{Operator: ValueFilterContains, Value: "uplink"},
+			want:   []string{"description"},
+		},
+		{
+			name:   "not a number",
+			filter: &ValueFilter{Operator: ValueFilterGreaterThan, Value: "uplink"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := gc.Subscribe(context.TODO(), &ReadOpts{
+				Subscription: "sub1",
+				Target:       "t1",
+				Mode:         ReadMode_Once,
+				ValueFilter:  tt.filter,
+			})
+			got := make([]string, 0)
+			for n := range ch {
+				if n.Err != nil {
+					t.Fatalf("unexpected error: %v", n.Err)
+				}
+				for _, upd := range n.Notification.GetUpdate() {
+					got = append(got, upd.GetPath().GetElem()[0].GetName())
+				}
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("unexpected updates, got %v, expected %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("unexpected updates, got %v, expected %v", got, tt.want)
+				}
+			}
+		})
+	}
+	// the cached values are not modified by the filtering.
+	rsp, err := gc.Read("sub1", "t1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 6 {
+		t.Errorf("unexpected cached values count, got %d, expected 6", len(rsp["sub1"]))
+	}
+	// an unknown operator fails the read.
+	ch := gc.Subscribe(context.TODO(), &ReadOpts{
+		Subscription: "sub1",
+		Mode:         ReadMode_Once,
+		ValueFilter:  &ValueFilter{Operator: "~", Value: "1"},
+	})
+	n, ok := <-ch
+	if !ok || n.Err == nil {
+		t.Errorf("expected an error for an unknown operator, got %v", n)
+	}
+}
+
+func Test_gnmiCache_syncMarker(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	rsp := hostnameResponse(now, "srl1")
+	rsp.GetUpdate().Update[0].Path.Elem[2].Name = "domain-name"
+	gc.Write(context.TODO(), "sub1", rsp)
+
+	// kinds returns the kind of the count next notifications,
+	// "sync" for a sync marker and "value" otherwise.
+	kinds := func(ch chan *Notification, count int) []string {
+		t.Helper()
+		ks := make([]string, 0, count)
+		for len(ks) < count {
+			select {
+			case n, ok := <-ch:
+				if !ok {
+					t.Fatalf("channel closed after %v", ks)
+				}
+				if n.Err != nil {
+					t.Fatalf("unexpected error: %v", n.Err)
+				}
+				if n.SyncResponse {
+					if n.Notification != nil {
+						t.Errorf("unexpected notification in sync marker: %v", n.Notification)
+					}
+					ks = append(ks, "sync")
+					continue
+				}
+				ks = append(ks, "value")
+			case <-time.After(time.Second):
+				t.Fatalf("timeout after %v", ks)
+			}
+		}
+		return ks
+	}
+	t.Run("once", func(t *testing.T) {
+		ch := gc.Subscribe(context.TODO(), &ReadOpts{Subscription: "sub1", Mode: ReadMode_Once})
+		if ks := kinds(ch, 3); !reflect.DeepEqual(ks, []string{"value", "value", "sync"}) {
+			t.Errorf("unexpected notifications: %v", ks)
+		}
+		if _, ok := <-ch; ok {
+			t.Errorf("unexpected notification after the sync marker")
+		}
+	})
+	t.Run("sample", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		ch := gc.Subscribe(ctx, &ReadOpts{
+			Subscription:   "sub1",
+			Mode:           ReadMode_StreamSample,
+			SampleInterval: 100 * time.Millisecond,
+		})
+		// the marker is only sent after the first sample.
+		if ks := kinds(ch, 5); !reflect.DeepEqual(ks, []string{"value", "value", "sync", "value", "value"}) {
+			t.Errorf("unexpected notifications: %v", ks)
+		}
+	})
+	t.Run("sample updates-only", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		ch := gc.Subscribe(ctx, &ReadOpts{
+			Subscription:   "sub1",
+			Mode:           ReadMode_StreamSample,
+			SampleInterval: 100 * time.Millisecond,
+			UpdatesOnly:    true,
+		})
+		if ks := kinds(ch, 3); !reflect.DeepEqual(ks, []string{"sync", "value", "value"}) {
+			t.Errorf("unexpected notifications: %v", ks)
+		}
+	})
+}
+
+func Test_gnmiCache_sampleUpdatesOnly(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription:   "sub1",
+		Mode:           ReadMode_StreamSample,
+		SampleInterval: 50 * time.Millisecond,
+		UpdatesOnly:    true,
+	})
+	// values received within d, the sync marker excluded.
+	values := func(d time.Duration) []string {
+		t.Helper()
+		vs := make([]string, 0)
+		timeout := time.After(d)
+		for {
+			select {
+			case n := <-ch:
+				if n.Err != nil {
+					t.Fatalf("unexpected error: %v", n.Err)
+				}
+				if !n.SyncResponse {
+					vs = append(vs, n.Notification.GetUpdate()[0].GetVal().GetAsciiVal())
+				}
+			case <-timeout:
+				return vs
+			}
+		}
+	}
+	// the static value is sent by the first sample only.
+	if vs := values(300 * time.Millisecond); !reflect.DeepEqual(vs, []string{"srl1"}) {
+		t.Errorf("unexpected values %v, expected [srl1]", vs)
+	}
+	// a changed value is sent by the next sample.
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now+1, "srl2"))
+	if vs := values(300 * time.Millisecond); !reflect.DeepEqual(vs, []string{"srl2"}) {
+		t.Errorf("unexpected values %v, expected [srl2]", vs)
+	}
+}
+
+func Test_gnmiCache_alignToClock(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	gc.Write(context.TODO(), "sub1", hostnameResponse(time.Now().UnixNano(), "srl1"))
+	// the fake clock is 200ms before an hour boundary.
+	boundary := time.Now().Truncate(time.Hour).Add(time.Hour)
+	gc.clock = func() time.Time { return boundary.Add(-200 * time.Millisecond) }
+	if d := gc.untilBoundary(time.Hour); d != 200*time.Millisecond {
+		t.Fatalf("unexpected duration until the boundary %s", d)
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	start := time.Now()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription:   "sub1",
+		Mode:           ReadMode_StreamSample,
+		SampleInterval: time.Hour,
+		AlignToClock:   true,
+	})
+	next := func() (*Notification, time.Duration) {
+		t.Helper()
+		for {
+			select {
+			case n := <-ch:
+				if n.Err != nil {
+					t.Fatalf("unexpected error: %v", n.Err)
+				}
+				if !n.SyncResponse {
+					return n, time.Since(start)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for a sample")
+			}
+		}
+	}
+	// the initial values are sent right away.
+	if _, d := next(); d > 100*time.Millisecond {
+		t.Errorf("initial values sent after %s", d)
+	}
+	// the first sample is taken on the boundary, not an interval later.
+	if _, d := next(); d < 200*time.Millisecond || d > time.Second {
+		t.Errorf("first sample taken after %s, expected 200ms", d)
+	}
+}
+
+func hostnameResponse(ts int64, name string) *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: ts,
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: name}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_gnmiCache_readDeleted(t *testing.T) {
+	hostname := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}}
+	deleteResponse := func(ts int64) *gnmi.SubscribeResponse {
+		return &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: ts,
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Delete:    []*gnmi.Path{hostname},
+				},
+			},
+		}
+	}
+	gc := newGNMICache(&Config{Expiration: time.Minute}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	gc.Write(context.TODO(), "sub1", deleteResponse(now+1))
+	// ReadAll returns the delete in place of the deleted value.
+	rsp, err := gc.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || len(rsp["sub1"][0].GetDelete()) != 1 ||
+		notificationXPath(rsp["sub1"][0]) != "system/name/host-name" {
+		t.Fatalf("expected the delete notification, got %v", rsp["sub1"])
+	}
+	// as well as a read of a parent path.
+	rsp, err = gc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || len(rsp["sub1"][0].GetDelete()) != 1 {
+		t.Errorf("expected the delete notification, got %v", rsp["sub1"])
+	}
+	// the delete is forgotten once the path is written again.
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now+2, "srl2"))
+	rsp, err = gc.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || len(rsp["sub1"][0].GetUpdate()) != 1 {
+		t.Errorf("expected the written value only, got %v", rsp["sub1"])
+	}
+	// an older delete does not delete the value, it is not returned.
+	gc.Write(context.TODO(), "sub1", deleteResponse(now+1))
+	rsp, err = gc.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || len(rsp["sub1"][0].GetUpdate()) != 1 {
+		t.Errorf("expected the written value only, got %v", rsp["sub1"])
+	}
+	// an expired delete is not returned, and is forgotten by the sweeper.
+	gc.Write(context.TODO(), "sub2", deleteResponse(time.Now().Add(-2*time.Minute).UnixNano()))
+	rsp, err = gc.Read("sub2", "*", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub2"]) != 0 {
+		t.Errorf("unexpected expired delete: %v", rsp["sub2"])
+	}
+	gc.sweep()
+	sc, _ := gc.caches.Load("sub2")
+	if ns := sc.(*subCache).deleted.query("*", nil); len(ns) != 0 {
+		t.Errorf("expired delete not swept: %v", ns)
+	}
+	// a ONCE subscription only returns the current values, not the deletes.
+	gc.Write(context.TODO(), "sub1", deleteResponse(now+4))
+	ch := gc.Subscribe(context.TODO(), &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Paths:        []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "system"}}}},
+		Mode:         ReadMode_Once,
+	})
+	for n := range ch {
+		if !n.SyncResponse {
+			t.Errorf("unexpected notification for a deleted path: %v", n)
+		}
+	}
+}
+
+func Test_gnmiCache_writeError(t *testing.T) {
+	now := time.Now().UnixNano()
+	update := func(n *gnmi.Notification) *gnmi.SubscribeResponse {
+		return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: n}}
+	}
+	hostname := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}}
+	tests := []struct {
+		name string
+		sub  string
+		rsp  *gnmi.SubscribeResponse
+		err  error
+	}{
+		{
+			name: "written",
+			sub:  "sub1",
+			rsp:  hostnameResponse(now, "srl1"),
+		},
+		{
+			name: "sync_response",
+			sub:  "sub1",
+			rsp:  &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}},
+		},
+		{
+			name: "missing_target",
+			sub:  "sub1",
+			rsp: update(&gnmi.Notification{
+				Timestamp: now,
+				Update:    []*gnmi.Update{{Path: hostname, Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "srl1"}}}},
+			}),
+			err: ErrMissingTarget,
+		},
+		{
+			name: "empty_path",
+			sub:  "sub1",
+			rsp: update(&gnmi.Notification{
+				Timestamp: now,
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Update:    []*gnmi.Update{{Path: &gnmi.Path{}, Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "srl1"}}}},
+			}),
+			err: ErrEmptyPath,
+		},
+		{
+			name: "empty_notification",
+			sub:  "sub1",
+			rsp: update(&gnmi.Notification{
+				Timestamp: now,
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Update:    []*gnmi.Update{{Path: hostname}},
+			}),
+			err: ErrEmptyNotification,
+		},
+		{
+			name: "read_only",
+			sub:  "sub2",
+			rsp:  hostnameResponse(now, "srl1"),
+			err:  ErrReadOnly,
+		},
+		{
+			name: "clock_skew",
+			sub:  "sub1",
+			rsp:  hostnameResponse(time.Now().Add(time.Hour).UnixNano(), "srl1"),
+			err:  ErrClockSkew,
+		},
+		{
+			name: "rejected",
+			sub:  "sub1",
+			rsp:  hostnameResponse(now-1, "srl0"),
+			err:  ErrRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := newGNMICache(&Config{
+				ReadOnlySubscriptions: []string{"sub2"},
+				MaxClockSkew:          time.Minute,
+			}, WithLogger(log.Default()))
+			// the rejected update is older than the cached value.
+			if tt.name == "rejected" {
+				if err := gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1")); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			err := gc.Write(context.TODO(), tt.sub, tt.rsp)
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("unexpected error, got %v, expected %v", err, tt.err)
+			}
+			var werr *WriteError
+			if !errors.As(err, &werr) || werr.Subscription != tt.sub {
+				t.Errorf("unexpected write error: %#v", err)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_readOnly(t *testing.T) {
+	now := time.Now()
+	gc := newGNMICache(&Config{ReadOnlySubscriptions: []string{"sub2"}}, WithLogger(log.Default()))
+	// configured as read-only
+	gc.Write(context.TODO(), "sub2", hostnameResponse(now.UnixNano(), "srl1"))
+	if rsp, _ := gc.read("sub2", "*", nil); len(rsp["sub2"]) != 0 {
+		t.Errorf("unexpected write to read-only subscription: %v", rsp["sub2"])
+	}
+	// marked as read-only after being populated
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+	gc.SetReadOnly("sub1", true)
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Second).UnixNano(), "srl2"))
+	rsp, _ := gc.read("sub1", "*", nil)
+	if len(rsp["sub1"]) != 1 {
+		t.Fatalf("unexpected response count, got %d, expected 1", len(rsp["sub1"]))
+	}
+	if v := rsp["sub1"][0].GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl1" {
+		t.Errorf("read-only subscription was overwritten, got %q, expected %q", v, "srl1")
+	}
+	// writable again
+	gc.SetReadOnly("sub1", false)
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Second).UnixNano(), "srl2"))
+	rsp, _ = gc.read("sub1", "*", nil)
+	if v := rsp["sub1"][0].GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl2" {
+		t.Errorf("unexpected value, got %q, expected %q", v, "srl2")
+	}
+}
+
+func Test_gnmiCache_subscribeExpiration(t *testing.T) {
+	gc := newGNMICache(&Config{
+		Expiration: time.Hour,
+		Subscriptions: map[string]*SubscriptionConfig{
+			"sub1": {Expiration: time.Minute},
+		},
+	}, WithLogger(log.Default()))
+	now := time.Now()
+	for i, ts := range []time.Time{now.Add(-10 * time.Minute), now} {
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: ts.UnixNano(),
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Update: []*gnmi.Update{
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{
+								{Name: "interface", Key: map[string]string{"name": fmt.Sprintf("e1/%d", i)}},
+								{Name: "admin-state"},
+							}},
+							Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "enable"}},
+						},
+					},
+				},
+			},
+		})
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Mode:         ReadMode_StreamOnChange,
+	})
+	received := make([]*gnmi.Notification, 0)
+	timer := time.NewTimer(200 * time.Millisecond)
+	defer timer.Stop()
+LOOP:
+	for {
+		select {
+		case n := <-ch:
+			received = append(received, n.Notification)
+		case <-timer.C:
+			break LOOP
+		}
+	}
+	if len(received) != 1 {
+		t.Fatalf("unexpected initial notifications count, got %d, expected 1", len(received))
+	}
+	if received[0].GetTimestamp() != now.UnixNano() {
+		t.Errorf("unexpected notification: %v", received[0])
+	}
+}
+
+func Test_gnmiCache_maxAge(t *testing.T) {
+	gc := newGNMICache(&Config{Expiration: time.Hour}, WithLogger(log.Default()))
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(-10*time.Minute).UnixNano(), "srl1"))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	for n := range gc.Subscribe(ctx, &ReadOpts{Target: "t1", Mode: ReadMode_Once, MaxAge: time.Minute}) {
+		if !n.SyncResponse {
+			t.Errorf("unexpected notification older than max-age: %v", n.Notification)
+		}
+	}
+	// the values are only filtered from the reads setting MaxAge.
+	count := 0
+	for n := range gc.Subscribe(ctx, &ReadOpts{Target: "t1", Mode: ReadMode_Once}) {
+		if !n.SyncResponse {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("unexpected notifications count, got %d, expected 1", count)
+	}
+
+	ch := gc.Subscribe(ctx, &ReadOpts{Target: "t1", Mode: ReadMode_StreamOnChange, MaxAge: time.Minute})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		gc.Write(ctx, "sub1", hostnameResponse(now.Add(-5*time.Minute).UnixNano(), "srl2"))
+		gc.Write(ctx, "sub1", hostnameResponse(now.UnixNano(), "srl3"))
+	}()
+	select {
+	case n := <-ch:
+		if v := n.Notification.GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl3" {
+			t.Errorf("unexpected value, got %q, expected %q", v, "srl3")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the on-change notification")
+	}
+}
+
+func Test_gnmiCache_readStatus(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	gc.Write(context.TODO(), "sub1", hostnameResponse(time.Now().UnixNano(), "srl1"))
+
+	_, err := gc.Read("sub2", "t1", nil)
+	if !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrSubscriptionNotFound)
+	}
+	_, err = gc.Read("sub1", "t2", nil)
+	if !errors.Is(err, ErrTargetNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrTargetNotFound)
+	}
+	rsp, err := gc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 0 {
+		t.Errorf("unexpected notifications: %v", rsp["sub1"])
+	}
+}
+
+func Test_gnmiCache_queryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	// the eviction callback blocks the queries visiting the expired leaf.
+	gc := newGNMICache(&Config{Expiration: time.Minute, SweepInterval: -1, QueryTimeout: 100 * time.Millisecond},
+		WithLogger(log.Default()),
+		WithOnEvict(func(_, _, _ string) { <-release }),
+	)
+	defer gc.Stop()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(time.Now().Add(-time.Hour).UnixNano(), "srl1"))
+
+	start := time.Now()
+	_, err := gc.Read("sub1", "t1", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("read returned after %s", d)
+	}
+}
+
+func Test_gnmiCache_queryTimeoutSlowSubscriber(t *testing.T) {
+	gc := newGNMICache(&Config{QueryTimeout: 100 * time.Millisecond}, WithLogger(log.Default()))
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+	rsp := hostnameResponse(now.UnixNano(), "srl1")
+	rsp.GetUpdate().Update[0].Path.Elem[2].Name = "domain-name"
+	gc.Write(context.TODO(), "sub1", rsp)
+
+	ch := gc.Subscribe(context.TODO(), &ReadOpts{Subscription: "sub1", Target: "t1", Mode: ReadMode_Once})
+	// the subscriber does not read the channel until the query timed out.
+	time.Sleep(300 * time.Millisecond)
+	n, ok := <-ch
+	if !ok || !errors.Is(n.Err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected notification: %v", n)
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("unexpected notification after the query timed out")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("subscription channel not closed")
+	}
+}
+
+func Test_gnmiCache_readHistory(t *testing.T) {
+	gc := newGNMICache(&Config{HistoryDepth: 3}, WithLogger(log.Default()))
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Duration(i)*time.Second).UnixNano(), fmt.Sprintf("srl%d", i)))
+	}
+	p := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}}}
+	rsp, err := gc.ReadHistory("sub1", "t1", p, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := make([]string, 0, len(rsp["sub1"]))
+	for _, n := range rsp["sub1"] {
+		got = append(got, n.GetUpdate()[0].GetVal().GetAsciiVal())
+	}
+	if !reflect.DeepEqual(got, []string{"srl4", "srl3", "srl2"}) {
+		t.Errorf("unexpected history: %v", got)
+	}
+	rsp, err = gc.ReadHistory("sub1", "t1", p, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || rsp["sub1"][0].GetUpdate()[0].GetVal().GetAsciiVal() != "srl4" {
+		t.Errorf("unexpected history with depth 1: %v", rsp["sub1"])
+	}
+	_, err = gc.ReadHistory("sub1", "t2", p, 0)
+	if !errors.Is(err, ErrTargetNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrTargetNotFound)
+	}
+	// deleting the path removes its history
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now.Add(10 * time.Second).UnixNano(),
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Delete:    []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "system"}}}},
+			},
+		},
+	})
+	rsp, err = gc.ReadHistory("sub1", "t1", p, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 0 {
+		t.Errorf("unexpected history after delete: %v", rsp["sub1"])
+	}
+}
+
+func Test_gnmiCache_historyRead(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ts := func(i int) int64 { return now.Add(time.Duration(i-10) * time.Second).UnixNano() }
+	// values received by a once history read, the sync marker excluded.
+	read := func(t *testing.T, gc *gnmiCache, ro *ReadOpts) []string {
+		t.Helper()
+		ro.Subscription = "sub1"
+		ro.Mode = ReadMode_Once
+		ro.History = true
+		vs := make([]string, 0)
+		for n := range gc.Subscribe(context.TODO(), ro) {
+			if n.Err != nil {
+				t.Fatalf("unexpected error: %v", n.Err)
+			}
+			if !n.SyncResponse {
+				vs = append(vs, n.Notification.GetUpdate()[0].GetVal().GetAsciiVal())
+			}
+		}
+		return vs
+	}
+	tests := []struct {
+		name   string
+		cfg    *Config
+		ro     *ReadOpts
+		values []string
+	}{
+		{
+			name:   "all_retained",
+			cfg:    &Config{HistoryDepth: 5},
+			ro:     &ReadOpts{},
+			values: []string{"srl0", "srl1", "srl2", "srl3", "srl4"},
+		},
+		{
+			name:   "evicted_at_depth",
+			cfg:    &Config{HistoryDepth: 3},
+			ro:     &ReadOpts{},
+			values: []string{"srl2", "srl3", "srl4"},
+		},
+		{
+			name: "window",
+			cfg:  &Config{HistoryDepth: 5},
+			ro: &ReadOpts{
+				HistoryStart: time.Unix(0, ts(1)),
+				HistoryEnd:   time.Unix(0, ts(3)),
+			},
+			values: []string{"srl1", "srl2", "srl3"},
+		},
+		{
+			name:   "open_ended_window",
+			cfg:    &Config{HistoryDepth: 5},
+			ro:     &ReadOpts{HistoryStart: time.Unix(0, ts(3))},
+			values: []string{"srl3", "srl4"},
+		},
+		{
+			name:   "expired",
+			cfg:    &Config{HistoryDepth: 5, Expiration: 8 * time.Second, SweepInterval: -1},
+			ro:     &ReadOpts{},
+			values: []string{"srl3", "srl4"},
+		},
+		{
+			name:   "latest_only",
+			cfg:    &Config{},
+			ro:     &ReadOpts{},
+			values: []string{"srl4"},
+		},
+		{
+			name:   "latest_out_of_window",
+			cfg:    &Config{},
+			ro:     &ReadOpts{HistoryEnd: time.Unix(0, ts(3))},
+			values: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := newGNMICache(tt.cfg, WithLogger(log.Default()))
+			defer gc.Stop()
+			for i := 0; i < 5; i++ {
+				gc.Write(context.TODO(), "sub1", hostnameResponse(ts(i), fmt.Sprintf("srl%d", i)))
+			}
+			if vs := read(t, gc, tt.ro); !reflect.DeepEqual(vs, tt.values) {
+				t.Errorf("unexpected values %v, expected %v", vs, tt.values)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_readRaw(t *testing.T) {
+	gc := newGNMICache(&Config{RetainRaw: true}, WithLogger(log.Default()))
+	now := time.Now()
+	// the third response is older than the cached value, it is
+	// rejected by the cache tree, but it is retained as is.
+	rsps := []*gnmi.SubscribeResponse{
+		hostnameResponse(now.Add(-2*time.Hour).UnixNano(), "srl0"),
+		hostnameResponse(now.Add(time.Second).UnixNano(), "srl1"),
+		hostnameResponse(now.UnixNano(), "srl1"),
+		{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}},
+	}
+	for _, rsp := range rsps {
+		gc.Write(context.TODO(), "sub1", rsp)
+	}
+	rs, err := gc.ReadRaw("sub1", "t1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// expired response not returned, ordered by timestamp
+	if len(rs["sub1"]) != 2 || rs["sub1"][0] != rsps[2] || rs["sub1"][1] != rsps[1] {
+		t.Errorf("unexpected raw responses: %v", rs["sub1"])
+	}
+	rs, err = gc.ReadRaw("*", "*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the sync response is ordered by its write time.
+	if len(rs["sub1"]) != 3 || !rs["sub1"][1].GetSyncResponse() {
+		t.Errorf("unexpected raw responses with sync: %v", rs["sub1"])
+	}
+	if _, err = gc.ReadRaw("sub1", "t2"); !errors.Is(err, ErrTargetNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrTargetNotFound)
+	}
+	if _, err = gc.ReadRaw("sub2", "t1"); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrSubscriptionNotFound)
+	}
+	gc.DeleteTarget("t1")
+	if rs, _ = gc.ReadRaw("sub1", "*"); len(rs["sub1"]) != 1 {
+		t.Errorf("unexpected raw responses after target removal: %v", rs["sub1"])
+	}
+	if _, err = newGNMICache(&Config{}).ReadRaw("sub1", "t1"); !errors.Is(err, ErrRawNotRetained) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrRawNotRetained)
+	}
+}
+
+func Test_gnmiCache_latestAll(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now()
+	for i, target := range []string{"t1", "t2"} {
+		for j := 0; j < 3; j++ {
+			rsp := hostnameResponse(now.Add(time.Duration(j)*time.Second).UnixNano(), fmt.Sprintf("%s-%d", target, j))
+			rsp.GetUpdate().Prefix.Target = target
+			// write a second path with an older timestamp
+			rsp.GetUpdate().Update = append(rsp.GetUpdate().Update, &gnmi.Update{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "domain-name"}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: fmt.Sprintf("d%d", i)}},
+			})
+			gc.Write(context.TODO(), "sub1", rsp)
+		}
+	}
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now.Add(-time.Second).UnixNano(),
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "information"}, {Name: "version"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "v1"}},
+					},
+				},
+			},
+		},
+	})
+	latest := gc.LatestAll("sub1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}}})
+	if len(latest) != 2 {
+		t.Fatalf("unexpected number of targets, got %d, expected 2", len(latest))
+	}
+	for _, target := range []string{"t1", "t2"} {
+		n, ok := latest[target]
+		if !ok {
+			t.Fatalf("missing target %q", target)
+		}
+		if n.GetTimestamp() != now.Add(2*time.Second).UnixNano() {
+			t.Errorf("target %q: unexpected notification timestamp %d", target, n.GetTimestamp())
+		}
+	}
+	latest = gc.LatestAll("sub1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "information"}}})
+	if len(latest) != 1 || latest["t1"] == nil {
+		t.Errorf("unexpected result: %v", latest)
+	}
+}
+
+func Test_gnmiCache_listTargets(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	for sub, targets := range map[string][]string{
+		"sub1": {"t2", "t1"},
+		"sub2": {"t2"},
+	} {
+		for _, target := range targets {
+			rsp := hostnameResponse(now, target)
+			rsp.GetUpdate().Prefix.Target = target
+			gc.Write(context.TODO(), sub, rsp)
+		}
+	}
+	expected := map[string][]string{
+		"sub1": {"t1", "t2"},
+		"sub2": {"t2"},
+	}
+	targets := gc.ListTargets()
+	if !reflect.DeepEqual(targets, expected) {
+		t.Fatalf("unexpected targets, got %v, expected %v", targets, expected)
+	}
+	// the returned map is a copy.
+	targets["sub1"][0] = "t3"
+	delete(targets, "sub2")
+	if !reflect.DeepEqual(gc.ListTargets(), expected) {
+		t.Errorf("cache targets modified through the returned map: %v", gc.ListTargets())
+	}
+	gc.DeleteTarget("t2")
+	expected = map[string][]string{
+		"sub1": {"t1"},
+		"sub2": {},
+	}
+	if targets = gc.ListTargets(); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("unexpected targets after delete, got %v, expected %v", targets, expected)
+	}
+}
+
+func Test_gnmiCache_deleteTargetReport(t *testing.T) {
+	now := time.Now().UnixNano()
+	newCache := func() *gnmiCache {
+		gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+		for sub, targets := range map[string][]string{
+			"sub1": {"leaf1", "leaf2", "spine1"},
+			"sub2": {"leaf1"},
+			"sub3": {"spine1"},
+		} {
+			for _, target := range targets {
+				rsp := hostnameResponse(now, target)
+				rsp.GetUpdate().Prefix.Target = target
+				gc.Write(context.TODO(), sub, rsp)
+			}
+		}
+		return gc
+	}
+	tests := []struct {
+		name           string
+		target         string
+		expectedReport map[string]bool
+		expected       map[string][]string
+	}{
+		{
+			name:           "exact",
+			target:         "leaf1",
+			expectedReport: map[string]bool{"sub1": true, "sub2": true, "sub3": false},
+			expected:       map[string][]string{"sub1": {"leaf2", "spine1"}, "sub2": {}, "sub3": {"spine1"}},
+		},
+		{
+			name:           "glob",
+			target:         "leaf*",
+			expectedReport: map[string]bool{"sub1": true, "sub2": true, "sub3": false},
+			expected:       map[string][]string{"sub1": {"spine1"}, "sub2": {}, "sub3": {"spine1"}},
+		},
+		{
+			name:           "all",
+			target:         "*",
+			expectedReport: map[string]bool{"sub1": true, "sub2": true, "sub3": true},
+			expected:       map[string][]string{"sub1": {}, "sub2": {}, "sub3": {}},
+		},
+		{
+			name:           "unknown",
+			target:         "leaf3",
+			expectedReport: map[string]bool{"sub1": false, "sub2": false, "sub3": false},
+			expected:       map[string][]string{"sub1": {"leaf1", "leaf2", "spine1"}, "sub2": {"leaf1"}, "sub3": {"spine1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := newCache()
+			report := gc.DeleteTargetReport(tt.target)
+			if !reflect.DeepEqual(report, tt.expectedReport) {
+				t.Errorf("unexpected report, got %v, expected %v", report, tt.expectedReport)
+			}
+			if targets := gc.ListTargets(); !reflect.DeepEqual(targets, tt.expected) {
+				t.Errorf("unexpected targets, got %v, expected %v", targets, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_includeOldValue(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription:    "sub1",
+		Target:          "t1",
+		Mode:            ReadMode_StreamOnChange,
+		UpdatesOnly:     true,
+		IncludeOldValue: true,
+	})
+	// wait for the on-change query to be registered
+	time.Sleep(100 * time.Millisecond)
+	receive := func() *Notification {
+		t.Helper()
+		select {
+		case n := <-ch:
+			return n
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for a notification")
+		}
+		return nil
+	}
+
+	// the subscribers are notified synchronously by Write.
+	go gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Second).UnixNano(), "srl2"))
+	n := receive()
+	if v := n.Notification.GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl2" {
+		t.Errorf("unexpected value, got %q, expected %q", v, "srl2")
+	}
+	if v := n.OldValue.GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl1" {
+		t.Errorf("unexpected old value, got %q, expected %q", v, "srl1")
+	}
+
+	go gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now.Add(2 * time.Second).UnixNano(),
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Delete:    []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "system"}}}},
+			},
+		},
+	})
+	n = receive()
+	if len(n.Notification.GetDelete()) != 1 {
+		t.Fatalf("expected a delete notification, got %v", n.Notification)
+	}
+	if v := n.OldValue.GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl2" {
+		t.Errorf("unexpected old value for delete, got %q, expected %q", v, "srl2")
+	}
+}
+
+type countingMatcher struct {
+	Matcher
+	m       *sync.Mutex
+	queries int
+	removes int
+	updates int
+}
+
+func (cm *countingMatcher) AddQuery(query []string, client match.Client) func() {
+	cm.m.Lock()
+	cm.queries++
+	cm.m.Unlock()
+	remove := cm.Matcher.AddQuery(query, client)
+	return func() {
+		cm.m.Lock()
+		cm.removes++
+		cm.m.Unlock()
+		remove()
+	}
+}
+
+func (cm *countingMatcher) UpdateNotification(v interface{}, n *gnmi.Notification, prefix []string) {
+	cm.m.Lock()
+	cm.updates++
+	cm.m.Unlock()
+	cm.Matcher.UpdateNotification(v, n, prefix)
+}
+
+func Test_gnmiCache_matchFactory(t *testing.T) {
+	cm := &countingMatcher{Matcher: newDefaultMatcher(), m: new(sync.Mutex)}
+	gc := newGNMICache(&Config{},
+		WithLogger(log.Default()),
+		WithMatchFactory(func() Matcher { return cm }),
+	)
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		UpdatesOnly:  true,
+	})
+	time.Sleep(100 * time.Millisecond)
+	go gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Second).UnixNano(), "srl2"))
+	select {
+	case n := <-ch:
+		if v := n.Notification.GetUpdate()[0].GetVal().GetAsciiVal(); v != "srl2" {
+			t.Errorf("unexpected value, got %q, expected %q", v, "srl2")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for a notification")
+	}
+	cm.m.Lock()
+	defer cm.m.Unlock()
+	if cm.queries != 1 {
+		t.Errorf("unexpected number of queries, got %d, expected 1", cm.queries)
+	}
+	if cm.updates != 2 {
+		t.Errorf("unexpected number of updates, got %d, expected 2", cm.updates)
+	}
+}
+
+func Test_gnmiCache_subscribeCancel(t *testing.T) {
+	cm := &countingMatcher{Matcher: newDefaultMatcher(), m: new(sync.Mutex)}
+	gc := newGNMICache(&Config{},
+		WithLogger(log.Default()),
+		WithMatchFactory(func() Matcher { return cm }),
+	)
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.TODO())
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Paths: []*gnmi.Path{
+			{Elem: []*gnmi.PathElem{{Name: "system"}}},
+			{Elem: []*gnmi.PathElem{{Name: "interface"}}},
+			{Elem: []*gnmi.PathElem{{Name: "network-instance"}}},
+		},
+		UpdatesOnly:       true,
+		HeartbeatInterval: 50 * time.Millisecond,
+	})
+	time.Sleep(100 * time.Millisecond)
+	// the subscriber does not read the channel,
+	// the write is blocked until the subscription is canceled.
+	written := make(chan struct{})
+	go func() {
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Second).UnixNano(), "srl2"))
+		close(written)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// the subscription goroutines wait for the cancellation without spinning.
+	checkNoSpin(t, "subscribed")
+	cancel()
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("write blocked after the subscription was canceled")
+	}
+	waitGoroutines(t, baseline)
+	checkNoSpin(t, "canceled")
+	if _, ok := <-ch; ok {
+		t.Errorf("subscription channel not closed")
+	}
+	cm.m.Lock()
+	defer cm.m.Unlock()
+	if cm.queries != 3 || cm.removes != cm.queries {
+		t.Errorf("unexpected queries count %d and removes count %d", cm.queries, cm.removes)
+	}
+}
+
+func Test_gnmiCache_subscribeCancelInitial(t *testing.T) {
+	cm := &countingMatcher{Matcher: newDefaultMatcher(), m: new(sync.Mutex)}
+	gc := newGNMICache(&Config{},
+		WithLogger(log.Default()),
+		WithMatchFactory(func() Matcher { return cm }),
+	)
+	gc.Write(context.TODO(), "sub1", hostnameResponse(time.Now().UnixNano(), "srl1"))
+
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.TODO())
+	// the first path has no initial value, its query is added,
+	// the initial value of the second path is never read.
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Paths: []*gnmi.Path{
+			{Elem: []*gnmi.PathElem{{Name: "interface"}}},
+			{Elem: []*gnmi.PathElem{{Name: "system"}}},
+			{Elem: []*gnmi.PathElem{{Name: "network-instance"}}},
+		},
+	})
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	waitGoroutines(t, baseline)
+	checkNoSpin(t, "canceled")
+	for range ch {
+	}
+	cm.m.Lock()
+	defer cm.m.Unlock()
+	if cm.queries != 1 || cm.removes != cm.queries {
+		t.Errorf("unexpected queries count %d and removes count %d", cm.queries, cm.removes)
+	}
+}
+
+// waitGoroutines fails the test if the number of goroutines
+// does not return to baseline within 2 seconds.
+func waitGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("goroutines leaked, got %d, baseline %d", n, baseline)
+	}
+}
+
+// checkNoSpin fails the test if the process uses
+// more than half a CPU over 200ms.
+func checkNoSpin(t *testing.T, state string) {
+	t.Helper()
+	start := userCPUSeconds()
+	time.Sleep(200 * time.Millisecond)
+	if used := userCPUSeconds() - start; used > 0.1 {
+		t.Errorf("%s: %.3fs of CPU used in 200ms", state, used)
+	}
+}
+
+// userCPUSeconds returns the CPU time spent running Go code,
+// the runtime only updates it on GC.
+func userCPUSeconds() float64 {
+	runtime.GC()
+	s := []metrics.Sample{{Name: "/cpu/classes/user:cpu-seconds"}}
+	metrics.Read(s)
+	return s[0].Value.Float64()
+}
+
+func Test_gnmiCache_heartbeatPaths(t *testing.T) {
+	cm := &countingMatcher{Matcher: newDefaultMatcher(), m: new(sync.Mutex)}
+	gc := newGNMICache(&Config{},
+		WithLogger(log.Default()),
+		WithMatchFactory(func() Matcher { return cm }),
+	)
+	now := time.Now()
+	interfaceResponse := func(ts int64, mtu int64) *gnmi.SubscribeResponse {
+		return &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: ts,
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Update: []*gnmi.Update{
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface"}, {Name: "mtu"}}},
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: mtu}},
+						},
+					},
+				},
+			},
+		}
+	}
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+	gc.Write(context.TODO(), "sub1", interfaceResponse(now.UnixNano(), 1500))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	heartbeat := 300 * time.Millisecond
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Paths: []*gnmi.Path{
+			{Elem: []*gnmi.PathElem{{Name: "system"}}},
+			{Elem: []*gnmi.PathElem{{Name: "interface"}}},
+		},
+		UpdatesOnly:       true,
+		HeartbeatInterval: heartbeat,
+	})
+	// receive returns the xpaths of the notifications received
+	// with timestamp ts within timeout.
+	receive := func(count int, ts int64, timeout time.Duration) []string {
+		t.Helper()
+		xpaths := make([]string, 0, count)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for len(xpaths) < count {
+			select {
+			case n := <-ch:
+				if n.Err != nil {
+					t.Fatalf("unexpected error: %v", n.Err)
+				}
+				if n.Notification.GetTimestamp() != ts {
+					t.Fatalf("unexpected notification: %v", n.Notification)
+				}
+				xpaths = append(xpaths, notificationXPath(n.Notification))
+			case <-timer.C:
+				t.Fatalf("received %d notification(s) out of %d: %v", len(xpaths), count, xpaths)
+			}
+		}
+		sort.Strings(xpaths)
+		return xpaths
+	}
+	expected := []string{"interface/mtu", "system/name/host-name"}
+	// the heartbeat samples both paths once the subscription starts.
+	if xpaths := receive(2, now.UnixNano(), heartbeat); !reflect.DeepEqual(xpaths, expected) {
+		t.Errorf("unexpected first heartbeat: %v", xpaths)
+	}
+	// both paths receive the changes before the next heartbeat,
+	// once their on-change queries are registered.
+	deadline := time.Now().Add(heartbeat / 2)
+	for {
+		cm.m.Lock()
+		queries := cm.queries
+		cm.m.Unlock()
+		if queries == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected number of queries, got %d, expected 2", queries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ts := now.Add(time.Second).UnixNano()
+	go func() {
+		gc.Write(context.TODO(), "sub1", hostnameResponse(ts, "srl2"))
+		gc.Write(context.TODO(), "sub1", interfaceResponse(ts, 9000))
+	}()
+	if xpaths := receive(2, ts, heartbeat/2); !reflect.DeepEqual(xpaths, expected) {
+		t.Errorf("unexpected on-change notifications: %v", xpaths)
+	}
+	// each path is sampled once per heartbeat.
+	if xpaths := receive(2, ts, 2*heartbeat); !reflect.DeepEqual(xpaths, expected) {
+		t.Errorf("unexpected second heartbeat: %v", xpaths)
+	}
+}
+
+func Test_gnmiCache_onEvict(t *testing.T) {
+	var mu sync.Mutex
+	var evicted []string
+	gc := newGNMICache(&Config{Expiration: time.Minute},
+		WithOnEvict(func(sub, target, xpath string) {
+			mu.Lock()
+			defer mu.Unlock()
+			evicted = append(evicted, sub+"|"+target+"|"+xpath)
+		}))
+	reset := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		rs := evicted
+		evicted = nil
+		return rs
+	}
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(-time.Hour).UnixNano(), "srl1"))
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now.UnixNano(),
+				Prefix:    &gnmi.Path{Target: "t1", Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "mtu"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1500}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "description"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "uplink"}},
+					},
+				},
+			},
+		},
+	})
+	// expired leaf skipped by two reads, reported once
+	for i := 0; i < 2; i++ {
+		_, err := gc.Read("sub1", "t1", nil)
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+	}
+	if rs := reset(); !reflect.DeepEqual(rs, []string{"sub1|t1|system/name/host-name"}) {
+		t.Errorf("unexpected evictions after read: %v", rs)
+	}
+	// explicit delete
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now.Add(time.Second).UnixNano(),
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Delete: []*gnmi.Path{{Elem: []*gnmi.PathElem{
+					{Name: "interface", Key: map[string]string{"name": "e1"}},
+					{Name: "mtu"},
+				}}},
+			},
+		},
+	})
+	if rs := reset(); !reflect.DeepEqual(rs, []string{"sub1|t1|interface[name=e1]/mtu"}) {
+		t.Errorf("unexpected evictions after delete: %v", rs)
+	}
+	// target removal
+	gc.DeleteTarget("t1")
+	rs := reset()
+	sort.Strings(rs)
+	if !reflect.DeepEqual(rs, []string{"sub1|t1|interface[name=e1]/description", "sub1|t1|system/name/host-name"}) {
+		t.Errorf("unexpected evictions after target removal: %v", rs)
+	}
+}
+
+func Test_gnmiCache_sweep(t *testing.T) {
+	var evicted atomic.Int32
+	gc := newGNMICache(&Config{Expiration: time.Minute, SweepInterval: -1},
+		WithLogger(log.Default()),
+		WithOnEvict(func(sub, target, xpath string) { evicted.Add(1) }))
+	now := time.Now()
+	gc.clock = func() time.Time { return now }
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+
+	gc.sweep()
+	if rsp, _ := gc.ReadAll(); len(rsp["sub1"]) != 1 {
+		t.Fatalf("unexpected notifications count before expiration, got %d, expected 1", len(rsp["sub1"]))
+	}
+	// advance the sweeper clock past the expiration,
+	// the reads still see the value as fresh.
+	gc.clock = func() time.Time { return now.Add(2 * time.Minute) }
+	gc.sweep()
+	if rsp, _ := gc.ReadAll(); len(rsp["sub1"]) != 0 {
+		t.Errorf("expired value not removed: %v", rsp["sub1"])
+	}
+	if n := evicted.Load(); n != 1 {
+		t.Errorf("unexpected evictions count, got %d, expected 1", n)
+	}
+}
+
+func Test_gnmiCache_sweepOnChange(t *testing.T) {
+	gc := newGNMICache(&Config{Expiration: time.Minute, SweepInterval: -1}, WithLogger(log.Default()))
+	now := time.Now()
+	gc.clock = func() time.Time { return now }
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{Target: "t1", Mode: ReadMode_StreamOnChange})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		gc.clock = func() time.Time { return now.Add(2 * time.Minute) }
+		gc.sweep()
+	}()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case n := <-ch:
+			if len(n.Notification.GetDelete()) == 0 {
+				continue
+			}
+			if xp := notificationXPath(n.Notification); xp != "system/name/host-name" {
+				t.Errorf("unexpected deleted path %q", xp)
+			}
+			return
+		case <-timeout:
+			t.Fatal("timeout waiting for the expired value delete")
+		}
+	}
+}
+
+func Test_gnmiCache_sweeper(t *testing.T) {
+	var evicted atomic.Int32
+	gc := newGNMICache(&Config{Expiration: time.Minute, SweepInterval: 10 * time.Millisecond},
+		WithLogger(log.Default()),
+		WithOnEvict(func(sub, target, xpath string) { evicted.Add(1) }))
+	defer gc.Stop()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(time.Now().Add(-time.Hour).UnixNano(), "srl1"))
+	// reported as expired by a read before being removed.
+	gc.ReadAll()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if rsp, _ := gc.ReadAll(); len(rsp["sub1"]) == 0 {
+			n := 0
+			gc.getCaches("sub1")["sub1"].c.Query("*", []string{"*"},
+				func([]string, *ctree.Leaf, interface{}) error {
+					n++
+					return nil
+				})
+			if n == 0 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired value not removed by the sweeper")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := evicted.Load(); n != 1 {
+		t.Errorf("unexpected evictions count, got %d, expected 1", n)
+	}
+	// Stop can be called more than once.
+	gc.Stop()
+}
+
+func Test_gnmiCache_partialUpdatePolicy(t *testing.T) {
+	intfPath := func(elems ...string) *gnmi.Path {
+		p := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}}
+		for _, e := range elems {
+			p.Elem = append(p.Elem, &gnmi.PathElem{Name: e})
+		}
+		return p
+	}
+	now := time.Now().UnixNano()
+	update := func(ts int64, upds ...*gnmi.Update) *gnmi.SubscribeResponse {
+		return &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now + ts,
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Update:    upds,
+				},
+			},
+		}
+	}
+	val := &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1}}
+	tests := []struct {
+		name   string
+		policy string
+		// the second update of the notification cannot be cached
+		bad *gnmi.Update
+		ts  int64
+		// expected to find the first update in the cache
+		expectCached bool
+	}{
+		{"best-effort collision", PartialUpdateBestEffort, &gnmi.Update{Path: intfPath("mtu", "value"), Val: val}, 10, true},
+		{"all-or-nothing collision", PartialUpdateAllOrNothing, &gnmi.Update{Path: intfPath("mtu", "value"), Val: val}, 10, false},
+		{"all-or-nothing branch collision", PartialUpdateAllOrNothing, &gnmi.Update{Path: intfPath(), Val: val}, 10, false},
+		{"best-effort stale", PartialUpdateBestEffort, &gnmi.Update{Path: intfPath("mtu"), Val: val}, 1, true},
+		{"all-or-nothing stale", PartialUpdateAllOrNothing, &gnmi.Update{Path: intfPath("mtu"), Val: val}, 1, false},
+		{"best-effort duplicate", PartialUpdateBestEffort, &gnmi.Update{Path: intfPath("mtu"), Val: val}, 5, true},
+		{"all-or-nothing duplicate", PartialUpdateAllOrNothing, &gnmi.Update{Path: intfPath("mtu"), Val: val}, 5, false},
+		{"all-or-nothing valid", PartialUpdateAllOrNothing, &gnmi.Update{Path: intfPath("mtu"), Val: val}, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(&Config{PartialUpdatePolicy: tt.policy, HistoryDepth: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gc := c.(*gnmiCache)
+			gc.Write(context.TODO(), "sub1", update(5, &gnmi.Update{Path: intfPath("mtu"), Val: val}))
+			gc.Write(context.TODO(), "sub1", update(tt.ts,
+				&gnmi.Update{Path: intfPath("description"), Val: val},
+				tt.bad,
+			))
+			rsp, err := gc.Read("sub1", "t1", intfPath("description"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cached := len(rsp["sub1"]) == 1; cached != tt.expectCached {
+				t.Errorf("unexpected cached state of the valid update, got %v, expected %v", cached, tt.expectCached)
+			}
+			// the history holds the applied updates only.
+			rsp, err = gc.ReadHistory("sub1", "t1", intfPath("description"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorded := len(rsp["sub1"]) == 1; recorded != tt.expectCached {
+				t.Errorf("unexpected history of the valid update, got %v, expected %v", recorded, tt.expectCached)
+			}
+			rsp, err = gc.ReadHistory("sub1", "t1", intfPath("mtu"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.name == "all-or-nothing valid" {
+				if len(rsp["sub1"]) != 2 {
+					t.Errorf("unexpected mtu history: %v", rsp["sub1"])
+				}
+			} else if len(rsp["sub1"]) != 1 {
+				t.Errorf("unexpected mtu history: %v", rsp["sub1"])
+			}
+		})
+	}
+	if _, err := New(&Config{PartialUpdatePolicy: "unknown"}); err == nil {
+		t.Error("expected an error for an unknown partial update policy")
+	}
+}
+
+func Test_gnmiCache_maxTargetEntries(t *testing.T) {
+	now := time.Now().UnixNano()
+	update := func(target string, ts int64, leaves ...string) *gnmi.SubscribeResponse {
+		n := &gnmi.Notification{
+			Timestamp: now + ts,
+			Prefix:    &gnmi.Path{Target: target},
+		}
+		for _, l := range leaves {
+			n.Update = append(n.Update, &gnmi.Update{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: l}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: ts}},
+			})
+		}
+		return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: n}}
+	}
+	cached := func(gc *gnmiCache, target string) []string {
+		rsp, err := gc.Read("sub1", target, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		xpaths := make([]string, 0, len(rsp["sub1"]))
+		for _, n := range rsp["sub1"] {
+			xpaths = append(xpaths, notificationXPath(n))
+		}
+		sort.Strings(xpaths)
+		return xpaths
+	}
+	tests := []struct {
+		name   string
+		policy string
+		// expected leaves of t1 once a notification with the new paths d and e
+		// and an update of the cached path a is written.
+		expected []string
+		// expected evicted paths
+		evicted []string
+	}{
+		{
+			name:     "reject",
+			policy:   OverflowPolicyReject,
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "evict-oldest",
+			policy:   OverflowPolicyEvictOldest,
+			expected: []string{"a", "d", "e"},
+			evicted:  []string{"b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu := new(sync.Mutex)
+			var evicted []string
+			c, err := New(&Config{MaxTargetEntries: 3, OverflowPolicy: tt.policy},
+				WithOnEvict(func(_, _, xpath string) {
+					mu.Lock()
+					defer mu.Unlock()
+					evicted = append(evicted, xpath)
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gc := c.(*gnmiCache)
+			defer gc.Stop()
+			gc.Write(context.TODO(), "sub1", update("t1", 1, "a"))
+			gc.Write(context.TODO(), "sub1", update("t1", 2, "b", "c"))
+			// at the boundary, the cached paths are still updated.
+			gc.Write(context.TODO(), "sub1", update("t1", 3, "a"))
+			if got := cached(gc, "t1"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+				t.Fatalf("unexpected cached paths at the boundary: %v", got)
+			}
+			gc.Write(context.TODO(), "sub1", update("t1", 4, "d", "a", "e"))
+			if got := cached(gc, "t1"); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected cached paths, got %v, expected %v", got, tt.expected)
+			}
+			rsp, _ := gc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "a"}}})
+			if len(rsp["sub1"]) != 1 || rsp["sub1"][0].GetTimestamp() != now+4 {
+				t.Errorf("cached path a not updated: %v", rsp["sub1"])
+			}
+			mu.Lock()
+			sort.Strings(evicted)
+			if !reflect.DeepEqual(evicted, tt.evicted) {
+				t.Errorf("unexpected evicted paths, got %v, expected %v", evicted, tt.evicted)
+			}
+			mu.Unlock()
+			// the limit applies per target.
+			gc.Write(context.TODO(), "sub1", update("t2", 5, "a", "b", "c"))
+			if got := cached(gc, "t2"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+				t.Errorf("unexpected cached paths of t2: %v", got)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_evictionBatch(t *testing.T) {
+	gc := newGNMICache(&Config{MaxTargetEntries: 20, OverflowPolicy: OverflowPolicyEvictOldest})
+	defer gc.Stop()
+	now := time.Now().UnixNano()
+	write := func(i int) {
+		rsp := hostnameResponse(now+int64(i), "srl1")
+		rsp.GetUpdate().Update[0].Path.Elem[2].Name = fmt.Sprintf("leaf%d", i)
+		gc.Write(context.TODO(), "sub1", rsp)
+	}
+	for i := 0; i < 21; i++ {
+		write(i)
+	}
+	c := gc.getCaches("sub1")["sub1"]
+	// the excess leaf and a batch of 20/10 leaves are evicted.
+	if n := c.leafCount("t1"); n != 18 {
+		t.Errorf("unexpected leaf count %d once the limit is reached", n)
+	}
+	if ev := gc.GetStats().Subscriptions["sub1"].Evictions; ev != 3 {
+		t.Errorf("unexpected evictions count %d", ev)
+	}
+	rsp, _ := gc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "leaf2"}}})
+	if len(rsp["sub1"]) != 0 {
+		t.Errorf("oldest leaf not evicted: %v", rsp["sub1"])
+	}
+	// no eviction until the limit is reached again.
+	write(21)
+	write(22)
+	if ev := gc.GetStats().Subscriptions["sub1"].Evictions; ev != 3 {
+		t.Errorf("unexpected evictions count %d below the limit", ev)
+	}
+	write(23)
+	if ev := gc.GetStats().Subscriptions["sub1"].Evictions; ev != 6 {
+		t.Errorf("unexpected evictions count %d", ev)
+	}
+}
+
+func Test_gnmiCache_maxEntries(t *testing.T) {
+	now := time.Now().UnixNano()
+	update := func(target string, ts int64, leaves ...string) *gnmi.SubscribeResponse {
+		n := &gnmi.Notification{
+			Timestamp: now + ts,
+			Prefix:    &gnmi.Path{Target: target},
+		}
+		for _, l := range leaves {
+			n.Update = append(n.Update, &gnmi.Update{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: l}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: ts}},
+			})
+		}
+		return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: n}}
+	}
+	// cached target/path of the subscription sub.
+	cached := func(gc *gnmiCache, sub string) []string {
+		rsp, err := gc.Read(sub, "*", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		paths := make([]string, 0, len(rsp[sub]))
+		for _, n := range rsp[sub] {
+			paths = append(paths, n.GetPrefix().GetTarget()+"/"+notificationXPath(n))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	tests := []struct {
+		name   string
+		policy string
+		// expected leaves of sub1 once a notification of t2 with
+		// the new paths c and d and an update of its cached path a is written.
+		expected  []string
+		evictions uint64
+	}{
+		{
+			name:     "reject",
+			policy:   OverflowPolicyReject,
+			expected: []string{"t1/a", "t1/b", "t2/a"},
+		},
+		{
+			name:      "evict-oldest",
+			policy:    OverflowPolicyEvictOldest,
+			expected:  []string{"t2/a", "t2/c", "t2/d"},
+			evictions: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(&Config{
+				MaxEntries:     3,
+				OverflowPolicy: tt.policy,
+				Subscriptions:  map[string]*SubscriptionConfig{"sub2": {MaxEntries: -1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gc := c.(*gnmiCache)
+			defer gc.Stop()
+			gc.Write(context.TODO(), "sub1", update("t1", 1, "a", "b"))
+			gc.Write(context.TODO(), "sub1", update("t2", 2, "a"))
+			gc.Write(context.TODO(), "sub1", update("t2", 3, "c", "a", "d"))
+			if got := cached(gc, "sub1"); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected cached paths, got %v, expected %v", got, tt.expected)
+			}
+			if ev := gc.GetStats().Subscriptions["sub1"].Evictions; ev != tt.evictions {
+				t.Errorf("unexpected evictions count, got %d, expected %d", ev, tt.evictions)
+			}
+			// the limit is overridden per subscription.
+			gc.Write(context.TODO(), "sub2", update("t1", 4, "a", "b", "c", "d"))
+			if got := cached(gc, "sub2"); len(got) != 4 {
+				t.Errorf("unexpected cached paths of sub2: %v", got)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_maxSize(t *testing.T) {
+	now := time.Now().UnixNano()
+	update := func(target string, ts int64, leaves ...string) *gnmi.SubscribeResponse {
+		n := &gnmi.Notification{
+			Timestamp: now + ts,
+			Prefix:    &gnmi.Path{Target: target},
+		}
+		for _, l := range leaves {
+			n.Update = append(n.Update, &gnmi.Update{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: l}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: ts}},
+			})
+		}
+		return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: n}}
+	}
+	// all the leaves written have the same size.
+	leafSize := int64(proto.Size(update("t1", 1, "a").GetUpdate()))
+	cached := func(gc *gnmiCache, sub string) []string {
+		rsp, err := gc.Read(sub, "*", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		paths := make([]string, 0, len(rsp[sub]))
+		for _, n := range rsp[sub] {
+			paths = append(paths, n.GetPrefix().GetTarget()+"/"+notificationXPath(n))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	tests := []struct {
+		name   string
+		policy string
+		// expected leaves of sub1 once a notification of t2 with
+		// the new paths c and d and an update of its cached path a is written.
+		expected  []string
+		evictions uint64
+		dropped   float64
+	}{
+		{
+			name:     "reject",
+			policy:   OverflowPolicyReject,
+			expected: []string{"t1/a", "t1/b", "t2/a"},
+			dropped:  1,
+		},
+		{
+			name:      "evict-oldest",
+			policy:    OverflowPolicyEvictOldest,
+			expected:  []string{"t2/a", "t2/c", "t2/d"},
+			evictions: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(&Config{
+				MaxSize:        3 * leafSize,
+				OverflowPolicy: tt.policy,
+				Subscriptions:  map[string]*SubscriptionConfig{"sub2": {MaxSize: -1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gc := c.(*gnmiCache)
+			defer gc.Stop()
+			gc.RegisterMetrics(prometheus.NewRegistry())
+			before := testutil.ToFloat64(cacheDroppedWrites.WithLabelValues("sub1", dropReasonMaxSize))
+			gc.Write(context.TODO(), "sub1", update("t1", 1, "a", "b"))
+			gc.Write(context.TODO(), "sub1", update("t2", 2, "a"))
+			gc.Write(context.TODO(), "sub1", update("t2", 3, "c", "a", "d"))
+			if got := cached(gc, "sub1"); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected cached paths, got %v, expected %v", got, tt.expected)
+			}
+			if ev := gc.GetStats().Subscriptions["sub1"].Evictions; ev != tt.evictions {
+				t.Errorf("unexpected evictions count, got %d, expected %d", ev, tt.evictions)
+			}
+			after := testutil.ToFloat64(cacheDroppedWrites.WithLabelValues("sub1", dropReasonMaxSize))
+			if after-before != tt.dropped {
+				t.Errorf("unexpected dropped writes count %v", after-before)
+			}
+			sCache := gc.getCaches("sub1")["sub1"]
+			if size := sCache.cachedSize(); size != 3*leafSize {
+				t.Errorf("unexpected cached size %d, expected %d", size, 3*leafSize)
+			}
+			// the size of the removed targets is released.
+			gc.DeleteTarget("t2")
+			var t1Leaves int64
+			for _, p := range tt.expected {
+				if strings.HasPrefix(p, "t1/") {
+					t1Leaves++
+				}
+			}
+			if size := sCache.cachedSize(); size != t1Leaves*leafSize {
+				t.Errorf("unexpected cached size %d once t2 is removed, expected %d", size, t1Leaves*leafSize)
+			}
+			// the limit is overridden per subscription.
+			gc.Write(context.TODO(), "sub2", update("t1", 4, "a", "b", "c", "d"))
+			if got := cached(gc, "sub2"); len(got) != 4 {
+				t.Errorf("unexpected cached paths of sub2: %v", got)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_maxTargetEntriesRejected(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gc := newGNMICache(&Config{MaxTargetEntries: 1}, WithLogger(log.Default()))
+	gc.RegisterMetrics(reg)
+	gc.Write(context.TODO(), "limits", hostnameResponse(1, "srl1"))
+	before := testutil.ToFloat64(cacheDroppedWrites.WithLabelValues("limits", dropReasonMaxTargetEntries))
+	rsp := hostnameResponse(2, "srl1")
+	rsp.GetUpdate().Update[0].Path.Elem[2].Name = "domain-name"
+	gc.Write(context.TODO(), "limits", rsp)
+	after := testutil.ToFloat64(cacheDroppedWrites.WithLabelValues("limits", dropReasonMaxTargetEntries))
+	if after-before != 1 {
+		t.Errorf("unexpected dropped writes count %v", after-before)
+	}
+	if _, err := New(&Config{OverflowPolicy: "evict-newest"}); err == nil {
+		t.Errorf("expected an error for an unknown overflow-policy")
+	}
+}
+
+func Test_gnmiCache_maxClockSkew(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		policy   string
+		ts       int64
+		expected int64
+		dropped  float64
+	}{
+		{
+			name:     "in window",
+			policy:   SkewPolicyReject,
+			ts:       now.Add(30 * time.Second).UnixNano(),
+			expected: now.Add(30 * time.Second).UnixNano(),
+		},
+		{
+			name:     "past",
+			policy:   SkewPolicyReject,
+			ts:       now.Add(-time.Hour).UnixNano(),
+			expected: now.Add(-time.Hour).UnixNano(),
+		},
+		{
+			name:    "future rejected",
+			policy:  SkewPolicyReject,
+			ts:      now.Add(time.Hour).UnixNano(),
+			dropped: 1,
+		},
+		{
+			name:     "future clamped",
+			policy:   SkewPolicyClamp,
+			ts:       now.Add(time.Hour).UnixNano(),
+			expected: now.UnixNano(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			gc := newGNMICache(&Config{Expiration: -1, MaxClockSkew: time.Minute, SkewPolicy: tt.policy}, WithLogger(log.Default()))
+			gc.clock = func() time.Time { return now }
+			gc.RegisterMetrics(reg)
+			before := testutil.ToFloat64(cacheDroppedWrites.WithLabelValues("skew", dropReasonClockSkew))
+			gc.Write(context.TODO(), "skew", hostnameResponse(tt.ts, "srl1"))
+			if d := testutil.ToFloat64(cacheDroppedWrites.WithLabelValues("skew", dropReasonClockSkew)) - before; d != tt.dropped {
+				t.Errorf("unexpected dropped writes count %v, expected %v", d, tt.dropped)
+			}
+			rsp, _ := gc.read("skew", "t1", nil)
+			if tt.dropped > 0 {
+				if len(rsp["skew"]) != 0 {
+					t.Errorf("unexpected cached values: %v", rsp["skew"])
+				}
+				return
+			}
+			if len(rsp["skew"]) != 1 {
+				t.Fatalf("unexpected cached values: %v", rsp["skew"])
+			}
+			if ts := rsp["skew"][0].GetTimestamp(); ts != tt.expected {
+				t.Errorf("unexpected timestamp %d, expected %d", ts, tt.expected)
+			}
+		})
+	}
+	if _, err := New(&Config{SkewPolicy: "ignore"}); err == nil {
+		t.Errorf("expected an error for an unknown skew-policy")
+	}
+}
+
+func Test_gnmiCache_targetAliases(t *testing.T) {
+	gc := newGNMICache(&Config{
+		TargetAliases: map[string]string{
+			"10.0.0.1":       "t1",
+			"t1.example.com": "t1",
+		},
+	}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	rsp1 := hostnameResponse(now, "srl1")
+	rsp1.GetUpdate().Prefix.Target = "10.0.0.1"
+	gc.Write(context.TODO(), "sub1", rsp1)
+	rsp2 := hostnameResponse(now, "srl1")
+	rsp2.GetUpdate().Prefix.Target = "t1.example.com"
+	rsp2.GetUpdate().Update[0].Path.Elem[2].Name = "domain-name"
+	gc.Write(context.TODO(), "sub1", rsp2)
+	// the written message is not modified.
+	if target := rsp1.GetUpdate().GetPrefix().GetTarget(); target != "10.0.0.1" {
+		t.Errorf("written prefix target changed to %q", target)
+	}
+	if targets := gc.ListTargets()["sub1"]; !reflect.DeepEqual(targets, []string{"t1"}) {
+		t.Errorf("unexpected targets %v, expected [t1]", targets)
+	}
+	for _, target := range []string{"t1", "10.0.0.1", "t1.example.com"} {
+		rsp, err := gc.Read("sub1", target, &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}}})
+		if err != nil {
+			t.Fatalf("read %q: unexpected error: %v", target, err)
+		}
+		if len(rsp["sub1"]) != 2 {
+			t.Fatalf("read %q: got %d notifications, expected 2", target, len(rsp["sub1"]))
+		}
+		for _, n := range rsp["sub1"] {
+			if n.GetPrefix().GetTarget() != "t1" {
+				t.Errorf("read %q: unexpected target %q", target, n.GetPrefix().GetTarget())
+			}
+		}
+	}
+	gc.DeleteTarget("10.0.0.1")
+	if targets := gc.ListTargets()["sub1"]; len(targets) != 0 {
+		t.Errorf("unexpected targets after delete: %v", targets)
+	}
+
+	if _, err := New(&Config{TargetAliases: map[string]string{"a": "b", "b": "c"}}); err == nil {
+		t.Errorf("expected an error for an aliased canonical name")
+	}
+}
+
+func Test_gnmiCache_getStats(t *testing.T) {
+	gc := newGNMICache(&Config{ReadOnlySubscriptions: []string{"sub3"}}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	rsp := hostnameResponse(now+10, "srl1")
+	rsp.GetUpdate().Update[0].Path.Elem[2].Name = "domain-name"
+	gc.Write(context.TODO(), "sub1", rsp)
+	// overwrites the host-name leaf.
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now+20, "srl2"))
+	rsp = hostnameResponse(now+5, "srl3")
+	rsp.GetUpdate().Prefix.Target = "t2"
+	gc.Write(context.TODO(), "sub2", rsp)
+	gc.Write(context.TODO(), "sub2", hostnameResponse(now+30, "srl1"))
+	// dropped: missing target and read-only.
+	rsp = hostnameResponse(now, "srl1")
+	rsp.GetUpdate().Prefix.Target = ""
+	gc.Write(context.TODO(), "sub2", rsp)
+	gc.Write(context.TODO(), "sub3", hostnameResponse(now, "srl1"))
+
+	expected := &CacheStats{
+		Subscriptions: map[string]*SubscriptionStats{
+			"sub1": {Targets: 1, Entries: 2, OldestTimestamp: now + 10, NewestTimestamp: now + 20, Writes: 3},
+			"sub2": {Targets: 2, Entries: 2, OldestTimestamp: now + 5, NewestTimestamp: now + 30, Writes: 2, DroppedWrites: 1, WriteErrors: 1},
+			"sub3": {DroppedWrites: 1, WriteErrors: 1},
+		},
+		Total: SubscriptionStats{Targets: 3, Entries: 4, OldestTimestamp: now + 5, NewestTimestamp: now + 30, Writes: 5, DroppedWrites: 2, WriteErrors: 2},
+	}
+	stats := gc.GetStats()
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("unexpected stats:\n got: %+v\nwant: %+v", stats.Total, expected.Total)
+		for name, ss := range stats.Subscriptions {
+			t.Errorf("%s: got %+v, want %+v", name, ss, expected.Subscriptions[name])
+		}
+	}
+	// the returned stats are a copy.
+	stats.Subscriptions["sub1"].Writes = 100
+	if gc.GetStats().Subscriptions["sub1"].Writes != 3 {
+		t.Errorf("the stats returned are not a copy")
+	}
+}
+
+func Test_gnmiCache_onWrite(t *testing.T) {
+	type write struct {
+		sub, target string
+		n           *gnmi.Notification
+	}
+	writes := make(chan write, 10)
+	gc := newGNMICache(&Config{ReadOnlySubscriptions: []string{"sub2"}},
+		WithLogger(log.Default()),
+		WithOnWrite(func(sub, target string, n *gnmi.Notification) {
+			writes <- write{sub, target, n}
+		}))
+	now := time.Now().UnixNano()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	// a dropped write does not call the hook.
+	gc.Write(context.TODO(), "sub2", hostnameResponse(now, "srl1"))
+	del := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}}
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: now + 1, Prefix: &gnmi.Path{Target: "t1"}, Delete: []*gnmi.Path{del}},
+		},
+	})
+	expected := []write{
+		{"sub1", "t1", hostnameResponse(now, "srl1").GetUpdate()},
+		{"sub1", "t1", &gnmi.Notification{Timestamp: now + 1, Prefix: &gnmi.Path{Target: "t1"}, Update: []*gnmi.Update{}, Delete: []*gnmi.Path{del}}},
+	}
+	for i, exp := range expected {
+		select {
+		case w := <-writes:
+			if w.sub != exp.sub || w.target != exp.target || !proto.Equal(w.n, exp.n) {
+				t.Errorf("write %d: got %s %s %v, expected %s %s %v", i, w.sub, w.target, w.n, exp.sub, exp.target, exp.n)
+			}
+		default:
+			t.Fatalf("write %d: hook not called", i)
+		}
+	}
+	if len(writes) != 0 {
+		t.Errorf("unexpected hook call: %v", <-writes)
+	}
+	// removing the hook.
+	gc.SetOnWrite(nil)
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now+2, "srl2"))
+	if len(writes) != 0 {
+		t.Errorf("unexpected hook call after removal: %v", <-writes)
+	}
+}
+
+func Test_gnmiCache_subscribeOnFull(t *testing.T) {
+	tests := []struct {
+		name     string
+		onFull   DropPolicy
+		expected []string
+		dropped  uint64
+	}{
+		{name: "block", onFull: DropPolicyBlock, expected: []string{"srl1", "srl2", "srl3", "srl4"}},
+		{name: "drop_newest", onFull: DropPolicyNewest, expected: []string{"srl1", "srl2"}, dropped: 2},
+		{name: "drop_oldest", onFull: DropPolicyOldest, expected: []string{"srl3", "srl4"}, dropped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+			gc.debug = true
+			now := time.Now().UnixNano()
+			gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl0"))
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			ro := &ReadOpts{
+				Target:            "t1",
+				Mode:              ReadMode_StreamOnChange,
+				UpdatesOnly:       true,
+				ChannelBufferSize: 2,
+				OnFull:            tt.onFull,
+			}
+			ch := gc.Subscribe(ctx, ro)
+			// let the on-change query register.
+			time.Sleep(50 * time.Millisecond)
+			written := make(chan struct{})
+			go func() {
+				defer close(written)
+				for i := 1; i <= 4; i++ {
+					gc.Write(ctx, "sub1", hostnameResponse(now+int64(i), fmt.Sprintf("srl%d", i)))
+				}
+			}()
+			select {
+			case <-written:
+				if tt.onFull == DropPolicyBlock {
+					t.Fatal("the writes did not wait for the slow consumer")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.onFull != DropPolicyBlock {
+					t.Fatal("the writes waited for the slow consumer")
+				}
+			}
+			for i, exp := range tt.expected {
+				select {
+				case n := <-ch:
+					if v := n.Notification.GetUpdate()[0].GetVal().GetAsciiVal(); v != exp {
+						t.Errorf("notification %d: got %q, expected %q", i, v, exp)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("timeout waiting for notification %d", i)
+				}
+				// slow consumer
+				time.Sleep(10 * time.Millisecond)
+			}
+			<-written
+			if len(ch) != 0 {
+				t.Errorf("unexpected notification: %v", <-ch)
+			}
+			if d := ro.Dropped(); d != tt.dropped {
+				t.Errorf("unexpected dropped count %d, expected %d", d, tt.dropped)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_subscribeOnFullInvalid(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	gc.Write(context.TODO(), "sub1", hostnameResponse(time.Now().UnixNano(), "srl1"))
+	ch := gc.Subscribe(context.TODO(), &ReadOpts{Mode: ReadMode_Once, OnFull: "drop-all"})
+	n, ok := <-ch
+	if !ok || n.Err == nil {
+		t.Fatalf("expected an error, got %v", n)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("expected the channel to be closed")
+	}
+}
+
+func Test_gnmiCache_concurrentReadWrite(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	gc.Write(context.TODO(), "sub0", hostnameResponse(time.Now().UnixNano(), "srl1"))
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				switch i % 4 {
+				case 0:
+					if _, err := gc.Read("sub0", "t1", nil); err != nil {
+						t.Errorf("unexpected error: %v", err)
+						return
+					}
+				case 1:
+					gc.ReadAll()
+				case 2:
+					gc.ListTargets()
+				case 3:
+					for n := range gc.Subscribe(ctx, &ReadOpts{Mode: ReadMode_Once}) {
+						if n.Err != nil && ctx.Err() == nil {
+							t.Errorf("unexpected error: %v", n.Err)
+						}
+					}
+				}
+			}
+		}(i)
+	}
+	// occasional writers adding and removing subscription caches and targets.
+	for i := 0; i < 50; i++ {
+		rsp := hostnameResponse(time.Now().UnixNano(), "srl1")
+		rsp.GetUpdate().Prefix.Target = fmt.Sprintf("t%d", i%5+2)
+		gc.Write(context.TODO(), fmt.Sprintf("sub%d", i%10), rsp)
+		if i%7 == 0 {
+			gc.DeleteTarget(fmt.Sprintf("t%d", i%5+2))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+	targets := gc.ListTargets()
+	if len(targets) != 10 {
+		t.Errorf("unexpected subscription caches count, got %d, expected 10: %v", len(targets), targets)
+	}
+	if !reflect.DeepEqual(targets["sub0"][:1], []string{"t1"}) {
+		t.Errorf("unexpected sub0 targets: %v", targets["sub0"])
+	}
+}
+
+func Test_gnmiCache_clear(t *testing.T) {
+	var evicted atomic.Int64
+	gc := newGNMICache(&Config{HistoryDepth: 2},
+		WithLogger(log.Default()),
+		WithOnEvict(func(_, _, _ string) { evicted.Add(1) }),
+	)
+	now := time.Now().UnixNano()
+	populate := func() {
+		for _, sub := range []string{"sub1", "sub2"} {
+			for _, target := range []string{"t1", "t2"} {
+				rsp := hostnameResponse(now, "srl1")
+				rsp.GetUpdate().Prefix.Target = target
+				gc.Write(context.TODO(), sub, rsp)
+			}
+		}
+	}
+	populate()
+	gc.ClearSubscription("sub1")
+	if targets := gc.ListTargets(); len(targets) != 1 || len(targets["sub2"]) != 2 {
+		t.Errorf("unexpected targets after clearing sub1: %v", targets)
+	}
+	if rsp, _ := gc.ReadHistory("sub1", "*", nil, 0); len(rsp["sub1"]) != 0 {
+		t.Errorf("unexpected sub1 history: %v", rsp)
+	}
+	if n := evicted.Load(); n != 2 {
+		t.Errorf("unexpected evicted count %d, expected 2", n)
+	}
+
+	// an in-flight on-change subscription does not block the clear.
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{Subscription: "sub2", Target: "*", UpdatesOnly: true})
+	go func() {
+		for range ch {
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	gc.Clear()
+	rsp, err := gc.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp) != 0 {
+		t.Errorf("unexpected values after clear: %v", rsp)
+	}
+	if targets := gc.ListTargets(); len(targets) != 0 {
+		t.Errorf("unexpected targets after clear: %v", targets)
+	}
+	if n := evicted.Load(); n != 4 {
+		t.Errorf("unexpected evicted count %d, expected 4", n)
+	}
+
+	// the subscription caches are created again by the next writes.
+	populate()
+	rsp, err = gc.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 2 || len(rsp["sub2"]) != 2 {
+		t.Errorf("unexpected values after writing again: %v", rsp)
+	}
+	if h, _ := gc.ReadHistory("sub1", "t1", nil, 0); len(h["sub1"]) != 1 {
+		t.Errorf("unexpected sub1 history after writing again: %v", h)
+	}
+}
+
+func Test_gnmiCache_clearConcurrent(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	ctx, cancel := context.WithCancel(context.TODO())
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				gc.Write(context.TODO(), fmt.Sprintf("sub%d", i), hostnameResponse(time.Now().UnixNano(), "srl1"))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				for range gc.Subscribe(ctx, &ReadOpts{Mode: ReadMode_Once}) {
+				}
+				gc.ReadAll()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			gc.Clear()
+		} else {
+			gc.ClearSubscription("sub1")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func Test_gnmiCache_readOrigin(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	now := time.Now().UnixNano()
+	write := func(origin string, elems ...string) {
+		p := &gnmi.Path{}
+		for _, e := range elems {
+			p.Elem = append(p.Elem, &gnmi.PathElem{Name: e})
+		}
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now,
+					Prefix:    &gnmi.Path{Target: "t1", Origin: origin},
+					Update: []*gnmi.Update{
+						{
+							Path: p,
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: origin}},
+						},
+					},
+				},
+			},
+		})
+	}
+	write("openconfig", "interfaces", "mtu")
+	write("cisco-xr", "interfaces", "mtu")
+	write("", "system", "name")
+	write("", "ports", "interfaces", "mtu")
+
+	tests := []struct {
+		query    *gnmi.Path
+		expected []string
+	}{
+		{&gnmi.Path{Origin: "openconfig", Elem: []*gnmi.PathElem{{Name: "interfaces"}}}, []string{"openconfig"}},
+		{&gnmi.Path{Origin: "cisco-xr", Elem: []*gnmi.PathElem{{Name: "interfaces"}}}, []string{"cisco-xr"}},
+		// a query without origin does not match the origin leaves
+		{&gnmi.Path{Elem: []*gnmi.PathElem{{Name: "cisco-xr"}}}, []string{}},
+		{&gnmi.Path{Elem: []*gnmi.PathElem{{Name: "*"}, {Name: "interfaces"}}}, []string{""}},
+		{&gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}}}, []string{""}},
+		// the first element of the query matches the origins,
+		// not the first element of the leaves without origin.
+		{&gnmi.Path{Origin: "*", Elem: []*gnmi.PathElem{{Name: "interfaces"}}}, []string{"cisco-xr", "openconfig"}},
+		// a query with the `*` origin and path elements
+		// does not match the leaves without origin.
+		{&gnmi.Path{Origin: "*", Elem: []*gnmi.PathElem{{Name: "*"}}}, []string{"cisco-xr", "openconfig"}},
+		// without path elements, it matches all the leaves.
+		{&gnmi.Path{Origin: "*"}, []string{"", "", "cisco-xr", "openconfig"}},
+		{nil, []string{"", "", "cisco-xr", "openconfig"}},
+	}
+	for _, tt := range tests {
+		rsp, err := gc.Read("sub1", "t1", tt.query)
+		if err != nil {
+			t.Fatalf("query %v: unexpected error: %v", tt.query, err)
+		}
+		origins := make([]string, 0, len(rsp["sub1"]))
+		for _, n := range rsp["sub1"] {
+			origins = append(origins, n.GetUpdate()[0].GetVal().GetStringVal())
+		}
+		sort.Strings(origins)
+		if !reflect.DeepEqual(origins, tt.expected) {
+			t.Errorf("query %v: got origins %q, expected %q", tt.query, origins, tt.expected)
+		}
+	}
+}
+
+func Test_gnmiCache_subscribeOrigin(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	now := time.Now().UnixNano()
+	write := func(origin string, elems ...string) {
+		p := &gnmi.Path{}
+		for _, e := range elems {
+			p.Elem = append(p.Elem, &gnmi.PathElem{Name: e})
+		}
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now,
+					Prefix:    &gnmi.Path{Target: "t1", Origin: origin},
+					Update: []*gnmi.Update{
+						{
+							Path: p,
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: origin}},
+						},
+					},
+				},
+			},
+		})
+	}
+	write("openconfig", "interfaces", "mtu")
+	write("cisco-xr", "interfaces", "mtu")
+	write("", "interfaces", "mtu")
+	write("", "system", "name")
+
+	intf := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interfaces"}}}
+	tests := []struct {
+		name     string
+		origin   string
+		paths    []*gnmi.Path
+		expected []string
+	}{
+		{"no_origin", "", []*gnmi.Path{intf}, []string{""}},
+		{"origin", "cisco-xr", []*gnmi.Path{intf}, []string{"cisco-xr"}},
+		{"all_origins", "*", []*gnmi.Path{intf}, []string{"", "cisco-xr", "openconfig"}},
+		{"all_origins_all_paths", "*", nil, []string{"", "", "cisco-xr", "openconfig"}},
+		{"origin_all_paths", "openconfig", nil, []string{"openconfig"}},
+		{"path_origin", "cisco-xr", []*gnmi.Path{{Origin: "openconfig", Elem: intf.Elem}}, []string{"openconfig"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ro := &ReadOpts{Target: "t1", Mode: ReadMode_Once, Origin: tt.origin, Paths: tt.paths}
+			origins := make([]string, 0)
+			for n := range gc.Subscribe(context.TODO(), ro) {
+				if n.Err != nil {
+					t.Fatal(n.Err)
+				}
+				if n.Notification == nil {
+					continue
+				}
+				origins = append(origins, n.Notification.GetUpdate()[0].GetVal().GetStringVal())
+			}
+			sort.Strings(origins)
+			if !reflect.DeepEqual(origins, tt.expected) {
+				t.Errorf("got origins %q, expected %q", origins, tt.expected)
+			}
+			// the paths of the read options are not modified.
+			if intf.GetOrigin() != "" {
+				t.Errorf("read path modified: %v", intf)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_readPathOrigin(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	now := time.Now().UnixNano()
+	update := func(origin string) *gnmi.Update {
+		return &gnmi.Update{
+			Path: &gnmi.Path{Origin: origin, Elem: []*gnmi.PathElem{{Name: "interfaces"}, {Name: "mtu"}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: origin}},
+		}
+	}
+	// the origins are set in the update paths, not in the prefix.
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now,
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Update:    []*gnmi.Update{update("openconfig"), update("eos_native")},
+			},
+		},
+	}
+	gc.Write(context.TODO(), "sub1", rsp)
+	// the written message is not modified.
+	if o := rsp.GetUpdate().GetUpdate()[0].GetPath().GetOrigin(); o != "openconfig" {
+		t.Errorf("written update origin changed to %q", o)
+	}
+	for _, origin := range []string{"openconfig", "eos_native"} {
+		rsp, err := gc.Read("sub1", "t1", &gnmi.Path{Origin: origin, Elem: []*gnmi.PathElem{{Name: "interfaces"}}})
+		if err != nil {
+			t.Fatalf("origin %q: unexpected error: %v", origin, err)
+		}
+		if len(rsp["sub1"]) != 1 {
+			t.Fatalf("origin %q: got %d notifications, expected 1", origin, len(rsp["sub1"]))
+		}
+		n := rsp["sub1"][0]
+		if v := n.GetUpdate()[0].GetVal().GetStringVal(); v != origin || n.GetPrefix().GetOrigin() != origin {
+			t.Errorf("origin %q: got value %q with prefix origin %q", origin, v, n.GetPrefix().GetOrigin())
+		}
+	}
+	// a query without origin does not match the origin leaves.
+	rsp2, err := gc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interfaces"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp2["sub1"]) != 0 {
+		t.Errorf("unexpected notifications for a query without origin: %v", rsp2["sub1"])
+	}
+	// deleting with a path origin only removes the leaf of that origin.
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: now + 1,
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Delete:    []*gnmi.Path{{Origin: "eos_native", Elem: []*gnmi.PathElem{{Name: "interfaces"}}}},
+			},
+		},
+	})
+	rsp2, err = gc.Read("sub1", "t1", &gnmi.Path{Origin: "*", Elem: []*gnmi.PathElem{{Name: "interfaces"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	origins := make(map[string]bool)
+	for _, n := range rsp2["sub1"] {
+		origins[n.GetPrefix().GetOrigin()] = len(n.GetDelete()) > 0
+	}
+	// the value of the openconfig origin and the delete of the eos_native one.
+	if !reflect.DeepEqual(origins, map[string]bool{"openconfig": false, "eos_native": true}) {
+		t.Errorf("unexpected notifications after delete: %v", rsp2["sub1"])
+	}
+}
+
+func Test_gnmiCache_queryPlanLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gc := newGNMICache(&Config{Debug: true}, WithLogger(log.New(buf, "", 0)))
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Prefix:    &gnmi.Path{Target: "t1"},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interfaces"}, {Name: "mtu"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 1500}},
+					},
+				},
+			},
+		},
+	})
+	_, err := gc.Read("sub1", "t1", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interfaces"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `query plan: subscription-cache="sub1" target="t1" path="interfaces" complete-path=["interfaces"] matched-leaves=1`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("query plan not logged, got:\n%s", buf.String())
+	}
+	_, err = gc.Read("sub1", "t2", &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interfaces"}}})
+	if !errors.Is(err, ErrTargetNotFound) {
+		t.Fatalf("expected ErrTargetNotFound, got: %v", err)
+	}
+	if !strings.Contains(buf.String(), `query plan: no subscription-cache has target "t2"`) {
+		t.Errorf("missing target not logged, got:\n%s", buf.String())
+	}
+}
+
+func Test_gnmiCache_subscriptionSuppressRedundant(t *testing.T) {
+	gc := newGNMICache(&Config{
+		Subscriptions: map[string]*SubscriptionConfig{
+			"sub1": {SuppressRedundant: true},
+		},
+	}, WithLogger(log.Default()))
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+
+	tests := []struct {
+		name     string
+		ro       *ReadOpts
+		expected []string
+	}{
+		{
+			name:     "suppress_redundant_default",
+			ro:       &ReadOpts{},
+			expected: []string{"srl1", "srl2"},
+		},
+		{
+			name:     "keep_redundant",
+			ro:       &ReadOpts{KeepRedundant: true},
+			expected: []string{"srl1", "srl1", "srl2"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			tt.ro.Subscription = "sub1"
+			tt.ro.Target = "t1"
+			tt.ro.Mode = ReadMode_StreamOnChange
+			ch := gc.Subscribe(ctx, tt.ro)
+			received := make([]string, 0, len(tt.expected))
+			receive := func() {
+				t.Helper()
+				select {
+				case n := <-ch:
+					received = append(received, n.Notification.GetUpdate()[0].GetVal().GetAsciiVal())
+				case <-time.After(time.Second):
+					t.Fatal("timeout waiting for a notification")
+				}
+			}
+			// initial value
+			receive()
+			// wait for the on-change query to be registered
+			time.Sleep(100 * time.Millisecond)
+			// the subscribers are notified synchronously by Write.
+			ts := now.Add(time.Duration(i*10) * time.Second)
+			go func() {
+				gc.Write(context.TODO(), "sub1", hostnameResponse(ts.Add(time.Second).UnixNano(), "srl1"))
+				gc.Write(context.TODO(), "sub1", hostnameResponse(ts.Add(2*time.Second).UnixNano(), "srl2"))
+				// reset the cached value for the next test case
+				gc.Write(context.TODO(), "sub1", hostnameResponse(ts.Add(3*time.Second).UnixNano(), "srl1"))
+			}()
+			for len(received) < len(tt.expected) {
+				receive()
+			}
+			if !reflect.DeepEqual(received, tt.expected) {
+				t.Errorf("got %q, expected %q", received, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_onChangeSuppressRedundant(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now.UnixNano(), "srl1"))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription:      "sub1",
+		Target:            "t1",
+		Mode:              ReadMode_StreamOnChange,
+		SuppressRedundant: true,
+	})
+	received := make([]string, 0)
+	receive := func() {
+		t.Helper()
+		select {
+		case n := <-ch:
+			if len(n.Notification.GetDelete()) > 0 {
+				received = append(received, "delete")
+				return
+			}
+			received = append(received, n.Notification.GetUpdate()[0].GetVal().GetAsciiVal())
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for a notification")
+		}
+	}
+	// initial value
+	receive()
+	// wait for the on-change query to be registered
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(1*time.Second).UnixNano(), "srl1"))
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(2*time.Second).UnixNano(), "srl2"))
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(3*time.Second).UnixNano(), "srl2"))
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now.Add(4 * time.Second).UnixNano(),
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Delete:    []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}}},
+				},
+			},
+		})
+		// the delete resets the last sent value.
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(5*time.Second).UnixNano(), "srl2"))
+	}()
+	expected := []string{"srl1", "srl2", "delete", "srl2"}
+	for len(received) < len(expected) {
+		receive()
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("got %q, expected %q", received, expected)
+	}
+}
+
+func Test_gnmiCache_collapseAtomic(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Prefix: &gnmi.Path{
+					Target: "t1",
+					Elem: []*gnmi.PathElem{
+						{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+					},
+				},
+				Atomic: true,
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "admin-state"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "enable"}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "description"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "uplink"}},
+					},
+				},
+			},
+		},
+	})
+	tests := []struct {
+		name           string
+		collapseAtomic bool
+		expectedCount  int
+		expectAtomic   bool
+	}{
+		{
+			name:           "preserve",
+			collapseAtomic: false,
+			expectedCount:  1,
+			expectAtomic:   true,
+		},
+		{
+			name:           "collapse",
+			collapseAtomic: true,
+			expectedCount:  2,
+			expectAtomic:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := gc.Subscribe(context.TODO(), &ReadOpts{
+				Subscription:   "sub1",
+				Target:         "t1",
+				Mode:           ReadMode_Once,
+				CollapseAtomic: tt.collapseAtomic,
+			})
+			rsps := make([]*Notification, 0)
+			for n := range ch {
+				if !n.SyncResponse {
+					rsps = append(rsps, n)
+				}
+			}
+			if len(rsps) != tt.expectedCount {
+				t.Fatalf("unexpected number of notifications, got %d, expected %d", len(rsps), tt.expectedCount)
+			}
+			for _, n := range rsps {
+				if n.Notification.GetAtomic() != tt.expectAtomic {
+					t.Errorf("unexpected atomic flag, got %v, expected %v", n.Notification.GetAtomic(), tt.expectAtomic)
+				}
+				if len(n.Notification.GetUpdate()) != 2/tt.expectedCount {
+					t.Errorf("unexpected number of updates: %v", n.Notification)
+				}
+			}
+		})
+	}
+}
+
+func Test_gnmiCache_deletePath(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	now := time.Now().UnixNano()
+	for _, name := range []string{"ethernet-1/1", "ethernet-1/2"} {
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now,
+					Prefix: &gnmi.Path{
+						Target: "t1",
+						Elem:   []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": name}}},
+					},
+					Update: []*gnmi.Update{
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "admin-state"}}},
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "enable"}},
+						},
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "mtu"}}},
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 1500}},
+						},
+					},
+				},
+			},
+		})
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := gc.Subscribe(ctx, &ReadOpts{
+		Subscription: "sub1",
+		Target:       "t1",
+		Mode:         ReadMode_StreamOnChange,
+		UpdatesOnly:  true,
+	})
+	// wait for the on-change query to be registered
+	time.Sleep(100 * time.Millisecond)
+
+	p := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "ethernet-1/2"}}}}
+	errCh := make(chan error, 1)
+	// the subscribers are notified synchronously.
+	go func() { errCh <- gc.DeletePath("sub1", "t1", p) }()
+	deleted := make([]string, 0, 2)
+	for len(deleted) < 2 {
+		select {
+		case n := <-ch:
+			if len(n.Notification.GetDelete()) != 1 {
+				t.Fatalf("expected a delete notification, got %v", n.Notification)
+			}
+			deleted = append(deleted, gpath.GnmiPathToXPath(n.Notification.GetDelete()[0], false))
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for the delete notifications, got %q", deleted)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(deleted)
+	expected := []string{"interface[name=ethernet-1/2]/admin-state", "interface[name=ethernet-1/2]/mtu"}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("got deleted paths %q, expected %q", deleted, expected)
+	}
+	rsp, err := gc.Read("sub1", "t1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the read returns the leaves left and the delete.
+	var leaves int
+	for _, n := range rsp["sub1"] {
+		if len(n.GetDelete()) > 0 {
+			if xp := notificationXPath(n); xp != "interface[name=ethernet-1/2]" {
+				t.Errorf("unexpected delete %q", xp)
+			}
+			continue
+		}
+		leaves++
+		if n.GetPrefix().GetElem()[0].GetKey()["name"] != "ethernet-1/1" {
+			t.Errorf("unexpected leaf left in cache: %v", n)
+		}
+	}
+	if leaves != 2 || len(rsp["sub1"]) != 3 {
+		t.Errorf("expected 2 leaves left and a delete, got %v", rsp["sub1"])
+	}
+
+	if err := gc.DeletePath("sub1", "t2", p); !errors.Is(err, ErrTargetNotFound) {
+		t.Errorf("expected ErrTargetNotFound, got %v", err)
+	}
+	if err := gc.DeletePath("sub2", "t1", p); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("expected ErrSubscriptionNotFound, got %v", err)
+	}
+	if err := gc.DeletePath("sub1", "t1", &gnmi.Path{}); err == nil {
+		t.Error("expected an error for an empty path")
+	}
+}
+
+func Test_gnmiCache_metrics(t *testing.T) {
+	gc := newGNMICache(&Config{}, WithLogger(log.Default()))
+	defer gc.Stop()
+	// the values written before the metrics are registered,
+	// e.g restored from a snapshot, are counted.
+	gc.Write(context.TODO(), "metrics-sub", hostnameResponse(time.Now().UnixNano(), "srl1"))
+	reg := prometheus.NewRegistry()
+	gc.RegisterMetrics(reg)
+	// registering twice is not an error.
+	gc.RegisterMetrics(reg)
+
+	// the counters are shared by the caches of the package tests,
+	// only their increments are checked.
+	counters := []struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		{cacheWrites.WithLabelValues("metrics-sub"), 1},
+		{cacheDroppedWrites.WithLabelValues("metrics-sub", dropReasonMissingTarget), 1},
+		{cacheWriteErrors.WithLabelValues("metrics-sub"), 1},
+		{cacheQueries.WithLabelValues("metrics-sub"), 1},
+	}
+	before := make([]float64, len(counters))
+	for i, tc := range counters {
+		before[i] = testutil.ToFloat64(tc.c)
+	}
+	gc.Write(context.TODO(), "metrics-sub", hostnameResponse(time.Now().UnixNano(), "srl1"))
+	// dropped, missing target
+	gc.Write(context.TODO(), "metrics-sub", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{}},
+	})
+	if _, err := gc.Read("metrics-sub", "t1", nil); err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if n := testutil.CollectAndCount(cacheQueryDuration, "gnmic_cache_query_duration_seconds"); n == 0 {
+		t.Errorf("query duration not collected")
+	}
+	for i, tc := range counters {
+		if got := testutil.ToFloat64(tc.c) - before[i]; got != tc.want {
+			t.Errorf("counter %d: got %v, want %v", i, got, tc.want)
+		}
+	}
+	if c := cacheGauges.counts()["metrics-sub"]; c == nil || c.targets != 1 || c.leaves != 1 {
+		t.Errorf("unexpected targets and leaves counts: %+v", c)
+	}
+	if n := testutil.CollectAndCount(cacheGauges, "gnmic_cache_targets"); n == 0 {
+		t.Errorf("targets gauge not collected")
+	}
+	gc.DeleteTarget("t1")
+	if c := cacheGauges.counts()["metrics-sub"]; c == nil || c.targets != 0 || c.leaves != 0 {
+		t.Errorf("unexpected targets and leaves counts after target removal: %+v", c)
+	}
+	gc.Clear()
+	if c, ok := cacheGauges.counts()["metrics-sub"]; ok {
+		t.Errorf("unexpected counts after clear: %+v", c)
+	}
+}
+
+func Test_gnmiCache_expiredMetrics(t *testing.T) {
+	now := time.Now()
+	gc := newGNMICache(&Config{Expiration: time.Minute, SweepInterval: -1}, WithLogger(log.Default()))
+	gc.clock = func() time.Time { return now }
+	gc.RegisterMetrics(prometheus.NewRegistry())
+	defer gc.Stop()
+	gc.Write(context.TODO(), "expired-sub", hostnameResponse(now.UnixNano(), "srl1"))
+	if got := cacheGauges.counts()["expired-sub"].leaves; got != 1 {
+		t.Errorf("unexpected leaves count: %v", got)
+	}
+	before := testutil.ToFloat64(cacheExpired.WithLabelValues("expired-sub"))
+	gc.clock = func() time.Time { return now.Add(2 * time.Minute) }
+	gc.sweep()
+	if d := testutil.ToFloat64(cacheExpired.WithLabelValues("expired-sub")) - before; d != 1 {
+		t.Errorf("unexpected expired count: %v", d)
+	}
+	if got := cacheGauges.counts()["expired-sub"].leaves; got != 0 {
+		t.Errorf("unexpected leaves count after sweep: %v", got)
+	}
+	if got := gc.GetStats().Subscriptions["expired-sub"].Expired; got != 1 {
+		t.Errorf("unexpected expired stats: %d", got)
+	}
+}
+
+func Test_gnmiCache_concurrentTargetWrites(t *testing.T) {
+	gc := newGNMICache(&Config{MaxTargetEntries: 2})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				rsp := hostnameResponse(time.Now().UnixNano(), "srl1")
+				rsp.GetUpdate().Prefix.Target = target
+				rsp.GetUpdate().Update[0].Path.Elem[2].Name = fmt.Sprintf("leaf%d", j)
+				gc.Write(context.TODO(), "sub1", rsp)
+			}
+		}(fmt.Sprintf("t%d", i))
+	}
+	wg.Wait()
+	c := gc.getCaches("sub1")["sub1"]
+	for i := 0; i < 50; i++ {
+		if n := c.leafCount(fmt.Sprintf("t%d", i)); n != 2 {
+			t.Errorf("target t%d: unexpected leaf count %d", i, n)
+		}
+	}
+	if n := c.totalLeafCount(); n != 100 {
+		t.Errorf("unexpected total leaf count %d", n)
+	}
+}
+
+// BenchmarkGnmiCacheWrite writes to many targets of a subscription
+// from parallel writers, run it with -cpu to compare the contention.
+func BenchmarkGnmiCacheWrite(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		cfg  *Config
+	}{
+		{name: "default", cfg: &Config{}},
+		{name: "max-target-entries", cfg: &Config{MaxTargetEntries: 100}},
+		{name: "history", cfg: &Config{HistoryDepth: 2}},
+	} {
+		for _, numTargets := range []int{1, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/targets=%d", bc.name, numTargets), func(b *testing.B) {
+				gc := newGNMICache(bc.cfg)
+				targets := make([]string, numTargets)
+				for i := range targets {
+					targets[i] = fmt.Sprintf("t%d", i)
+				}
+				var ts atomic.Int64
+				ts.Store(time.Now().UnixNano())
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						t := ts.Add(1)
+						rsp := hostnameResponse(t, "srl1")
+						rsp.GetUpdate().Prefix.Target = targets[t%int64(numTargets)]
+						// the stale values of a target written by
+						// concurrent writers are rejected.
+						gc.Write(context.TODO(), "sub1", rsp)
+					}
+				})
+			})
+		}
+	}
+}
+
+func Test_shardIndex(t *testing.T) {
+	// the assignment only depends on the target name and the shard count.
+	expected := map[string][2]int{
+		"router1":  {3, 15},
+		"router2":  {2, 2},
+		"10.0.0.1": {1, 13},
+		"leaf1":    {0, 12},
+	}
+	for target, shards := range expected {
+		for i := 0; i < 3; i++ {
+			if idx := shardIndex(target, 4); idx != shards[0] {
+				t.Errorf("target %q assigned to shard %d of 4, expected %d", target, idx, shards[0])
+			}
+			if idx := shardIndex(target, 16); idx != shards[1] {
+				t.Errorf("target %q assigned to shard %d of 16, expected %d", target, idx, shards[1])
+			}
+			if idx := shardIndex(target, 1); idx != 0 {
+				t.Errorf("target %q assigned to shard %d of 1", target, idx)
+			}
+		}
+	}
+	used := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		used[shardIndex(fmt.Sprintf("router%d", i), 4)] = true
+	}
+	if len(used) != 4 {
+		t.Errorf("only %d of 4 shards used by 100 targets", len(used))
+	}
+}
+
+func Test_gnmiCache_shards(t *testing.T) {
+	gc := newGNMICache(&Config{Shards: 4}, WithLogger(log.Default()))
+	targets := []string{"router1", "router2", "10.0.0.1", "leaf1"}
+	now := time.Now().UnixNano()
+	for _, target := range targets {
+		rsp := hostnameResponse(now, target)
+		rsp.GetUpdate().Prefix.Target = target
+		if err := gc.Write(context.TODO(), "sub1", rsp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	sc := gc.getCaches("sub1")["sub1"]
+	for _, target := range targets {
+		if !sc.c.shards[shardIndex(target, 4)].HasTarget(target) {
+			t.Errorf("target %q not cached in its shard", target)
+		}
+	}
+	rsp, err := gc.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != len(targets) {
+		t.Errorf("got %d notifications, expected %d", len(rsp["sub1"]), len(targets))
+	}
+	gc.DeleteTarget("router1")
+	if sc.c.HasTarget("router1") || len(sc.c.Metadata()) != len(targets)-1 {
+		t.Errorf("target not deleted, cached targets: %v", sc.c.Metadata())
+	}
+}
+
+func TestCheckShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *Config
+		shards  int
+		wantErr bool
+	}{
+		{name: "unchanged", cfg: &Config{Shards: 4}, shards: 4},
+		{name: "default", cfg: &Config{}, shards: 1},
+		{name: "no config", shards: 0},
+		{name: "changed", cfg: &Config{Shards: 4}, shards: 8, wantErr: true},
+		{name: "set", cfg: &Config{}, shards: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckShards(tt.cfg, tt.shards)
+			if tt.wantErr != errors.Is(err, ErrLiveReshard) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
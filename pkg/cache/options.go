@@ -8,7 +8,11 @@
 
 package cache
 
-import "log"
+import (
+	"log"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
 
 type Option func(Cache)
 
@@ -17,3 +21,41 @@ func WithLogger(logger *log.Logger) Option {
 		c.SetLogger(logger)
 	}
 }
+
+// WithMatchFactory sets the function used to create the Matcher
+// of each subscription cache, it defaults to the gNMI match package.
+func WithMatchFactory(f MatchFactory) Option {
+	return func(c Cache) {
+		if gc, ok := c.(*gnmiCache); ok {
+			gc.matchFactory = f
+		}
+	}
+}
+
+// EvictFunc is called with the subscription name, the target name
+// and the xpath of a leaf leaving the cache.
+type EvictFunc func(sub, target, xpath string)
+
+// WithOnEvict sets a callback called for each leaf that expired,
+// was deleted by a notification or removed with its target.
+// It is called synchronously, possibly concurrently, while the cache
+// is locked, hence it must not call the cache methods.
+func WithOnEvict(f EvictFunc) Option {
+	return func(c Cache) {
+		if gc, ok := c.(*gnmiCache); ok {
+			gc.onEvict = f
+		}
+	}
+}
+
+// WriteFunc is called with the subscription name, the target name
+// and the notification written to the cache.
+type WriteFunc func(sub, target string, n *gnmi.Notification)
+
+// WithOnWrite sets a callback called for each notification written
+// to the cache, see Cache.SetOnWrite.
+func WithOnWrite(f WriteFunc) Option {
+	return func(c Cache) {
+		c.SetOnWrite(f)
+	}
+}
@@ -10,6 +10,7 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -20,6 +21,7 @@ import (
 
 	redis "github.com/go-redis/redis/v8"
 	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/openconfig/gnmic/pkg/utils"
 )
@@ -53,7 +55,7 @@ func newRedisCache(cfg *Config, opts ...Option) (*redisCache, error) {
 
 	c := &redisCache{
 		cfg:         cfg,
-		oc:          newGNMICache(cfg, "redis", opts...),
+		oc:          newGNMICache(cfg, opts...),
 		channelChan: make(chan string),
 		m:           new(sync.RWMutex),
 		channels:    make(map[string]struct{}),
@@ -84,10 +86,20 @@ CLIENT:
 	}
 
 	c.logger.Printf("ping result: %s", pong)
+	if cfg.Persist {
+		err = c.loadStored(ctx)
+		if err != nil {
+			c.logger.Printf("failed to load the stored values: %v", err)
+		}
+	}
 	go c.sync(ctx)
 	return c, nil
 }
 
+func (c *redisCache) SetOnWrite(f WriteFunc) {
+	c.oc.SetOnWrite(f)
+}
+
 func (c *redisCache) SetLogger(logger *log.Logger) {
 	if logger != nil && c.logger != nil {
 		c.logger.SetOutput(logger.Writer())
@@ -96,9 +108,9 @@ func (c *redisCache) SetLogger(logger *log.Logger) {
 	}
 }
 
-func (c *redisCache) Write(ctx context.Context, subscriptionName string, m proto.Message) {
+func (c *redisCache) Write(ctx context.Context, subscriptionName string, m proto.Message) error {
 	// write the msg to redis
-	c.writeRemoteREDIS(ctx, subscriptionName, m)
+	err := c.writeRemoteREDIS(ctx, subscriptionName, m)
 	// publish the subscription name to redis for other gnmic instances
 	var ok bool
 	c.m.RLock()
@@ -112,9 +124,10 @@ func (c *redisCache) Write(ctx context.Context, subscriptionName string, m proto
 		}
 	}()
 	_, ok = c.channels[subscriptionName]
+	return err
 }
 
-func (c *redisCache) writeRemoteREDIS(ctx context.Context, subscriptionName string, m proto.Message) {
+func (c *redisCache) writeRemoteREDIS(ctx context.Context, subscriptionName string, m proto.Message) error {
 	switch m := m.ProtoReflect().Interface().(type) {
 	case *gnmi.SubscribeResponse:
 		switch rsp := m.GetResponse().(type) {
@@ -122,16 +135,17 @@ func (c *redisCache) writeRemoteREDIS(ctx context.Context, subscriptionName stri
 			targetName := rsp.Update.GetPrefix().GetTarget()
 			if targetName == "" {
 				c.logger.Printf("subscription=%q: response missing target: %v", subscriptionName, rsp)
-				return
+				return &WriteError{Subscription: subscriptionName, Err: ErrMissingTarget}
 			}
 			c.channelChan <- subscriptionName
-			var err error
-			err = c.publishNotificationREDIS(ctx, subscriptionName, targetName, m)
+			err := c.publishNotificationREDIS(ctx, subscriptionName, targetName, m)
 			if err != nil {
 				c.logger.Print(err)
+				return &WriteError{Subscription: subscriptionName, Target: targetName, Err: fmt.Errorf("%w: %w", ErrRejected, err)}
 			}
 		}
 	}
+	return nil
 }
 
 func (c *redisCache) publishNotificationREDIS(ctx context.Context, subscriptionName, targetName string, r *gnmi.SubscribeResponse) error {
@@ -153,6 +167,9 @@ func (c *redisCache) publishNotificationREDIS(ctx context.Context, subscriptionN
 		err = fmt.Errorf("failed to publish resultErr: %v", err)
 		c.logger.Print(err)
 	}
+	if c.cfg.Persist {
+		return c.storeNotification(ctx, subscriptionName, r)
+	}
 	return nil
 }
 
@@ -161,7 +178,19 @@ func (c *redisCache) ReadAll() (map[string][]*gnmi.Notification, error) {
 }
 
 func (c *redisCache) Read(sub, target string, p *gnmi.Path) (map[string][]*gnmi.Notification, error) {
-	return c.oc.read(sub, target, p), nil
+	return c.oc.read(sub, target, p)
+}
+
+func (c *redisCache) ReadHistory(sub, target string, p *gnmi.Path, depth int) (map[string][]*gnmi.Notification, error) {
+	return c.oc.ReadHistory(sub, target, p, depth)
+}
+
+func (c *redisCache) ReadRaw(sub, target string) (map[string][]*gnmi.SubscribeResponse, error) {
+	return c.oc.ReadRaw(sub, target)
+}
+
+func (c *redisCache) LatestAll(sub string, p *gnmi.Path) map[string]*gnmi.Notification {
+	return c.oc.LatestAll(sub, p)
 }
 
 func (c *redisCache) sync(ctx context.Context) {
@@ -236,13 +265,74 @@ func (c *redisCache) Subscribe(ctx context.Context, ro *ReadOpts) chan *Notifica
 	return c.oc.Subscribe(ctx, ro)
 }
 
+func (c *redisCache) ListTargets() map[string][]string {
+	return c.oc.ListTargets()
+}
+
+func (c *redisCache) GetStats() *CacheStats {
+	return c.oc.GetStats()
+}
+
+func (c *redisCache) RegisterMetrics(reg *prometheus.Registry) {
+	c.oc.RegisterMetrics(reg)
+}
+
 func (c *redisCache) Stop() {
 	c.cfn()
+	c.oc.Stop()
 	if c.c != nil {
 		c.c.Close()
 	}
 }
 
 func (c *redisCache) DeleteTarget(name string) {
-	c.oc.DeleteTarget(name)
+	c.DeleteTargetReport(name)
+}
+
+func (c *redisCache) DeleteTargetReport(name string) map[string]bool {
+	report := c.oc.DeleteTargetReport(name)
+	if c.cfg.Persist {
+		err := c.deleteStored(redisTargetKeys(c.oc.canonicalTarget(name)))
+		if err != nil {
+			c.logger.Printf("target %q: %v", name, err)
+		}
+	}
+	return report
+}
+
+func (c *redisCache) Clear() {
+	c.oc.Clear()
+	if c.cfg.Persist {
+		err := c.deleteStored(redisValuesKeyPrefix + "*")
+		if err != nil {
+			c.logger.Print(err)
+		}
+	}
+}
+
+func (c *redisCache) ClearSubscription(name string) {
+	c.oc.ClearSubscription(name)
+	if c.cfg.Persist {
+		err := c.deleteStored(redisValuesKeyPrefix + redisGlobEscape(name) + "\x00*")
+		if err != nil {
+			c.logger.Printf("subscription %q: %v", name, err)
+		}
+	}
+}
+
+func (c *redisCache) DeletePath(sub, target string, p *gnmi.Path) error {
+	err := c.oc.DeletePath(sub, target, p)
+	if !c.cfg.Persist || errors.Is(err, ErrSubscriptionNotFound) || len(p.GetElem()) == 0 {
+		return err
+	}
+	if sub == "*" {
+		sub = ""
+	}
+	// the stored values are deleted even if the target is not in the local cache,
+	// they could have been removed from it as expired.
+	serr := c.deleteStoredPath(sub, c.oc.canonicalTarget(target), p)
+	if serr != nil {
+		return errors.Join(err, serr)
+	}
+	return err
 }
@@ -0,0 +1,275 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/openconfig/gnmi/path"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// prefix of the redis hashes storing the latest values of each subscription
+// and target, if persist is set.
+const redisValuesKeyPrefix = "gnmic_cache_values."
+
+// redisValuesKey returns the key of the redis hash
+// storing the latest values of target in subscription sub.
+func redisValuesKey(sub, target string) string {
+	return redisValuesKeyPrefix + sub + "\x00" + target
+}
+
+// redisKeyNames returns the subscription and the target of the redis
+// values hash key.
+func redisKeyNames(key string) (string, string) {
+	sub, target, _ := strings.Cut(strings.TrimPrefix(key, redisValuesKeyPrefix), "\x00")
+	return sub, target
+}
+
+// redisGlobEscape escapes the redis glob-style pattern special characters of s.
+func redisGlobEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// redisTargetKeys returns the pattern of the keys of the redis values hashes
+// of the target name in all the subscriptions,
+// a name ending with `*` matches all the targets starting with the name prefix.
+func redisTargetKeys(name string) string {
+	if prefix, ok := strings.CutSuffix(name, "*"); ok {
+		return redisValuesKeyPrefix + "*\x00" + redisGlobEscape(prefix) + "*"
+	}
+	return redisValuesKeyPrefix + "*\x00" + redisGlobEscape(name)
+}
+
+// redisField returns the field of the redis values hash storing
+// the value of path p under prefix, the target of prefix is ignored.
+// Like in the oc cache tree, the field is made of the origin, the path elements
+// names and their keys values.
+func redisField(prefix, p *gnmi.Path) (string, error) {
+	cp, err := path.CompletePath(prefix, p)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(cp, "\x00"), nil
+}
+
+// redisEntries returns the values of notification n stored in the redis
+// values hash, keyed by field, and the paths deleted by n, in the fields format.
+// The updates of a non-atomic notification are stored individually,
+// like the oc cache leaves, an atomic notification is stored as a whole.
+// The paths that cannot be stored in the oc cache are ignored.
+func redisEntries(n *gnmi.Notification) (map[string]*gnmi.Notification, [][]string) {
+	dels := make([][]string, 0, len(n.GetDelete()))
+	for _, del := range n.GetDelete() {
+		cp, err := path.CompletePath(n.GetPrefix(), del)
+		if err != nil {
+			continue
+		}
+		dels = append(dels, cp)
+	}
+	if len(n.GetUpdate()) == 0 {
+		return nil, dels
+	}
+	if n.GetAtomic() {
+		f, err := redisField(n.GetPrefix(), nil)
+		if err != nil {
+			return nil, dels
+		}
+		return map[string]*gnmi.Notification{
+			f: {
+				Timestamp: n.GetTimestamp(),
+				Prefix:    n.GetPrefix(),
+				Update:    n.GetUpdate(),
+				Atomic:    true,
+			},
+		}, dels
+	}
+	sets := make(map[string]*gnmi.Notification, len(n.GetUpdate()))
+	for _, upd := range n.GetUpdate() {
+		f, err := redisField(n.GetPrefix(), upd.GetPath())
+		if err != nil {
+			continue
+		}
+		sets[f] = singleUpdate(n, upd)
+	}
+	return sets, dels
+}
+
+// deletedFields returns the fields deleted by the paths dels.
+// Like a delete from the oc cache tree, a path deletes the fields
+// under it, and a `*` element or key value matches any of them.
+func deletedFields(fields []string, dels [][]string) []string {
+	deleted := make([]string, 0)
+	for _, f := range fields {
+		fc := strings.Split(f, "\x00")
+		for _, del := range dels {
+			if pathMatches(del, fc) {
+				deleted = append(deleted, f)
+				break
+			}
+		}
+	}
+	return deleted
+}
+
+// storeNotification stores the values of the response r of subscription sub
+// in the redis values hash of its target, and removes the values of its deleted paths.
+// The hash expires once the subscription expiration elapsed without writes to the target,
+// the values expired individually are dropped when they are loaded.
+func (c *redisCache) storeNotification(ctx context.Context, sub string, r *gnmi.SubscribeResponse) error {
+	key := redisValuesKey(sub, c.oc.canonicalTarget(r.GetUpdate().GetPrefix().GetTarget()))
+	sets, dels := redisEntries(r.GetUpdate())
+	var deleted []string
+	if len(dels) > 0 {
+		fields, err := c.c.HKeys(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("failed to list the stored values: %w", err)
+		}
+		deleted = deletedFields(fields, dels)
+	}
+	values := make([]interface{}, 0, 2*len(sets))
+	for f, n := range sets {
+		b, err := proto.Marshal(&gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{Update: n},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal proto message: %w", err)
+		}
+		values = append(values, f, b)
+	}
+	exp := c.oc.subscriptionExpiration(sub)
+	_, err := c.c.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if len(deleted) > 0 {
+			p.HDel(ctx, key, deleted...)
+		}
+		if len(values) > 0 {
+			p.HSet(ctx, key, values...)
+		}
+		if exp > 0 {
+			p.Expire(ctx, key, exp)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store the values: %w", err)
+	}
+	return nil
+}
+
+// scanStored calls f with the keys of the redis values hashes matching pattern.
+func (c *redisCache) scanStored(ctx context.Context, pattern string, f func([]string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.c.Scan(ctx, cursor, pattern, 0).Result()
+		if err != nil {
+			return fmt.Errorf("failed to list the stored values: %w", err)
+		}
+		if len(keys) > 0 {
+			if err = f(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// deleteStored removes the redis values hashes matching pattern.
+func (c *redisCache) deleteStored(pattern string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	return c.scanStored(ctx, pattern, func(keys []string) error {
+		err := c.c.Del(ctx, keys...).Err()
+		if err != nil {
+			return fmt.Errorf("failed to delete the stored values: %w", err)
+		}
+		return nil
+	})
+}
+
+// deleteStoredPath removes the values of path p of target from the redis values hash
+// of subscription sub, or of all the subscriptions if sub is empty.
+func (c *redisCache) deleteStoredPath(sub, target string, p *gnmi.Path) error {
+	del, err := path.CompletePath(&gnmi.Path{Origin: p.GetOrigin()}, &gnmi.Path{Elem: p.GetElem()})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	pattern := redisValuesKeyPrefix + redisGlobEscape(sub) + "\x00" + redisGlobEscape(target)
+	if sub == "" {
+		pattern = redisTargetKeys(target)
+	}
+	return c.scanStored(ctx, pattern, func(keys []string) error {
+		for _, key := range keys {
+			fields, err := c.c.HKeys(ctx, key).Result()
+			if err != nil {
+				return fmt.Errorf("failed to list the stored values: %w", err)
+			}
+			deleted := deletedFields(fields, [][]string{del})
+			if len(deleted) == 0 {
+				continue
+			}
+			if err = c.c.HDel(ctx, key, deleted...).Err(); err != nil {
+				return fmt.Errorf("failed to delete the stored values: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// loadStored writes the values stored in redis to the local cache,
+// the expired values are removed from redis instead.
+func (c *redisCache) loadStored(ctx context.Context) error {
+	return c.scanStored(ctx, redisValuesKeyPrefix+"*", func(keys []string) error {
+		for _, key := range keys {
+			sub, target := redisKeyNames(key)
+			values, err := c.c.HGetAll(ctx, key).Result()
+			if err != nil {
+				return fmt.Errorf("subscription %q: target %q: failed to read the stored values: %w", sub, target, err)
+			}
+			now := time.Now()
+			expired := make([]string, 0)
+			for f, v := range values {
+				m := new(gnmi.SubscribeResponse)
+				if err = proto.Unmarshal([]byte(v), m); err != nil {
+					c.logger.Printf("subscription %q: target %q: failed to unmarshal stored value: %v", sub, target, err)
+					continue
+				}
+				if c.oc.expired(sub, m.GetUpdate(), now) {
+					expired = append(expired, f)
+					continue
+				}
+				c.oc.Write(ctx, sub, m)
+			}
+			if len(expired) > 0 {
+				if err = c.c.HDel(ctx, key, expired...).Err(); err != nil {
+					c.logger.Printf("subscription %q: target %q: failed to delete the expired stored values: %v", sub, target, err)
+				}
+			}
+			c.logger.Printf("loaded %d stored value(s) of subscription %q target %q", len(values)-len(expired), sub, target)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,266 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_redisEntries(t *testing.T) {
+	prefix := &gnmi.Path{
+		Target: "t1",
+		Elem:   []*gnmi.PathElem{{Name: "interfaces"}, {Name: "interface", Key: map[string]string{"name": "e1"}}},
+	}
+	val := &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 1500}}
+	mtu := &gnmi.Update{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "mtu"}}}, Val: val}
+	desc := &gnmi.Update{
+		Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "description"}}},
+		Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "uplink"}},
+	}
+	tests := []struct {
+		name string
+		n    *gnmi.Notification
+		sets map[string]*gnmi.Notification
+		dels [][]string
+	}{
+		{
+			name: "updates",
+			n:    &gnmi.Notification{Timestamp: 1, Prefix: prefix, Update: []*gnmi.Update{mtu, desc}},
+			sets: map[string]*gnmi.Notification{
+				"interfaces\x00interface\x00e1\x00mtu":         {Timestamp: 1, Prefix: prefix, Update: []*gnmi.Update{mtu}},
+				"interfaces\x00interface\x00e1\x00description": {Timestamp: 1, Prefix: prefix, Update: []*gnmi.Update{desc}},
+			},
+			dels: [][]string{},
+		},
+		{
+			name: "atomic",
+			n:    &gnmi.Notification{Timestamp: 1, Prefix: prefix, Update: []*gnmi.Update{mtu, desc}, Atomic: true},
+			sets: map[string]*gnmi.Notification{
+				"interfaces\x00interface\x00e1": {Timestamp: 1, Prefix: prefix, Update: []*gnmi.Update{mtu, desc}, Atomic: true},
+			},
+			dels: [][]string{},
+		},
+		{
+			name: "deletes",
+			n:    &gnmi.Notification{Timestamp: 1, Prefix: prefix, Delete: []*gnmi.Path{mtu.GetPath()}},
+			dels: [][]string{{"interfaces", "interface", "e1", "mtu"}},
+		},
+		{
+			name: "origin",
+			n: &gnmi.Notification{
+				Timestamp: 1,
+				Prefix:    &gnmi.Path{Target: "t1", Origin: "openconfig"},
+				Delete:    []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "system"}}}},
+			},
+			dels: [][]string{{"openconfig", "system"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets, dels := redisEntries(tt.n)
+			if len(sets) != len(tt.sets) {
+				t.Fatalf("unexpected values: %v", sets)
+			}
+			for f, n := range tt.sets {
+				if !proto.Equal(sets[f], n) {
+					t.Errorf("unexpected value of field %q, got %v, expected %v", f, sets[f], n)
+				}
+			}
+			if !reflect.DeepEqual(dels, tt.dels) {
+				t.Errorf("unexpected deleted paths %q, expected %q", dels, tt.dels)
+			}
+		})
+	}
+}
+
+func Test_deletedFields(t *testing.T) {
+	fields := []string{
+		"interfaces\x00interface\x00e1\x00mtu",
+		"interfaces\x00interface\x00e1\x00description",
+		"interfaces\x00interface\x00e10\x00mtu",
+		"system\x00name",
+	}
+	tests := []struct {
+		name     string
+		dels     [][]string
+		expected []string
+	}{
+		{
+			name: "subtree",
+			dels: [][]string{{"interfaces", "interface", "e1"}},
+			expected: []string{
+				"interfaces\x00interface\x00e1\x00description",
+				"interfaces\x00interface\x00e1\x00mtu",
+			},
+		},
+		{
+			name: "wildcard",
+			dels: [][]string{{"interfaces", "interface", "*", "mtu"}},
+			expected: []string{
+				"interfaces\x00interface\x00e1\x00mtu",
+				"interfaces\x00interface\x00e10\x00mtu",
+			},
+		},
+		{
+			name:     "leaf",
+			dels:     [][]string{{"system", "name"}},
+			expected: []string{"system\x00name"},
+		},
+		{
+			name:     "no match",
+			dels:     [][]string{{"interfaces", "interface", "e1", "mtu", "value"}},
+			expected: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deletedFields(fields, tt.dels)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected deleted fields %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_redisCache_persistDelete(t *testing.T) {
+	ifPath := func(name, leaf string) *gnmi.Path {
+		return &gnmi.Path{Elem: []*gnmi.PathElem{
+			{Name: "interfaces"},
+			{Name: "interface", Key: map[string]string{"name": name}},
+			{Name: leaf},
+		}}
+	}
+	update := func(target string, p *gnmi.Path) *gnmi.SubscribeResponse {
+		return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    &gnmi.Path{Target: target},
+			Update: []*gnmi.Update{{
+				Path: p,
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 1}},
+			}},
+		}}}
+	}
+	tests := []struct {
+		name     string
+		delete   func(t *testing.T, c *redisCache)
+		expected []string
+	}{
+		{
+			name:   "delete path",
+			delete: func(t *testing.T, c *redisCache) { c.DeletePath("sub1", "t1", ifPath("*", "mtu")) },
+			expected: []string{
+				"sub1/t1/interfaces/interface[name=e1]/description",
+				"sub1/t2/interfaces/interface[name=e1]/mtu",
+				"sub2/t1/interfaces/interface[name=e1]/mtu",
+			},
+		},
+		{
+			name: "delete notification",
+			delete: func(t *testing.T, c *redisCache) {
+				err := c.Write(context.Background(), "sub1", &gnmi.SubscribeResponse{
+					Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{
+						Timestamp: time.Now().UnixNano(),
+						Prefix:    &gnmi.Path{Target: "t1"},
+						Delete: []*gnmi.Path{{Elem: []*gnmi.PathElem{
+							{Name: "interfaces"},
+							{Name: "interface", Key: map[string]string{"name": "e1"}},
+						}}},
+					}},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			expected: []string{
+				"sub1/t2/interfaces/interface[name=e1]/mtu",
+				"sub2/t1/interfaces/interface[name=e1]/mtu",
+			},
+		},
+		{
+			name:   "delete target",
+			delete: func(t *testing.T, c *redisCache) { c.DeleteTarget("t1") },
+			expected: []string{
+				"sub1/t2/interfaces/interface[name=e1]/mtu",
+			},
+		},
+		{
+			name:   "clear subscription",
+			delete: func(t *testing.T, c *redisCache) { c.ClearSubscription("sub1") },
+			expected: []string{
+				"sub2/t1/interfaces/interface[name=e1]/mtu",
+			},
+		},
+		{
+			name:     "clear",
+			delete:   func(t *testing.T, c *redisCache) { c.Clear() },
+			expected: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := miniredis.RunT(t)
+			cfg := &Config{
+				Type:       cacheType_Redis,
+				Address:    s.Addr(),
+				Expiration: time.Minute,
+				Persist:    true,
+			}
+			c, err := newRedisCache(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for sub, rsps := range map[string][]*gnmi.SubscribeResponse{
+				"sub1": {
+					update("t1", ifPath("e1", "mtu")),
+					update("t1", ifPath("e1", "description")),
+					update("t2", ifPath("e1", "mtu")),
+				},
+				"sub2": {update("t1", ifPath("e1", "mtu"))},
+			} {
+				for _, rsp := range rsps {
+					if err = c.Write(context.Background(), sub, rsp); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			tt.delete(t, c)
+			c.Stop()
+
+			// restart
+			c, err = newRedisCache(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Stop()
+			all, err := c.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for sub, ns := range all {
+				for _, n := range ns {
+					got = append(got, sub+"/"+n.GetPrefix().GetTarget()+"/"+notificationXPath(n))
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected values after restart %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
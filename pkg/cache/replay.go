@@ -0,0 +1,87 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// Replay sends the notifications of subscription sub and target stored in the cache c,
+// including the values retained by the history if enabled, ordered by timestamp.
+// The notifications are sent with the same time gaps as their timestamps,
+// divided by speed. If speed is not greater than 0, they are sent without delay.
+// The channel is closed when all the notifications are sent or ctx is done.
+func Replay(ctx context.Context, c Cache, sub, target string, speed float64) (<-chan *gnmi.Notification, error) {
+	rs, err := c.ReadHistory(sub, target, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return replay(ctx, rs, speed), nil
+}
+
+// ReplaySnapshot is like Replay for the notifications of the snapshot r,
+// an empty or `*` sub or target matches all the subscriptions or targets.
+func ReplaySnapshot(ctx context.Context, r io.Reader, sub, target string, speed float64) (<-chan *gnmi.Notification, error) {
+	ns, err := readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[string][]*gnmi.Notification)
+	for name, sns := range ns {
+		if sub != "" && sub != "*" && name != sub {
+			continue
+		}
+		for _, n := range sns {
+			if target != "" && target != "*" && n.GetPrefix().GetTarget() != target {
+				continue
+			}
+			rs[name] = append(rs[name], n)
+		}
+	}
+	return replay(ctx, rs, speed), nil
+}
+
+func replay(ctx context.Context, rs map[string][]*gnmi.Notification, speed float64) <-chan *gnmi.Notification {
+	ns := make([]*gnmi.Notification, 0)
+	for _, sns := range rs {
+		ns = append(ns, sns...)
+	}
+	sort.SliceStable(ns, func(i, j int) bool {
+		return ns[i].GetTimestamp() < ns[j].GetTimestamp()
+	})
+	ch := make(chan *gnmi.Notification)
+	go func() {
+		defer close(ch)
+		for i, n := range ns {
+			if i > 0 && speed > 0 {
+				gap := time.Duration(float64(n.GetTimestamp()-ns[i-1].GetTimestamp()) / speed)
+				if gap > 0 {
+					timer := time.NewTimer(gap)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					case <-timer.C:
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- n:
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,81 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestReplay(t *testing.T) {
+	gc := newGNMICache(&Config{HistoryDepth: 3})
+	now := time.Now()
+	gap := 50 * time.Millisecond
+	for i, name := range []string{"srl1", "srl2", "srl3"} {
+		gc.Write(context.TODO(), "sub1", hostnameResponse(now.Add(time.Duration(i)*gap).UnixNano(), name))
+	}
+	tests := []struct {
+		name       string
+		speed      float64
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{name: "original_cadence", speed: 1, minElapsed: 2 * gap, maxElapsed: time.Second},
+		{name: "double_speed", speed: 2, minElapsed: gap, maxElapsed: time.Second},
+		{name: "no_delay", speed: 0, minElapsed: 0, maxElapsed: gap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			ch, err := Replay(context.TODO(), gc, "sub1", "t1", tt.speed)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			values := make([]string, 0, 3)
+			for n := range ch {
+				values = append(values, n.GetUpdate()[0].GetVal().GetAsciiVal())
+			}
+			elapsed := time.Since(start)
+			if !reflect.DeepEqual(values, []string{"srl1", "srl2", "srl3"}) {
+				t.Errorf("unexpected replayed values: %q", values)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("replay took %s, expected between %s and %s", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+	if _, err := Replay(context.TODO(), gc, "sub1", "t2", 1); err == nil {
+		t.Error("expected an error for an unknown target")
+	}
+}
+
+func TestReplaySnapshot(t *testing.T) {
+	buf := testSnapshot(t, map[string][]*gnmi.Notification{
+		"sub1": {
+			leafNotification(3, "t1", "mtu", 9000),
+			leafNotification(2, "t2", "mtu", 1500),
+			leafNotification(1, "t1", "mtu", 1500),
+		},
+	})
+	ch, err := ReplaySnapshot(context.TODO(), buf, "*", "t1", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ts := make([]int64, 0, 2)
+	for n := range ch {
+		ts = append(ts, n.GetTimestamp())
+	}
+	if !reflect.DeepEqual(ts, []int64{1, 3}) {
+		t.Errorf("unexpected replayed timestamps: %v", ts)
+	}
+}
@@ -0,0 +1,153 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// DropPolicy defines what happens to a notification
+// sent to a shared subscription consumer whose buffer is full.
+type DropPolicy string
+
+const (
+	// DropPolicyBlock waits for the consumer, blocking all the consumers.
+	DropPolicyBlock DropPolicy = "block"
+	// DropPolicyNewest drops the notification being sent.
+	DropPolicyNewest DropPolicy = "drop-newest"
+	// DropPolicyOldest drops the oldest buffered notification to make room.
+	DropPolicyOldest DropPolicy = "drop-oldest"
+)
+
+// ConsumerConfig is the configuration of a shared subscription consumer.
+type ConsumerConfig struct {
+	// BufferSize, number of notifications buffered for the consumer.
+	BufferSize int
+	// DropPolicy, defaults to DropPolicyBlock.
+	DropPolicy DropPolicy
+}
+
+// Consumer receives the notifications of a shared subscription.
+type Consumer struct {
+	// C is closed when the shared subscription ends.
+	C <-chan *Notification
+
+	ch      chan *Notification
+	policy  DropPolicy
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of notifications dropped
+// because the consumer buffer was full.
+func (c *Consumer) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// SubscribeShared subscribes to the cache c once and sends the notifications
+// to a consumer per ConsumerConfig, in the same order.
+// This avoids running the same cache queries and match registrations
+// for each consumer of the same subscription.
+// The notifications are shared by the consumers and must not be modified.
+// The consumers channels are closed when ctx is done or,
+// in `once` mode, when all the notifications are sent.
+func SubscribeShared(ctx context.Context, c Cache, ro *ReadOpts, ccs ...*ConsumerConfig) []*Consumer {
+	consumers := make([]*Consumer, 0, len(ccs))
+	for _, cc := range ccs {
+		if cc == nil {
+			cc = new(ConsumerConfig)
+		}
+		policy := cc.DropPolicy
+		if policy == "" {
+			policy = DropPolicyBlock
+		}
+		bufferSize := cc.BufferSize
+		// the drop policies need a buffer to drop from.
+		if policy != DropPolicyBlock && bufferSize < 1 {
+			bufferSize = 1
+		}
+		ch := make(chan *Notification, bufferSize)
+		consumer := &Consumer{C: ch, ch: ch, policy: policy}
+		consumers = append(consumers, consumer)
+	}
+	sub := c.Subscribe(ctx, ro)
+	go func() {
+		defer func() {
+			for _, consumer := range consumers {
+				close(consumer.ch)
+			}
+			// unblock the cache until it closes the subscription channel.
+			for range sub {
+			}
+		}()
+		for n := range sub {
+			for _, consumer := range consumers {
+				if !consumer.send(ctx, n) {
+					return
+				}
+			}
+		}
+	}()
+	return consumers
+}
+
+// send sends n to the consumer according to its drop policy,
+// it returns false if ctx is done before n is sent.
+func (c *Consumer) send(ctx context.Context, n *Notification) bool {
+	return sendWithPolicy(ctx, c.ch, n, c.policy, func(*Notification) { c.dropped.Add(1) })
+}
+
+// validate returns an error if p is not a known drop policy.
+// An empty policy is valid and means DropPolicyBlock.
+func (p DropPolicy) validate() error {
+	switch p {
+	case "", DropPolicyBlock, DropPolicyNewest, DropPolicyOldest:
+		return nil
+	}
+	return fmt.Errorf("unknown drop policy %q", p)
+}
+
+// sendWithPolicy sends n to ch, applying policy if ch is full.
+// drop is called with each dropped notification, either n
+// or the oldest notification buffered in ch.
+// It returns false if ctx is done before n is sent,
+// which only happens with DropPolicyBlock.
+func sendWithPolicy(ctx context.Context, ch chan *Notification, n *Notification, policy DropPolicy, drop func(*Notification)) bool {
+	switch policy {
+	case DropPolicyNewest:
+		select {
+		case ch <- n:
+		default:
+			drop(n)
+		}
+	case DropPolicyOldest:
+		for {
+			select {
+			case ch <- n:
+				return true
+			default:
+			}
+			// the consumer might have read the oldest notification
+			// in the meantime, in which case nothing is dropped.
+			select {
+			case old := <-ch:
+				drop(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case ch <- n:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
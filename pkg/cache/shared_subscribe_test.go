@@ -0,0 +1,83 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestSubscribeShared(t *testing.T) {
+	gc := newGNMICache(&Config{})
+	now := time.Now().UnixNano()
+	for i := 0; i < 3; i++ {
+		gc.Write(context.TODO(), "sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: now,
+					Prefix:    &gnmi.Path{Target: "t1"},
+					Update: []*gnmi.Update{
+						{
+							Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: fmt.Sprintf("leaf%d", i)}}},
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(i)}},
+						},
+					},
+				},
+			},
+		})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	consumers := SubscribeShared(ctx, gc,
+		&ReadOpts{Subscription: "sub1", Mode: ReadMode_Once, PathOrdered: true},
+		&ConsumerConfig{},
+		&ConsumerConfig{BufferSize: 1, DropPolicy: DropPolicyNewest},
+		&ConsumerConfig{BufferSize: 1, DropPolicy: DropPolicyOldest},
+	)
+	// the blocking consumer is read while the others are not.
+	var blocking []int64
+	for n := range consumers[0].C {
+		if n.SyncResponse {
+			continue
+		}
+		blocking = append(blocking, n.Notification.GetUpdate()[0].GetVal().GetIntVal())
+	}
+	if fmt.Sprint(blocking) != "[0 1 2]" {
+		t.Errorf("blocking consumer: unexpected notifications: %v", blocking)
+	}
+	// the sync marker follows the values.
+	tests := []struct {
+		name     string
+		consumer *Consumer
+		expected string
+	}{
+		{"drop-newest", consumers[1], "0"},
+		{"drop-oldest", consumers[2], "sync"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for n := range tt.consumer.C {
+			if n.SyncResponse {
+				got = append(got, "sync")
+				continue
+			}
+			got = append(got, fmt.Sprint(n.Notification.GetUpdate()[0].GetVal().GetIntVal()))
+		}
+		if len(got) != 1 || got[0] != tt.expected {
+			t.Errorf("%s consumer: unexpected notifications %v, expected [%s]", tt.name, got, tt.expected)
+		}
+		if tt.consumer.Dropped() != 3 {
+			t.Errorf("%s consumer: unexpected dropped count %d, expected 3", tt.name, tt.consumer.Dropped())
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+
+	gpath "github.com/openconfig/gnmic/pkg/path"
+)
+
+// maximum size of a single snapshot record,
+// protects against corrupted length prefixes.
+const maxSnapshotRecordSize = 64 * 1024 * 1024
+
+// A snapshot is a sequence of subscription groups, each group is made of:
+//   - the length prefixed subscription name,
+//   - the number of notifications in the group,
+//   - the length prefixed marshaled gnmi.Notification protos.
+//
+// All the lengths and counts are encoded as unsigned varints.
+
+// WriteSnapshot writes the current content of the cache c to w,
+// in the format read by Diff and DiffCurrent.
+func WriteSnapshot(c Cache, w io.Writer) error {
+	notifications, err := c.ReadAll()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, notifications)
+}
+
+// WriteNotifications writes the notifications grouped by subscription name
+// to w, in the snapshot format read by ReadNotifications.
+func WriteNotifications(w io.Writer, notifications map[string][]*gnmi.Notification) error {
+	return writeSnapshot(w, notifications)
+}
+
+// ReadNotifications reads the notifications written by WriteNotifications
+// or WriteSnapshot from r, grouped by subscription name.
+// A notification, or subscription name, larger than maxRecordSize bytes is rejected
+// before it is read, a zero maxRecordSize applies the snapshot files limit.
+func ReadNotifications(r io.Reader, maxRecordSize int) (map[string][]*gnmi.Notification, error) {
+	if maxRecordSize <= 0 {
+		maxRecordSize = maxSnapshotRecordSize
+	}
+	return readNotifications(r, uint64(maxRecordSize))
+}
+
+// writeSnapshot encodes the notifications grouped by subscription name to w.
+func writeSnapshot(w io.Writer, notifications map[string][]*gnmi.Notification) error {
+	bw := bufio.NewWriter(w)
+	subs := make([]string, 0, len(notifications))
+	for sub := range notifications {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	for _, sub := range subs {
+		err := writeRecord(bw, []byte(sub))
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(binary.AppendUvarint(nil, uint64(len(notifications[sub]))))
+		if err != nil {
+			return err
+		}
+		for _, n := range notifications[sub] {
+			b, err := proto.Marshal(n)
+			if err != nil {
+				return err
+			}
+			err = writeRecord(bw, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// readSnapshot decodes a snapshot written by WriteSnapshot.
+func readSnapshot(r io.Reader) (map[string][]*gnmi.Notification, error) {
+	return readNotifications(r, maxSnapshotRecordSize)
+}
+
+func readNotifications(r io.Reader, maxRecordSize uint64) (map[string][]*gnmi.Notification, error) {
+	br := bufio.NewReader(r)
+	notifications := make(map[string][]*gnmi.Notification)
+	for {
+		sub, err := readRecord(br, maxRecordSize)
+		if errors.Is(err, io.EOF) {
+			return notifications, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		count, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read subscription %q notifications count: %w", sub, io.ErrUnexpectedEOF)
+		}
+		for i := uint64(0); i < count; i++ {
+			b, err := readRecord(br, maxRecordSize)
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read subscription %q notification: %w", sub, err)
+			}
+			n := new(gnmi.Notification)
+			err = proto.Unmarshal(b, n)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode subscription %q notification: %w", sub, err)
+			}
+			notifications[string(sub)] = append(notifications[string(sub)], n)
+		}
+	}
+}
+
+func writeRecord(w io.Writer, b []byte) error {
+	_, err := w.Write(binary.AppendUvarint(nil, uint64(len(b))))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readRecord reads a length prefixed record,
+// it returns io.EOF only if r is at the end of a record.
+func readRecord(r *bufio.Reader, maxSize uint64) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxSize {
+		return nil, fmt.Errorf("snapshot record too large: %d bytes", l)
+	}
+	b := make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+type DiffType string
+
+const (
+	DiffAdded   DiffType = "added"
+	DiffRemoved DiffType = "removed"
+	DiffChanged DiffType = "changed"
+)
+
+// LeafDiff is the difference of a single leaf between two snapshots.
+type LeafDiff struct {
+	Subscription string
+	Target       string
+	// Path is the leaf xpath, including its origin if any.
+	Path string
+	Type DiffType
+	// Old is nil for an added leaf, New is nil for a removed leaf.
+	Old *gnmi.TypedValue
+	New *gnmi.TypedValue
+}
+
+type diffKey struct {
+	sub    string
+	target string
+	path   string
+}
+
+type diffValue struct {
+	ts  int64
+	val *gnmi.TypedValue
+}
+
+// Diff decodes the snapshots a and b and returns the leaves
+// added, removed or changed in b compared to a,
+// ordered by subscription, target and path.
+func Diff(a, b io.Reader) ([]LeafDiff, error) {
+	na, err := readSnapshot(a)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := readSnapshot(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffLeaves(snapshotLeaves(na), snapshotLeaves(nb)), nil
+}
+
+// DiffCurrent returns the leaves added, removed or changed
+// in the current content of the cache c compared to the snapshot.
+func DiffCurrent(c Cache, snapshot io.Reader) ([]LeafDiff, error) {
+	ns, err := readSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	current, err := c.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return diffLeaves(snapshotLeaves(ns), snapshotLeaves(current)), nil
+}
+
+// snapshotLeaves returns the latest value of each leaf,
+// deletes are ignored.
+func snapshotLeaves(notifications map[string][]*gnmi.Notification) map[diffKey]*diffValue {
+	leaves := make(map[diffKey]*diffValue)
+	for sub, ns := range notifications {
+		for _, n := range ns {
+			for _, upd := range n.GetUpdate() {
+				k := diffKey{
+					sub:    sub,
+					target: n.GetPrefix().GetTarget(),
+					path: gpath.GnmiPathToXPath(&gnmi.Path{
+						Origin: n.GetPrefix().GetOrigin(),
+						Elem:   gpath.PathElems(n.GetPrefix(), upd.GetPath()),
+					}, false),
+				}
+				if lv, ok := leaves[k]; ok && lv.ts > n.GetTimestamp() {
+					continue
+				}
+				leaves[k] = &diffValue{ts: n.GetTimestamp(), val: upd.GetVal()}
+			}
+		}
+	}
+	return leaves
+}
+
+func diffLeaves(a, b map[diffKey]*diffValue) []LeafDiff {
+	diffs := make([]LeafDiff, 0)
+	for k, av := range a {
+		bv, ok := b[k]
+		switch {
+		case !ok:
+			diffs = append(diffs, LeafDiff{Subscription: k.sub, Target: k.target, Path: k.path, Type: DiffRemoved, Old: av.val})
+		case !proto.Equal(av.val, bv.val):
+			diffs = append(diffs, LeafDiff{Subscription: k.sub, Target: k.target, Path: k.path, Type: DiffChanged, Old: av.val, New: bv.val})
+		}
+	}
+	for k, bv := range b {
+		if _, ok := a[k]; !ok {
+			diffs = append(diffs, LeafDiff{Subscription: k.sub, Target: k.target, Path: k.path, Type: DiffAdded, New: bv.val})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Subscription != diffs[j].Subscription {
+			return diffs[i].Subscription < diffs[j].Subscription
+		}
+		if diffs[i].Target != diffs[j].Target {
+			return diffs[i].Target < diffs[j].Target
+		}
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
@@ -0,0 +1,282 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func leafNotification(ts int64, target, elem string, v int64) *gnmi.Notification {
+	return &gnmi.Notification{
+		Timestamp: ts,
+		Prefix:    &gnmi.Path{Target: target, Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+		Update: []*gnmi.Update{
+			{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: elem}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: v}},
+			},
+		},
+	}
+}
+
+func testSnapshot(t *testing.T, notifications map[string][]*gnmi.Notification) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	err := writeSnapshot(buf, notifications)
+	if err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	return buf
+}
+
+func Test_snapshot_roundTrip(t *testing.T) {
+	ns := map[string][]*gnmi.Notification{
+		"sub1": {leafNotification(1, "t1", "mtu", 1500), leafNotification(2, "t2", "mtu", 9000)},
+		"sub2": {leafNotification(3, "t1", "speed", 10)},
+		"sub3": {},
+	}
+	rs, err := readSnapshot(testSnapshot(t, ns))
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	for sub, sns := range ns {
+		if len(rs[sub]) != len(sns) {
+			t.Fatalf("subscription %q: unexpected notifications count, got %d, expected %d", sub, len(rs[sub]), len(sns))
+		}
+		for i := range sns {
+			if !proto.Equal(rs[sub][i], sns[i]) {
+				t.Errorf("subscription %q: unexpected notification %d, got %v, expected %v", sub, i, rs[sub][i], sns[i])
+			}
+		}
+	}
+	// truncated snapshot
+	b := testSnapshot(t, ns).Bytes()
+	_, err = readSnapshot(bytes.NewReader(b[:len(b)-3]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error reading a truncated snapshot: %v", err)
+	}
+	// records larger than the limit are rejected.
+	maxSize := 0
+	for _, sns := range ns {
+		for _, n := range sns {
+			maxSize = max(maxSize, proto.Size(n))
+		}
+	}
+	if _, err = ReadNotifications(testSnapshot(t, ns), maxSize); err != nil {
+		t.Errorf("unexpected error reading records within the limit: %v", err)
+	}
+	if _, err = ReadNotifications(testSnapshot(t, ns), maxSize-1); err == nil {
+		t.Error("expected an error reading records beyond the limit")
+	}
+	// the length of a record is checked before it is allocated.
+	if _, err = ReadNotifications(bytes.NewReader(binary.AppendUvarint(nil, 1<<40)), 0); err == nil {
+		t.Error("expected an error reading a record beyond the snapshot limit")
+	}
+}
+
+func Test_Diff(t *testing.T) {
+	a := testSnapshot(t, map[string][]*gnmi.Notification{
+		"sub1": {
+			leafNotification(1, "t1", "mtu", 1500),
+			leafNotification(1, "t1", "speed", 10),
+			leafNotification(1, "t2", "mtu", 1500),
+		},
+	})
+	b := testSnapshot(t, map[string][]*gnmi.Notification{
+		"sub1": {
+			// older value ignored
+			leafNotification(3, "t1", "mtu", 1500),
+			leafNotification(2, "t1", "mtu", 9000),
+			leafNotification(2, "t1", "speed", 100),
+			leafNotification(2, "t3", "mtu", 1500),
+		},
+	})
+	diffs, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []struct {
+		target string
+		path   string
+		typ    DiffType
+	}{
+		{"t1", "interface[name=e1]/speed", DiffChanged},
+		{"t2", "interface[name=e1]/mtu", DiffRemoved},
+		{"t3", "interface[name=e1]/mtu", DiffAdded},
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("unexpected diffs, got %+v", diffs)
+	}
+	for i, e := range expected {
+		d := diffs[i]
+		if d.Subscription != "sub1" || d.Target != e.target || d.Path != e.path || d.Type != e.typ {
+			t.Errorf("diff %d: got %+v, expected %+v", i, d, e)
+		}
+	}
+	if diffs[0].Old.GetIntVal() != 10 || diffs[0].New.GetIntVal() != 100 {
+		t.Errorf("unexpected changed values: %v -> %v", diffs[0].Old, diffs[0].New)
+	}
+	if diffs[1].New != nil || diffs[2].Old != nil {
+		t.Errorf("unexpected values for removed/added leaves: %+v, %+v", diffs[1], diffs[2])
+	}
+}
+
+func Test_DiffCurrent(t *testing.T) {
+	now := time.Now().UnixNano()
+	gc := newGNMICache(&Config{})
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl2"))
+	snapshot := testSnapshot(t, map[string][]*gnmi.Notification{
+		"sub1": {hostnameResponse(now-1, "srl1").GetUpdate()},
+	})
+	diffs, err := DiffCurrent(gc, snapshot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Type != DiffChanged || diffs[0].Path != "system/name/host-name" ||
+		diffs[0].Old.GetAsciiVal() != "srl1" || diffs[0].New.GetAsciiVal() != "srl2" {
+		t.Errorf("unexpected diffs: %+v", diffs)
+	}
+}
+
+func Test_WriteSnapshot(t *testing.T) {
+	now := time.Now().UnixNano()
+	gc := newGNMICache(&Config{})
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	snapshot := new(bytes.Buffer)
+	err := WriteSnapshot(gc, snapshot)
+	if err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now+1, "srl2"))
+	diffs, err := DiffCurrent(gc, snapshot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Type != DiffChanged ||
+		diffs[0].Old.GetAsciiVal() != "srl1" || diffs[0].New.GetAsciiVal() != "srl2" {
+		t.Errorf("unexpected diffs: %+v", diffs)
+	}
+}
+
+func Test_gnmiCache_snapshotRestore(t *testing.T) {
+	now := time.Now()
+	gc := newGNMICache(&Config{})
+	for _, sub := range []string{"sub1", "sub2"} {
+		for _, target := range []string{"t1", "t2"} {
+			gc.Write(context.TODO(), sub, &gnmi.SubscribeResponse{
+				Response: &gnmi.SubscribeResponse_Update{Update: leafNotification(now.UnixNano(), target, "mtu", 1500)},
+			})
+		}
+	}
+	snapshot := new(bytes.Buffer)
+	err := gc.Snapshot(snapshot)
+	if err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	b := snapshot.Bytes()
+
+	restored := newGNMICache(&Config{})
+	err = restored.Restore(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+	diffs, err := DiffCurrent(restored, bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("unexpected diffs after restore: %+v", diffs)
+	}
+	targets := restored.ListTargets()
+	if len(targets["sub1"]) != 2 || len(targets["sub2"]) != 2 {
+		t.Errorf("unexpected restored targets: %v", targets)
+	}
+
+	// the expired notifications are not restored.
+	expired := testSnapshot(t, map[string][]*gnmi.Notification{
+		"sub1": {
+			leafNotification(now.Add(-time.Hour).UnixNano(), "t1", "mtu", 1500),
+			leafNotification(now.UnixNano(), "t2", "mtu", 1500),
+		},
+	})
+	restored = newGNMICache(&Config{Expiration: time.Minute})
+	err = restored.Restore(expired)
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+	if targets := restored.ListTargets(); !reflect.DeepEqual(targets, map[string][]string{"sub1": {"t2"}}) {
+		t.Errorf("unexpected restored targets: %v", targets)
+	}
+}
+
+func Test_gnmiCache_snapshotPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	now := time.Now().UnixNano()
+	gc := newGNMICache(&Config{SnapshotPath: path, SnapshotInterval: -1})
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("snapshot saved before the cache is stopped: %v", err)
+	}
+	gc.Stop()
+
+	restored := newGNMICache(&Config{SnapshotPath: path})
+	defer restored.Stop()
+	rsp, err := restored.Read("sub1", "t1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rsp["sub1"]) != 1 || rsp["sub1"][0].GetUpdate()[0].GetVal().GetAsciiVal() != "srl1" {
+		t.Errorf("unexpected restored notifications: %v", rsp)
+	}
+}
+
+func Test_gnmiCache_snapshotMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	now := time.Now().UnixNano()
+	gc := newGNMICache(&Config{SnapshotPath: path, SnapshotInterval: -1, SnapshotMaxSize: 512})
+	defer gc.Stop()
+	gc.Write(context.TODO(), "sub1", hostnameResponse(now, "srl1"))
+	if err := gc.saveFile(path); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	// the snapshot grows beyond the max size, the previous one is kept.
+	for i := 0; i < 20; i++ {
+		gc.Write(context.TODO(), fmt.Sprintf("sub%d", i+2), hostnameResponse(now, "srl1"))
+	}
+	if err = gc.saveFile(path); !errors.Is(err, errSnapshotTooLarge) {
+		t.Fatalf("unexpected error, got %v, expected %v", err, errSnapshotTooLarge)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	if !bytes.Equal(b, saved) {
+		t.Errorf("snapshot overwritten by a snapshot exceeding the max size")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("unexpected files left in the snapshot directory: %v", entries)
+	}
+}
@@ -9,6 +9,7 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"time"
 
@@ -16,10 +17,11 @@ import (
 )
 
 const (
-	minTargetWatchTimer            = 20 * time.Second
-	defaultTargetAssignmentTimeout = 10 * time.Second
-	defaultServicesWatchTimer      = 1 * time.Minute
-	defaultLeaderWaitTimer         = 5 * time.Second
+	minTargetWatchTimer             = 20 * time.Second
+	defaultTargetAssignmentTimeout  = 10 * time.Second
+	defaultServicesWatchTimer       = 1 * time.Minute
+	defaultLeaderWaitTimer          = 5 * time.Second
+	defaultCacheReplicationInterval = time.Second
 )
 
 type clustering struct {
@@ -32,6 +34,12 @@ type clustering struct {
 	LeaderWaitTimer         time.Duration          `mapstructure:"leader-wait-timer,omitempty" json:"leader-wait-timer,omitempty" yaml:"leader-wait-timer,omitempty"`
 	Tags                    []string               `mapstructure:"tags,omitempty" json:"tags,omitempty" yaml:"tags,omitempty"`
 	Locker                  map[string]interface{} `mapstructure:"locker,omitempty" json:"locker,omitempty" yaml:"locker,omitempty"`
+	// if true, the values written to the gNMI server cache
+	// are replicated to the other instances of the cluster.
+	CacheReplication         bool          `mapstructure:"cache-replication,omitempty" json:"cache-replication,omitempty" yaml:"cache-replication,omitempty"`
+	CacheReplicationInterval time.Duration `mapstructure:"cache-replication-interval,omitempty" json:"cache-replication-interval,omitempty" yaml:"cache-replication-interval,omitempty"`
+	// shared by the instances of the cluster to authenticate the replication requests.
+	CacheReplicationToken string `mapstructure:"cache-replication-token,omitempty" json:"cache-replication-token,omitempty" yaml:"cache-replication-token,omitempty"`
 }
 
 func (c *Config) GetClustering() error {
@@ -47,10 +55,16 @@ func (c *Config) GetClustering() error {
 	c.Clustering.ServicesWatchTimer = c.FileConfig.GetDuration("clustering/services-watch-timer")
 	c.Clustering.LeaderWaitTimer = c.FileConfig.GetDuration("clustering/leader-wait-timer")
 	c.Clustering.Tags = c.FileConfig.GetStringSlice("clustering/tags")
+	c.Clustering.CacheReplication = os.ExpandEnv(c.FileConfig.GetString("clustering/cache-replication")) == trueString
+	c.Clustering.CacheReplicationInterval = c.FileConfig.GetDuration("clustering/cache-replication-interval")
+	c.Clustering.CacheReplicationToken = os.ExpandEnv(c.FileConfig.GetString("clustering/cache-replication-token"))
 	for i := range c.Clustering.Tags {
 		c.Clustering.Tags[i] = os.ExpandEnv(c.Clustering.Tags[i])
 	}
 	c.setClusteringDefaults()
+	if c.Clustering.CacheReplication && c.Clustering.CacheReplicationToken == "" {
+		return errors.New("clustering/cache-replication requires clustering/cache-replication-token")
+	}
 	return c.getLocker()
 }
 
@@ -86,4 +100,7 @@ func (c *Config) setClusteringDefaults() {
 	if c.Clustering.LeaderWaitTimer <= defaultLeaderWaitTimer {
 		c.Clustering.LeaderWaitTimer = defaultLeaderWaitTimer
 	}
+	if c.Clustering.CacheReplicationInterval <= 0 {
+		c.Clustering.CacheReplicationInterval = defaultCacheReplicationInterval
+	}
 }
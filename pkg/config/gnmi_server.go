@@ -125,6 +125,7 @@ func (c *Config) GetGNMIServer() error {
 		//
 		c.GnmiServer.Cache.MaxBytes = c.FileConfig.GetInt64("gnmi-server/cache/max-bytes")
 		c.GnmiServer.Cache.MaxMsgsPerSubscription = c.FileConfig.GetInt64("gnmi-server/cache/max-msgs-per-subscription")
+		c.GnmiServer.Cache.Shards = c.FileConfig.GetInt("gnmi-server/cache/shards")
 		//
 		c.GnmiServer.Cache.FetchBatchSize = c.FileConfig.GetInt("gnmi-server/cache/fetch-batch-size")
 		c.GnmiServer.Cache.FetchWaitTime = c.FileConfig.GetDuration("gnmi-server/cache/fetch-wait-time")
@@ -65,6 +65,10 @@ func (i *influxDBOutput) readCache(ctx context.Context, name string) {
 	for subName, notifs := range notifications {
 		// build events without processors
 		for _, notif := range notifs {
+			// the deletes returned by the cache are not exported.
+			if len(notif.GetUpdate()) == 0 {
+				continue
+			}
 			ievents, err := formatters.ResponseToEventMsgs(subName,
 				&gnmi.SubscribeResponse{
 					Response: &gnmi.SubscribeResponse_Update{Update: notif},
@@ -0,0 +1,186 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package outputstest provides a conformance test suite
+// checking that an output implements the outputs.Output contract.
+package outputstest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+
+	"github.com/openconfig/gnmic/pkg/formatters"
+	"github.com/openconfig/gnmic/pkg/outputs"
+)
+
+// maximum duration of a Write, WriteEvent or Close call
+// once the output is closed or its context is canceled.
+const returnTimeout = time.Second
+
+// Config describes the output under test.
+type Config struct {
+	// New returns a new, uninitialized, output.
+	New outputs.Initializer
+	// Config returns a valid configuration of the output,
+	// it is called once per subtest.
+	Config func(t *testing.T) map[string]interface{}
+	// Options are passed to the output Init.
+	Options []outputs.Option
+}
+
+// number of outputs initialized by the suite, used to give each one
+// a unique name, the outputs metrics being global and labeled by output name.
+var initialized atomic.Int64
+
+// Run runs the conformance suite against the output described by cfg.
+// It checks that:
+//   - the setters can be called before Init,
+//   - Close can be called before Init, after a failed Init, and more than once,
+//   - Write and WriteEvent accept nil messages,
+//   - Write and WriteEvent return once the output is closed
+//     or once the context passed to Init or to the write is canceled.
+func Run(t *testing.T, cfg *Config) {
+	t.Run("Setters", func(t *testing.T) {
+		o := cfg.New()
+		o.SetLogger(log.New(io.Discard, "", 0))
+		o.SetName("test")
+		o.SetClusterName("test")
+		o.SetTargetsConfig(nil)
+		if err := o.SetEventProcessors(nil, log.New(io.Discard, "", 0), nil, nil); err != nil {
+			t.Errorf("SetEventProcessors failed without processors: %v", err)
+		}
+		_ = o.String()
+	})
+	t.Run("CloseBeforeInit", func(t *testing.T) {
+		o := cfg.New()
+		noPanic(t, "Close", func() { o.Close() })
+	})
+	t.Run("CloseAfterFailedInit", func(t *testing.T) {
+		o := cfg.New()
+		// most outputs require at least an address.
+		err := o.Init(context.Background(), name(t), map[string]interface{}{})
+		if err == nil {
+			o.Close()
+			t.Skip("the output accepted an empty config")
+		}
+		noPanic(t, "Close", func() { o.Close() })
+	})
+	t.Run("DoubleClose", func(t *testing.T) {
+		o := initOutput(context.Background(), t, cfg)
+		returns(t, "Close", func() { o.Close() })
+		returns(t, "second Close", func() { o.Close() })
+	})
+	t.Run("NilMessages", func(t *testing.T) {
+		o := initOutput(context.Background(), t, cfg)
+		defer o.Close()
+		returns(t, "Write(nil)", func() { o.Write(context.Background(), nil, outputs.Meta{}) })
+		returns(t, "WriteEvent(nil)", func() { o.WriteEvent(context.Background(), nil) })
+	})
+	t.Run("WriteAfterClose", func(t *testing.T) {
+		o := initOutput(context.Background(), t, cfg)
+		o.Close()
+		returns(t, "Write", func() { o.Write(context.Background(), testResponse(), testMeta()) })
+		returns(t, "WriteEvent", func() { o.WriteEvent(context.Background(), testEvent()) })
+	})
+	t.Run("InitContextCanceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		o := initOutput(ctx, t, cfg)
+		defer o.Close()
+		cancel()
+		returns(t, "Write", func() { o.Write(context.Background(), testResponse(), testMeta()) })
+		returns(t, "WriteEvent", func() { o.WriteEvent(context.Background(), testEvent()) })
+	})
+	t.Run("WriteContextCanceled", func(t *testing.T) {
+		o := initOutput(context.Background(), t, cfg)
+		defer o.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		returns(t, "Write", func() { o.Write(ctx, testResponse(), testMeta()) })
+		returns(t, "WriteEvent", func() { o.WriteEvent(ctx, testEvent()) })
+	})
+}
+
+func name(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), initialized.Add(1))
+}
+
+func initOutput(ctx context.Context, t *testing.T, cfg *Config) outputs.Output {
+	t.Helper()
+	o := cfg.New()
+	o.SetLogger(log.New(io.Discard, "", 0))
+	if err := o.Init(ctx, name(t), cfg.Config(t), cfg.Options...); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return o
+}
+
+// noPanic fails the test if f panics.
+func noPanic(t *testing.T, call string, f func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%s panicked: %v", call, r)
+		}
+	}()
+	f()
+}
+
+// returns fails the test if f panics or does not return within returnTimeout.
+func returns(t *testing.T, call string, f func()) {
+	t.Helper()
+	done := make(chan interface{})
+	go func() {
+		defer func() { done <- recover() }()
+		f()
+	}()
+	select {
+	case r := <-done:
+		if r != nil {
+			t.Errorf("%s panicked: %v", call, r)
+		}
+	case <-time.After(returnTimeout):
+		t.Errorf("%s did not return within %s", call, returnTimeout)
+	}
+}
+
+func testMeta() outputs.Meta {
+	return outputs.Meta{"source": "target1", "subscription-name": "sub1"}
+}
+
+func testResponse() *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Prefix:    &gnmi.Path{Target: "target1"},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: "srl1"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func testEvent() *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: time.Now().UnixNano(),
+		Tags:      map[string]string{"source": "target1"},
+		Values:    map[string]interface{}{"/system/name": "srl1"},
+	}
+}
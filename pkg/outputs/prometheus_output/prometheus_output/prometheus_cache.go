@@ -33,6 +33,10 @@ func (p *prometheusOutput) collectFromCache(ch chan<- prometheus.Metric) {
 	for subName, notifs := range notifications {
 		// build events without processors
 		for _, notif := range notifs {
+			// the deletes returned by the cache are not exported.
+			if len(notif.GetUpdate()) == 0 {
+				continue
+			}
 			targetName := notif.GetPrefix().GetTarget()
 			var meta outputs.Meta
 			if item := p.targetsMeta.Get(subName + "/" + targetName); item != nil {
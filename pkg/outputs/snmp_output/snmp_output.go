@@ -497,6 +497,10 @@ func (s *snmpOutput) buildPDUFromCache(bd *binding, targetName string, ev *forma
 	evs := make([]*formatters.EventMsg, 0)
 	for subName, notifs := range rsps {
 		for _, notif := range notifs {
+			// the deleted paths returned by the cache are ignored.
+			if len(notif.GetUpdate()) == 0 {
+				continue
+			}
 			revs, err := formatters.ResponseToEventMsgs(ev.Name, &gnmi.SubscribeResponse{
 				Response: &gnmi.SubscribeResponse_Update{
 					Update: notif,
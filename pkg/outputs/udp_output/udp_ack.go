@@ -0,0 +1,119 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// readAcks connects to the configured ack address and reads the cumulative
+// number of datagrams received by the collector.
+// Each ack is an 8 bytes big endian unsigned integer.
+// The difference between the number of sent datagrams and the last
+// acknowledged count is reported as the estimated loss.
+func (u *UDPSock) readAcks(ctx context.Context) {
+	var lastLoss, maxLoss uint64
+	dialer := &net.Dialer{}
+	// close the current connection once ctx is done
+	// to unblock the pending read.
+	var m sync.Mutex
+	var conn net.Conn
+	go func() {
+		<-ctx.Done()
+		m.Lock()
+		defer m.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		c, err := dialer.DialContext(ctx, "tcp", u.Cfg.AckAddress)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			u.logger.Printf("failed to dial ack address: %v", err)
+			if !sleepCtx(ctx, u.Cfg.RetryInterval) {
+				return
+			}
+			continue
+		}
+		m.Lock()
+		conn = c
+		m.Unlock()
+		if ctx.Err() != nil {
+			c.Close()
+			return
+		}
+		r := bufio.NewReader(c)
+		for {
+			var acked uint64
+			err = binary.Read(r, binary.BigEndian, &acked)
+			if err != nil {
+				break
+			}
+			u.acked.Store(acked)
+			loss := u.estimatedLoss()
+			if loss != lastLoss {
+				u.logger.Printf("estimated loss: sent=%d, acked=%d, lost=%d", u.ackSent(), acked, loss)
+				lastLoss = loss
+			}
+			// the estimate includes the datagrams in flight,
+			// only its new highs are counted as lost.
+			if loss > maxLoss {
+				if u.Cfg.EnableMetrics {
+					udpLostDatagrams.WithLabelValues(u.metricName).Add(float64(loss - maxLoss))
+				}
+				maxLoss = loss
+			}
+		}
+		c.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		u.logger.Printf("failed to read ack: %v", err)
+		if !sleepCtx(ctx, u.Cfg.RetryInterval) {
+			return
+		}
+	}
+}
+
+// sleepCtx waits for d, it returns false if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// ackSent returns the number of datagrams sent to the collector,
+// including the self-test ones, to be compared with the acknowledged count.
+func (u *UDPSock) ackSent() uint64 {
+	return u.sent.Load() + u.selfTestsSent.Load()
+}
+
+// estimatedLoss returns the number of sent datagrams
+// not yet acknowledged by the collector.
+// The estimate includes the datagrams in flight.
+func (u *UDPSock) estimatedLoss() uint64 {
+	sent, acked := u.ackSent(), u.acked.Load()
+	if acked >= sent {
+		return 0
+	}
+	return sent - acked
+}
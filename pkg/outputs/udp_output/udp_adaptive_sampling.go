@@ -0,0 +1,143 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSamplingCheckInterval = time.Second
+	defaultSamplingHighWatermark = 0.8
+	defaultSamplingLowWatermark  = 0.2
+	defaultSamplingMaxRatio      = 16
+)
+
+// AdaptiveSamplingConfig configures the decimation of the messages
+// while the output buffer stays filled above a watermark.
+type AdaptiveSamplingConfig struct {
+	// CheckInterval, interval at which the buffer fill level is checked.
+	CheckInterval time.Duration `mapstructure:"check-interval,omitempty"`
+	// HighWatermark, buffer fill ratio above which the sampling ratio is doubled.
+	HighWatermark float64 `mapstructure:"high-watermark,omitempty"`
+	// LowWatermark, buffer fill ratio below which the sampling ratio is halved.
+	LowWatermark float64 `mapstructure:"low-watermark,omitempty"`
+	// MaxRatio, maximum N of the 1-in-N sampling.
+	MaxRatio uint64 `mapstructure:"max-ratio,omitempty"`
+}
+
+func (c *AdaptiveSamplingConfig) setDefaults() error {
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = defaultSamplingCheckInterval
+	}
+	if c.HighWatermark == 0 {
+		c.HighWatermark = defaultSamplingHighWatermark
+	}
+	if c.LowWatermark == 0 {
+		c.LowWatermark = defaultSamplingLowWatermark
+	}
+	if c.MaxRatio == 0 {
+		c.MaxRatio = defaultSamplingMaxRatio
+	}
+	if c.LowWatermark < 0 || c.LowWatermark >= c.HighWatermark || c.HighWatermark > 1 {
+		return fmt.Errorf("invalid adaptive-sampling watermarks: must be 0 <= low-watermark < high-watermark <= 1")
+	}
+	return nil
+}
+
+// sampler keeps 1 in `ratio` messages of each target,
+// the ratio is adjusted to the buffer fill level.
+type sampler struct {
+	cfg   *AdaptiveSamplingConfig
+	ratio atomic.Uint64
+	m     sync.Mutex
+	// number of messages seen per target while sampling.
+	counts map[string]uint64
+}
+
+func newSampler(cfg *AdaptiveSamplingConfig) *sampler {
+	s := &sampler{
+		cfg:    cfg,
+		counts: make(map[string]uint64),
+	}
+	s.ratio.Store(1)
+	return s
+}
+
+// keep returns true if the message of target must be sent.
+// While sampling, the first of every `ratio` messages of each target is kept,
+// so that all the targets keep being reported at a reduced rate.
+func (s *sampler) keep(target string) bool {
+	ratio := s.ratio.Load()
+	if ratio <= 1 {
+		return true
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	c := s.counts[target]
+	s.counts[target] = c + 1
+	return c%ratio == 0
+}
+
+// adjust doubles the sampling ratio if the buffer fill ratio is above
+// the high watermark, halves it if it is below the low watermark.
+// It returns the previous and the new sampling ratios.
+func (s *sampler) adjust(fill float64) (uint64, uint64) {
+	old := s.ratio.Load()
+	ratio := old
+	switch {
+	case fill >= s.cfg.HighWatermark && ratio < s.cfg.MaxRatio:
+		ratio = min(ratio*2, s.cfg.MaxRatio)
+	case fill <= s.cfg.LowWatermark && ratio > 1:
+		ratio /= 2
+	}
+	if ratio == old {
+		return old, ratio
+	}
+	s.m.Lock()
+	// restart the per target sequences at the new ratio.
+	s.counts = make(map[string]uint64)
+	s.m.Unlock()
+	s.ratio.Store(ratio)
+	return old, ratio
+}
+
+// adaptSampling checks the buffer fill level every check interval
+// and adjusts the sampling ratio, logging its transitions.
+func (u *UDPSock) adaptSampling(ctx context.Context) {
+	ticker := time.NewTicker(u.sampler.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fill := float64(len(u.buffer)) / float64(cap(u.buffer))
+			old, ratio := u.sampler.adjust(fill)
+			if old == ratio {
+				continue
+			}
+			switch {
+			case ratio == 1:
+				u.logger.Printf("buffer fill %.0f%%: backpressure cleared, sending all messages", fill*100)
+			case old == 1:
+				u.logger.Printf("buffer fill %.0f%%: sustained backpressure, sending 1 in %d messages per target", fill*100, ratio)
+			default:
+				u.logger.Printf("buffer fill %.0f%%: sending 1 in %d messages per target", fill*100, ratio)
+			}
+			if u.Cfg.EnableMetrics {
+				udpSamplingRatio.WithLabelValues(u.metricName).Set(float64(ratio))
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	binaryEncodingBase64 = "base64"
+	binaryEncodingHex    = "hex"
+	binaryEncodingRaw    = "raw"
+)
+
+// encodeBinaryValues returns rsp with its bytes values replaced by
+// string values encoded as per the binary encoding enc.
+// rsp is cloned if it has bytes values, since it is shared with the other outputs.
+// With the base64 encoding, rsp is returned as is
+// and the bytes values are base64 encoded by the marshaler.
+func encodeBinaryValues(rsp *gnmi.SubscribeResponse, enc string) *gnmi.SubscribeResponse {
+	if enc == "" || enc == binaryEncodingBase64 || !hasBytesValues(rsp) {
+		return rsp
+	}
+	rsp = proto.Clone(rsp).(*gnmi.SubscribeResponse)
+	for _, upd := range rsp.GetUpdate().GetUpdate() {
+		b, ok := upd.GetVal().GetValue().(*gnmi.TypedValue_BytesVal)
+		if !ok {
+			continue
+		}
+		var s string
+		switch enc {
+		case binaryEncodingHex:
+			s = hex.EncodeToString(b.BytesVal)
+		case binaryEncodingRaw:
+			s = rawString(b.BytesVal)
+		}
+		upd.Val = &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: s}}
+	}
+	return rsp
+}
+
+func hasBytesValues(rsp *gnmi.SubscribeResponse) bool {
+	for _, upd := range rsp.GetUpdate().GetUpdate() {
+		if _, ok := upd.GetVal().GetValue().(*gnmi.TypedValue_BytesVal); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rawString returns the length of b in bytes, a colon, then the bytes of b,
+// each one as the character with the same code point (0 to 255).
+// Once marshaled as JSON, the bytes >= 0x80 take two bytes, the length prefix
+// allows the receiver to check it recovered all the bytes, see DecodeRawString.
+func rawString(b []byte) string {
+	sb := new(strings.Builder)
+	sb.Grow(len(b) + 8)
+	sb.WriteString(strconv.Itoa(len(b)))
+	sb.WriteByte(':')
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
+
+// DecodeRawString returns the bytes of a value encoded
+// with the `raw` binary encoding, once unmarshaled from JSON.
+func DecodeRawString(s string) ([]byte, error) {
+	ls, chars, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, errors.New("missing raw value length prefix")
+	}
+	l, err := strconv.Atoi(ls)
+	if err != nil || l < 0 {
+		return nil, fmt.Errorf("invalid raw value length prefix %q", ls)
+	}
+	b := make([]byte, 0, l)
+	for _, r := range chars {
+		if r > 0xff {
+			return nil, fmt.Errorf("invalid raw value character %q", r)
+		}
+		b = append(b, byte(r))
+	}
+	if len(b) != l {
+		return nil, fmt.Errorf("raw value length mismatch, got %d bytes, expected %d", len(b), l)
+	}
+	return b, nil
+}
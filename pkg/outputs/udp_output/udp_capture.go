@@ -0,0 +1,83 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	defaultCaptureMaxSize = 100 // MB
+	// timestamp (8 bytes) + datagram length (4 bytes)
+	captureRecordHeaderSize = 12
+)
+
+// CaptureRecord is a datagram written to the capture file.
+// In the file, each record is made of the send time in nanoseconds since
+// the Unix epoch (8 bytes), the datagram length (4 bytes), both big endian,
+// followed by the datagram.
+type CaptureRecord struct {
+	Timestamp time.Time
+	Datagram  []byte
+}
+
+// ReadCaptureRecord reads the next record from a capture file,
+// it returns io.EOF if there are no more records.
+func ReadCaptureRecord(r io.Reader) (*CaptureRecord, error) {
+	h := make([]byte, captureRecordHeaderSize)
+	_, err := io.ReadFull(r, h)
+	if err != nil {
+		return nil, err
+	}
+	rec := &CaptureRecord{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(h[:8]))),
+		Datagram:  make([]byte, binary.BigEndian.Uint32(h[8:])),
+	}
+	_, err = io.ReadFull(r, rec.Datagram)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func newCaptureWriter(cfg *Config) io.WriteCloser {
+	return newRotatingWriter(cfg.CaptureFile, cfg.CaptureMaxSize, cfg.CaptureMaxBackups)
+}
+
+// newRotatingWriter returns a writer to filename, rotated once maxSize megabytes
+// are written and keeping up to maxBackups rotated files.
+func newRotatingWriter(filename string, maxSize, maxBackups int) io.WriteCloser {
+	if maxSize <= 0 {
+		maxSize = defaultCaptureMaxSize
+	}
+	return &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+}
+
+// writeCaptureRecord writes the datagram b sent at ts to w.
+// The record is written with a single call so that it is not
+// split over two files when the capture file is rotated.
+func writeCaptureRecord(w io.Writer, ts time.Time, b []byte) error {
+	rec := make([]byte, captureRecordHeaderSize, captureRecordHeaderSize+len(b))
+	binary.BigEndian.PutUint64(rec[:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(rec[8:], uint32(len(b)))
+	_, err := w.Write(append(rec, b...))
+	return err
+}
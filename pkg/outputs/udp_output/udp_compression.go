@@ -0,0 +1,115 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compression codes prepended to each datagram payload
+// when compression is enabled.
+// The values are part of the wire format and must not change.
+const (
+	CompressionNone byte = 0x00
+	CompressionGzip byte = 0x01
+	CompressionZlib byte = 0x02
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+)
+
+// compressor compresses the datagrams payloads,
+// it is only used by the sending goroutine.
+type compressor struct {
+	code byte
+	buf  bytes.Buffer
+	w    interface {
+		io.WriteCloser
+		Reset(io.Writer)
+	}
+}
+
+func newCompressor(compression string, level int) (*compressor, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("invalid compression-level %d: must be in the range [%d..%d]",
+			level, flate.HuffmanOnly, flate.BestCompression)
+	}
+	c := new(compressor)
+	var err error
+	switch compression {
+	case compressionGzip:
+		c.code = CompressionGzip
+		c.w, err = gzip.NewWriterLevel(&c.buf, level)
+	case compressionZlib:
+		c.code = CompressionZlib
+		c.w, err = zlib.NewWriterLevel(&c.buf, level)
+	default:
+		return nil, fmt.Errorf("unknown compression %q: must be one of %q or %q",
+			compression, compressionGzip, compressionZlib)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compress returns the payload b prefixed with its compression code.
+// b is sent uncompressed if compressing it does not reduce its size.
+// The returned slice is only valid until the next call.
+func (c *compressor) compress(b []byte) []byte {
+	c.buf.Reset()
+	c.buf.WriteByte(c.code)
+	c.w.Reset(&c.buf)
+	_, err := c.w.Write(b)
+	if err == nil {
+		err = c.w.Close()
+	}
+	if err != nil || c.buf.Len() >= len(b)+1 {
+		c.buf.Reset()
+		c.buf.WriteByte(CompressionNone)
+		c.buf.Write(b)
+	}
+	return c.buf.Bytes()
+}
+
+// Decompress returns the payload of a datagram sent with compression enabled,
+// after its content type header if any.
+func Decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, errors.New("missing compression code")
+	}
+	var r io.ReadCloser
+	var err error
+	switch b[0] {
+	case CompressionNone:
+		return b[1:], nil
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(b[1:]))
+	case CompressionZlib:
+		r, err = zlib.NewReader(bytes.NewReader(b[1:]))
+	default:
+		return nil, fmt.Errorf("unknown compression code 0x%02x", b[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,62 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Content type codes prepended to each datagram
+// when content-type-header is enabled.
+// The values are part of the wire format and must not change.
+const (
+	ContentTypeJSON      byte = 0x01
+	ContentTypeProto     byte = 0x02
+	ContentTypeProtoJSON byte = 0x03
+	ContentTypeProtoText byte = 0x04
+	ContentTypeEvent     byte = 0x05
+	ContentTypeFlat      byte = 0x06
+)
+
+// formats supported by the output, an empty format means json.
+var formats = []string{"json", "proto", "protojson", "prototext", "event", "flat"}
+
+// checkFormat returns an error if format is not supported,
+// the marshaler would otherwise send it as JSON.
+func checkFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	for _, f := range formats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q: must be one of %s", format, strings.Join(formats, ", "))
+}
+
+// contentType returns the content type code of the output format,
+// the empty format is marshaled as JSON.
+func contentType(format string) byte {
+	switch format {
+	case "proto":
+		return ContentTypeProto
+	case "protojson":
+		return ContentTypeProtoJSON
+	case "prototext":
+		return ContentTypeProtoText
+	case "event":
+		return ContentTypeEvent
+	case "flat":
+		return ContentTypeFlat
+	default:
+		return ContentTypeJSON
+	}
+}
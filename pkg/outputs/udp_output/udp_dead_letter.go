@@ -0,0 +1,114 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DeadLetterConfig configures where the dropped payloads are sent,
+// either to a UDP address or to a file.
+type DeadLetterConfig struct {
+	Address string `mapstructure:"address,omitempty"`
+	File    string `mapstructure:"file,omitempty"`
+	// Sample, only 1 in Sample dropped payloads is sent, defaults to 1.
+	Sample uint64 `mapstructure:"sample,omitempty"`
+	// file rotation options, as for the capture file.
+	MaxSize    int `mapstructure:"max-size,omitempty"`
+	MaxBackups int `mapstructure:"max-backups,omitempty"`
+}
+
+// deadLetter sends the dropped payloads, each one prefixed with
+// the drop reason and a new line, as a datagram to the dead letter address,
+// or as a capture record to the dead letter file.
+type deadLetter struct {
+	m      sync.Mutex
+	sample uint64
+	count  uint64
+	conn   *net.UDPConn
+	file   io.WriteCloser
+	// set by Close, the later writes are discarded.
+	closed bool
+}
+
+func newDeadLetter(cfg *DeadLetterConfig) (*deadLetter, error) {
+	if (cfg.Address == "") == (cfg.File == "") {
+		return nil, errors.New("dead-letter requires exactly one of address or file")
+	}
+	d := &deadLetter{sample: cfg.Sample}
+	if d.sample == 0 {
+		d.sample = 1
+	}
+	if cfg.File != "" {
+		d.file = newRotatingWriter(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		return d, nil
+	}
+	raddr, err := net.ResolveUDPAddr("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("wrong dead-letter address: %v", err)
+	}
+	d.conn, err = net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// write sends the payload b dropped for the given reason,
+// unless it is not part of the sample.
+func (d *deadLetter) write(reason string, b []byte) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.count++
+	if (d.count-1)%d.sample != 0 {
+		return nil
+	}
+	rec := make([]byte, 0, len(reason)+1+len(b))
+	rec = append(rec, reason...)
+	rec = append(rec, '\n')
+	rec = append(rec, b...)
+	if d.file != nil {
+		return writeCaptureRecord(d.file, time.Now(), rec)
+	}
+	_, err := d.conn.Write(rec)
+	return err
+}
+
+func (d *deadLetter) Close() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.file != nil {
+		return d.file.Close()
+	}
+	return d.conn.Close()
+}
+
+// sendDeadLetter sends the dropped payloads bs to the dead letter, if configured.
+func (u *UDPSock) sendDeadLetter(reason string, bs ...[]byte) {
+	if u.deadLetter == nil {
+		return
+	}
+	for _, b := range bs {
+		if err := u.deadLetter.write(reason, b); err != nil {
+			u.logger.Printf("failed to send dropped payload to the dead letter: %v", err)
+		}
+	}
+}
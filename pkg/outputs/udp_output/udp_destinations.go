@@ -0,0 +1,154 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// checkAddresses checks the options that cannot be used
+// when the messages are sent to several addresses.
+func (u *UDPSock) checkAddresses() error {
+	if u.Cfg.Address != "" {
+		return fmt.Errorf("address and addresses cannot be used together")
+	}
+	if len(u.Cfg.Addresses) < 2 {
+		return nil
+	}
+	// a destination that is down must not block the writers.
+	if u.Cfg.BufferSize == 0 {
+		return fmt.Errorf("addresses requires buffer-size to be set")
+	}
+	if u.Cfg.BlockOnFull {
+		return fmt.Errorf("block-on-full cannot be used with addresses")
+	}
+	// the acknowledgements, the capture file and the adaptive sampling
+	// are tied to a single destination.
+	if u.Cfg.AckAddress != "" {
+		return fmt.Errorf("ack-address cannot be used with addresses")
+	}
+	if u.Cfg.CaptureFile != "" {
+		return fmt.Errorf("capture-file cannot be used with addresses")
+	}
+	if u.Cfg.AdaptiveSampling != nil {
+		return fmt.Errorf("adaptive-sampling cannot be used with addresses")
+	}
+	return nil
+}
+
+// newDestination returns the sender of the payloads to addr,
+// with its own socket, buffer, batches, compressor and retry backoff.
+// It shares the dead letter of u, closed by u.
+func (u *UDPSock) newDestination(ctx context.Context, addr string) *UDPSock {
+	cfg := *u.Cfg
+	cfg.Address = addr
+	cfg.Addresses = nil
+	d := &UDPSock{
+		Cfg:         &cfg,
+		name:        u.name,
+		metricName:  u.metricName,
+		logger:      log.New(u.logger.Writer(), u.logger.Prefix()+addr+" ", u.logger.Flags()),
+		buffer:      make(chan *payload, cfg.BufferSize),
+		flushReqs:   make(chan chan error),
+		delimiter:   u.delimiter,
+		contentType: u.contentType,
+		localAddr:   u.localAddr,
+		dtlsConfig:  u.dtlsConfig,
+		proxyURL:    u.proxyURL,
+		resolve:     u.resolve,
+		deadLetter:  u.deadLetter,
+		fanOutOf:    u,
+		onFailed:    u.destinationFailed,
+		limiter:     newLimiter(&cfg),
+	}
+	if u.compressor != nil {
+		// the compressor buffer is reused by each sending goroutine,
+		// the compression options were validated by Init.
+		d.compressor, _ = newCompressor(cfg.Compression, cfg.CompressionLevel)
+	}
+	ctx, d.cancelFn = context.WithCancel(ctx)
+	d.done = ctx.Done()
+	d.stopWaiting = d.done
+	d.drained = make(chan struct{})
+	return d
+}
+
+// startDestinations starts a sender per address, the dead letter
+// is closed once the output is closed.
+func (u *UDPSock) startDestinations(ctx context.Context) {
+	u.dests = make([]*UDPSock, 0, len(u.Cfg.Addresses))
+	for _, addr := range u.Cfg.Addresses {
+		u.dests = append(u.dests, u.newDestination(ctx, addr))
+	}
+	for _, d := range u.dests {
+		go d.start(ctx)
+	}
+	if u.deadLetter != nil {
+		go func() {
+			<-ctx.Done()
+			u.deadLetter.Close()
+		}()
+	}
+}
+
+// fanOut hands over the payloads to the sender of each destination.
+// The payloads are dropped for the destinations whose buffer is full,
+// so that a destination that is down does not hold back the others.
+func (u *UDPSock) fanOut(ps []*payload) {
+	for _, d := range u.dests {
+	PAYLOADS:
+		for i, p := range ps {
+			select {
+			case d.buffer <- p:
+			default:
+				d.drop(dropReasonBufferFull, ps[i:])
+				break PAYLOADS
+			}
+		}
+	}
+}
+
+// destinationFailed marks the output as failed once
+// all its destinations gave up retrying.
+func (u *UDPSock) destinationFailed(_ string, err error) {
+	for _, d := range u.dests {
+		if !d.Failed() {
+			return
+		}
+	}
+	u.fail(fmt.Errorf("all destinations failed: %w", err))
+}
+
+// destinationsStats sums the counters of the destinations
+// and adds the messages dropped before being handed over to them.
+func (u *UDPSock) destinationsStats() OutputStats {
+	st := OutputStats{Dropped: u.dropped.Load()}
+	for _, d := range u.dests {
+		ds := d.Stats()
+		st.Sent += ds.Sent
+		st.Dropped += ds.Dropped
+		st.Buffered += ds.Buffered
+		st.Reconnects += ds.Reconnects
+	}
+	return st
+}
+
+// flushDestinations flushes all the destinations, it returns their errors joined.
+func (u *UDPSock) flushDestinations(ctx context.Context) error {
+	var errs []error
+	for _, d := range u.dests {
+		if err := d.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.Cfg.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/dtls/v2"
+
+	"github.com/openconfig/gnmic/pkg/utils"
+)
+
+// time allowed for the DTLS handshake with the collector
+const dtlsHandshakeTimeout = 5 * time.Second
+
+// initTLS checks the options that cannot be used with tls
+// and builds the DTLS client configuration from the tls block.
+func (u *UDPSock) initTLS() error {
+	err := u.Cfg.TLS.Validate()
+	if err != nil {
+		return err
+	}
+	// a DTLS session is bound to a socket connected to a single peer.
+	if u.Cfg.SharedSocket {
+		return fmt.Errorf("tls cannot be used with shared-socket")
+	}
+	if u.Cfg.Proxy != "" {
+		return fmt.Errorf("tls cannot be used with proxy")
+	}
+	if u.Cfg.Broadcast || u.Cfg.MulticastInterface != "" {
+		return fmt.Errorf("tls cannot be used with broadcast and multicast-interface")
+	}
+	// the PROXY protocol header would be encrypted with the payload.
+	if u.Cfg.ProxyProtocol {
+		return fmt.Errorf("tls cannot be used with proxy-protocol")
+	}
+	tlsConfig, err := utils.NewTLSConfig(
+		u.Cfg.TLS.CaFile,
+		u.Cfg.TLS.CertFile,
+		u.Cfg.TLS.KeyFile,
+		"",
+		u.Cfg.TLS.SkipVerify,
+		false)
+	if err != nil {
+		return err
+	}
+	u.dtlsConfig = &dtls.Config{
+		ServerName:           u.Cfg.TLSServerName,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+	// without any file, the collector certificate
+	// is verified using the system roots.
+	if tlsConfig != nil {
+		u.dtlsConfig.Certificates = tlsConfig.Certificates
+		u.dtlsConfig.RootCAs = tlsConfig.RootCAs
+		u.dtlsConfig.InsecureSkipVerify = tlsConfig.InsecureSkipVerify
+	}
+	return nil
+}
+
+// handshake establishes a DTLS session over conn, verifying the collector
+// certificate against tls-server-name, or the host of the configured address if not set.
+// A failed handshake is returned as a dial error, retried with backoff.
+// The handshake is abandoned when the output stops waiting, i.e when it is
+// closed, unless it is the dial made to drain the buffer of a closed output.
+func (u *UDPSock) handshake(conn *net.UDPConn) (*dtls.Conn, error) {
+	cfg := *u.dtlsConfig
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(u.Cfg.Address)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dtlsHandshakeTimeout)
+	defer cancel()
+	stopWaiting := u.stopWaiting
+	select {
+	case <-stopWaiting:
+	default:
+		go func() {
+			select {
+			case <-stopWaiting:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	secure, err := dtls.ClientWithContext(ctx, conn, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("dtls handshake with %s failed: %w", conn.RemoteAddr(), err)
+	}
+	return secure, nil
+}
+
+// writer returns the connection the datagrams are written to,
+// the DTLS session if tls is set, the socket otherwise.
+func (u *UDPSock) writer() net.Conn {
+	if u.secure != nil {
+		return u.secure
+	}
+	return u.conn
+}
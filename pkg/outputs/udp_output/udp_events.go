@@ -0,0 +1,89 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/openconfig/gnmic/pkg/formatters"
+	"github.com/openconfig/gnmic/pkg/outputs"
+)
+
+// WriteEvent applies the event processors to ev and sends the resulting
+// events as JSON, like the responses written with Write in format event.
+// The events are dropped with the other formats, which apply to gNMI messages.
+func (u *UDPSock) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if ev == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+	if u.Cfg.Format != "event" {
+		u.eventFormatOnce.Do(func() {
+			u.logger.Printf("format %q does not support event messages, the event messages are dropped", u.Cfg.Format)
+		})
+		u.countDropped(dropReasonMarshalError, 1)
+		return
+	}
+	meta := outputs.Meta{"source": ev.Tags["source"], "subscription-name": ev.Name}
+	if u.sampler != nil && !u.sampler.keep(meta["source"]) {
+		u.countDropped(dropReasonSampled, 1)
+		return
+	}
+	bb, err := u.marshalEvents(ev)
+	if err != nil {
+		u.logger.Printf("failed marshaling event msg: %v", err)
+		u.countDropped(dropReasonMarshalError, 1)
+		return
+	}
+	if len(bb) == 0 {
+		u.countFiltered()
+		return
+	}
+	u.enqueue(ctx, u.payloads(bb, meta))
+}
+
+// marshalEvents applies the event processors to ev and marshals the resulting events,
+// as an array or, if split-events is set, individually.
+func (u *UDPSock) marshalEvents(ev *formatters.EventMsg) ([][]byte, error) {
+	evs := []*formatters.EventMsg{ev}
+	for _, proc := range u.evps {
+		evs = proc.Apply(evs...)
+	}
+	if len(evs) == 0 {
+		return nil, nil
+	}
+	if u.Cfg.OverrideTimestamps {
+		now := time.Now().UnixNano()
+		for _, e := range evs {
+			e.Timestamp = now
+		}
+	}
+	if !u.Cfg.SplitEvents {
+		b, err := json.Marshal(evs)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{b}, nil
+	}
+	bb := make([][]byte, 0, len(evs))
+	for _, e := range evs {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		bb = append(bb, b)
+	}
+	return bb, nil
+}
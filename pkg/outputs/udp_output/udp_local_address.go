@@ -0,0 +1,40 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"fmt"
+	"net"
+)
+
+// resolveLocalAddress resolves the local address s, an IP address
+// with an optional port, and checks that it can be bound.
+func resolveLocalAddress(s string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		// no port, any port is used.
+		s = net.JoinHostPort(s, "0")
+	}
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, fmt.Errorf("wrong local-address format: %v", err)
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("wrong local-address format: %q is not an IP address", host)
+	}
+	laddr, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		return nil, fmt.Errorf("wrong local-address format: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("local-address %q cannot be bound: %v", s, err)
+	}
+	conn.Close()
+	return laddr, nil
+}
@@ -0,0 +1,149 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/openconfig/gnmic/pkg/outputs"
+)
+
+// marshalJob is a message handed over by Write to the marshal workers.
+type marshalJob struct {
+	ctx  context.Context
+	m    proto.Message
+	meta outputs.Meta
+	// position of the message in its target stream,
+	// only set if preserve-target-order is set.
+	seq uint64
+}
+
+// submit hands over the message m to the marshal workers.
+// If preserve-target-order is set, the message is tagged with the next
+// sequence number of its target. The lock is released before handing over
+// the message, so the callers do not wait on each other when the workers are busy.
+// Like the buffer, the queue of the workers does not block the callers
+// when it is full, unless block-on-full is set.
+// If the message is dropped, its sequence number is marked as done
+// so that the next messages of the target are not held back.
+func (u *UDPSock) submit(ctx context.Context, m proto.Message, meta outputs.Meta) {
+	j := &marshalJob{ctx: ctx, m: m, meta: meta}
+	target := meta["source"]
+	if u.reorder != nil {
+		u.seqMu.Lock()
+		j.seq = u.seqs[target]
+		u.seqs[target]++
+		u.seqMu.Unlock()
+	}
+	var reason string
+	if u.dropOnFull() {
+		select {
+		case u.marshalJobs <- j:
+			return
+		default:
+			reason = dropReasonBufferFull
+		}
+	} else {
+		select {
+		case u.marshalJobs <- j:
+			return
+		case <-ctx.Done():
+			reason = dropReasonCanceled
+		case <-u.done:
+			reason = dropReasonClosed
+		}
+	}
+	u.countDropped(reason, 1)
+	if u.reorder != nil {
+		u.reorder.done(target, j.seq, func() {})
+	}
+}
+
+func (u *UDPSock) marshalWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-u.marshalJobs:
+			ps := u.process(j.m, j.meta)
+			if u.reorder == nil {
+				u.enqueue(j.ctx, ps)
+				continue
+			}
+			u.reorder.done(j.meta["source"], j.seq, func() {
+				u.enqueue(j.ctx, ps)
+			})
+		}
+	}
+}
+
+// reorderer releases the marshaled messages of a target
+// in the order of their sequence numbers.
+type reorderer struct {
+	m       sync.Mutex
+	targets map[string]*targetOrder
+}
+
+// targetOrder is the release state of a target,
+// its lock is held while its messages are released.
+type targetOrder struct {
+	m sync.Mutex
+	// next sequence number to release
+	next uint64
+	// functions releasing the messages processed out of order,
+	// per sequence number
+	pending map[uint64]func()
+}
+
+func newReorderer() *reorderer {
+	return &reorderer{
+		targets: make(map[string]*targetOrder),
+	}
+}
+
+// target returns the release state of target, created if needed.
+func (r *reorderer) target(target string) *targetOrder {
+	r.m.Lock()
+	defer r.m.Unlock()
+	to, ok := r.targets[target]
+	if !ok {
+		to = &targetOrder{pending: make(map[uint64]func())}
+		r.targets[target] = to
+	}
+	return to
+}
+
+// done records that the message seq of target is processed,
+// release is called once all the previous messages of the target are released.
+// The release functions of a target are called with the target locked,
+// one at a time, a release blocked on a full buffer does not hold back
+// the other targets.
+func (r *reorderer) done(target string, seq uint64, release func()) {
+	to := r.target(target)
+	to.m.Lock()
+	defer to.m.Unlock()
+	if seq != to.next {
+		to.pending[seq] = release
+		return
+	}
+	release()
+	to.next++
+	for {
+		f, ok := to.pending[to.next]
+		if !ok {
+			break
+		}
+		delete(to.pending, to.next)
+		f()
+		to.next++
+	}
+}
@@ -0,0 +1,188 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var udpNumberOfFilteredMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "number_of_filtered_msgs_total",
+	Help:      "Number of messages filtered out before being sent by gnmic udp output",
+}, []string{"name"})
+
+// reasons a message is dropped by the udp output
+const (
+	dropReasonFiltered     = "filtered"
+	dropReasonMarshalError = "marshal_error"
+	dropReasonCanceled     = "canceled"
+	dropReasonClosed       = "output_closed"
+	dropReasonSendError    = "send_error"
+	dropReasonSampled      = "sampled"
+	dropReasonBufferFull   = "buffer_full"
+	// the message does not fit in a datagram.
+	dropReasonOversize = "oversize"
+)
+
+var udpNumberOfDroppedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "dropped_total",
+	Help:      "Number of messages dropped by gnmic udp output, by reason",
+}, []string{"name", "reason"})
+
+var udpMarshalCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "marshal_cache_hits_total",
+	Help:      "Number of messages whose marshaled payload was found in the gnmic udp output marshal cache",
+}, []string{"name"})
+
+var udpMarshalCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "marshal_cache_misses_total",
+	Help:      "Number of messages not found in the gnmic udp output marshal cache",
+}, []string{"name"})
+
+var udpSelfTests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "self_tests_total",
+	Help:      "Number of self-test datagrams sent by gnmic udp output, by destination and result",
+}, []string{"name", "destination", "result"})
+
+var udpFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "failed",
+	Help:      "Set to 1 when gnmic udp output gave up retrying after max-retries consecutive failures",
+}, []string{"name", "destination"})
+
+var udpMsgSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "msg_size_bytes",
+	Help:      "Size of the messages marshaled by gnmic udp output, by format",
+	// 64B to 64KB
+	Buckets: prometheus.ExponentialBuckets(64, 2, 11),
+}, []string{"name", "format"})
+
+var udpLostDatagrams = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "estimated_lost_datagrams_total",
+	Help:      "Number of datagrams sent by gnmic udp output and not acknowledged by the collector",
+}, []string{"name"})
+
+var udpSamplingRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "sampling_ratio",
+	Help:      "N of the 1-in-N adaptive sampling applied by gnmic udp output, 1 when all messages are sent",
+}, []string{"name"})
+
+var udpSentDatagrams = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "sent_datagrams_total",
+	Help:      "Number of datagrams sent by gnmic udp output",
+}, []string{"name", "destination"})
+
+var udpSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "sent_bytes_total",
+	Help:      "Number of bytes sent by gnmic udp output, including the datagram headers",
+}, []string{"name", "destination"})
+
+var udpSendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "send_errors_total",
+	Help:      "Number of failed sends that made gnmic udp output reconnect",
+}, []string{"name", "destination"})
+
+var udpReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "reconnects_total",
+	Help:      "Number of times gnmic udp output socket was connected again after a failure",
+}, []string{"name", "destination"})
+
+var udpBufferedMsgs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "udp_output",
+	Name:      "buffered_msgs",
+	Help:      "Number of messages waiting to be sent by gnmic udp output",
+}, []string{"name", "destination"})
+
+func initMetrics() {
+	udpNumberOfFilteredMsgs.WithLabelValues("").Add(0)
+	udpNumberOfDroppedMsgs.WithLabelValues("", "").Add(0)
+	udpMarshalCacheHits.WithLabelValues("").Add(0)
+	udpMarshalCacheMisses.WithLabelValues("").Add(0)
+	udpSelfTests.WithLabelValues("", "", "").Add(0)
+	udpFailed.WithLabelValues("", "").Set(0)
+	udpLostDatagrams.WithLabelValues("").Add(0)
+	udpSamplingRatio.WithLabelValues("").Set(1)
+	udpSentDatagrams.WithLabelValues("", "").Add(0)
+	udpSentBytes.WithLabelValues("", "").Add(0)
+	udpSendErrors.WithLabelValues("", "").Add(0)
+	udpReconnects.WithLabelValues("", "").Add(0)
+	udpBufferedMsgs.WithLabelValues("", "").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(udpNumberOfFilteredMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(udpNumberOfDroppedMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(udpMarshalCacheHits); err != nil {
+		return err
+	}
+	if err = reg.Register(udpMarshalCacheMisses); err != nil {
+		return err
+	}
+	if err = reg.Register(udpSelfTests); err != nil {
+		return err
+	}
+	if err = reg.Register(udpFailed); err != nil {
+		return err
+	}
+	if err = reg.Register(udpMsgSize); err != nil {
+		return err
+	}
+	if err = reg.Register(udpLostDatagrams); err != nil {
+		return err
+	}
+	if err = reg.Register(udpSamplingRatio); err != nil {
+		return err
+	}
+	if err = reg.Register(udpSentDatagrams); err != nil {
+		return err
+	}
+	if err = reg.Register(udpSentBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(udpSendErrors); err != nil {
+		return err
+	}
+	if err = reg.Register(udpReconnects); err != nil {
+		return err
+	}
+	if err = reg.Register(udpBufferedMsgs); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const defaultMulticastTTL = 1
+
+// isBroadcast reports whether the datagrams to raddr must be sent
+// with the broadcast socket option set.
+func (u *UDPSock) isBroadcast(raddr *net.UDPAddr) bool {
+	return u.Cfg.Broadcast || raddr.IP.Equal(net.IPv4bcast)
+}
+
+// dialUDP returns a socket connected to raddr.
+// If raddr is a broadcast address, the broadcast option is set
+// before connecting, if it is a multicast group, the multicast
+// interface, TTL and loopback options are set.
+func (u *UDPSock) dialUDP(raddr *net.UDPAddr) (*net.UDPConn, error) {
+	if !u.isBroadcast(raddr) && !raddr.IP.IsMulticast() {
+		return net.DialUDP("udp", u.localAddr, raddr)
+	}
+	d := &net.Dialer{}
+	if u.localAddr != nil {
+		d.LocalAddr = u.localAddr
+	}
+	if u.isBroadcast(raddr) {
+		d.Control = func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = setBroadcast(fd)
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		}
+	}
+	c, err := d.DialContext(context.Background(), "udp", raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UDPConn)
+	if raddr.IP.IsMulticast() {
+		err = u.setMulticastOptions(conn, raddr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// setMulticastOptions sets the multicast interface, TTL (or hop limit)
+// and loopback options of conn, connected to the multicast group raddr.
+func (u *UDPSock) setMulticastOptions(conn *net.UDPConn, raddr *net.UDPAddr) error {
+	var ifi *net.Interface
+	if u.Cfg.MulticastInterface != "" {
+		var err error
+		ifi, err = net.InterfaceByName(u.Cfg.MulticastInterface)
+		if err != nil {
+			return fmt.Errorf("multicast-interface %q: %v", u.Cfg.MulticastInterface, err)
+		}
+	}
+	if raddr.IP.To4() != nil {
+		pc := ipv4.NewPacketConn(conn)
+		if ifi != nil {
+			if err := pc.SetMulticastInterface(ifi); err != nil {
+				return fmt.Errorf("failed to set the multicast interface: %v", err)
+			}
+		}
+		if err := pc.SetMulticastTTL(u.Cfg.MulticastTTL); err != nil {
+			return fmt.Errorf("failed to set the multicast ttl: %v", err)
+		}
+		return pc.SetMulticastLoopback(u.Cfg.MulticastLoopback)
+	}
+	pc := ipv6.NewPacketConn(conn)
+	if ifi != nil {
+		if err := pc.SetMulticastInterface(ifi); err != nil {
+			return fmt.Errorf("failed to set the multicast interface: %v", err)
+		}
+	}
+	if err := pc.SetMulticastHopLimit(u.Cfg.MulticastTTL); err != nil {
+		return fmt.Errorf("failed to set the multicast hop limit: %v", err)
+	}
+	return pc.SetMulticastLoopback(u.Cfg.MulticastLoopback)
+}
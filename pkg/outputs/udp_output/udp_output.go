@@ -9,15 +9,31 @@
 package udp_output
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"text/template"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/pion/dtls/v2"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,15 +46,25 @@ import (
 )
 
 const (
-	defaultRetryTimer = 2 * time.Second
-	loggingPrefix     = "[udp_output:%s] "
+	defaultRetryTimer      = 2 * time.Second
+	defaultMaxRetryTimer   = 30 * time.Second
+	defaultMaxDatagramSize = 65507
+	defaultDrainTimeout    = 2 * time.Second
+	defaultDelimiter       = "\n"
+	loggingPrefix          = "[udp_output:%s] "
+	// relative jitter applied to the retry backoff.
+	retryJitter = 0.2
+	// time Close waits for the sending goroutine beyond drain-timeout,
+	// covering a dial or a self-test in progress when the output is closed.
+	closeGrace = time.Second
 )
 
 func init() {
 	outputs.Register("udp", func() outputs.Output {
 		return &UDPSock{
-			Cfg:    &Config{},
-			logger: log.New(io.Discard, loggingPrefix, utils.DefaultLoggingFlags),
+			Cfg:     &Config{},
+			logger:  log.New(io.Discard, loggingPrefix, utils.DefaultLoggingFlags),
+			resolve: net.ResolveUDPAddr,
 		}
 	})
 }
@@ -46,29 +72,147 @@ func init() {
 type UDPSock struct {
 	Cfg *Config
 
+	// name label of the metrics, the output name
+	// prefixed with the gnmic instance name set with SetName.
+	metricName string
+
+	name     string
 	conn     *net.UDPConn
 	cancelFn context.CancelFunc
-	buffer   chan []byte
-	limiter  *time.Ticker
+	done     <-chan struct{}
+	buffer   chan *payload
+	limiter  *rate.Limiter
 	logger   *log.Logger
 	mo       *formatters.MarshalOptions
 	evps     []formatters.EventProcessor
 
 	targetTpl *template.Template
+	delimiter []byte
+	// marshaled payloads keyed by the hash of the message and its meta,
+	// nil if marshal-cache-size is not set.
+	marshalCache *lru.Cache[[sha256.Size]byte, [][]byte]
+	// destination address of the shared socket currently in use,
+	// empty if the output uses a dedicated socket.
+	sharedAddr string
+	// SOCKS5 proxy, nil if proxy is not set.
+	proxyURL *url.URL
+	// TCP control connection of the SOCKS5 UDP association
+	// and the header prepended to each datagram sent through the proxy relay.
+	socksCtrl   net.Conn
+	socksHeader []byte
+	// PROXY protocol v2 header prepended to each datagram if proxy-protocol is set.
+	proxyProtocolHeader []byte
+	// format code prepended to each datagram if content-type-header is set.
+	contentType byte
+	// compresses the datagrams, nil if compression is not set.
+	compressor *compressor
+	// source address of the datagrams, nil if local-address is not set.
+	localAddr *net.UDPAddr
+	// DTLS client configuration, nil if tls is not set,
+	// and the DTLS session over conn.
+	dtlsConfig *dtls.Config
+	secure     *dtls.Conn
+	// senders to each of the addresses, nil if addresses is not set.
+	dests []*UDPSock
+	// the output this one is a destination of, nil otherwise.
+	fanOutOf *UDPSock
+	// resolves the address of the collector, replaced in tests.
+	resolve func(network, address string) (*net.UDPAddr, error)
+	// capture file writer, nil if capture-file is not set.
+	capture io.WriteCloser
+	// dropped payloads sink, nil if dead-letter is not set.
+	deadLetter *deadLetter
+	// per target decimation under backpressure, nil if adaptive-sampling is not set.
+	sampler *sampler
+	// logs once that the configured format does not support the event messages.
+	eventFormatOnce sync.Once
+	// messages to marshal, nil if marshal-workers is not set.
+	marshalJobs chan *marshalJob
+	// per target sequence numbers and reordering of
+	// the marshaled messages, used if preserve-target-order is set.
+	seqMu   sync.Mutex
+	seqs    map[string]uint64
+	reorder *reorderer
+	// number of datagrams sent and acknowledged by the collector
+	sent  atomic.Uint64
+	acked atomic.Uint64
+	// number of self-test datagrams sent, also acknowledged by the collector.
+	selfTestsSent atomic.Uint64
+	// set when the socket is connected and the self-test, if any, succeeded.
+	ready atomic.Bool
+	// number of messages added to the batches and not sent yet.
+	batched atomic.Int64
+	// number of times the socket was connected again.
+	reconnects atomic.Uint64
+	// number of messages dropped, for any reason.
+	dropped atomic.Uint64
+	// closed once the sending goroutine returned, after draining the buffer.
+	drained chan struct{}
+	// closed when the sends waiting for the rate limiter must stop waiting:
+	// when the output is closed, or once drain-timeout elapsed while draining.
+	// Only set by the sending goroutine.
+	stopWaiting <-chan struct{}
+	// Flush requests, handled by the sending goroutine.
+	flushReqs chan chan error
+	// set when the output gave up retrying.
+	failed   atomic.Bool
+	onFailed func(name string, err error)
 }
 
 type Config struct {
-	Address            string        `mapstructure:"address,omitempty"` // ip:port
-	Rate               time.Duration `mapstructure:"rate,omitempty"`
-	BufferSize         uint          `mapstructure:"buffer-size,omitempty"`
-	Format             string        `mapstructure:"format,omitempty"`
-	AddTarget          string        `mapstructure:"add-target,omitempty"`
-	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
-	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
-	SplitEvents        bool          `mapstructure:"split-events,omitempty"`
-	RetryInterval      time.Duration `mapstructure:"retry-interval,omitempty"`
-	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty"`
-	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	Address             string                  `mapstructure:"address,omitempty"` // ip:port
+	Addresses           []string                `mapstructure:"addresses,omitempty"`
+	Rate                time.Duration           `mapstructure:"rate,omitempty"`
+	Burst               int                     `mapstructure:"burst,omitempty"`
+	BufferSize          uint                    `mapstructure:"buffer-size,omitempty"`
+	BlockOnFull         bool                    `mapstructure:"block-on-full,omitempty"`
+	Format              string                  `mapstructure:"format,omitempty"`
+	BinaryEncoding      string                  `mapstructure:"binary-encoding,omitempty"`
+	AddTarget           string                  `mapstructure:"add-target,omitempty"`
+	TargetTemplate      string                  `mapstructure:"target-template,omitempty"`
+	OverrideTimestamps  bool                    `mapstructure:"override-timestamps,omitempty"`
+	SplitEvents         bool                    `mapstructure:"split-events,omitempty"`
+	RetryInterval       time.Duration           `mapstructure:"retry-interval,omitempty"`
+	RedialInterval      time.Duration           `mapstructure:"redial-interval,omitempty"`
+	MaxRetryInterval    time.Duration           `mapstructure:"max-retry-interval,omitempty"`
+	StartupDelayMax     time.Duration           `mapstructure:"startup-delay-max,omitempty"`
+	MaxRetries          int                     `mapstructure:"max-retries,omitempty"`
+	TTL                 int                     `mapstructure:"ttl,omitempty"`
+	MulticastInterface  string                  `mapstructure:"multicast-interface,omitempty"`
+	MulticastTTL        int                     `mapstructure:"multicast-ttl,omitempty"`
+	MulticastLoopback   bool                    `mapstructure:"multicast-loopback,omitempty"`
+	Broadcast           bool                    `mapstructure:"broadcast,omitempty"`
+	AckAddress          string                  `mapstructure:"ack-address,omitempty"`
+	FlushInterval       time.Duration           `mapstructure:"flush-interval,omitempty"`
+	BatchTimeout        time.Duration           `mapstructure:"batch-timeout,omitempty"`
+	MaxBatchSize        int                     `mapstructure:"max-batch-size,omitempty"`
+	DrainTimeout        time.Duration           `mapstructure:"drain-timeout,omitempty"`
+	MaxDatagramSize     int                     `mapstructure:"max-datagram-size,omitempty"`
+	Delimiter           string                  `mapstructure:"delimiter,omitempty"`
+	SharedSocket        bool                    `mapstructure:"shared-socket,omitempty"`
+	MetaKeys            map[string]string       `mapstructure:"meta-keys,omitempty"`
+	PartitionByTarget   bool                    `mapstructure:"partition-by-target,omitempty"`
+	MarshalCacheSize    int                     `mapstructure:"marshal-cache-size,omitempty"`
+	Proxy               string                  `mapstructure:"proxy,omitempty"`
+	ProxyProtocol       bool                    `mapstructure:"proxy-protocol,omitempty"`
+	ContentTypeHeader   bool                    `mapstructure:"content-type-header,omitempty"`
+	Compression         string                  `mapstructure:"compression,omitempty"`
+	LocalAddress        string                  `mapstructure:"local-address,omitempty"`
+	TLS                 *types.TLSConfig        `mapstructure:"tls,omitempty"`
+	TLSServerName       string                  `mapstructure:"tls-server-name,omitempty"`
+	CompressionLevel    int                     `mapstructure:"compression-level,omitempty"`
+	CaptureFile         string                  `mapstructure:"capture-file,omitempty"`
+	CaptureMaxSize      int                     `mapstructure:"capture-max-size,omitempty"`
+	CaptureMaxBackups   int                     `mapstructure:"capture-max-backups,omitempty"`
+	CaptureOnly         bool                    `mapstructure:"capture-only,omitempty"`
+	DeadLetter          *DeadLetterConfig       `mapstructure:"dead-letter,omitempty"`
+	MarshalWorkers      int                     `mapstructure:"marshal-workers,omitempty"`
+	PreserveTargetOrder bool                    `mapstructure:"preserve-target-order,omitempty"`
+	AdaptiveSampling    *AdaptiveSamplingConfig `mapstructure:"adaptive-sampling,omitempty"`
+	SelfTest            bool                    `mapstructure:"self-test,omitempty"`
+	SelfTestPayload     string                  `mapstructure:"self-test-payload,omitempty"`
+	EnableMetrics       bool                    `mapstructure:"enable-metrics,omitempty"`
+	EventProcessors     []string                `mapstructure:"event-processors,omitempty"`
 }
 
 func (u *UDPSock) SetLogger(logger *log.Logger) {
@@ -101,44 +245,270 @@ func (u *UDPSock) Init(ctx context.Context, name string, cfg map[string]interfac
 	if err != nil {
 		return err
 	}
+	u.name = name
+	u.metricName = name
 	u.logger.SetPrefix(fmt.Sprintf(loggingPrefix, name))
+	err = u.resolveConfigRefs()
+	if err != nil {
+		return err
+	}
 
 	for _, opt := range opts {
 		if err := opt(u); err != nil {
 			return err
 		}
 	}
-	_, _, err = net.SplitHostPort(u.Cfg.Address)
-	if err != nil {
-		return fmt.Errorf("wrong address format: %v", err)
+	if len(u.Cfg.Addresses) > 0 {
+		if err = u.checkAddresses(); err != nil {
+			return err
+		}
+		for _, addr := range u.Cfg.Addresses {
+			if _, _, err = net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("wrong address format %q: %v", addr, err)
+			}
+		}
+		// a single address is handled as address.
+		if len(u.Cfg.Addresses) == 1 {
+			u.Cfg.Address = u.Cfg.Addresses[0]
+			u.Cfg.Addresses = nil
+		}
+	}
+	if len(u.Cfg.Addresses) == 0 {
+		_, _, err = net.SplitHostPort(u.Cfg.Address)
+		if err != nil {
+			return fmt.Errorf("wrong address format: %v", err)
+		}
+	}
+	if u.Cfg.AckAddress != "" {
+		_, _, err = net.SplitHostPort(u.Cfg.AckAddress)
+		if err != nil {
+			return fmt.Errorf("wrong ack-address format: %v", err)
+		}
+		// the acknowledged count must match the datagrams sent by this output only.
+		if u.Cfg.CaptureOnly {
+			return fmt.Errorf("ack-address cannot be used with capture-only")
+		}
+		if u.Cfg.SharedSocket {
+			return fmt.Errorf("ack-address cannot be used with shared-socket")
+		}
+	}
+	if u.Cfg.Proxy != "" {
+		u.proxyURL, err = url.Parse(u.Cfg.Proxy)
+		if err != nil {
+			return fmt.Errorf("wrong proxy format: %v", err)
+		}
+		if u.proxyURL.Scheme != "socks5" {
+			return fmt.Errorf("unsupported proxy scheme %q: must be socks5", u.proxyURL.Scheme)
+		}
+		if err = checkSocks5User(u.proxyURL.User); err != nil {
+			return err
+		}
+		if u.Cfg.SharedSocket {
+			return fmt.Errorf("shared-socket cannot be used with proxy")
+		}
+	}
+	if u.Cfg.RedialInterval > 0 {
+		// the shared socket is used by other outputs and
+		// the relay address of a SOCKS5 association does not change.
+		if u.Cfg.SharedSocket {
+			return fmt.Errorf("redial-interval cannot be used with shared-socket")
+		}
+		if u.Cfg.Proxy != "" {
+			return fmt.Errorf("redial-interval cannot be used with proxy")
+		}
+	}
+	if u.Cfg.TLS != nil {
+		if err = u.initTLS(); err != nil {
+			return err
+		}
+	} else if u.Cfg.TLSServerName != "" {
+		return fmt.Errorf("tls-server-name requires tls")
+	}
+	if u.Cfg.LocalAddress != "" {
+		if u.Cfg.SharedSocket {
+			return fmt.Errorf("local-address cannot be used with shared-socket")
+		}
+		if u.Cfg.Proxy != "" {
+			return fmt.Errorf("local-address cannot be used with proxy")
+		}
+		u.localAddr, err = resolveLocalAddress(u.Cfg.LocalAddress)
+		if err != nil {
+			return err
+		}
+	}
+	if u.Cfg.MulticastTTL == 0 {
+		u.Cfg.MulticastTTL = defaultMulticastTTL
+	}
+	if u.Cfg.MulticastTTL < 0 || u.Cfg.MulticastTTL > 255 {
+		return fmt.Errorf("invalid multicast-ttl %d: must be in the range [1..255]", u.Cfg.MulticastTTL)
+	}
+	if u.Cfg.Broadcast || u.Cfg.MulticastInterface != "" {
+		if u.Cfg.SharedSocket {
+			return fmt.Errorf("broadcast and multicast-interface cannot be used with shared-socket")
+		}
+		if u.Cfg.Proxy != "" {
+			return fmt.Errorf("broadcast and multicast-interface cannot be used with proxy")
+		}
+	}
+	if u.Cfg.StartupDelayMax < 0 {
+		return fmt.Errorf("invalid startup-delay-max %s: must be greater than or equal to 0", u.Cfg.StartupDelayMax)
+	}
+	if u.Cfg.MaxRetries < 0 {
+		return fmt.Errorf("invalid max-retries %d: must be greater than or equal to 0", u.Cfg.MaxRetries)
 	}
 	if u.Cfg.RetryInterval == 0 {
 		u.Cfg.RetryInterval = defaultRetryTimer
 	}
+	if u.Cfg.MaxRetryInterval == 0 {
+		u.Cfg.MaxRetryInterval = defaultMaxRetryTimer
+	}
+	if u.Cfg.MaxRetryInterval < u.Cfg.RetryInterval {
+		u.Cfg.MaxRetryInterval = u.Cfg.RetryInterval
+	}
+	if u.Cfg.DrainTimeout == 0 {
+		u.Cfg.DrainTimeout = defaultDrainTimeout
+	}
+	if u.Cfg.Rate < 0 {
+		return fmt.Errorf("invalid rate %s: must be greater than or equal to 0", u.Cfg.Rate)
+	}
+	if u.Cfg.Burst < 0 {
+		return fmt.Errorf("invalid burst %d: must be greater than or equal to 0", u.Cfg.Burst)
+	}
+	if u.Cfg.Burst == 0 {
+		u.Cfg.Burst = 1
+	}
+	if u.Cfg.TTL < 0 || u.Cfg.TTL > 255 {
+		return fmt.Errorf("invalid ttl %d: must be in the range [0..255]", u.Cfg.TTL)
+	}
+	if u.Cfg.MaxDatagramSize <= 0 {
+		u.Cfg.MaxDatagramSize = defaultMaxDatagramSize
+	}
+	if u.Cfg.BatchTimeout < 0 {
+		return fmt.Errorf("invalid batch-timeout %s: must be greater than or equal to 0", u.Cfg.BatchTimeout)
+	}
+	if u.Cfg.MaxBatchSize < 0 {
+		return fmt.Errorf("invalid max-batch-size %d: must be greater than or equal to 0", u.Cfg.MaxBatchSize)
+	}
+	if u.Cfg.SelfTestPayload == "" {
+		u.Cfg.SelfTestPayload = defaultSelfTestPayload
+	}
+	if u.Cfg.Delimiter == "" {
+		u.Cfg.Delimiter = defaultDelimiter
+	}
+	u.delimiter = []byte(u.Cfg.Delimiter)
+	if err = checkFormat(u.Cfg.Format); err != nil {
+		return err
+	}
+	u.contentType = contentType(u.Cfg.Format)
+	if u.Cfg.Compression != "" {
+		u.compressor, err = newCompressor(u.Cfg.Compression, u.Cfg.CompressionLevel)
+		if err != nil {
+			return err
+		}
+	}
+	if u.Cfg.MarshalCacheSize > 0 {
+		if u.Cfg.OverrideTimestamps {
+			return fmt.Errorf("marshal-cache-size cannot be used with override-timestamps")
+		}
+		u.marshalCache, err = lru.New[[sha256.Size]byte, [][]byte](u.Cfg.MarshalCacheSize)
+		if err != nil {
+			return err
+		}
+	}
+	switch u.Cfg.BinaryEncoding {
+	case "", binaryEncodingBase64:
+	case binaryEncodingHex, binaryEncodingRaw:
+		switch u.Cfg.Format {
+		case "proto", "prototext":
+			return fmt.Errorf("binary-encoding %q is not supported with format %q", u.Cfg.BinaryEncoding, u.Cfg.Format)
+		}
+	default:
+		return fmt.Errorf("unknown binary-encoding %q: must be one of %q, %q or %q",
+			u.Cfg.BinaryEncoding, binaryEncodingBase64, binaryEncodingHex, binaryEncodingRaw)
+	}
+	if len(u.Cfg.MetaKeys) > 0 {
+		switch u.Cfg.Format {
+		case "", "json", "protojson", "event":
+		default:
+			return fmt.Errorf("meta-keys is not supported with format %q", u.Cfg.Format)
+		}
+	}
 
-	u.buffer = make(chan []byte, u.Cfg.BufferSize)
-	if u.Cfg.Rate > 0 {
-		u.limiter = time.NewTicker(u.Cfg.Rate)
+	if u.Cfg.TargetTemplate == "" {
+		u.targetTpl = outputs.DefaultTargetTemplate
+	} else if u.Cfg.AddTarget != "" {
+		u.targetTpl, err = gtemplate.CreateTemplate("target-template", u.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+		u.targetTpl = u.targetTpl.Funcs(outputs.TemplateFuncs)
+	}
+
+	if u.Cfg.AdaptiveSampling != nil {
+		if u.Cfg.BufferSize == 0 {
+			return fmt.Errorf("adaptive-sampling requires buffer-size to be set")
+		}
+		if err = u.Cfg.AdaptiveSampling.setDefaults(); err != nil {
+			return err
+		}
+		u.sampler = newSampler(u.Cfg.AdaptiveSampling)
+	}
+	if u.Cfg.CaptureOnly && u.Cfg.CaptureFile == "" {
+		return fmt.Errorf("capture-only requires capture-file to be set")
+	}
+	if u.Cfg.CaptureFile != "" {
+		u.capture = newCaptureWriter(u.Cfg)
 	}
+	// the dead letter is created last as it holds a socket
+	// that would leak if a later step of Init failed.
+	if u.Cfg.DeadLetter != nil {
+		u.deadLetter, err = newDeadLetter(u.Cfg.DeadLetter)
+		if err != nil {
+			if u.capture != nil {
+				u.capture.Close()
+			}
+			return err
+		}
+	}
+
+	u.buffer = make(chan *payload, u.Cfg.BufferSize)
+	u.flushReqs = make(chan chan error)
+	if len(u.Cfg.Addresses) == 0 {
+		u.limiter = newLimiter(u.Cfg)
+	}
+	ctx, u.cancelFn = context.WithCancel(ctx)
+	u.done = ctx.Done()
+	u.stopWaiting = u.done
+	u.drained = make(chan struct{})
 	go func() {
 		<-ctx.Done()
 		u.Close()
 	}()
-	ctx, u.cancelFn = context.WithCancel(ctx)
 	u.mo = &formatters.MarshalOptions{
 		Format:     u.Cfg.Format,
 		OverrideTS: u.Cfg.OverrideTimestamps,
 	}
-	if u.Cfg.TargetTemplate == "" {
-		u.targetTpl = outputs.DefaultTargetTemplate
-	} else if u.Cfg.AddTarget != "" {
-		u.targetTpl, err = gtemplate.CreateTemplate("target-template", u.Cfg.TargetTemplate)
-		if err != nil {
-			return err
+	if u.Cfg.MarshalWorkers > 0 {
+		u.marshalJobs = make(chan *marshalJob, u.Cfg.BufferSize)
+		if u.Cfg.PreserveTargetOrder {
+			u.seqs = make(map[string]uint64)
+			u.reorder = newReorderer()
 		}
-		u.targetTpl = u.targetTpl.Funcs(outputs.TemplateFuncs)
+		for i := 0; i < u.Cfg.MarshalWorkers; i++ {
+			go u.marshalWorker(ctx)
+		}
+	}
+	if len(u.Cfg.Addresses) > 0 {
+		u.startDestinations(ctx)
+	} else {
+		go u.start(ctx)
+	}
+	if u.sampler != nil {
+		go u.adaptSampling(ctx)
+	}
+	if u.Cfg.AckAddress != "" {
+		go u.readAcks(ctx)
 	}
-	go u.start(ctx)
 	return nil
 }
 
@@ -151,32 +521,277 @@ func (u *UDPSock) Write(ctx context.Context, m proto.Message, meta outputs.Meta)
 	case <-ctx.Done():
 		return
 	default:
-		rsp, err := outputs.AddSubscriptionTarget(m, meta, u.Cfg.AddTarget, u.targetTpl)
-		if err != nil {
-			u.logger.Printf("failed to add target to the response: %v", err)
+		if u.sampler != nil && !u.sampler.keep(meta["source"]) {
+			u.countDropped(dropReasonSampled, 1)
+			return
 		}
-		bb, err := outputs.Marshal(rsp, meta, u.mo, u.Cfg.SplitEvents, u.evps...)
-		if err != nil {
-			u.logger.Printf("failed marshaling proto msg: %v", err)
+		if u.marshalJobs != nil {
+			u.submit(ctx, m, meta)
 			return
 		}
-		for _, b := range bb {
-			u.buffer <- b
+		u.enqueue(ctx, u.process(m, meta))
+	}
+}
+
+// process marshals the message m and adds the configured meta keys,
+// it returns the resulting payloads, counting the filtered and dropped messages.
+func (u *UDPSock) process(m proto.Message, meta outputs.Meta) []*payload {
+	rsp, err := outputs.AddSubscriptionTarget(m, meta, u.Cfg.AddTarget, u.targetTpl)
+	if err != nil {
+		u.logger.Printf("failed to add target to the response: %v", err)
+	}
+	rsp = encodeBinaryValues(rsp, u.Cfg.BinaryEncoding)
+	bb, err := u.marshal(rsp, meta)
+	if err != nil {
+		u.logger.Printf("failed marshaling proto msg: %v", err)
+		u.countDropped(dropReasonMarshalError, 1)
+		return nil
+	}
+	if len(bb) == 0 {
+		u.countFiltered()
+		return nil
+	}
+	return u.payloads(bb, meta)
+}
+
+// payloads adds the configured meta keys to the marshaled messages bb
+// and returns the resulting payloads.
+// The event messages already carry the meta fields as tags,
+// the meta keys are not added to them.
+func (u *UDPSock) payloads(bb [][]byte, meta outputs.Meta) []*payload {
+	var err error
+	ps := make([]*payload, 0, len(bb))
+	for _, b := range bb {
+		// an empty payload means the message was entirely
+		// filtered out by the event processors.
+		if len(b) == 0 {
+			u.countFiltered()
+			continue
+		}
+		if len(u.Cfg.MetaKeys) > 0 && u.Cfg.Format != "event" {
+			b, err = addMetaKeys(b, meta, u.Cfg.MetaKeys)
+			if err != nil {
+				u.logger.Printf("failed adding meta keys: %v", err)
+				u.countDropped(dropReasonMarshalError, 1)
+				continue
+			}
+		}
+		if u.Cfg.EnableMetrics {
+			udpMsgSize.WithLabelValues(u.metricName, u.formatLabel()).Observe(float64(len(b)))
+		}
+		p := &payload{target: meta["source"], b: b}
+		if max := u.maxPayloadSize(); len(b) > max {
+			u.logger.Printf("dropped message from %q: size %d exceeds the max datagram payload size %d",
+				meta["source"], len(b), max)
+			u.drop(dropReasonOversize, []*payload{p})
+			continue
 		}
+		ps = append(ps, p)
 	}
+	return ps
 }
 
-func (u *UDPSock) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+// maxPayloadSize returns the maximum size of a message sent in a datagram,
+// max-datagram-size minus the SOCKS5 UDP request header if proxy is set,
+// and the content type and compression headers if enabled.
+func (u *UDPSock) maxPayloadSize() int {
+	size := u.Cfg.MaxDatagramSize
+	if u.proxyURL != nil {
+		size -= socks5UDPHeaderLen(u.Cfg.Address)
+	}
+	if u.Cfg.ContentTypeHeader {
+		size--
+	}
+	if u.compressor != nil {
+		size--
+	}
+	return size
+}
 
+// enqueue hands over the payloads to the sending goroutine,
+// or to the sender of each destination if addresses is set.
+// The payloads written once the output is closed are dropped.
+func (u *UDPSock) enqueue(ctx context.Context, ps []*payload) {
+	select {
+	case <-u.done:
+		u.drop(dropReasonClosed, ps)
+		return
+	default:
+	}
+	if u.dests != nil {
+		u.fanOut(ps)
+		return
+	}
+	for i, p := range ps {
+		if u.dropOnFull() {
+			select {
+			case u.buffer <- p:
+				continue
+			default:
+				u.drop(dropReasonBufferFull, ps[i:])
+				return
+			}
+		}
+		// do not block on a full buffer if the output
+		// or the caller are done.
+		select {
+		case u.buffer <- p:
+		case <-ctx.Done():
+			u.drop(dropReasonCanceled, ps[i:])
+			return
+		case <-u.done:
+			u.drop(dropReasonClosed, ps[i:])
+			return
+		}
+	}
+}
+
+// dropOnFull reports whether the messages are dropped instead
+// of blocking the writer when the buffer is full.
+// Without buffer, the writers always wait for the messages to be handed over.
+func (u *UDPSock) dropOnFull() bool {
+	return u.Cfg.BufferSize > 0 && !u.Cfg.BlockOnFull
+}
+
+// drop counts the payloads ps as dropped and sends them to the dead letter.
+func (u *UDPSock) drop(reason string, ps []*payload) {
+	u.countDropped(reason, len(ps))
+	for _, p := range ps {
+		u.sendDeadLetter(reason, p.b)
+	}
+}
+
+// marshal marshals the message m, reusing the previous result
+// if the same message and meta were already marshaled and the marshal cache is enabled.
+func (u *UDPSock) marshal(m proto.Message, meta outputs.Meta) ([][]byte, error) {
+	if u.marshalCache == nil {
+		return outputs.Marshal(m, meta, u.mo, u.Cfg.SplitEvents, u.evps...)
+	}
+	key, err := marshalCacheKey(m, meta)
+	if err != nil {
+		return nil, err
+	}
+	if bb, ok := u.marshalCache.Get(key); ok {
+		if u.Cfg.EnableMetrics {
+			udpMarshalCacheHits.WithLabelValues(u.metricName).Inc()
+		}
+		return bb, nil
+	}
+	if u.Cfg.EnableMetrics {
+		udpMarshalCacheMisses.WithLabelValues(u.metricName).Inc()
+	}
+	bb, err := outputs.Marshal(m, meta, u.mo, u.Cfg.SplitEvents, u.evps...)
+	if err != nil {
+		return nil, err
+	}
+	u.marshalCache.Add(key, bb)
+	return bb, nil
+}
+
+// marshalCacheKey returns a hash of the deterministic
+// binary encoding of m and of the sorted meta fields.
+func marshalCacheKey(m proto.Message, meta outputs.Meta) ([sha256.Size]byte, error) {
+	var key [sha256.Size]byte
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return key, err
+	}
+	h := sha256.New()
+	h.Write(b)
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(meta[k]))
+	}
+	h.Sum(key[:0])
+	return key, nil
+}
+
+// WithOnFailed sets a function called with the output name and the last error
+// when the output gives up retrying after max-retries consecutive failures.
+// f is called by the sending goroutine, which Close waits for,
+// so the output must be closed asynchronously from f.
+func WithOnFailed(f func(name string, err error)) outputs.Option {
+	return func(o outputs.Output) error {
+		if u, ok := o.(*UDPSock); ok {
+			u.onFailed = f
+		}
+		return nil
+	}
+}
+
+// Failed returns true if the output gave up retrying after max-retries consecutive failures.
+func (u *UDPSock) Failed() bool {
+	return u.failed.Load()
+}
+
+// Close stops accepting messages and waits for the buffered ones
+// to be sent, for up to drain-timeout.
 func (u *UDPSock) Close() error {
-	u.cancelFn()
-	if u.limiter != nil {
-		u.limiter.Stop()
+	// Init did not complete.
+	if u.cancelFn == nil {
+		return nil
 	}
+	u.cancelFn()
+	u.waitDrained()
 	return nil
 }
 
-func (u *UDPSock) RegisterMetrics(reg *prometheus.Registry) {}
+// waitDrained waits for the sending goroutines to return.
+func (u *UDPSock) waitDrained() {
+	if u.dests != nil {
+		for _, d := range u.dests {
+			d.waitDrained()
+		}
+		return
+	}
+	if u.Cfg.DrainTimeout < 0 {
+		return
+	}
+	select {
+	case <-u.drained:
+	case <-time.After(u.Cfg.DrainTimeout + closeGrace):
+		u.logger.Printf("buffer not drained after %s", u.Cfg.DrainTimeout+closeGrace)
+	}
+}
+
+func (u *UDPSock) RegisterMetrics(reg *prometheus.Registry) {
+	if !u.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		u.logger.Printf("failed to register metrics: %v", err)
+	}
+}
+
+func (u *UDPSock) countFiltered() {
+	if u.Cfg.EnableMetrics {
+		udpNumberOfFilteredMsgs.WithLabelValues(u.metricName).Inc()
+	}
+	u.countDropped(dropReasonFiltered, 1)
+}
+
+// formatLabel returns the format the messages are marshaled to.
+func (u *UDPSock) formatLabel() string {
+	if u.Cfg.Format == "" {
+		return "json"
+	}
+	return u.Cfg.Format
+}
+
+// countDropped counts n messages that will not be sent for the given reason.
+func (u *UDPSock) countDropped(reason string, n int) {
+	u.dropped.Add(uint64(n))
+	if u.Cfg.EnableMetrics {
+		udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, reason).Add(float64(n))
+	}
+}
 
 func (u *UDPSock) String() string {
 	b, err := json.Marshal(u)
@@ -186,45 +801,676 @@ func (u *UDPSock) String() string {
 	return string(b)
 }
 
+// payload is a marshaled message along with the name of
+// the target it originates from.
+type payload struct {
+	target string
+	b      []byte
+}
+
+// batch is a datagram being built out of coalesced payloads.
+type batch struct {
+	b []byte
+	// number of payloads in b
+	count int
+}
+
+func (bt *batch) add(b, delimiter []byte) {
+	if bt.count > 0 {
+		bt.b = append(bt.b, delimiter...)
+	}
+	bt.b = append(bt.b, b...)
+	bt.count++
+}
+
+func (bt *batch) reset() {
+	bt.b = bt.b[:0]
+	bt.count = 0
+}
+
 func (u *UDPSock) start(ctx context.Context) {
 	var udpAddr *net.UDPAddr
 	var err error
-	defer u.Close()
+	defer close(u.drained)
+	// the output is closed if the sending goroutine gives up.
+	defer u.cancelFn()
+	defer u.closeConn()
+	if u.capture != nil {
+		defer u.capture.Close()
+	}
+	// the dead letter shared by the destinations is closed by their output.
+	if u.deadLetter != nil && u.fanOutOf == nil {
+		defer u.deadLetter.Close()
+	}
+	// when flush-interval or batch-timeout is set, the payloads are coalesced
+	// into a single datagram until the interval elapses, the oldest batched
+	// payload is batch-timeout old, or the max batch size is reached.
+	// The batches are only accessed from this goroutine,
+	// Write hands over the payloads through the buffer channel.
+	batching := u.Cfg.FlushInterval > 0 || u.Cfg.BatchTimeout > 0
+	var flushC <-chan time.Time
+	if u.Cfg.FlushInterval > 0 {
+		flushTicker := time.NewTicker(u.Cfg.FlushInterval)
+		defer flushTicker.Stop()
+		flushC = flushTicker.C
+	}
+	// the batch timer is started when a payload is batched
+	// while no other payload is, and stopped when the batches are sent.
+	var batchTimer *time.Timer
+	var batchC <-chan time.Time
+	if u.Cfg.BatchTimeout > 0 {
+		batchTimer = time.NewTimer(u.Cfg.BatchTimeout)
+		batchTimer.Stop()
+		defer batchTimer.Stop()
+	}
+	startBatchTimer := func() {
+		if batchTimer != nil && batchC == nil {
+			batchTimer.Reset(u.Cfg.BatchTimeout)
+			batchC = batchTimer.C
+		}
+	}
+	stopBatchTimer := func() {
+		if batchC == nil {
+			return
+		}
+		if !batchTimer.Stop() {
+			select {
+			case <-batchTimer.C:
+			default:
+			}
+		}
+		batchC = nil
+	}
+	// when redial-interval is set, the address is resolved again
+	// and the socket replaced periodically, the interval restarts
+	// each time the socket is connected after a failure.
+	var redialTicker *time.Ticker
+	var redialC <-chan time.Time
+	if u.Cfg.RedialInterval > 0 && !u.Cfg.CaptureOnly {
+		redialTicker = time.NewTicker(u.Cfg.RedialInterval)
+		defer redialTicker.Stop()
+		redialC = redialTicker.C
+	}
+	// batches keyed by target name if partition-by-target is set,
+	// otherwise a single batch with an empty key is used.
+	batches := make(map[string]*batch)
+	// the content type header is accounted for in the datagram size,
+	// the PROXY protocol header size depends on the address family
+	// and is accounted for when batching.
+	maxSize := u.maxPayloadSize()
+	// number of messages lost if sending fails
+	var lost int
+	// number of consecutive failed attempts
+	var retries int
+	// batchLimit returns the maximum size of a batch,
+	// max-batch-size if set, never more than a datagram can carry.
+	batchLimit := func() int {
+		limit := maxSize - len(u.proxyProtocolHeader)
+		if u.Cfg.MaxBatchSize > 0 && u.Cfg.MaxBatchSize < limit {
+			return u.Cfg.MaxBatchSize
+		}
+		return limit
+	}
+	// sendAlone sends the payload p in its own datagram.
+	sendAlone := func(p *payload) error {
+		lost = 1
+		err := u.send(p.b)
+		if err != nil {
+			u.sendDeadLetter(sendErrorReason(err), p.b)
+		}
+		return err
+	}
+	// handle sends the payload p, or adds it to its batch
+	// if flush-interval or batch-timeout is set.
+	// A payload larger than the batch limit is sent alone,
+	// after the payloads batched before it.
+	handle := func(p *payload) error {
+		if !batching {
+			return sendAlone(p)
+		}
+		var key string
+		if u.Cfg.PartitionByTarget {
+			key = p.target
+		}
+		bt, ok := batches[key]
+		if !ok {
+			bt = &batch{b: make([]byte, 0, u.Cfg.MaxDatagramSize)}
+			batches[key] = bt
+		}
+		var err error
+		limit := batchLimit()
+		if len(bt.b) > 0 && len(bt.b)+len(u.delimiter)+len(p.b) > limit {
+			err = u.send(bt.b)
+			if err != nil {
+				u.sendDeadLetter(sendErrorReason(err), bt.b)
+			}
+			lost = bt.count
+			u.batched.Add(-int64(bt.count))
+			bt.reset()
+		}
+		if err == nil && len(p.b) > limit {
+			return sendAlone(p)
+		}
+		bt.add(p.b, u.delimiter)
+		startBatchTimer()
+		u.batched.Add(1)
+		return err
+	}
+	// flushBatches sends the non empty batches.
+	flushBatches := func() error {
+		for _, bt := range batches {
+			if bt.count == 0 {
+				continue
+			}
+			err := u.send(bt.b)
+			lost = bt.count
+			u.batched.Add(-int64(bt.count))
+			if err != nil {
+				u.sendDeadLetter(sendErrorReason(err), bt.b)
+				bt.reset()
+				// the other batches are sent once the timer fires again.
+				if u.batched.Load() > 0 {
+					startBatchTimer()
+				}
+				return err
+			}
+			bt.reset()
+		}
+		stopBatchTimer()
+		return nil
+	}
+	// true once the socket was connected.
+	var connected bool
+	// spread the first dial of the outputs started together.
+	if delay := u.startupDelay(); delay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
 DIAL:
-	if ctx.Err() != nil {
+	// a first dial is attempted if the output is closed
+	// before being connected, to drain the buffered messages.
+	if ctx.Err() != nil && (connected || retries > 0 || len(u.buffer) == 0 || u.Cfg.DrainTimeout < 0) {
 		u.logger.Printf("context error: %v", ctx.Err())
 		return
 	}
-	udpAddr, err = net.ResolveUDPAddr("udp", u.Cfg.Address)
+	if u.Cfg.CaptureOnly {
+		u.ready.Store(true)
+		goto SEND
+	}
+	udpAddr, err = u.resolve("udp", u.Cfg.Address)
 	if err != nil {
 		u.logger.Printf("failed to dial udp: %v", err)
-		time.Sleep(u.Cfg.RetryInterval)
+		if !u.retry(ctx, &retries, err) {
+			return
+		}
 		goto DIAL
 	}
-	u.conn, err = net.DialUDP("udp", nil, udpAddr)
+	err = u.dial(udpAddr)
 	if err != nil {
 		u.logger.Printf("failed to dial udp: %v", err)
-		time.Sleep(u.Cfg.RetryInterval)
+		if !u.retry(ctx, &retries, err) {
+			return
+		}
 		goto DIAL
 	}
+	if u.Cfg.SelfTest {
+		err = u.selfTest()
+		u.countSelfTest(err)
+		if err != nil {
+			u.logger.Printf("self-test to %s failed: %v", udpAddr, err)
+			u.closeConn()
+			if !u.retry(ctx, &retries, err) {
+				return
+			}
+			goto DIAL
+		}
+		u.logger.Printf("self-test to %s succeeded", udpAddr)
+	}
+	if connected {
+		u.reconnects.Add(1)
+		if u.Cfg.EnableMetrics {
+			udpReconnects.WithLabelValues(u.metricName, u.Cfg.Address).Inc()
+		}
+	}
+	connected = true
+	u.ready.Store(true)
+	if redialTicker != nil {
+		redialTicker.Reset(u.Cfg.RedialInterval)
+	}
+SEND:
 	for {
+		err = nil
 		select {
 		case <-ctx.Done():
-			return
-		case b := <-u.buffer:
-			if u.limiter != nil {
-				<-u.limiter.C
+			// send the buffered messages, then the partially filled
+			// datagrams before closing, for up to drain-timeout.
+			if u.Cfg.DrainTimeout > 0 {
+				dctx, cancel := context.WithTimeout(context.Background(), u.Cfg.DrainTimeout)
+				defer cancel()
+				u.stopWaiting = dctx.Done()
+				for n := len(u.buffer); n > 0 && err == nil && dctx.Err() == nil; n-- {
+					err = handle(<-u.buffer)
+				}
+			}
+			if err == nil {
+				err = flushBatches()
 			}
-			_, err = u.conn.Write(b)
 			if err != nil {
+				u.countDropped(sendErrorReason(err), lost)
 				u.logger.Printf("failed sending udp bytes: %v", err)
-				time.Sleep(u.Cfg.RetryInterval)
-				goto DIAL
 			}
+			// the messages left in the buffer are lost.
+			for n := len(u.buffer); n > 0; n-- {
+				u.drop(dropReasonClosed, []*payload{<-u.buffer})
+			}
+			return
+		case p := <-u.buffer:
+			err = handle(p)
+		case <-flushC:
+			err = flushBatches()
+		case <-batchC:
+			batchC = nil
+			err = flushBatches()
+		case <-redialC:
+			// the current socket is kept if the redial fails,
+			// send errors, if any, trigger the retries.
+			if err := u.redial(); err != nil {
+				u.logger.Printf("failed to redial udp: %v", err)
+			}
+			continue
+		case errCh := <-u.flushReqs:
+			// only the payloads buffered when Flush was called are sent,
+			// the ones written in the meantime are handled afterwards.
+			for n := len(u.buffer); n > 0 && err == nil; n-- {
+				err = handle(<-u.buffer)
+			}
+			if err == nil {
+				err = flushBatches()
+			}
+			errCh <- err
+		}
+		if u.Cfg.EnableMetrics {
+			udpBufferedMsgs.WithLabelValues(u.metricName, u.Cfg.Address).Set(float64(len(u.buffer) + int(u.batched.Load())))
+		}
+		if err != nil && sendErrorReason(err) == dropReasonOversize {
+			// the socket is still usable, only the datagram is dropped.
+			u.countDropped(dropReasonOversize, lost)
+			u.logger.Printf("dropped %d message(s): %v", lost, err)
+			retries = 0
+			continue
+		}
+		if err != nil {
+			u.countDropped(dropReasonSendError, lost)
+			if u.Cfg.EnableMetrics {
+				udpSendErrors.WithLabelValues(u.metricName, u.Cfg.Address).Inc()
+			}
+			u.logger.Printf("failed sending udp bytes: %v", err)
+			u.closeConn()
+			if !u.retry(ctx, &retries, err) {
+				return
+			}
+			goto DIAL
 		}
+		retries = 0
+	}
+}
+
+// sendErrorReason returns the reason the messages that failed
+// to be sent with err are dropped.
+func sendErrorReason(err error) string {
+	if errors.Is(err, syscall.EMSGSIZE) {
+		return dropReasonOversize
+	}
+	return dropReasonSendError
+}
+
+// startupDelay returns a random delay in the range [0..startup-delay-max).
+func (u *UDPSock) startupDelay() time.Duration {
+	if u.Cfg.StartupDelayMax <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(u.Cfg.StartupDelayMax)))
+}
+
+// retry waits for the backoff of the number of consecutive failed attempts
+// before the next attempt, it returns false if ctx is done while waiting.
+// If max-retries is set and the number of consecutive failed attempts exceeds it,
+// the output is marked as failed and retry returns false without waiting.
+func (u *UDPSock) retry(ctx context.Context, retries *int, err error) bool {
+	*retries++
+	if u.Cfg.MaxRetries > 0 && *retries > u.Cfg.MaxRetries {
+		u.fail(fmt.Errorf("giving up after %d retries: %w", u.Cfg.MaxRetries, err))
+		return false
+	}
+	return sleepCtx(ctx, u.backoff(*retries))
+}
+
+// backoff returns the delay before the attempt following the retries-th
+// consecutive failure: retry-interval doubled for each previous failure,
+// up to max-retry-interval, with a jitter of ±20%.
+func (u *UDPSock) backoff(retries int) time.Duration {
+	d := u.Cfg.RetryInterval
+	for i := 1; i < retries && d < u.Cfg.MaxRetryInterval; i++ {
+		d *= 2
+	}
+	d = min(d, u.Cfg.MaxRetryInterval)
+	return time.Duration(float64(d) * (1 + retryJitter*(2*rand.Float64()-1)))
+}
+
+// fail marks the output as failed, the output is then closed by the caller.
+func (u *UDPSock) fail(err error) {
+	u.failed.Store(true)
+	u.logger.Printf("output failed: %v", err)
+	if u.Cfg.EnableMetrics && u.dests == nil {
+		udpFailed.WithLabelValues(u.metricName, u.Cfg.Address).Set(1)
+	}
+	if u.onFailed != nil {
+		u.onFailed(u.name, err)
+	}
+}
+
+// dial sets u.conn to a socket connected to raddr.
+// If shared-socket is enabled, the socket shared with the other outputs
+// sending to the same address is used, unless it was created with different options.
+// If proxy is set, the socket is connected to the relay of a SOCKS5 UDP association.
+// If proxy-protocol is set, the PROXY protocol header is built from the socket local address.
+// If tls is set, a DTLS session is established over the socket.
+func (u *UDPSock) dial(raddr *net.UDPAddr) error {
+	err := u.dialConn(raddr)
+	if err != nil {
+		return err
+	}
+	if u.Cfg.ProxyProtocol {
+		u.proxyProtocolHeader = proxyProtocolV2Header(u.conn.LocalAddr().(*net.UDPAddr), raddr)
+	}
+	return nil
+}
+
+func (u *UDPSock) dialConn(raddr *net.UDPAddr) error {
+	if u.proxyURL != nil {
+		return u.dialProxy(raddr)
+	}
+	if u.Cfg.SharedSocket {
+		conn, ok, err := acquireSharedConn(raddr, u.Cfg.TTL, u.logger)
+		if err != nil {
+			return err
+		}
+		if ok {
+			u.conn = conn
+			u.sharedAddr = raddr.String()
+			return nil
+		}
+		u.logger.Printf("shared socket to %s has different options, using a dedicated socket", raddr)
+	}
+	conn, err := u.dialDedicated(raddr)
+	if err != nil {
+		return err
+	}
+	if u.dtlsConfig != nil {
+		secure, err := u.handshake(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		u.secure = secure
+	}
+	u.conn = conn
+	return nil
+}
+
+// dialDedicated returns a socket connected to raddr, not shared with other outputs.
+func (u *UDPSock) dialDedicated(raddr *net.UDPAddr) (*net.UDPConn, error) {
+	conn, err := u.dialUDP(raddr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Cfg.TTL > 0 {
+		if err := setTTL(conn, raddr, u.Cfg.TTL); err != nil {
+			u.logger.Printf("failed to set ttl=%d: %v", u.Cfg.TTL, err)
+		}
+	}
+	return conn, nil
+}
+
+// dialProxy sets u.conn to a socket connected to the relay of a UDP association
+// with the SOCKS5 proxy. The association ends when the proxy closes the
+// control connection, the socket is then closed so that the next send fails
+// and a new association is requested.
+func (u *UDPSock) dialProxy(raddr *net.UDPAddr) error {
+	ctrl, conn, header, err := socks5Associate(u.proxyURL, raddr)
+	if err != nil {
+		return err
+	}
+	if u.Cfg.TTL > 0 {
+		if err := setTTL(conn, conn.RemoteAddr().(*net.UDPAddr), u.Cfg.TTL); err != nil {
+			u.logger.Printf("failed to set ttl=%d: %v", u.Cfg.TTL, err)
+		}
+	}
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		conn.Close()
+	}()
+	u.conn = conn
+	u.socksCtrl = ctrl
+	u.socksHeader = header
+	return nil
+}
+
+// closeConn closes the dedicated socket or releases the shared one.
+func (u *UDPSock) closeConn() {
+	u.ready.Store(false)
+	if u.conn == nil {
+		return
+	}
+	if u.socksCtrl != nil {
+		u.socksCtrl.Close()
+		u.socksCtrl = nil
+		u.socksHeader = nil
+	}
+	u.proxyProtocolHeader = nil
+	if u.secure != nil {
+		u.secure.Close()
+		u.secure = nil
+	}
+	if u.sharedAddr != "" {
+		releaseSharedConn(u.sharedAddr)
+		u.sharedAddr = ""
+	} else {
+		u.conn.Close()
+	}
+	u.conn = nil
+}
+
+// send writes b as a single datagram, waiting for the rate limiter if configured.
+// The datagram is written to the capture file, if any, without the SOCKS5 UDP
+// and PROXY protocol headers.
+func (u *UDPSock) send(b []byte) error {
+	u.waitLimiter()
+	if u.compressor != nil {
+		b = u.compressor.compress(b)
+	}
+	if u.Cfg.ContentTypeHeader {
+		b = append([]byte{u.contentType}, b...)
+	}
+	if u.capture != nil {
+		err := writeCaptureRecord(u.capture, time.Now(), b)
+		if err != nil {
+			u.logger.Printf("failed writing to capture file: %v", err)
+		}
+	}
+	if u.Cfg.CaptureOnly {
+		u.countSent(len(b))
+		return nil
+	}
+	n, err := u.writer().Write(u.addHeaders(b))
+	if err != nil {
+		return err
+	}
+	u.countSent(n)
+	return nil
+}
+
+// newLimiter returns the token bucket pacing the datagrams to one per rate,
+// with bursts of up to burst datagrams, or nil if rate is not set.
+func newLimiter(cfg *Config) *rate.Limiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Every(cfg.Rate), cfg.Burst)
+}
+
+// waitLimiter waits for a token of the rate limiter, if any.
+// It stops waiting, without consuming the token, once stopWaiting is closed.
+func (u *UDPSock) waitLimiter() {
+	if u.limiter == nil {
+		return
+	}
+	r := u.limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-u.stopWaiting:
+		r.Cancel()
+	}
+}
+
+// countSent counts a datagram of n bytes as sent.
+func (u *UDPSock) countSent(n int) {
+	u.sent.Add(1)
+	if u.Cfg.EnableMetrics {
+		udpSentDatagrams.WithLabelValues(u.metricName, u.Cfg.Address).Inc()
+		udpSentBytes.WithLabelValues(u.metricName, u.Cfg.Address).Add(float64(n))
+	}
+}
+
+// addHeaders prepends the SOCKS5 UDP header and the PROXY protocol header,
+// if any, to the datagram b.
+func (u *UDPSock) addHeaders(b []byte) []byte {
+	if len(u.socksHeader) == 0 && len(u.proxyProtocolHeader) == 0 {
+		return b
+	}
+	hb := make([]byte, 0, len(u.socksHeader)+len(u.proxyProtocolHeader)+len(b))
+	hb = append(hb, u.socksHeader...)
+	hb = append(hb, u.proxyProtocolHeader...)
+	return append(hb, b...)
+}
+
+// addMetaKeys adds the meta values listed in metaKeys as top level keys
+// of the JSON object b, or of each object if b is a JSON array.
+// metaKeys maps a meta field name to the JSON key it is written under.
+func addMetaKeys(b []byte, meta outputs.Meta, metaKeys map[string]string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	add := func(m map[string]interface{}) {
+		for mk, k := range metaKeys {
+			if mv, ok := meta[mk]; ok {
+				m[k] = mv
+			}
+		}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		add(v)
+	case []interface{}:
+		for _, e := range v {
+			if m, ok := e.(map[string]interface{}); ok {
+				add(m)
+			}
+		}
+	default:
+		return b, nil
+	}
+	return json.Marshal(v)
+}
+
+// resolveConfigRefs resolves the environment variables and
+// file references in the config fields that might carry secrets.
+func (u *UDPSock) resolveConfigRefs() error {
+	var err error
+	refs := map[string]*string{
+		"address":     &u.Cfg.Address,
+		"ack-address": &u.Cfg.AckAddress,
+		"proxy":       &u.Cfg.Proxy,
+	}
+	if u.Cfg.DeadLetter != nil {
+		refs["dead-letter.address"] = &u.Cfg.DeadLetter.Address
+	}
+	if u.Cfg.TLS != nil {
+		refs["tls.ca-file"] = &u.Cfg.TLS.CaFile
+		refs["tls.cert-file"] = &u.Cfg.TLS.CertFile
+		refs["tls.key-file"] = &u.Cfg.TLS.KeyFile
+	}
+	for i := range u.Cfg.Addresses {
+		refs[fmt.Sprintf("addresses[%d]", i)] = &u.Cfg.Addresses[i]
+	}
+	for name, f := range refs {
+		*f, err = resolveRef(*f)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveRef returns the content of the file referenced by s
+// if s is of the form `file:/path/to/file`,
+// otherwise it returns s with its ${VAR} environment variables expanded.
+// It returns an error if the file or any of the variables does not exist.
+func resolveRef(s string) (string, error) {
+	if fn, ok := strings.CutPrefix(s, "file:"); ok {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	var missing []string
+	s = os.Expand(s, func(v string) string {
+		val, ok := os.LookupEnv(v)
+		if !ok {
+			missing = append(missing, v)
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable(s) not set: %s", strings.Join(missing, ", "))
+	}
+	return s, nil
+}
+
+// setTTL sets the IP TTL (IPv4) or the unicast hop limit (IPv6)
+// on the connected socket, based on the destination address family.
+// Some platforms (e.g Windows) do not allow changing these options
+// on an already connected UDP socket, in which case the error is logged
+// and the OS default is kept.
+func setTTL(conn *net.UDPConn, raddr *net.UDPAddr, ttl int) error {
+	if raddr.IP.To4() != nil {
+		return ipv4.NewConn(conn).SetTTL(ttl)
+	}
+	return ipv6.NewConn(conn).SetHopLimit(ttl)
+}
+
+// SetName sets the gnmic instance name, it prefixes the output name
+// in the name label of the metrics.
+func (u *UDPSock) SetName(name string) {
+	u.metricName = u.name
+	if name != "" {
+		u.metricName = name + "-" + u.name
 	}
 }
 
-func (u *UDPSock) SetName(name string)                             {}
 func (u *UDPSock) SetClusterName(name string)                      {}
 func (u *UDPSock) SetTargetsConfig(map[string]*types.TargetConfig) {}
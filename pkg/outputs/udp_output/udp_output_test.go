@@ -0,0 +1,2352 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pion/dtls/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/openconfig/gnmic/pkg/formatters"
+	"github.com/openconfig/gnmic/pkg/outputs"
+	"github.com/openconfig/gnmic/pkg/outputs/outputstest"
+)
+
+func newTestListener(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// number of test outputs created, used to give each one a unique name,
+// the metrics being global and labeled by output name.
+var testOutputs atomic.Int64
+
+func newTestOutput(ctx context.Context, t *testing.T, cfg map[string]interface{}, opts ...outputs.Option) *UDPSock {
+	t.Helper()
+	u := outputs.Outputs["udp"]().(*UDPSock)
+	err := u.Init(ctx, fmt.Sprintf("%s-%d", t.Name(), testOutputs.Add(1)), cfg, opts...)
+	if err != nil {
+		t.Fatalf("failed to init udp output: %v", err)
+	}
+	return u
+}
+
+func testSubscribeResponse(target string, val int64) *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Prefix:    &gnmi.Path{Target: target},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{
+							{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+							{Name: "mtu"},
+						}},
+						Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: val}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// readDatagram reads a single datagram from conn,
+// it returns a nil slice if nothing is received within the timeout.
+func readDatagram(t *testing.T, conn *net.UDPConn, timeout time.Duration) []byte {
+	t.Helper()
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		t.Fatalf("failed to read from listener: %v", err)
+	}
+	return buf[:n]
+}
+
+func TestUDPSock_Write_filtered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t,
+		map[string]interface{}{
+			"address":          l.LocalAddr().String(),
+			"format":           "event",
+			"event-processors": []string{"drop-all"},
+			"enable-metrics":   true,
+		},
+		outputs.WithEventProcessors(
+			map[string]map[string]interface{}{
+				"drop-all": {
+					"event-drop": map[string]interface{}{
+						"condition": "true",
+					},
+				},
+			},
+			log.New(io.Discard, "", 0),
+			nil,
+			nil,
+		),
+	)
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l, 500*time.Millisecond); b != nil {
+		t.Fatalf("unexpected datagram received: %q", b)
+	}
+	if v := testutil.ToFloat64(udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, dropReasonFiltered)); v != 1 {
+		t.Errorf("unexpected dropped messages count, got %v, expected 1", v)
+	}
+}
+
+func TestUDPSock_Write_flushInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"format":         "json",
+		"buffer-size":    10,
+		"flush-interval": "200ms",
+	})
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	if n := bytes.Count(b, []byte("\n")) + 1; n != 3 {
+		t.Errorf("unexpected number of coalesced messages, got %d, expected 3", n)
+	}
+}
+
+func TestUDPSock_Write_flushOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"format":         "json",
+		"rate":           "1ms",
+		"flush-interval": time.Hour,
+	})
+	// without buffer, Write returns once the message is batched.
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	if b := readDatagram(t, l, 100*time.Millisecond); b != nil {
+		t.Fatalf("unexpected datagram before close: %s", b)
+	}
+	cancel()
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("partially filled datagram not sent on close")
+	}
+	if n := bytes.Count(b, []byte("\n")) + 1; n != 3 {
+		t.Errorf("unexpected number of coalesced messages, got %d, expected 3", n)
+	}
+}
+
+func TestUDPSock_Write_dropOnFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":     l.LocalAddr().String(),
+		"format":      "json",
+		"buffer-size": 1,
+		// stalls the sending loop after the first message.
+		"rate":           time.Hour,
+		"enable-metrics": true,
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on a full buffer")
+	}
+	if v := testutil.ToFloat64(udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, dropReasonBufferFull)); v == 0 {
+		t.Error("no message counted as dropped on a full buffer")
+	}
+}
+
+func TestUDPSock_Write_blockOnFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":       l.LocalAddr().String(),
+		"format":        "json",
+		"buffer-size":   1,
+		"block-on-full": true,
+		"rate":          "10ms",
+	})
+	const numMsgs = 10
+	go func() {
+		for i := 0; i < numMsgs; i++ {
+			u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+		}
+	}()
+	for i := 0; i < numMsgs; i++ {
+		if b := readDatagram(t, l, time.Second); b == nil {
+			t.Fatalf("received %d datagrams, expected %d", i, numMsgs)
+		}
+	}
+	if d := u.Stats().Dropped; d != 0 {
+		t.Errorf("unexpected dropped messages count, got %d, expected 0", d)
+	}
+}
+
+func TestUDPSock_Write_rateBurst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":       l.LocalAddr().String(),
+		"format":        "json",
+		"buffer-size":   20,
+		"block-on-full": true,
+		"rate":          "20ms",
+		"burst":         5,
+	})
+	const numMsgs = 15
+	start := time.Now()
+	for i := 0; i < numMsgs; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	for i := 0; i < numMsgs; i++ {
+		if b := readDatagram(t, l, time.Second); b == nil {
+			t.Fatalf("received %d datagrams, expected %d", i, numMsgs)
+		}
+		// the burst is sent right away.
+		if i == 4 {
+			if d := time.Since(start); d > 50*time.Millisecond {
+				t.Errorf("burst received after %s, expected it right away", d)
+			}
+		}
+	}
+	// the datagrams beyond the burst are paced at the rate.
+	if d := time.Since(start); d < 180*time.Millisecond || d > 400*time.Millisecond {
+		t.Errorf("%d datagrams received after %s, expected about 200ms", numMsgs, d)
+	}
+}
+
+func TestUDPSock_Write_batchTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		batchTimeout string
+		// expected number of messages per datagram
+		expected []int
+	}{
+		{name: "disabled", batchTimeout: "0s", expected: []int{1, 1, 1, 1, 1}},
+		{name: "enabled", batchTimeout: "100ms", expected: []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := newTestListener(t)
+			u := newTestOutput(ctx, t, map[string]interface{}{
+				"address":       l.LocalAddr().String(),
+				"format":        "json",
+				"buffer-size":   10,
+				"batch-timeout": tt.batchTimeout,
+			})
+			for i := 0; i < 5; i++ {
+				u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+			}
+			got := make([]int, 0)
+			for {
+				b := readDatagram(t, l, 500*time.Millisecond)
+				if b == nil {
+					break
+				}
+				got = append(got, bytes.Count(b, []byte("\n"))+1)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected messages per datagram, got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUDPSock_Write_maxBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	// the size of a single message.
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address": l.LocalAddr().String(),
+		"format":  "json",
+	})
+	u.Write(ctx, testSubscribeResponse("t1", 0), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	size := len(b)
+
+	tests := []struct {
+		name         string
+		maxBatchSize int
+		// expected number of messages per datagram
+		expected []int
+	}{
+		// the 5th message is sent when the output is closed.
+		{name: "two messages", maxBatchSize: 2*size + 1, expected: []int{2, 2, 1}},
+		{name: "larger than the limit", maxBatchSize: size - 1, expected: []int{1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := newTestListener(t)
+			u := newTestOutput(ctx, t, map[string]interface{}{
+				"address":        l.LocalAddr().String(),
+				"format":         "json",
+				"batch-timeout":  time.Hour,
+				"max-batch-size": tt.maxBatchSize,
+			})
+			// without buffer, Write returns once the message is batched or sent.
+			for i := 0; i < 5; i++ {
+				u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+			}
+			cancel()
+			got := make([]int, 0)
+			for {
+				b := readDatagram(t, l, 500*time.Millisecond)
+				if b == nil {
+					break
+				}
+				n := bytes.Count(b, []byte("\n")) + 1
+				if n > 1 && len(b) > tt.maxBatchSize {
+					t.Errorf("batch of %d bytes larger than max-batch-size %d", len(b), tt.maxBatchSize)
+				}
+				got = append(got, n)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected messages per datagram, got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUDPSock_Init_batch(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:0", "batch-timeout": "-1s"},
+		{"address": "127.0.0.1:0", "max-batch-size": -1},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), t.Name(), cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_Write_concurrentBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	l.SetReadBuffer(4 << 20)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":             l.LocalAddr().String(),
+		"format":              "json",
+		"buffer-size":         100,
+		"block-on-full":       true,
+		"flush-interval":      "50ms",
+		"max-datagram-size":   1400,
+		"partition-by-target": true,
+	})
+	numTargets := 5
+	numWriters := 50
+	numMsgs := 20
+	wg := new(sync.WaitGroup)
+	wg.Add(numWriters)
+	for i := 0; i < numWriters; i++ {
+		go func(i int) {
+			defer wg.Done()
+			target := fmt.Sprintf("t%d", i%numTargets)
+			for j := 0; j < numMsgs; j++ {
+				u.Write(ctx, testSubscribeResponse(target, int64(j)), outputs.Meta{"source": target})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	received := 0
+	for received < numWriters*numMsgs {
+		b := readDatagram(t, l, time.Second)
+		if b == nil {
+			break
+		}
+		var target string
+		for _, m := range bytes.Split(b, []byte("\n")) {
+			msg := make(map[string]interface{})
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatalf("corrupted message %q: %v", m, err)
+			}
+			if target == "" {
+				target, _ = msg["source"].(string)
+			}
+			if msg["source"] != target {
+				t.Fatalf("datagram mixes targets %q and %v", target, msg["source"])
+			}
+			received++
+		}
+	}
+	if received != numWriters*numMsgs {
+		t.Errorf("unexpected number of messages, got %d, expected %d", received, numWriters*numMsgs)
+	}
+}
+
+func TestUDPSock_Write_marshalCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":            l.LocalAddr().String(),
+		"format":             "json",
+		"marshal-cache-size": 10,
+		"enable-metrics":     true,
+	})
+	rsp := testSubscribeResponse("t1", 1500)
+	for i := 0; i < 2; i++ {
+		u.Write(ctx, rsp, outputs.Meta{"source": "t1"})
+	}
+	b1 := readDatagram(t, l, time.Second)
+	b2 := readDatagram(t, l, time.Second)
+	if b1 == nil || !bytes.Equal(b1, b2) {
+		t.Fatalf("unexpected datagrams: %q, %q", b1, b2)
+	}
+	if v := testutil.ToFloat64(udpMarshalCacheHits.WithLabelValues(u.metricName)); v != 1 {
+		t.Errorf("unexpected cache hits, got %v, expected 1", v)
+	}
+	if v := testutil.ToFloat64(udpMarshalCacheMisses.WithLabelValues(u.metricName)); v != 1 {
+		t.Errorf("unexpected cache misses, got %v, expected 1", v)
+	}
+	// a different meta is a different cache entry
+	u.Write(ctx, rsp, outputs.Meta{"source": "t2"})
+	if v := testutil.ToFloat64(udpMarshalCacheMisses.WithLabelValues(u.metricName)); v != 2 {
+		t.Errorf("unexpected cache misses, got %v, expected 2", v)
+	}
+}
+
+func Test_resolveRef(t *testing.T) {
+	t.Setenv("UDP_OUTPUT_TEST_HOST", "10.0.0.1")
+	fn := filepath.Join(t.TempDir(), "address")
+	err := os.WriteFile(fn, []byte("10.0.0.2:9000\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", in: "10.0.0.1:9000", want: "10.0.0.1:9000"},
+		{name: "env", in: "${UDP_OUTPUT_TEST_HOST}:9000", want: "10.0.0.1:9000"},
+		{name: "missing_env", in: "${UDP_OUTPUT_TEST_MISSING}:9000", wantErr: true},
+		{name: "file", in: "file:" + fn, want: "10.0.0.2:9000"},
+		{name: "missing_file", in: "file:" + fn + ".missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveRef(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveRef() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUDPSock_resolveConfigRefs_addresses(t *testing.T) {
+	t.Setenv("UDP_OUTPUT_TEST_HOST", "10.0.0.1")
+	u := &UDPSock{Cfg: &Config{Addresses: []string{"${UDP_OUTPUT_TEST_HOST}:9000", "10.0.0.2:9000"}}}
+	if err := u.resolveConfigRefs(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u.Cfg.Addresses, []string{"10.0.0.1:9000", "10.0.0.2:9000"}) {
+		t.Errorf("unexpected resolved addresses %q", u.Cfg.Addresses)
+	}
+	u = &UDPSock{Cfg: &Config{Addresses: []string{"10.0.0.2:9000", "${UDP_OUTPUT_TEST_MISSING}:9000"}}}
+	if err := u.resolveConfigRefs(); err == nil {
+		t.Error("expected an error for a missing variable in addresses")
+	}
+}
+
+func Test_sharedConn(t *testing.T) {
+	l := newTestListener(t)
+	raddr := l.LocalAddr().(*net.UDPAddr)
+	logger := log.New(io.Discard, "", 0)
+	c1, ok, err := acquireSharedConn(raddr, 0, logger)
+	if err != nil || !ok {
+		t.Fatalf("failed to acquire shared conn: ok=%v, err=%v", ok, err)
+	}
+	c2, ok, err := acquireSharedConn(raddr, 0, logger)
+	if err != nil || !ok {
+		t.Fatalf("failed to acquire shared conn: ok=%v, err=%v", ok, err)
+	}
+	if c1 != c2 {
+		t.Fatalf("expected the same socket to be shared")
+	}
+	// different options fall back to a dedicated socket
+	_, ok, err = acquireSharedConn(raddr, 10, logger)
+	if err != nil || ok {
+		t.Fatalf("unexpected shared conn with different options: ok=%v, err=%v", ok, err)
+	}
+	releaseSharedConn(raddr.String())
+	if _, err := c1.Write([]byte("foo")); err != nil {
+		t.Fatalf("shared socket closed while still referenced: %v", err)
+	}
+	releaseSharedConn(raddr.String())
+	if _, err := c1.Write([]byte("foo")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected shared socket to be closed, got: %v", err)
+	}
+	if _, ok := sharedConns.conns[raddr.String()]; ok {
+		t.Fatalf("shared socket not removed")
+	}
+}
+
+func Test_addMetaKeys(t *testing.T) {
+	meta := outputs.Meta{"subscription-name": "sub1", "source": "router1:57400"}
+	metaKeys := map[string]string{"subscription-name": "sub", "source": "device", "subscription-target": "st"}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "object",
+			in:   `{"timestamp":1,"values":{"a":1}}`,
+			want: `{"device":"router1:57400","sub":"sub1","timestamp":1,"values":{"a":1}}`,
+		},
+		{
+			name: "array",
+			in:   `[{"name":"a"},{"name":"b"}]`,
+			want: `[{"device":"router1:57400","name":"a","sub":"sub1"},{"device":"router1:57400","name":"b","sub":"sub1"}]`,
+		},
+		{
+			name: "large_number",
+			in:   `{"value":18446744073709551615}`,
+			want: `{"device":"router1:57400","sub":"sub1","value":18446744073709551615}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := addMetaKeys([]byte(tt.in), meta, metaKeys)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUDPSock_Write_metaKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address": l.LocalAddr().String(),
+		// unlike json, protojson does not carry the meta fields.
+		"format": "protojson",
+		"meta-keys": map[string]string{
+			"source":            "source",
+			"subscription-name": "subscription-name",
+		},
+	})
+	defer u.Close()
+	u.Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("datagram not received")
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(bytes.TrimSpace(b), &m); err != nil {
+		t.Fatalf("failed to unmarshal datagram %q: %v", b, err)
+	}
+	if m["source"] != "router1:57400" || m["subscription-name"] != "sub1" {
+		t.Errorf("meta fields missing from the datagram: %s", b)
+	}
+}
+
+func TestUDPSock_Write_metaKeysEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	rsp := testSubscribeResponse("t1", 1)
+	write := func(cfg map[string]interface{}) []byte {
+		l := newTestListener(t)
+		cfg["address"] = l.LocalAddr().String()
+		cfg["format"] = "event"
+		u := newTestOutput(ctx, t, cfg)
+		defer u.Close()
+		u.Write(ctx, rsp, meta)
+		b := readDatagram(t, l, time.Second)
+		if b == nil {
+			t.Fatal("datagram not received")
+		}
+		return b
+	}
+	expected := write(map[string]interface{}{})
+	// the event messages carry the meta fields as tags,
+	// meta-keys does not change them.
+	got := write(map[string]interface{}{
+		"meta-keys": map[string]string{"source": "device", "subscription-name": "sub"},
+	})
+	if !bytes.Equal(got, expected) {
+		t.Errorf("meta-keys changed the event message:\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+func TestUDPSock_Write_blockedBuffer(t *testing.T) {
+	u := outputs.Outputs["udp"]().(*UDPSock)
+	u.mo = &formatters.MarshalOptions{Format: "json"}
+	// unbuffered channel without a sender loop consuming it.
+	u.buffer = make(chan *payload)
+	done := make(chan struct{})
+	u.done = done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	returned := make(chan struct{})
+	go func() {
+		u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{})
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return after the caller context was canceled")
+	}
+
+	close(done)
+	returned = make(chan struct{})
+	go func() {
+		u.Write(context.Background(), testSubscribeResponse("t1", 1500), outputs.Meta{})
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return after the output was closed")
+	}
+}
+
+// newTestSOCKS5Proxy starts a minimal SOCKS5 server accepting a single
+// UDP ASSOCIATE request authenticated with user:pass,
+// the relay is a UDP listener returned to the caller.
+func newTestSOCKS5Proxy(t *testing.T) (string, *net.UDPConn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	relay := newTestListener(t)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 512)
+		// greeting: VER NMETHODS METHODS
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+			return
+		}
+		conn.Write([]byte{socks5Version, socks5AuthPassword})
+		// auth: VER ULEN UNAME PLEN PASSWD
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != "user" || string(pass) != "pass" {
+			conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+		// request: VER CMD RSV ATYP(IPv4) ADDR PORT
+		if _, err := io.ReadFull(conn, buf[:10]); err != nil || buf[1] != socks5CmdAssociate {
+			return
+		}
+		raddr := relay.LocalAddr().(*net.UDPAddr)
+		rep := []byte{socks5Version, socks5ReplySuccess, 0x00, socks5AtypIPv4}
+		rep = append(rep, raddr.IP.To4()...)
+		rep = append(rep, byte(raddr.Port>>8), byte(raddr.Port))
+		conn.Write(rep)
+		// keep the association until the client closes the control connection
+		io.Copy(io.Discard, conn)
+	}()
+	return l.Addr().String(), relay
+}
+
+func TestUDPSock_Write_socks5Proxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proxyAddr, relay := newTestSOCKS5Proxy(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address": "192.0.2.1:5000",
+		"format":  "json",
+		"proxy":   "socks5://user:pass@" + proxyAddr,
+	})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, relay, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received by the relay")
+	}
+	header := []byte{0, 0, 0, socks5AtypIPv4, 192, 0, 2, 1, 0x13, 0x88}
+	if !bytes.HasPrefix(b, header) {
+		t.Fatalf("unexpected datagram header, got %v, expected %v", b[:len(header)], header)
+	}
+	var v interface{}
+	if err := json.Unmarshal(b[len(header):], &v); err != nil {
+		t.Errorf("failed to unmarshal relayed payload: %v", err)
+	}
+}
+
+func TestUDPSock_maxPayloadSize_socks5Proxy(t *testing.T) {
+	for _, tt := range []struct {
+		address  string
+		expected int
+	}{
+		{"192.0.2.1:5000", 1000 - socks5UDPHeaderLenIPv4},
+		{"[2001:db8::1]:5000", 1000 - socks5UDPHeaderLenIPv6},
+		{"collector:5000", 1000 - socks5UDPHeaderLenIPv6},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		err := u.Init(context.Background(), "test", map[string]interface{}{
+			"address":           tt.address,
+			"proxy":             "socks5://127.0.0.1:1080",
+			"max-datagram-size": 1000,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.address, err)
+		}
+		if got := u.maxPayloadSize(); got != tt.expected {
+			t.Errorf("%s: unexpected max payload size %d, expected %d", tt.address, got, tt.expected)
+		}
+		u.Close()
+	}
+}
+
+func TestUDPSock_Init_socks5Credentials(t *testing.T) {
+	long := strings.Repeat("a", socks5MaxCredLen+1)
+	for _, proxy := range []string{
+		"socks5://" + long + ":pass@127.0.0.1:1080",
+		"socks5://user:" + long + "@127.0.0.1:1080",
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", map[string]interface{}{"address": "127.0.0.1:9999", "proxy": proxy}); err == nil {
+			t.Errorf("expected an error for proxy credentials longer than %d bytes", socks5MaxCredLen)
+			u.Close()
+		}
+	}
+}
+
+func TestUDPSock_Write_contentTypeHeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":             l.LocalAddr().String(),
+		"format":              "proto",
+		"content-type-header": true,
+	})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	if b[0] != ContentTypeProto {
+		t.Fatalf("unexpected content type code, got %#x, expected %#x", b[0], ContentTypeProto)
+	}
+	rsp := new(gnmi.SubscribeResponse)
+	if err := proto.Unmarshal(b[1:], rsp); err != nil {
+		t.Errorf("failed to unmarshal payload: %v", err)
+	}
+}
+
+func TestUDPSock_Write_compression(t *testing.T) {
+	// a large JSON message, with many similar updates.
+	large := testSubscribeResponse("t1", 1500)
+	for i := 0; i < 200; i++ {
+		large.GetUpdate().Update = append(large.GetUpdate().Update, &gnmi.Update{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{
+				{Name: "interface", Key: map[string]string{"name": fmt.Sprintf("ethernet-1/%d", i)}},
+				{Name: "mtu"},
+			}},
+			Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(i)}},
+		})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the reference payload, sent without compression.
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address": l.LocalAddr().String(),
+		"format":  "json",
+	})
+	u.Write(ctx, large, outputs.Meta{"source": "t1"})
+	expected := readDatagram(t, l, time.Second)
+	if expected == nil {
+		t.Fatal("no datagram received")
+	}
+	for _, tt := range []struct {
+		compression string
+		code        byte
+	}{
+		{compressionGzip, CompressionGzip},
+		{compressionZlib, CompressionZlib},
+	} {
+		t.Run(tt.compression, func(t *testing.T) {
+			l := newTestListener(t)
+			u := newTestOutput(ctx, t, map[string]interface{}{
+				"address":             l.LocalAddr().String(),
+				"format":              "json",
+				"content-type-header": true,
+				"compression":         tt.compression,
+				"compression-level":   9,
+			})
+			u.Write(ctx, large, outputs.Meta{"source": "t1"})
+			b := readDatagram(t, l, time.Second)
+			if b == nil {
+				t.Fatal("no datagram received")
+			}
+			if b[0] != ContentTypeJSON || b[1] != tt.code {
+				t.Fatalf("unexpected headers %#x %#x", b[0], b[1])
+			}
+			if len(b) >= len(expected)/2 {
+				t.Errorf("payload not compressed enough, got %d bytes for %d bytes", len(b), len(expected))
+			}
+			payload, err := Decompress(b[1:])
+			if err != nil {
+				t.Fatalf("failed to decompress: %v", err)
+			}
+			if !bytes.Equal(payload, expected) {
+				t.Errorf("unexpected payload, got %q, expected %q", payload, expected)
+			}
+		})
+	}
+}
+
+func Test_compressor_tinyPayload(t *testing.T) {
+	c, err := newCompressor(compressionGzip, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := c.compress([]byte("{}"))
+	if !bytes.Equal(b, []byte{CompressionNone, '{', '}'}) {
+		t.Errorf("unexpected compressed tiny payload %q", b)
+	}
+	payload, err := Decompress(b)
+	if err != nil || string(payload) != "{}" {
+		t.Errorf("unexpected decompressed payload %q: %v", payload, err)
+	}
+}
+
+func TestUDPSock_Init_compression(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "compression": "lz4"},
+		{"address": "127.0.0.1:9999", "compression": "gzip", "compression-level": 10},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_Write_localAddress(t *testing.T) {
+	// a free local port.
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+	tests := []struct {
+		name  string
+		laddr string
+		ip    net.IP
+		port  int
+		// the whole 127.0.0.0/8 range is bound to the loopback interface on linux only.
+		linuxOnly bool
+	}{
+		{name: "ip and port", laddr: fmt.Sprintf("127.0.0.1:%d", port), ip: net.IPv4(127, 0, 0, 1), port: port},
+		{name: "ip only", laddr: "127.0.0.2", ip: net.IPv4(127, 0, 0, 2), linuxOnly: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.linuxOnly && runtime.GOOS != "linux" {
+				t.Skip("127.0.0.2 is not a local address")
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := newTestListener(t)
+			u := newTestOutput(ctx, t, map[string]interface{}{
+				"address":       l.LocalAddr().String(),
+				"format":        "json",
+				"local-address": tt.laddr,
+			})
+			defer u.Close()
+			u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+			buf := make([]byte, 65535)
+			l.SetReadDeadline(time.Now().Add(time.Second))
+			_, src, err := l.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("failed to read from listener: %v", err)
+			}
+			if !src.IP.Equal(tt.ip) || (tt.port != 0 && src.Port != tt.port) {
+				t.Errorf("unexpected source address %s, expected %s:%d", src, tt.ip, tt.port)
+			}
+		})
+	}
+}
+
+func TestUDPSock_Init_localAddress(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "local-address": "localhost"},
+		{"address": "127.0.0.1:9999", "local-address": "127.0.0.1:port"},
+		// not a local address.
+		{"address": "127.0.0.1:9999", "local-address": "192.0.2.1"},
+		{"address": "127.0.0.1:9999", "local-address": "127.0.0.1", "shared-socket": true},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_Write_captureFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fn := filepath.Join(t.TempDir(), "udp.capture")
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":             "127.0.0.1:1",
+		"format":              "json",
+		"content-type-header": true,
+		"capture-file":        fn,
+		"capture-only":        true,
+	})
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	var recs []*CaptureRecord
+	deadline := time.Now().Add(time.Second)
+	for len(recs) < 3 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		f, err := os.Open(fn)
+		if err != nil {
+			continue
+		}
+		recs = recs[:0]
+		for {
+			// a record might be partially written, it is read again on the next attempt.
+			rec, err := ReadCaptureRecord(f)
+			if err != nil {
+				break
+			}
+			recs = append(recs, rec)
+		}
+		f.Close()
+	}
+	if len(recs) != 3 {
+		t.Fatalf("unexpected number of captured datagrams, got %d, expected 3", len(recs))
+	}
+	for _, rec := range recs {
+		if rec.Datagram[0] != ContentTypeJSON || !json.Valid(rec.Datagram[1:]) {
+			t.Errorf("unexpected captured datagram: %q", rec.Datagram)
+		}
+		if time.Since(rec.Timestamp) > time.Minute {
+			t.Errorf("unexpected capture timestamp: %v", rec.Timestamp)
+		}
+	}
+}
+
+func TestUDPSock_Write_marshalWorkersOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":               l.LocalAddr().String(),
+		"format":                "proto",
+		"buffer-size":           100,
+		"block-on-full":         true,
+		"marshal-workers":       8,
+		"preserve-target-order": true,
+	})
+	const numTargets = 5
+	const numMsgs = 50
+	var wg sync.WaitGroup
+	for i := 0; i < numTargets; i++ {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			for j := 0; j < numMsgs; j++ {
+				u.Write(ctx, testSubscribeResponse(target, int64(j)), outputs.Meta{"source": target})
+			}
+		}(fmt.Sprintf("t%d", i))
+	}
+	last := make(map[string]int64)
+	for i := 0; i < numTargets*numMsgs; i++ {
+		b := readDatagram(t, l, time.Second)
+		if b == nil {
+			t.Fatalf("received %d datagrams, expected %d", i, numTargets*numMsgs)
+		}
+		rsp := new(gnmi.SubscribeResponse)
+		if err := proto.Unmarshal(b, rsp); err != nil {
+			t.Fatalf("failed to unmarshal datagram: %v", err)
+		}
+		target := rsp.GetUpdate().GetPrefix().GetTarget()
+		v := rsp.GetUpdate().GetUpdate()[0].GetVal().GetIntVal()
+		if prev, ok := last[target]; (ok && v != prev+1) || (!ok && v != 0) {
+			t.Fatalf("target %s: out of order message %d after %d", target, v, prev)
+		}
+		last[target] = v
+	}
+	wg.Wait()
+}
+
+func TestUDPSock_submit_dropped(t *testing.T) {
+	u := &UDPSock{
+		Cfg:         &Config{},
+		marshalJobs: make(chan *marshalJob),
+		seqs:        make(map[string]uint64),
+		reorder:     newReorderer(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// no worker receives the message, it is dropped.
+	u.submit(ctx, testSubscribeResponse("t1", 0), outputs.Meta{"source": "t1"})
+	if s := u.Stats(); s.Dropped != 1 {
+		t.Errorf("unexpected dropped count %d", s.Dropped)
+	}
+	// the next message of the target is not held back by the dropped one.
+	released := false
+	u.reorder.done("t1", 1, func() { released = true })
+	if !released {
+		t.Error("message following a dropped message not released")
+	}
+}
+
+func Test_reorderer_blockedTarget(t *testing.T) {
+	r := newReorderer()
+	blocked := make(chan struct{})
+	go r.done("t1", 0, func() { <-blocked })
+	defer close(blocked)
+	// wait for the release of t1 to be in progress.
+	for {
+		to := r.target("t1")
+		if !to.m.TryLock() {
+			break
+		}
+		to.m.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	released := make(chan struct{})
+	go r.done("t2", 0, func() { close(released) })
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("a blocked release of t1 holds back t2")
+	}
+}
+
+func TestUDPSock_selfTest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":           l.LocalAddr().String(),
+		"self-test":         true,
+		"self-test-payload": "ping",
+		"enable-metrics":    true,
+	})
+	if b := readDatagram(t, l, time.Second); string(b) != "ping" {
+		t.Fatalf("unexpected self-test datagram: %q", b)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !u.Ready() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !u.Ready() {
+		t.Error("output not ready after a successful self-test")
+	}
+	if v := testutil.ToFloat64(udpSelfTests.WithLabelValues(u.metricName, u.Cfg.Address, "success")); v != 1 {
+		t.Errorf("unexpected self-test success count, got %v, expected 1", v)
+	}
+
+	// closed port
+	closed := newTestListener(t)
+	addr := closed.LocalAddr().String()
+	closed.Close()
+	u2 := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        addr,
+		"self-test":      true,
+		"retry-interval": "10s",
+		"enable-metrics": true,
+	})
+	deadline = time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(udpSelfTests.WithLabelValues(u2.name, u2.Cfg.Address, "failure")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if v := testutil.ToFloat64(udpSelfTests.WithLabelValues(u2.name, u2.Cfg.Address, "failure")); v != 1 {
+		t.Errorf("unexpected self-test failure count, got %v, expected 1", v)
+	}
+	if u2.Ready() {
+		t.Error("output ready after a failed self-test")
+	}
+}
+
+func TestUDPSock_maxRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	closed := newTestListener(t)
+	addr := closed.LocalAddr().String()
+	closed.Close()
+	failed := make(chan error, 1)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        addr,
+		"self-test":      true,
+		"max-retries":    2,
+		"retry-interval": "10ms",
+		"enable-metrics": true,
+	}, WithOnFailed(func(name string, err error) {
+		failed <- err
+	}))
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("expected a non nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("output did not fail")
+	}
+	if !u.Failed() || u.Ready() {
+		t.Errorf("unexpected output state: failed=%v, ready=%v", u.Failed(), u.Ready())
+	}
+	if v := testutil.ToFloat64(udpFailed.WithLabelValues(u.metricName, u.Cfg.Address)); v != 1 {
+		t.Errorf("unexpected failed metric value, got %v, expected 1", v)
+	}
+	// 1 attempt + 2 retries
+	if v := testutil.ToFloat64(udpSelfTests.WithLabelValues(u.metricName, u.Cfg.Address, "failure")); v < 3 {
+		t.Errorf("unexpected self-test failure count, got %v, expected at least 3", v)
+	}
+}
+
+func TestUDPSock_backoff(t *testing.T) {
+	u := &UDPSock{Cfg: &Config{RetryInterval: 100 * time.Millisecond, MaxRetryInterval: time.Second}}
+	within := func(d, want time.Duration) bool {
+		return d >= time.Duration(float64(want)*(1-retryJitter)) && d <= time.Duration(float64(want)*(1+retryJitter))
+	}
+	// consecutive failures double the delay up to max-retry-interval,
+	// the retries count is reset after a successful send.
+	for i, want := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second,
+	} {
+		if d := u.backoff(i + 1); !within(d, want) {
+			t.Errorf("retry %d: got delay %s, expected %s ±20%%", i+1, d, want)
+		}
+	}
+
+	// the wait is interrupted by the context cancellation.
+	u.Cfg.RetryInterval = time.Hour
+	u.Cfg.MaxRetryInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	retries := 0
+	start := time.Now()
+	if u.retry(ctx, &retries, errors.New("test")) {
+		t.Error("retry returned true with a canceled context")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("retry waited %s with a canceled context", time.Since(start))
+	}
+}
+
+func TestUDPSock_Write_proxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":             l.LocalAddr().String(),
+		"format":              "proto",
+		"content-type-header": true,
+		"proxy-protocol":      true,
+	})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	if !bytes.HasPrefix(b, proxyProtocolV2Signature) {
+		t.Fatalf("missing PROXY protocol signature: %x", b)
+	}
+	h := b[len(proxyProtocolV2Signature):]
+	if h[0] != proxyProtocolV2Proxy || h[1] != proxyProtocolV2UDPv4 {
+		t.Fatalf("unexpected PROXY protocol version/command %#x or family %#x", h[0], h[1])
+	}
+	if n := binary.BigEndian.Uint16(h[2:4]); n != proxyProtocolV2IPv4AddrsL {
+		t.Fatalf("unexpected PROXY protocol addresses length %d", n)
+	}
+	addrs := h[4 : 4+proxyProtocolV2IPv4AddrsL]
+	laddr := l.LocalAddr().(*net.UDPAddr)
+	if !net.IP(addrs[4:8]).Equal(laddr.IP) || int(binary.BigEndian.Uint16(addrs[10:12])) != laddr.Port {
+		t.Errorf("unexpected destination address %v:%d, expected %v", net.IP(addrs[4:8]), binary.BigEndian.Uint16(addrs[10:12]), laddr)
+	}
+	srcPort := int(binary.BigEndian.Uint16(addrs[8:10]))
+	if srcPort == 0 {
+		t.Errorf("missing source port")
+	}
+	// the PROXY protocol header comes before the content type header.
+	b = h[4+proxyProtocolV2IPv4AddrsL:]
+	if b[0] != ContentTypeProto {
+		t.Fatalf("unexpected content type code, got %#x, expected %#x", b[0], ContentTypeProto)
+	}
+	rsp := new(gnmi.SubscribeResponse)
+	if err := proto.Unmarshal(b[1:], rsp); err != nil {
+		t.Errorf("failed to unmarshal payload: %v", err)
+	}
+}
+
+func TestUDPSock_Write_msgSizeMetric(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"format":         "proto",
+		"enable-metrics": true,
+	})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	m := new(dto.Metric)
+	err := udpMsgSize.WithLabelValues(u.metricName, "proto").(prometheus.Histogram).Write(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := m.GetHistogram().GetSampleCount(); c != 1 {
+		t.Errorf("unexpected sample count, got %d, expected 1", c)
+	}
+	if s := m.GetHistogram().GetSampleSum(); s != float64(len(b)) {
+		t.Errorf("unexpected sample sum, got %v, expected %d", s, len(b))
+	}
+}
+
+func TestUDPSock_WriteEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":      l.LocalAddr().String(),
+		"format":       "event",
+		"split-events": true,
+	})
+	u.WriteEvent(ctx, &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags:      map[string]string{"source": "t1"},
+		Values:    map[string]interface{}{"/interface/mtu": 1500},
+	})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	ev := new(formatters.EventMsg)
+	if err := json.Unmarshal(b, ev); err != nil {
+		t.Fatalf("failed to unmarshal %q: %v", b, err)
+	}
+	if ev.Name != "sub1" || ev.Timestamp != 42 || ev.Tags["source"] != "t1" || ev.Values["/interface/mtu"] != float64(1500) {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestUDPSock_WriteEvent_format(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"format":         "json",
+		"enable-metrics": true,
+	})
+	u.WriteEvent(ctx, &formatters.EventMsg{Name: "sub1", Tags: map[string]string{"source": "t1"}})
+	if b := readDatagram(t, l, 500*time.Millisecond); b != nil {
+		t.Fatalf("unexpected datagram received: %q", b)
+	}
+	if v := testutil.ToFloat64(udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, dropReasonMarshalError)); v != 1 {
+		t.Errorf("unexpected dropped messages count, got %v, expected 1", v)
+	}
+}
+
+func TestUDPSock_Write_oversize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":           l.LocalAddr().String(),
+		"format":            "proto",
+		"max-datagram-size": 100,
+		"enable-metrics":    true,
+	})
+	large := testSubscribeResponse("t1", 1500)
+	large.GetUpdate().GetUpdate()[0].Val = &gnmi.TypedValue{
+		Value: &gnmi.TypedValue_StringVal{StringVal: strings.Repeat("x", 200)},
+	}
+	u.Write(ctx, large, outputs.Meta{"source": "t1"})
+	if v := testutil.ToFloat64(udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, dropReasonOversize)); v != 1 {
+		t.Errorf("unexpected oversize dropped messages count, got %v, expected 1", v)
+	}
+	// a message that fits is still sent.
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	rsp := new(gnmi.SubscribeResponse)
+	if err := proto.Unmarshal(b, rsp); err != nil {
+		t.Fatalf("failed to unmarshal datagram: %v", err)
+	}
+	if v := rsp.GetUpdate().GetUpdate()[0].GetVal().GetIntVal(); v != 1500 {
+		t.Errorf("unexpected value received, got %d, expected 1500", v)
+	}
+}
+
+func TestUDPSock_Write_sendTooLarge(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("EMSGSIZE is not reported on windows")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	// larger than the maximum UDP payload over IPv4,
+	// the message passes the size check and fails to be sent.
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":           l.LocalAddr().String(),
+		"format":            "proto",
+		"max-datagram-size": 100000,
+		"enable-metrics":    true,
+	})
+	large := testSubscribeResponse("t1", 1500)
+	large.GetUpdate().GetUpdate()[0].Val = &gnmi.TypedValue{
+		Value: &gnmi.TypedValue_StringVal{StringVal: strings.Repeat("x", 70000)},
+	}
+	u.Write(ctx, large, outputs.Meta{"source": "t1"})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l, time.Second); b == nil {
+		t.Fatal("no datagram received")
+	}
+	if v := testutil.ToFloat64(udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, dropReasonOversize)); v != 1 {
+		t.Errorf("unexpected oversize dropped messages count, got %v, expected 1", v)
+	}
+	if v := testutil.ToFloat64(udpSendErrors.WithLabelValues(u.metricName, u.Cfg.Address)); v != 0 {
+		t.Errorf("unexpected send errors count, got %v, expected 0", v)
+	}
+	if n := u.reconnects.Load(); n != 0 {
+		t.Errorf("unexpected reconnects count, got %d, expected 0", n)
+	}
+}
+
+func TestUDPSock_startupDelay(t *testing.T) {
+	u := &UDPSock{Cfg: &Config{}}
+	if d := u.startupDelay(); d != 0 {
+		t.Errorf("unexpected delay without startup-delay-max: %s", d)
+	}
+	u.Cfg.StartupDelayMax = 100 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		if d := u.startupDelay(); d < 0 || d >= u.Cfg.StartupDelayMax {
+			t.Fatalf("delay %s out of range [0..%s)", d, u.Cfg.StartupDelayMax)
+		}
+	}
+}
+
+func TestUDPSock_Flush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"format":         "json",
+		"flush-interval": time.Hour,
+	})
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	}
+	// the messages move from the buffer to the batch asynchronously.
+	deadline := time.Now().Add(time.Second)
+	for u.Stats().Buffered != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := u.Stats(); s.Buffered != 3 || s.Sent != 0 {
+		t.Errorf("unexpected stats before flush: %+v", s)
+	}
+	err := u.Flush(ctx)
+	if err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received after flush")
+	}
+	if n := bytes.Count(b, []byte(defaultDelimiter)); n != 2 {
+		t.Errorf("expected 3 messages in the datagram, got %d delimiters: %q", n, b)
+	}
+	if s := u.Stats(); s.Buffered != 0 || s.Sent != 1 || s.Dropped != 0 {
+		t.Errorf("unexpected stats after flush: %+v", s)
+	}
+	cancel()
+	if err := u.Flush(context.Background()); !errors.Is(err, errOutputClosed) {
+		t.Errorf("expected errOutputClosed after close, got: %v", err)
+	}
+}
+
+func TestUDPSock_Write_binaryEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		format   string
+		expected string
+	}{
+		{encoding: "", format: "json", expected: `"AP8Q"`},
+		{encoding: "base64", format: "event", expected: `"AP8Q"`},
+		{encoding: "hex", format: "json", expected: `"00ff10"`},
+		{encoding: "hex", format: "event", expected: `"00ff10"`},
+		{encoding: "raw", format: "json", expected: `"3:\u0000ÿ\u0010"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding+"_"+tt.format, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := newTestListener(t)
+			u := newTestOutput(ctx, t, map[string]interface{}{
+				"address":         l.LocalAddr().String(),
+				"format":          tt.format,
+				"binary-encoding": tt.encoding,
+			})
+			rsp := testSubscribeResponse("t1", 0)
+			rsp.GetUpdate().GetUpdate()[0].Val = &gnmi.TypedValue{
+				Value: &gnmi.TypedValue_BytesVal{BytesVal: []byte{0x00, 0xff, 0x10}},
+			}
+			u.Write(ctx, rsp, outputs.Meta{"source": "t1"})
+			b := readDatagram(t, l, time.Second)
+			if !bytes.Contains(b, []byte(tt.expected)) {
+				t.Errorf("datagram %s does not contain %s", b, tt.expected)
+			}
+			// the written message is shared with the other outputs.
+			if _, ok := rsp.GetUpdate().GetUpdate()[0].GetVal().GetValue().(*gnmi.TypedValue_BytesVal); !ok {
+				t.Errorf("written message modified: %v", rsp)
+			}
+		})
+	}
+}
+
+func Test_rawString(t *testing.T) {
+	in := []byte{0x00, 0x7f, 0x80, 0xc3, 0xa9, 0xff, '\n', '"'}
+	b, err := json.Marshal(rawString(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeRawString(s)
+	if err != nil {
+		t.Fatalf("failed to decode %s: %v", b, err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("got %x, expected %x", out, in)
+	}
+	for _, s := range []string{"\u00ff", "2:\u00ff", "x:\u00ff", "1:\u0100"} {
+		if _, err := DecodeRawString(s); err == nil {
+			t.Errorf("expected an error decoding %q", s)
+		}
+	}
+}
+
+func TestUDPSock_Init_binaryEncoding(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "binary-encoding": "base32"},
+		{"address": "127.0.0.1:9999", "binary-encoding": "hex", "format": "proto"},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_Init_format(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr bool
+	}{
+		{name: "valid", format: "protojson"},
+		{name: "empty", format: ""},
+		{name: "invalid", format: "jsom", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := outputs.Outputs["udp"]().(*UDPSock)
+			err := u.Init(context.Background(), "test", map[string]interface{}{
+				"address": "127.0.0.1:9999",
+				"format":  tt.format,
+			})
+			if u.cancelFn != nil {
+				defer u.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "protojson") {
+				t.Errorf("the error does not list the valid formats: %v", err)
+			}
+		})
+	}
+}
+
+func TestUDPSock_Write_deadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the collector port is closed, the sends fail
+	// once the ICMP port unreachable error is received.
+	collector := newTestListener(t)
+	addr := collector.LocalAddr().String()
+	collector.Close()
+	dl := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        addr,
+		"format":         "json",
+		"retry-interval": "10ms",
+		"dead-letter": map[string]interface{}{
+			"address": dl.LocalAddr().String(),
+		},
+	})
+	var b []byte
+	deadline := time.Now().Add(2 * time.Second)
+	for b == nil && time.Now().Before(deadline) {
+		u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+		b = readDatagram(t, dl, 50*time.Millisecond)
+	}
+	if b == nil {
+		t.Fatal("no dead letter received")
+	}
+	reason, payload, ok := bytes.Cut(b, []byte("\n"))
+	if !ok || string(reason) != dropReasonSendError {
+		t.Fatalf("unexpected dead letter reason header: %q", b)
+	}
+	if !json.Valid(payload) {
+		t.Errorf("unexpected dead letter payload: %q", payload)
+	}
+}
+
+func Test_deadLetter_sample(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "dead-letter")
+	d, err := newDeadLetter(&DeadLetterConfig{File: f, Sample: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := d.write(dropReasonClosed, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+	// the writes after Close are discarded, the file is not reopened.
+	for i := 7; i < 10; i++ {
+		if err := d.write(dropReasonClosed, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := os.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var got []string
+	for {
+		rec, err := ReadCaptureRecord(r)
+		if err != nil {
+			break
+		}
+		got = append(got, string(rec.Datagram))
+	}
+	expected := []string{"output_closed\n0", "output_closed\n3", "output_closed\n6"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("got dead letters %q, expected %q", got, expected)
+	}
+	if _, err := newDeadLetter(&DeadLetterConfig{}); err == nil {
+		t.Error("expected an error without address nor file")
+	}
+}
+
+func TestUDPSock_acks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	ackL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ackL.Close()
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"ack-address":    ackL.Addr().String(),
+		"self-test":      true,
+		"retry-interval": "10ms",
+		"enable-metrics": true,
+	})
+	accept := func() net.Conn {
+		t.Helper()
+		ackL.(*net.TCPListener).SetDeadline(time.Now().Add(2 * time.Second))
+		c, err := ackL.Accept()
+		if err != nil {
+			t.Fatalf("failed to accept ack connection: %v", err)
+		}
+		return c
+	}
+	ack := func(c net.Conn, n uint64) {
+		t.Helper()
+		if err := binary.Write(c, binary.BigEndian, n); err != nil {
+			t.Fatalf("failed to write ack: %v", err)
+		}
+	}
+	waitLoss := func(expected uint64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for u.estimatedLoss() != expected && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if l := u.estimatedLoss(); l != expected {
+			t.Fatalf("unexpected estimated loss, got %d, expected %d", l, expected)
+		}
+	}
+	c := accept()
+	// self-test datagram
+	if b := readDatagram(t, l, time.Second); b == nil {
+		t.Fatal("no self-test datagram received")
+	}
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+		if b := readDatagram(t, l, time.Second); b == nil {
+			t.Fatal("no datagram received")
+		}
+	}
+	// the self-test datagram and 3 messages sent, 3 acknowledged.
+	ack(c, 3)
+	waitLoss(1)
+	// the output reconnects when the ack connection is closed.
+	c.Close()
+	c = accept()
+	defer c.Close()
+	ack(c, 4)
+	waitLoss(0)
+	if v := testutil.ToFloat64(udpLostDatagrams.WithLabelValues(u.metricName)); v != 1 {
+		t.Errorf("unexpected lost datagrams count, got %v, expected 1", v)
+	}
+}
+
+func TestUDPSock_Init_ackAddress(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "ack-address": "127.0.0.1", "format": "json"},
+		{"address": "127.0.0.1:9999", "ack-address": "127.0.0.1:9998", "capture-file": filepath.Join(t.TempDir(), "capture"), "capture-only": true},
+		{"address": "127.0.0.1:9999", "ack-address": "127.0.0.1:9998", "shared-socket": true},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func Test_setTTL(t *testing.T) {
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		l, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		if err != nil {
+			t.Logf("skipping %s: %v", ip, err)
+			continue
+		}
+		defer l.Close()
+		raddr := l.LocalAddr().(*net.UDPAddr)
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := setTTL(conn, raddr, 7); err != nil {
+			t.Fatalf("%s: failed to set ttl: %v", ip, err)
+		}
+		var ttl int
+		if ip.To4() != nil {
+			ttl, err = ipv4.NewConn(conn).TTL()
+		} else {
+			ttl, err = ipv6.NewConn(conn).HopLimit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl != 7 {
+			t.Errorf("%s: unexpected ttl, got %d, expected 7", ip, ttl)
+		}
+	}
+}
+
+func TestUDPSock_Write_ttl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	pc := ipv4.NewPacketConn(l)
+	if err := pc.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+		t.Skipf("received ttl not supported: %v", err)
+	}
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address": l.LocalAddr().String(),
+		"ttl":     7,
+	})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	_, cm, _, err := pc.ReadFrom(make([]byte, 65535))
+	if err != nil {
+		t.Fatalf("no datagram received: %v", err)
+	}
+	if cm == nil || cm.TTL != 7 {
+		t.Errorf("unexpected datagram ttl, control message: %v", cm)
+	}
+	for _, ttl := range []int{-1, 256} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", map[string]interface{}{"address": "127.0.0.1:9999", "ttl": ttl}); err == nil {
+			t.Errorf("expected an error for ttl %d", ttl)
+		}
+	}
+}
+
+func Test_sampler(t *testing.T) {
+	cfg := &AdaptiveSamplingConfig{}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s := newSampler(cfg)
+	for i := 0; i < 10; i++ {
+		if !s.keep("t1") {
+			t.Fatalf("message %d dropped while not sampling", i)
+		}
+	}
+	// sustained backpressure doubles the ratio up to max-ratio.
+	for _, want := range []uint64{2, 4, 8, 16, 16} {
+		if _, got := s.adjust(0.9); got != want {
+			t.Fatalf("got ratio %d, want %d", got, want)
+		}
+	}
+	// a buffer fill between the watermarks keeps the ratio.
+	if _, got := s.adjust(0.5); got != 16 {
+		t.Fatalf("got ratio %d, want 16", got)
+	}
+	// each target keeps 1 in 16 messages.
+	for _, target := range []string{"t1", "t2"} {
+		kept := 0
+		for i := 0; i < 64; i++ {
+			if s.keep(target) {
+				kept++
+			}
+		}
+		if kept != 4 {
+			t.Errorf("target %s: kept %d messages, want 4", target, kept)
+		}
+	}
+	// draining the buffer halves the ratio back to 1.
+	for _, want := range []uint64{8, 4, 2, 1, 1} {
+		if _, got := s.adjust(0.1); got != want {
+			t.Fatalf("got ratio %d, want %d", got, want)
+		}
+	}
+}
+
+func TestUDPSock_Init_adaptiveSampling(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "adaptive-sampling": map[string]interface{}{}},
+		{"address": "127.0.0.1:9999", "buffer-size": 10, "adaptive-sampling": map[string]interface{}{"low-watermark": 0.9, "high-watermark": 0.5}},
+		{"address": "127.0.0.1:9999", "buffer-size": 10, "adaptive-sampling": map[string]interface{}{"high-watermark": 1.5}},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_conformance(t *testing.T) {
+	outputstest.Run(t, &outputstest.Config{
+		New: outputs.Outputs["udp"],
+		Config: func(t *testing.T) map[string]interface{} {
+			return map[string]interface{}{"address": newTestListener(t).LocalAddr().String()}
+		},
+	})
+}
+
+func TestUDPSock_Write_metrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"format":         "event",
+		"enable-metrics": true,
+	})
+	// a JSON value that cannot be decoded into an event.
+	invalid := testSubscribeResponse("t1", 1500)
+	invalid.GetUpdate().GetUpdate()[0].Val = &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: []byte("{")}}
+	u.Write(ctx, invalid, outputs.Meta{"source": "t1"})
+	u.Write(ctx, testSubscribeResponse("t1", 1500), outputs.Meta{"source": "t1"})
+	b := readDatagram(t, l, time.Second)
+	if b == nil {
+		t.Fatal("no datagram received")
+	}
+	if err := u.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		c    prometheus.Collector
+		want float64
+	}{
+		{"marshal errors", udpNumberOfDroppedMsgs.WithLabelValues(u.metricName, dropReasonMarshalError), 1},
+		{"sent datagrams", udpSentDatagrams.WithLabelValues(u.metricName, u.Cfg.Address), 1},
+		{"sent bytes", udpSentBytes.WithLabelValues(u.metricName, u.Cfg.Address), float64(len(b))},
+		{"buffered messages", udpBufferedMsgs.WithLabelValues(u.metricName, u.Cfg.Address), 0},
+	} {
+		if got := testutil.ToFloat64(tc.c); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// multicastInterface returns an interface that is up and supports multicast,
+// loopback first.
+func multicastInterface(t *testing.T) *net.Interface {
+	t.Helper()
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("failed to list interfaces: %v", err)
+	}
+	var found *net.Interface
+	for i := range ifis {
+		ifi := &ifis[i]
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi
+		}
+		if found == nil {
+			found = ifi
+		}
+	}
+	if found == nil {
+		t.Skip("no multicast interface")
+	}
+	return found
+}
+
+func TestUDPSock_Write_multicast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ifi := multicastInterface(t)
+	group := net.IPv4(239, 255, 77, 77)
+	l, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: group})
+	if err != nil {
+		t.Skipf("failed to join multicast group on %s: %v", ifi.Name, err)
+	}
+	defer l.Close()
+	newTestOutput(ctx, t, map[string]interface{}{
+		"address":             net.JoinHostPort(group.String(), fmt.Sprint(l.LocalAddr().(*net.UDPAddr).Port)),
+		"format":              "json",
+		"multicast-interface": ifi.Name,
+		"multicast-loopback":  true,
+	}).Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l, time.Second); b == nil {
+		t.Fatal("no datagram received from the multicast group")
+	}
+}
+
+func TestUDPSock_Init_multicast(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "239.255.77.77:9999", "multicast-ttl": 256},
+		{"address": "239.255.77.77:9999", "multicast-interface": "lo", "shared-socket": true},
+		{"address": "255.255.255.255:9999", "broadcast": true, "proxy": "socks5://127.0.0.1:1080"},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_Write_broadcast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	newTestOutput(ctx, t, map[string]interface{}{
+		"address": net.JoinHostPort(net.IPv4bcast.String(), fmt.Sprint(l.LocalAddr().(*net.UDPAddr).Port)),
+		"format":  "json",
+	}).Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l, time.Second); b == nil {
+		t.Fatal("no broadcast datagram received")
+	}
+}
+
+func TestUDPSock_Write_redialInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l1 := newTestListener(t)
+	l2 := newTestListener(t)
+	var addr atomic.Pointer[net.UDPAddr]
+	addr.Store(l1.LocalAddr().(*net.UDPAddr))
+	u := outputs.Outputs["udp"]().(*UDPSock)
+	u.resolve = func(string, string) (*net.UDPAddr, error) {
+		return addr.Load(), nil
+	}
+	err := u.Init(ctx, fmt.Sprintf("%s-%d", t.Name(), testOutputs.Add(1)), map[string]interface{}{
+		"address":         "collector.example:9999",
+		"format":          "json",
+		"redial-interval": 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to init udp output: %v", err)
+	}
+	defer u.Close()
+	u.Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l1, time.Second); b == nil {
+		t.Fatal("no datagram received before the address change")
+	}
+	addr.Store(l2.LocalAddr().(*net.UDPAddr))
+	// one redial interval, with some margin.
+	time.Sleep(400 * time.Millisecond)
+	u.Write(ctx, testSubscribeResponse("t1", 2), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l2, time.Second); b == nil {
+		t.Fatal("no datagram received at the new address")
+	}
+	if b := readDatagram(t, l1, 100*time.Millisecond); b != nil {
+		t.Errorf("unexpected datagram at the previous address: %s", b)
+	}
+	if n := u.reconnects.Load(); n != 0 {
+		t.Errorf("unexpected reconnects: %d", n)
+	}
+}
+
+func TestUDPSock_Init_redialInterval(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "redial-interval": time.Second, "shared-socket": true},
+		{"address": "127.0.0.1:9999", "redial-interval": time.Second, "proxy": "socks5://127.0.0.1:1080"},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
+
+func TestUDPSock_SetName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.LocalAddr().String(),
+		"enable-metrics": true,
+	}, outputs.WithName("gnmic1"))
+	defer u.Close()
+	if expected := "gnmic1-" + u.name; u.metricName != expected {
+		t.Fatalf("unexpected metrics name label %q, expected %q", u.metricName, expected)
+	}
+	u.Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "t1"})
+	if b := readDatagram(t, l, time.Second); b == nil {
+		t.Fatal("datagram not received")
+	}
+	if v := testutil.ToFloat64(udpSentDatagrams.WithLabelValues("gnmic1-"+u.name, u.Cfg.Address)); v != 1 {
+		t.Errorf("unexpected sent datagrams metric %v, expected 1", v)
+	}
+}
+
+func TestUDPSock_Write_addresses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l1 := newTestListener(t)
+	l2 := newTestListener(t)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"addresses":      []string{l1.LocalAddr().String(), l2.LocalAddr().String()},
+		"format":         "json",
+		"buffer-size":    10,
+		"retry-interval": time.Second,
+		"enable-metrics": true,
+		"compression":    "gzip",
+	})
+	defer u.Close()
+	if u.dests[0].compressor == u.dests[1].compressor {
+		t.Fatal("the destinations share the same compressor")
+	}
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	for _, l := range []*net.UDPConn{l1, l2} {
+		for i := 0; i < 3; i++ {
+			if b := readDatagram(t, l, time.Second); b == nil {
+				t.Fatalf("%s: datagram %d not received", l.LocalAddr(), i)
+			}
+		}
+	}
+	for _, l := range []*net.UDPConn{l1, l2} {
+		if v := testutil.ToFloat64(udpSentDatagrams.WithLabelValues(u.metricName, l.LocalAddr().String())); v != 3 {
+			t.Errorf("%s: unexpected sent datagrams metric %v, expected 3", l.LocalAddr(), v)
+		}
+	}
+	// the second collector goes down.
+	l2.Close()
+	for i := 0; i < 5; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+		if b := readDatagram(t, l1, time.Second); b == nil {
+			t.Fatalf("datagram %d not received by the first collector", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := u.Stats(); st.Sent < 8 {
+		t.Errorf("unexpected sent count %d, expected at least 8", st.Sent)
+	}
+	if err := u.dests[0].Flush(ctx); err != nil {
+		t.Errorf("unexpected flush error for the first destination: %v", err)
+	}
+}
+
+func TestUDPSock_Init_addresses(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "addresses": []string{"127.0.0.1:9998"}},
+		{"addresses": []string{"127.0.0.1:9998", "127.0.0.1"}, "buffer-size": 10},
+		{"addresses": []string{"127.0.0.1:9998", "127.0.0.1:9999"}},
+		{"addresses": []string{"127.0.0.1:9998", "127.0.0.1:9999"}, "buffer-size": 10, "block-on-full": true},
+		{"addresses": []string{"127.0.0.1:9998", "127.0.0.1:9999"}, "buffer-size": 10, "ack-address": "127.0.0.1:9997"},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+			u.Close()
+		}
+	}
+	// a single address is handled as address.
+	u := outputs.Outputs["udp"]().(*UDPSock)
+	if err := u.Init(context.Background(), "test", map[string]interface{}{"addresses": []string{"127.0.0.1:9998"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer u.Close()
+	if u.Cfg.Address != "127.0.0.1:9998" || u.dests != nil {
+		t.Errorf("unexpected address %q and destinations %v", u.Cfg.Address, u.dests)
+	}
+}
+
+func TestUDPSock_Close_drain(t *testing.T) {
+	l := newTestListener(t)
+	u := newTestOutput(context.Background(), t, map[string]interface{}{
+		"address":     l.LocalAddr().String(),
+		"format":      "json",
+		"buffer-size": 10,
+		"rate":        20 * time.Millisecond,
+	})
+	for i := 0; i < 10; i++ {
+		u.Write(context.Background(), testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	if st := u.Stats(); st.Buffered == 0 {
+		t.Fatal("expected buffered messages before closing")
+	}
+	u.Close()
+	// the datagrams were sent before Close returned.
+	for i := 0; i < 10; i++ {
+		if b := readDatagram(t, l, 50*time.Millisecond); b == nil {
+			t.Fatalf("datagram %d not received", i)
+		}
+	}
+	// the messages written once closed are dropped.
+	u.Write(context.Background(), testSubscribeResponse("t1", 10), outputs.Meta{"source": "t1"})
+	if st := u.Stats(); st.Dropped != 1 || st.Sent != 10 {
+		t.Errorf("unexpected stats after close: %+v", st)
+	}
+}
+
+func TestUDPSock_Close_drainTimeout(t *testing.T) {
+	l := newTestListener(t)
+	u := newTestOutput(context.Background(), t, map[string]interface{}{
+		"address":       l.LocalAddr().String(),
+		"format":        "json",
+		"buffer-size":   10,
+		"rate":          100 * time.Millisecond,
+		"drain-timeout": 250 * time.Millisecond,
+	})
+	for i := 0; i < 10; i++ {
+		u.Write(context.Background(), testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	start := time.Now()
+	u.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close took %s, expected at most the drain timeout", d)
+	}
+	st := u.Stats()
+	if st.Sent == 0 || st.Sent >= 10 {
+		t.Errorf("unexpected sent count %d, expected some but not all messages", st.Sent)
+	}
+	if st.Sent+st.Dropped != 10 || st.Buffered != 0 {
+		t.Errorf("unexpected stats after close: %+v", st)
+	}
+}
+
+// newTestDTLSListener returns a DTLS listener on the loopback address
+// using a self-signed certificate for 127.0.0.1, written to a CA file.
+func newTestDTLSListener(t *testing.T) (net.Listener, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "collector"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := dtls.Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &dtls.Config{
+		Certificates:         []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, caFile
+}
+
+func TestUDPSock_Write_tls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, caFile := newTestDTLSListener(t)
+	received := make(chan []byte, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			buf := make([]byte, 65535)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received <- buf[:n]
+		}
+	}()
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address": l.Addr().String(),
+		"format":  "json",
+		"tls":     map[string]interface{}{"ca-file": caFile},
+	})
+	defer u.Close()
+	for i := 0; i < 3; i++ {
+		u.Write(ctx, testSubscribeResponse("t1", int64(i)), outputs.Meta{"source": "t1"})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case b := <-received:
+			m := make(map[string]interface{})
+			if err := json.Unmarshal(bytes.TrimSpace(b), &m); err != nil {
+				t.Fatalf("datagram %d: not decrypted: %v: %q", i, err, b)
+			}
+			if m["source"] != "t1" {
+				t.Errorf("datagram %d: unexpected message %v", i, m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("datagram %d not received", i)
+		}
+	}
+}
+
+func TestUDPSock_Write_tlsHandshakeFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, _ := newTestDTLSListener(t)
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err == nil {
+				conn.Close()
+				accepted <- struct{}{}
+			}
+		}
+	}()
+	// the collector certificate is not trusted.
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":        l.Addr().String(),
+		"format":         "json",
+		"buffer-size":    10,
+		"retry-interval": 100 * time.Millisecond,
+		"tls":            map[string]interface{}{},
+	})
+	defer u.Close()
+	u.Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "t1"})
+	select {
+	case <-accepted:
+		t.Fatal("unexpected DTLS session with an untrusted collector")
+	case <-time.After(500 * time.Millisecond):
+	}
+	if u.Ready() || u.Failed() {
+		t.Errorf("unexpected output state: failed=%v, ready=%v", u.Failed(), u.Ready())
+	}
+	if st := u.Stats(); st.Sent != 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestUDPSock_Write_tlsServerName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, caFile := newTestDTLSListener(t)
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err == nil {
+				conn.Close()
+				accepted <- struct{}{}
+			}
+		}
+	}()
+	// the collector certificate is trusted but not issued for tls-server-name.
+	t.Setenv("UDP_OUTPUT_TEST_CA", caFile)
+	u := newTestOutput(ctx, t, map[string]interface{}{
+		"address":         l.Addr().String(),
+		"format":          "json",
+		"buffer-size":     10,
+		"retry-interval":  100 * time.Millisecond,
+		"tls":             map[string]interface{}{"ca-file": "${UDP_OUTPUT_TEST_CA}"},
+		"tls-server-name": "collector.example.com",
+	})
+	defer u.Close()
+	if u.Cfg.TLS.CaFile != caFile {
+		t.Errorf("unexpected resolved ca-file %q, expected %q", u.Cfg.TLS.CaFile, caFile)
+	}
+	u.Write(ctx, testSubscribeResponse("t1", 1), outputs.Meta{"source": "t1"})
+	select {
+	case <-accepted:
+		t.Fatal("unexpected DTLS session with a collector not matching tls-server-name")
+	case <-time.After(500 * time.Millisecond):
+	}
+	if st := u.Stats(); st.Sent != 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestUDPSock_Init_tls(t *testing.T) {
+	tlsCfg := map[string]interface{}{"skip-verify": true}
+	for _, cfg := range []map[string]interface{}{
+		{"address": "127.0.0.1:9999", "tls": tlsCfg, "shared-socket": true},
+		{"address": "127.0.0.1:9999", "tls": tlsCfg, "proxy": "socks5://127.0.0.1:1080"},
+		{"address": "127.0.0.1:9999", "tls": tlsCfg, "proxy-protocol": true},
+		{"address": "127.0.0.1:9999", "tls": tlsCfg, "broadcast": true},
+		{"address": "127.0.0.1:9999", "tls": map[string]interface{}{"ca-file": "/nonexistent/ca.pem"}},
+		{"address": "127.0.0.1:9999", "tls-server-name": "collector"},
+	} {
+		u := outputs.Outputs["udp"]().(*UDPSock)
+		if err := u.Init(context.Background(), "test", cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// PROXY protocol v2 signature, version and command, and address families
+// (https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt).
+var proxyProtocolV2Signature = []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+
+const (
+	proxyProtocolV2Proxy      = 0x21 // version 2, PROXY command
+	proxyProtocolV2UDPv4      = 0x12 // AF_INET, SOCK_DGRAM
+	proxyProtocolV2UDPv6      = 0x22 // AF_INET6, SOCK_DGRAM
+	proxyProtocolV2IPv4AddrsL = 12
+	proxyProtocolV2IPv6AddrsL = 36
+)
+
+// proxyProtocolV2Header returns the PROXY protocol v2 header
+// identifying a datagram sent from src to dst.
+// IPv4 addresses are used only if both addresses are IPv4.
+func proxyProtocolV2Header(src, dst *net.UDPAddr) []byte {
+	h := make([]byte, 0, len(proxyProtocolV2Signature)+4+proxyProtocolV2IPv6AddrsL)
+	h = append(h, proxyProtocolV2Signature...)
+	h = append(h, proxyProtocolV2Proxy)
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	if src4 != nil && dst4 != nil {
+		h = append(h, proxyProtocolV2UDPv4)
+		h = binary.BigEndian.AppendUint16(h, proxyProtocolV2IPv4AddrsL)
+		h = append(h, src4...)
+		h = append(h, dst4...)
+	} else {
+		h = append(h, proxyProtocolV2UDPv6)
+		h = binary.BigEndian.AppendUint16(h, proxyProtocolV2IPv6AddrsL)
+		h = append(h, src.IP.To16()...)
+		h = append(h, dst.IP.To16()...)
+	}
+	h = binary.BigEndian.AppendUint16(h, uint16(src.Port))
+	return binary.BigEndian.AppendUint16(h, uint16(dst.Port))
+}
@@ -0,0 +1,53 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"net"
+
+	"github.com/pion/dtls/v2"
+)
+
+// redial resolves the address again and replaces the socket with a new one
+// connected to the resolved address, following DNS changes of the collector.
+// The current socket is only closed once the new one is connected, it is kept
+// if resolving or dialing fails.
+// The socket is only used by the sending goroutine, which calls redial between
+// two sends, so the batches and the buffered payloads are sent on the new socket.
+func (u *UDPSock) redial() error {
+	raddr, err := u.resolve("udp", u.Cfg.Address)
+	if err != nil {
+		return err
+	}
+	conn, err := u.dialDedicated(raddr)
+	if err != nil {
+		return err
+	}
+	var secure *dtls.Conn
+	if u.dtlsConfig != nil {
+		secure, err = u.handshake(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	if prev := u.conn.RemoteAddr().String(); prev != raddr.String() {
+		u.logger.Printf("address %s resolved to %s, was %s", u.Cfg.Address, raddr, prev)
+	}
+	if u.secure != nil {
+		u.secure.Close()
+	}
+	u.conn.Close()
+	u.conn = conn
+	u.secure = secure
+	if u.Cfg.ProxyProtocol {
+		u.proxyProtocolHeader = proxyProtocolV2Header(conn.LocalAddr().(*net.UDPAddr), raddr)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	defaultSelfTestPayload = "gnmic udp output self-test"
+	// time to wait for an ICMP error after sending the self-test datagram
+	selfTestTimeout = 500 * time.Millisecond
+)
+
+// Ready returns true if the output socket is connected and,
+// if self-test is enabled, the last self-test succeeded.
+// An output sending to several addresses is ready once all its destinations are.
+func (u *UDPSock) Ready() bool {
+	if u.dests != nil {
+		for _, d := range u.dests {
+			if !d.Ready() {
+				return false
+			}
+		}
+		return true
+	}
+	return u.ready.Load()
+}
+
+// selfTest sends the self-test payload over the connected socket.
+// A connected UDP socket reports the ICMP port unreachable error sent back
+// by the collector host on the next read, the socket is read until
+// the self-test timeout to catch it.
+// A timeout or a reply from the collector mean the self-test succeeded.
+// The read is skipped on shared sockets and through a proxy, where only
+// the send error is checked, and over DTLS, where the handshake
+// already reached the collector.
+func (u *UDPSock) selfTest() error {
+	_, err := u.writer().Write(u.addHeaders([]byte(u.Cfg.SelfTestPayload)))
+	if err != nil {
+		return err
+	}
+	u.selfTestsSent.Add(1)
+	if u.sharedAddr != "" || u.proxyURL != nil || u.secure != nil {
+		return nil
+	}
+	u.conn.SetReadDeadline(time.Now().Add(selfTestTimeout))
+	defer u.conn.SetReadDeadline(time.Time{})
+	_, err = u.conn.Read(make([]byte, 1))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+func (u *UDPSock) countSelfTest(err error) {
+	if !u.Cfg.EnableMetrics {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	udpSelfTests.WithLabelValues(u.metricName, u.Cfg.Address, result).Inc()
+}
@@ -0,0 +1,75 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// sharedConns holds the UDP sockets shared by the outputs
+// configured with `shared-socket: true`, keyed by destination address.
+var sharedConns = struct {
+	m     sync.Mutex
+	conns map[string]*sharedConn
+}{
+	conns: make(map[string]*sharedConn),
+}
+
+type sharedConn struct {
+	conn *net.UDPConn
+	ttl  int
+	refs int
+}
+
+// acquireSharedConn returns the socket shared by the outputs sending to raddr,
+// dialing it if it does not exist yet.
+// It returns false if the existing socket was created with different options,
+// in which case the caller should use a dedicated socket.
+func acquireSharedConn(raddr *net.UDPAddr, ttl int, logger *log.Logger) (*net.UDPConn, bool, error) {
+	sharedConns.m.Lock()
+	defer sharedConns.m.Unlock()
+	key := raddr.String()
+	if sc, ok := sharedConns.conns[key]; ok {
+		if sc.ttl != ttl {
+			return nil, false, nil
+		}
+		sc.refs++
+		return sc.conn, true, nil
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, false, err
+	}
+	if ttl > 0 {
+		if err := setTTL(conn, raddr, ttl); err != nil {
+			logger.Printf("failed to set ttl=%d: %v", ttl, err)
+		}
+	}
+	sharedConns.conns[key] = &sharedConn{conn: conn, ttl: ttl, refs: 1}
+	return conn, true, nil
+}
+
+// releaseSharedConn decrements the reference count of the socket
+// shared for raddr and closes it when it is no longer used.
+func releaseSharedConn(raddr string) {
+	sharedConns.m.Lock()
+	defer sharedConns.m.Unlock()
+	sc, ok := sharedConns.conns[raddr]
+	if !ok {
+		return
+	}
+	sc.refs--
+	if sc.refs > 0 {
+		return
+	}
+	sc.conn.Close()
+	delete(sharedConns.conns, raddr)
+}
@@ -0,0 +1,196 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	socks5Version       = 0x05
+	socks5AuthNone      = 0x00
+	socks5AuthPassword  = 0x02
+	socks5AuthNoAccept  = 0xff
+	socks5CmdAssociate  = 0x03
+	socks5AtypIPv4      = 0x01
+	socks5AtypDomain    = 0x03
+	socks5AtypIPv6      = 0x04
+	socks5ReplySuccess  = 0x00
+	socks5HandshakeTime = 10 * time.Second
+	// maximum length of the username and password (RFC 1929)
+	socks5MaxCredLen = 255
+	// length of the UDP request header with an IPv4 and an IPv6 address
+	socks5UDPHeaderLenIPv4 = 10
+	socks5UDPHeaderLenIPv6 = 22
+)
+
+// checkSocks5User checks that the credentials of the proxy URL
+// fit in the username and password authentication request.
+func checkSocks5User(user *url.Userinfo) error {
+	if user == nil {
+		return nil
+	}
+	if len(user.Username()) > socks5MaxCredLen {
+		return fmt.Errorf("proxy username longer than %d bytes", socks5MaxCredLen)
+	}
+	if password, _ := user.Password(); len(password) > socks5MaxCredLen {
+		return fmt.Errorf("proxy password longer than %d bytes", socks5MaxCredLen)
+	}
+	return nil
+}
+
+// socks5UDPHeaderLen returns the length of the UDP request header
+// prepended to the datagrams sent to address through the proxy.
+// A host name is assumed to resolve to an IPv6 address, the longest header.
+func socks5UDPHeaderLen(address string) int {
+	host, _, _ := net.SplitHostPort(address)
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		return socks5UDPHeaderLenIPv4
+	}
+	return socks5UDPHeaderLenIPv6
+}
+
+// socks5Associate requests a UDP association from the SOCKS5 proxy
+// described by proxyURL (RFC 1928, UDP ASSOCIATE command).
+// It returns the TCP control connection, which must be kept open for the
+// lifetime of the association, a UDP socket connected to the proxy relay
+// and the header to prepend to each datagram sent to raddr through the relay.
+func socks5Associate(proxyURL *url.URL, raddr *net.UDPAddr) (net.Conn, *net.UDPConn, []byte, error) {
+	ctrl, err := net.DialTimeout("tcp", proxyURL.Host, socks5HandshakeTime)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	relay, err := socks5Handshake(ctrl, proxyURL.User)
+	if err != nil {
+		ctrl.Close()
+		return nil, nil, nil, fmt.Errorf("socks5 handshake failed: %v", err)
+	}
+	// a relay bound to an unspecified address is reachable
+	// at the proxy address.
+	if relay.IP.IsUnspecified() {
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		ctrl.Close()
+		return nil, nil, nil, err
+	}
+	return ctrl, conn, socks5UDPHeader(raddr), nil
+}
+
+// socks5Handshake negotiates the authentication method and
+// sends the UDP ASSOCIATE request, it returns the relay address.
+func socks5Handshake(ctrl net.Conn, user *url.Userinfo) (*net.UDPAddr, error) {
+	ctrl.SetDeadline(time.Now().Add(socks5HandshakeTime))
+	defer ctrl.SetDeadline(time.Time{})
+
+	methods := []byte{socks5Version, 1, socks5AuthNone}
+	if user != nil {
+		methods = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := ctrl.Write(methods); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(ctrl, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5Version {
+		return nil, fmt.Errorf("unexpected version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return nil, errors.New("proxy requires a username and password")
+		}
+		password, _ := user.Password()
+		req := []byte{0x01, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := ctrl.Write(req); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(ctrl, buf); err != nil {
+			return nil, err
+		}
+		if buf[1] != 0x00 {
+			return nil, errors.New("authentication failed")
+		}
+	case socks5AuthNoAccept:
+		return nil, errors.New("no acceptable authentication method")
+	default:
+		return nil, fmt.Errorf("unexpected authentication method %d", buf[1])
+	}
+	// the client address is not known in advance,
+	// hence the zero address and port.
+	req := []byte{socks5Version, socks5CmdAssociate, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := ctrl.Write(req); err != nil {
+		return nil, err
+	}
+	rep := make([]byte, 4)
+	if _, err := io.ReadFull(ctrl, rep); err != nil {
+		return nil, err
+	}
+	if rep[1] != socks5ReplySuccess {
+		return nil, fmt.Errorf("udp associate request failed with code %d", rep[1])
+	}
+	var ip net.IP
+	switch rep[3] {
+	case socks5AtypIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case socks5AtypIPv6:
+		ip = make(net.IP, net.IPv6len)
+	case socks5AtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(ctrl, l); err != nil {
+			return nil, err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(ctrl, name); err != nil {
+			return nil, err
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(ctrl, port); err != nil {
+			return nil, err
+		}
+		return net.ResolveUDPAddr("udp", net.JoinHostPort(string(name), fmt.Sprint(binary.BigEndian.Uint16(port))))
+	default:
+		return nil, fmt.Errorf("unexpected address type %d", rep[3])
+	}
+	if _, err := io.ReadFull(ctrl, ip); err != nil {
+		return nil, err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(ctrl, port); err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(port))}, nil
+}
+
+// socks5UDPHeader returns the header of the datagrams relayed to raddr:
+// RSV(2) FRAG(1) ATYP(1) DST.ADDR DST.PORT(2)
+func socks5UDPHeader(raddr *net.UDPAddr) []byte {
+	h := []byte{0, 0, 0}
+	if ip4 := raddr.IP.To4(); ip4 != nil {
+		h = append(h, socks5AtypIPv4)
+		h = append(h, ip4...)
+	} else {
+		h = append(h, socks5AtypIPv6)
+		h = append(h, raddr.IP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(h, uint16(raddr.Port))
+}
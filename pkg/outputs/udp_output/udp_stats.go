@@ -0,0 +1,69 @@
+// © 2022 Nokia.
+//
+// This code is a Contribution to the gNMIc project (“Work”) made under the Google Software Grant and Corporate Contributor License Agreement (“CLA”) and governed by the Apache License 2.0.
+// No other rights or licenses in or to any of Nokia’s intellectual property are granted for any other purpose.
+// This code is provided on an “as is” basis without any warranties of any kind.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_output
+
+import (
+	"context"
+	"errors"
+)
+
+var errOutputClosed = errors.New("output closed")
+
+// OutputStats is a snapshot of the output counters.
+type OutputStats struct {
+	// Sent is the number of datagrams sent.
+	Sent uint64
+	// Dropped is the number of messages dropped, for any reason,
+	// including the messages filtered out by the event processors.
+	Dropped uint64
+	// Buffered is the number of messages waiting to be sent.
+	Buffered int
+	// Reconnects is the number of times the socket was connected again
+	// after a failure.
+	Reconnects uint64
+}
+
+// Stats returns the output counters, it is safe to call concurrently with Write.
+// The counters of an output sending to several addresses are summed.
+func (u *UDPSock) Stats() OutputStats {
+	if u.dests != nil {
+		return u.destinationsStats()
+	}
+	return OutputStats{
+		Sent:       u.sent.Load(),
+		Dropped:    u.dropped.Load(),
+		Buffered:   len(u.buffer) + int(u.batched.Load()),
+		Reconnects: u.reconnects.Load(),
+	}
+}
+
+// Flush sends the messages buffered when it is called, including
+// the partially filled datagrams if flush-interval or batch-timeout is set.
+// The messages still being marshaled by the marshal workers are not waited for.
+// It returns the send error if any, or the ctx error if ctx is done first.
+// It is safe to call concurrently with Write.
+func (u *UDPSock) Flush(ctx context.Context) error {
+	if u.dests != nil {
+		return u.flushDestinations(ctx)
+	}
+	errCh := make(chan error, 1)
+	select {
+	case u.flushReqs <- errCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-u.done:
+		return errOutputClosed
+	}
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}